@@ -3,8 +3,11 @@ package aarch64
 import (
 	"fmt"
 	"io"
+	"strconv"
 
+	"github.com/rj45/gosling/hlir"
 	"github.com/rj45/gosling/ir"
+	"github.com/rj45/gosling/types"
 )
 
 var argRegs = []string{"x0", "x1", "x2", "x3", "x4", "x5", "x6", "x7"}
@@ -13,6 +16,19 @@ type Assembler struct {
 	Out   io.Writer
 	depth int
 	fn    string
+
+	// whether the current function returns a result, which
+	// is pushed on the stack while the deferred calls run
+	result bool
+
+	// the callee saved registers the current function saves, at
+	// the bottom of its frame, and the size of the frame
+	saved ir.RegMask
+	frame int
+
+	// the size of the area below the saved registers which the
+	// args of C functions passed on the stack are stored to
+	outgoing int
 }
 
 const WordSize = 8
@@ -32,23 +48,64 @@ func (g *Assembler) regFor(reg ir.RegMask) string {
 	return argRegs[reg.Pop()]
 }
 
-func (g *Assembler) Prologue(fnname string, locals int) {
-	g.fn = "_" + fnname
+func (g *Assembler) Prologue(fn *ir.Func) {
+	g.fn = "_" + fn.Name
+	g.result = fn.Types().Func(fn.Sig).ReturnType() != types.Void
+
+	// a frame which defers calls can be resumed by a recover, which
+	// skips the epilogues of the frames called from it, so it saves
+	// all the callee saved registers for them
+	g.saved = 0
+	if fn.HasOp(hlir.Defer) {
+		g.saved = calleeSaved
+	}
+	g.outgoing = 0
+	g.enter(fn.NumLocals())
+}
+
+// enter makes the frame for the locals, and saves the callee saved
+// registers below them, above the area for the stack args of calls.
+func (g *Assembler) enter(locals int) {
+	g.frame = align(locals*WordSize, 16) + align(len(g.saved.Regs())*WordSize, 16) + g.outgoing
 	g.printf(".text")
-	g.printf(".global %s", g.fn)
+	g.printf(".global %s", symbol(g.fn))
 	g.printf(".align 2")
-	g.printf("%s:", g.fn)
+	g.printf("%s:", symbol(g.fn))
 	g.printf("  stp x29, x30, [sp, #-16]!")
 	g.printf("  mov x29, sp")
-	g.printf("  sub sp, sp, #%d", align(locals*WordSize, 16))
+	g.printf("  sub sp, sp, #%d", g.frame)
+	g.saveRegs(true)
+}
+
+// saveRegs stores the saved registers to the bottom of the frame, above
+// the area for stack args, or loads them back, in pairs.
+func (g *Assembler) saveRegs(store bool) {
+	regs := g.saved.Regs()
+	if len(regs) == 0 {
+		return
+	}
+	pair, single := "ldp", "ldr"
+	if store {
+		pair, single = "stp", "str"
+	}
+
+	g.printf("  sub x17, x29, #%d", g.frame-g.outgoing)
+	for i := 0; i < len(regs); i += 2 {
+		if i+1 < len(regs) {
+			g.printf("  %s x%d, x%d, [x17, #%d]", pair, regs[i], regs[i+1], i*WordSize)
+		} else {
+			g.printf("  %s x%d, [x17, #%d]", single, regs[i], i*WordSize)
+		}
+	}
 }
 
 func (g *Assembler) Epilogue() {
+	g.saveRegs(false)
 	g.printf("  mov sp, x29")
 	g.printf("  ldp x29, x30, [sp], #16")
 }
 
-func (g *Assembler) Push(src ir.RegMask) {
+func (g *Assembler) Push(src ir.RegMask, typ types.Type) {
 	g.depth++
 	// note: stack is 16-byte aligned, so this is wasteful, we will fix that later
 	g.printf("  str %s, [sp, #-16]!", g.regFor(src))
@@ -77,7 +134,22 @@ func (g *Assembler) Store(src ir.RegMask, addr ir.RegMask) {
 }
 
 func (g *Assembler) LoadInt(dst ir.RegMask, lit int64) {
-	g.printf("  mov %s, #%d", g.regFor(dst), lit)
+	g.movImm(g.regFor(dst), lit)
+}
+
+// movImm moves the constant into the register. Constants too wide for
+// a single mov are built 16 bits at a time with movz and movk.
+func (g *Assembler) movImm(reg string, c int64) {
+	if c >= -0xffff && c <= 0xffff {
+		g.printf("  mov %s, #%d", reg, c)
+		return
+	}
+	g.printf("  movz %s, #%d", reg, uint64(c)&0xffff)
+	for shift := 16; shift < 64; shift += 16 {
+		if half := uint64(c) >> shift & 0xffff; half != 0 {
+			g.printf("  movk %s, #%d, lsl #%d", reg, half, shift)
+		}
+	}
 }
 
 func (g *Assembler) LocalAddr(dst ir.RegMask, offset int) {
@@ -97,7 +169,16 @@ func (g *Assembler) Mul(dst ir.RegMask, src1 ir.RegMask, src2 ir.RegMask) {
 }
 
 func (g *Assembler) Div(dst ir.RegMask, src1 ir.RegMask, src2 ir.RegMask) {
-	g.printf("  sdiv %s, %s, %s", g.regFor(dst), g.regFor(src1), g.regFor(src2))
+	g.div(g.regFor(dst), g.regFor(src1), g.regFor(src2))
+}
+
+// div divides, after panicking if the divisor is zero, which sdiv would
+// divide to zero rather than trap on.
+func (g *Assembler) div(dst, src1, src2 string) {
+	g.printf("  cbnz %s, 1f", src2)
+	g.printf("  bl _gosling_panicdivide")
+	g.printf("1:")
+	g.printf("  sdiv %s, %s, %s", dst, src1, src2)
 }
 
 func (g *Assembler) Neg(dst ir.RegMask, src ir.RegMask) {
@@ -135,21 +216,126 @@ func (g *Assembler) Ge(dst ir.RegMask, src1 ir.RegMask, src2 ir.RegMask) {
 }
 
 func (g *Assembler) Call(fnname string) {
-	g.printf("  bl _%s", fnname)
+	g.printf("  bl %s", symbol("_"+fnname))
+}
+
+// CallExtern calls a C function, following the AAPCS64 calling convention.
+// The args were pushed in order, each to its own 16 byte slot, so the first
+// eight are loaded into x0-x7, and the rest are copied 8 bytes apart to the
+// bottom of the stack, where the convention expects them.
+func (g *Assembler) CallExtern(fn *ir.Func) {
+	nargs := len(fn.Types().Func(fn.Sig).ParamTypes())
+
+	// the stack args take up a 16 byte aligned area below the pushed args
+	area := align(max(nargs-len(argRegs), 0)*WordSize, 16)
+	if area > 0 {
+		g.printf("  sub sp, sp, #%d", area)
+	}
+	slot := func(arg int) int {
+		return area + (nargs-1-arg)*16
+	}
+
+	for i := len(argRegs); i < nargs; i++ {
+		g.printf("  ldr x9, [sp, #%d]", slot(i))
+		g.printf("  str x9, [sp, #%d]", (i-len(argRegs))*WordSize)
+	}
+	for i := 0; i < nargs && i < len(argRegs); i++ {
+		g.printf("  ldr %s, [sp, #%d]", argRegs[i], slot(i))
+	}
+
+	g.printf("  bl %s", symbol("_"+fn.Name))
+
+	if area+nargs*16 > 0 {
+		g.printf("  add sp, sp, #%d", area+nargs*16)
+	}
+	g.depth -= nargs
+}
+
+// Go is never called, as the unsupported pass reports go statements
+// as errors.
+func (g *Assembler) Go(fnname string) {
+	panic("goroutines are not supported by the aarch64 backend yet")
+}
+
+// Defer records a deferred call with the runtime. The record holds the
+// frame pointer, so the runtime can run the frame's deferred calls when
+// it returns, and where to resume the frame if it recovers from a panic.
+func (g *Assembler) Defer(fn *ir.Func) {
+	nargs := len(fn.Types().Func(fn.Sig).ParamTypes())
+
+	// the args are copied out of the stack by the runtime
+	for i := 0; i < nargs; i++ {
+		g.printf("  str %s, [sp, #-16]!", argRegs[i])
+	}
+
+	// the stack pointer the frame resumes with has the zero result pushed
+	resume := (g.depth + nargs) * 16
+	if g.result {
+		resume -= 16
+	}
+
+	g.printf("  adr x0, %s", symbol("_"+fn.Name))
+	g.printf("  mov x1, x29")
+	if resume < 0 {
+		g.printf("  sub x2, sp, #%d", -resume)
+	} else {
+		g.printf("  add x2, sp, #%d", resume)
+	}
+	g.printf("  adr x3, %s", symbol(".L."+g.fn+".deferreturn"))
+	g.printf("  mov x4, #%d", nargs)
+	g.printf("  mov x5, sp")
+	if g.result {
+		g.printf("  mov x6, #1")
+	} else {
+		g.printf("  mov x6, #0")
+	}
+	g.printf("  bl _gosling_deferproc")
+	if nargs > 0 {
+		g.printf("  add sp, sp, #%d", nargs*16)
+	}
+}
+
+// DeferReturn runs the deferred calls of the current frame.
+func (g *Assembler) DeferReturn() {
+	g.printf("%s:", symbol(".L."+g.fn+".deferreturn"))
+	g.printf("  mov x0, x29")
+	g.printf("  bl _gosling_deferreturn")
+}
+
+// CallRuntime calls a runtime helper from runtime/runtime.c, which follows the
+// AAPCS64 calling convention: args in x0-x7 and the result in x0.
+func (g *Assembler) CallRuntime(fnname string, typ types.Type) {
+	if fnname == "selectgo" {
+		// the select cases are on the stack
+		g.printf("  mov x2, sp")
+	}
+	g.printf("  bl _gosling_%s", fnname)
 }
 
 func (g *Assembler) If(reg ir.RegMask, then string, els string) {
 	g.printf("  cmp %s, #0", g.regFor(reg))
-	g.printf("  b.eq .L.%s", els)
-	g.printf("  b .L.%s", then)
+	g.printf("  b.eq %s", symbol(".L."+els))
+	g.printf("  b %s", symbol(".L."+then))
 }
 
 func (g *Assembler) Jump(label string) {
-	g.printf("  b .L.%s", label)
+	g.printf("  b %s", symbol(".L."+label))
 }
 
 func (g *Assembler) Label(label string) {
-	g.printf(".L.%s:", label)
+	g.printf("%s:", symbol(".L."+label))
+}
+
+// symbol returns the symbol or label in the assembly, which is quoted if
+// it has characters which can't be in an identifier, like the slashes of
+// the import paths functions are qualified by.
+func symbol(name string) string {
+	for _, c := range name {
+		if c != '_' && c != '.' && !('a' <= c && c <= 'z') && !('A' <= c && c <= 'Z') && !('0' <= c && c <= '9') {
+			return strconv.Quote(name)
+		}
+	}
+	return name
 }
 
 func (g *Assembler) Return() {
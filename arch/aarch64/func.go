@@ -0,0 +1,282 @@
+package aarch64
+
+import (
+	stderrors "errors"
+	"fmt"
+
+	"github.com/rj45/gosling/errors"
+	"github.com/rj45/gosling/hlir"
+	"github.com/rj45/gosling/ir"
+	"github.com/rj45/gosling/llir"
+	"github.com/rj45/gosling/regalloc"
+	"github.com/rj45/gosling/types"
+)
+
+// target is the machine the functions are lowered for: add, sub and cmp
+// take 12 bit unsigned immediates, loads and stores take 9 bit signed
+// offsets, and C functions take their first eight args in registers.
+var target = &llir.Target{
+	WordSize:  WordSize,
+	MinImm:    0,
+	MaxImm:    4095,
+	MinOffset: -256,
+	MaxOffset: 255,
+	ArgRegs:   len(argRegs),
+}
+
+// config allocates x9-x15, which calls may overwrite, and x19-x28, which
+// calls preserve, so the functions which use them save them.
+var config = &regalloc.Config{
+	Regs:        ir.NewRegMask(9, 10, 11, 12, 13, 14, 15, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28),
+	CallerSaved: ir.NewRegMask(9, 10, 11, 12, 13, 14, 15),
+}
+
+// calleeSaved are the registers which functions must preserve.
+var calleeSaved = ir.NewRegMask(19, 20, 21, 22, 23, 24, 25, 26, 27, 28)
+
+// x16 is the scratch register the moves of Phi parameters go through,
+// and x17 is the scratch register addresses are computed in.
+const scratch = ir.RegID(16)
+
+// runtimeFuncs are the runtime helpers the runtime ops call.
+var runtimeFuncs = map[llir.Op]string{
+	llir.MakeMap:    "makemap",
+	llir.MapIndex:   "mapaccess1",
+	llir.MapIndexOk: "mapaccess2",
+	llir.MapAssign:  "mapassign",
+	llir.MapDelete:  "mapdelete",
+	llir.MapLen:     "maplen",
+	llir.MakeChan:   "makechan",
+	llir.ChanSend:   "chansend",
+	llir.ChanRecv:   "chanrecv1",
+	llir.ChanRecv2:  "chanrecv2",
+	llir.ChanClose:  "closechan",
+	llir.Select:     "selectgo",
+	llir.Panic:      "gopanic",
+	llir.Recover:    "gorecover",
+}
+
+// conds are the condition codes of the Set and Branch ops.
+var conds = map[llir.Op]string{
+	llir.SetEq:    "eq",
+	llir.SetNe:    "ne",
+	llir.SetLt:    "lt",
+	llir.SetLe:    "le",
+	llir.SetGt:    "gt",
+	llir.SetGe:    "ge",
+	llir.BranchEq: "eq",
+	llir.BranchNe: "ne",
+	llir.BranchLt: "lt",
+	llir.BranchLe: "le",
+	llir.BranchGt: "gt",
+	llir.BranchGe: "ge",
+}
+
+// AddPasses adds the passes which take the functions from their stack
+// operations to registers, after a pass which reports the features the
+// backend doesn't support.
+func (g *Assembler) AddPasses(pm *ir.PassManager) {
+	pm.AddProgramPass("unsupported", unsupported)
+	regalloc.AddPasses(pm, target, config)
+}
+
+// unsupported reports an error for each use of a feature the backend
+// doesn't support yet, which is only go statements.
+func unsupported(prog *ir.Program) error {
+	var errs []error
+	for i := 0; i < prog.NumFuncs(); i++ {
+		fn := prog.Func(i)
+		fn.EachValue(func(v ir.Value) {
+			if v.Op() == hlir.Go {
+				errs = append(errs, errors.New(fn.File(v.Token()), v.Token(), "go statements are not supported by the aarch64 backend yet"))
+			}
+		})
+	}
+	return stderrors.Join(errs...)
+}
+
+// AssembleFunc assembles the function with its values allocated to
+// registers, if the passes put it in SSA form, rather than from its
+// stack operations.
+func (g *Assembler) AssembleFunc(fn *ir.Func) bool {
+	if !fn.SSA {
+		return false
+	}
+
+	g.fn = "_" + fn.Name
+	g.saved = 0
+	g.outgoing = 0
+	for i := 0; i < fn.NumValues(); i++ {
+		v := fn.ValueAt(i)
+		g.saved = g.saved.Union(v.Regs().Intersect(calleeSaved))
+		if !v.IsNil() && v.Op() == llir.StoreArg {
+			index, _ := ir.Int64Value(v.Operand(1).Constant())
+			g.outgoing = max(g.outgoing, align(int(index+1)*WordSize, 16))
+		}
+	}
+
+	for i := 0; i < fn.NumBlocks(); i++ {
+		blk := fn.BlockAt(i)
+		g.Label(blk.Name)
+		for j := 0; j < blk.NumValues(); j++ {
+			if v := blk.ValueAt(j); !v.IsNil() {
+				g.value(v)
+			}
+		}
+		g.terminator(blk)
+	}
+	return true
+}
+
+// reg returns the register of the value.
+func reg(v ir.Value) string {
+	return fmt.Sprintf("x%d", v.Regs().Peek())
+}
+
+// operand returns the register of the value, or the immediate if
+// it is a constant.
+func operand(v ir.Value) string {
+	if v.IsConstant() {
+		c, _ := ir.Int64Value(v.Constant())
+		return fmt.Sprintf("#%d", c)
+	}
+	return reg(v)
+}
+
+// local returns the address of the local, which is computed in x17
+// if it is too far from the frame pointer for an offset.
+func (g *Assembler) local(index ir.Value) string {
+	i, _ := ir.Int64Value(index.Constant())
+	offset := (i + 1) * WordSize
+	if offset > -target.MinOffset {
+		g.printf("  sub x17, x29, #%d", offset)
+		return "[x17]"
+	}
+	return fmt.Sprintf("[x29, #%d]", -offset)
+}
+
+func (g *Assembler) value(v ir.Value) {
+	switch op := v.Op(); op {
+	case llir.Prologue:
+		g.prologue(v.Func())
+	case llir.Epilogue:
+		// the frame is taken down by the return, once the result is
+		// moved out of the registers the epilogue restores
+	case llir.LoadImm:
+		c, _ := ir.Int64Value(v.Operand(0).Constant())
+		g.movImm(reg(v), c)
+	case llir.Copy:
+		g.printf("  mov %s, %s", reg(v), reg(v.Operand(0)))
+	case llir.FramePtr:
+		g.printf("  mov %s, x29", reg(v))
+	case llir.Load:
+		g.printf("  ldr %s, [%s, %s]", reg(v), reg(v.Operand(0)), operand(v.Operand(1)))
+	case llir.Store:
+		g.printf("  str %s, [%s, %s]", reg(v.Operand(0)), reg(v.Operand(1)), operand(v.Operand(2)))
+	case llir.Spill:
+		g.printf("  str %s, %s", reg(v.Operand(0)), g.local(v.Operand(1)))
+	case llir.Reload:
+		g.printf("  ldr %s, %s", reg(v), g.local(v.Operand(0)))
+	case llir.Add:
+		g.printf("  add %s, %s, %s", reg(v), reg(v.Operand(0)), operand(v.Operand(1)))
+	case llir.Sub:
+		g.printf("  sub %s, %s, %s", reg(v), reg(v.Operand(0)), operand(v.Operand(1)))
+	case llir.Mul:
+		g.printf("  mul %s, %s, %s", reg(v), reg(v.Operand(0)), reg(v.Operand(1)))
+	case llir.Div:
+		g.div(reg(v), reg(v.Operand(0)), reg(v.Operand(1)))
+	case llir.Neg:
+		g.printf("  neg %s, %s", reg(v), reg(v.Operand(0)))
+	case llir.Cmp:
+		g.printf("  cmp %s, %s", reg(v.Operand(0)), operand(v.Operand(1)))
+	case llir.SetEq, llir.SetNe, llir.SetLt, llir.SetLe, llir.SetGt, llir.SetGe:
+		g.printf("  cset %s, %s", reg(v), conds[op.(llir.Op)])
+	case llir.StoreArg:
+		// the args a C function takes on the stack are 8 bytes apart
+		// at the bottom of the frame, where the convention expects them
+		index, _ := ir.Int64Value(v.Operand(1).Constant())
+		g.printf("  str %s, [sp, #%d]", reg(v.Operand(0)), index*WordSize)
+	case llir.Call, llir.CallExtern:
+		// the args after the eighth of a CallExtern were stored by
+		// the StoreArgs before it
+		callee, _ := ir.FuncValue(v.Operand(0).Constant())
+		g.args(v.Operands()[1:])
+		g.printf("  bl %s", symbol("_"+callee.Name))
+		g.callResult(v)
+	case llir.Select:
+		g.selectgo(v)
+	default:
+		name, found := runtimeFuncs[op.(llir.Op)]
+		if !found {
+			panic("unknown op: " + op.String())
+		}
+		g.args(v.Operands())
+		g.printf("  bl _gosling_%s", name)
+		g.callResult(v)
+	}
+}
+
+// prologue makes the frame of the function, saves the callee saved
+// registers it uses, and moves the arguments to their registers.
+func (g *Assembler) prologue(fn *ir.Func) {
+	g.enter(fn.NumLocals())
+
+	entry := fn.BlockAt(0)
+	for i := 0; i < entry.NumParams(); i++ {
+		arg := entry.Param(i)
+		index, _ := ir.Int64Value(arg.Operand(0).Constant())
+		g.printf("  mov %s, %s", reg(arg), argRegs[index])
+	}
+}
+
+// args moves the values to the argument registers. The values are
+// never in argument registers, so they can be moved in any order.
+func (g *Assembler) args(args []ir.Value) {
+	for i, arg := range args {
+		g.printf("  mov %s, %s", argRegs[i], reg(arg))
+	}
+}
+
+// callResult moves the result of a call to the register of the value.
+func (g *Assembler) callResult(v ir.Value) {
+	if v.Type() != types.Void {
+		g.printf("  mov %s, x0", reg(v))
+	}
+}
+
+// selectgo pushes the cases of the select, each to its own 16 byte slot,
+// as they are when assembled from the stack operations, and passes the
+// runtime the stack pointer to them.
+func (g *Assembler) selectgo(v ir.Value) {
+	cases := v.Operands()[2:]
+	for _, c := range cases {
+		g.printf("  str %s, [sp, #-16]!", reg(c))
+	}
+	g.args(v.Operands()[:2])
+	g.printf("  mov x2, sp")
+	g.printf("  bl _gosling_selectgo")
+	if len(cases) > 0 {
+		g.printf("  add sp, sp, #%d", len(cases)*16)
+	}
+	g.callResult(v)
+}
+
+func (g *Assembler) terminator(blk *ir.Block) {
+	term := blk.Terminator()
+	switch op := term.Op(); op {
+	case llir.Jump:
+		for _, move := range regalloc.Moves(blk, scratch) {
+			g.printf("  mov x%d, x%d", move.Dst, move.Src)
+		}
+		g.Jump(blk.Successor(0).Name)
+	case llir.Return:
+		if term.NumOperands() > 0 {
+			g.printf("  mov x0, %s", reg(term.Operand(0)))
+		}
+		g.Epilogue()
+		g.Return()
+	default:
+		g.printf("  b.%s %s", conds[op.(llir.Op)], symbol(".L."+blk.Successor(0).Name))
+		g.printf("  b %s", symbol(".L."+blk.Successor(1).Name))
+	}
+}
@@ -0,0 +1,10 @@
+package aarch64
+
+import _ "embed"
+
+// Runtime is the C source of the runtime helpers called by the
+// generated assembly. It needs to be compiled and linked with the
+// assembly output, for example: `gcc -o prog prog.s runtime.c`
+//
+//go:embed runtime/runtime.c
+var Runtime []byte
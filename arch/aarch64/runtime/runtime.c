@@ -0,0 +1,434 @@
+// Runtime helpers for gosling programs compiled to native code.
+// Every gosling value is one 64 bit word.
+
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+
+typedef int64_t word;
+
+static void gosling_fatal(const char *msg) {
+  fprintf(stderr, "fatal error: %s\n", msg);
+  exit(2);
+}
+
+static void gopanic(word value, const char *err);
+
+// runtime_error panics with a runtime error, which runs the deferred calls
+// and can be recovered, like a call to panic. Recover returns -1 for it, as
+// on the VM.
+static void runtime_error(const char *err) {
+  gopanic(-1, err);
+}
+
+// Maps are open addressing hash tables with linear probing.
+// Deleted entries leave a tombstone behind so that probe sequences
+// are not broken, and the tombstones are cleared out on rehash.
+
+enum { EMPTY, FULL, DELETED };
+
+typedef struct {
+  word *keys;
+  word *vals;
+  uint8_t *state;
+  word size;
+  word count; // live entries
+  word used;  // live entries plus tombstones
+} gosling_map;
+
+// splitmix64 finalizer
+static uint64_t hash_word(word k) {
+  uint64_t x = (uint64_t)k;
+  x ^= x >> 30;
+  x *= 0xbf58476d1ce4e5b9ULL;
+  x ^= x >> 27;
+  x *= 0x94d049bb133111ebULL;
+  x ^= x >> 31;
+  return x;
+}
+
+static void map_alloc(gosling_map *m, word size) {
+  m->keys = calloc(size, sizeof(word));
+  m->vals = calloc(size, sizeof(word));
+  m->state = calloc(size, 1);
+  if (!m->keys || !m->vals || !m->state)
+    gosling_fatal("out of memory");
+  m->size = size;
+  m->count = 0;
+  m->used = 0;
+}
+
+// map_find returns the slot containing the key, or the slot the key
+// should be inserted into if it is not found.
+static word map_find(gosling_map *m, word key, int *found) {
+  word mask = m->size - 1;
+  word insert = -1;
+  for (word i = hash_word(key) & mask;; i = (i + 1) & mask) {
+    switch (m->state[i]) {
+    case EMPTY:
+      *found = 0;
+      return insert < 0 ? i : insert;
+    case DELETED:
+      if (insert < 0)
+        insert = i;
+      break;
+    case FULL:
+      if (m->keys[i] == key) {
+        *found = 1;
+        return i;
+      }
+    }
+  }
+}
+
+static void map_rehash(gosling_map *m) {
+  gosling_map old = *m;
+  word size = old.size;
+  if (old.count * 2 >= size)
+    size *= 2;
+
+  map_alloc(m, size);
+  for (word i = 0; i < old.size; i++) {
+    if (old.state[i] != FULL)
+      continue;
+    int found;
+    word j = map_find(m, old.keys[i], &found);
+    m->keys[j] = old.keys[i];
+    m->vals[j] = old.vals[i];
+    m->state[j] = FULL;
+    m->count++;
+    m->used++;
+  }
+
+  free(old.keys);
+  free(old.vals);
+  free(old.state);
+}
+
+gosling_map *gosling_makemap(word hint) {
+  word size = 8;
+  while (size * 3 / 4 < hint)
+    size *= 2;
+
+  gosling_map *m = malloc(sizeof(gosling_map));
+  if (!m)
+    gosling_fatal("out of memory");
+  map_alloc(m, size);
+  return m;
+}
+
+word gosling_mapaccess1(gosling_map *m, word key) {
+  if (!m)
+    return 0;
+  int found;
+  word i = map_find(m, key, &found);
+  return found ? m->vals[i] : 0;
+}
+
+word gosling_mapaccess2(gosling_map *m, word key, word *ok) {
+  if (!m) {
+    *ok = 0;
+    return 0;
+  }
+  int found;
+  word i = map_find(m, key, &found);
+  *ok = found;
+  return found ? m->vals[i] : 0;
+}
+
+// mapassign returns the map so consecutive map literal
+// elements can be assigned without reloading it.
+gosling_map *gosling_mapassign(gosling_map *m, word key, word val) {
+  if (!m)
+    runtime_error("assignment to entry in nil map");
+
+  int found;
+  word i = map_find(m, key, &found);
+  if (found) {
+    m->vals[i] = val;
+    return m;
+  }
+
+  if (m->state[i] == EMPTY) {
+    if ((m->used + 1) * 4 > m->size * 3) {
+      map_rehash(m);
+      i = map_find(m, key, &found);
+    }
+    m->used++;
+  }
+
+  m->keys[i] = key;
+  m->vals[i] = val;
+  m->state[i] = FULL;
+  m->count++;
+  return m;
+}
+
+void gosling_mapdelete(gosling_map *m, word key) {
+  if (!m)
+    return;
+  int found;
+  word i = map_find(m, key, &found);
+  if (!found)
+    return;
+  m->state[i] = DELETED;
+  m->count--;
+}
+
+word gosling_maplen(gosling_map *m) {
+  return m ? m->count : 0;
+}
+
+// Channels are ring buffers. Native code has no scheduler yet, so
+// there is only ever one goroutine, and any operation which would
+// block can never complete.
+
+typedef struct {
+  word *buf;
+  word size;
+  word head;
+  word count;
+  int closed;
+} gosling_chan;
+
+static void gosling_deadlock(void) {
+  fprintf(stderr, "fatal error: all goroutines are asleep - deadlock!\n");
+  exit(2);
+}
+
+gosling_chan *gosling_makechan(word size) {
+  if (size < 0)
+    runtime_error("makechan: size out of range");
+
+  gosling_chan *c = calloc(1, sizeof(gosling_chan));
+  if (!c)
+    gosling_fatal("out of memory");
+  if (size > 0) {
+    c->buf = calloc(size, sizeof(word));
+    if (!c->buf)
+      gosling_fatal("out of memory");
+  }
+  c->size = size;
+  return c;
+}
+
+static int chan_can_send(gosling_chan *c) {
+  if (c->closed)
+    runtime_error("send on closed channel");
+  return c->count < c->size;
+}
+
+static void chan_send(gosling_chan *c, word val) {
+  c->buf[(c->head + c->count) % c->size] = val;
+  c->count++;
+}
+
+static int chan_can_recv(gosling_chan *c) {
+  return c->count > 0 || c->closed;
+}
+
+static word chan_recv(gosling_chan *c, word *ok) {
+  if (c->count == 0) {
+    *ok = 0;
+    return 0;
+  }
+  word val = c->buf[c->head];
+  c->head = (c->head + 1) % c->size;
+  c->count--;
+  *ok = 1;
+  return val;
+}
+
+void gosling_chansend(gosling_chan *c, word val) {
+  if (!c || !chan_can_send(c))
+    gosling_deadlock();
+  chan_send(c, val);
+}
+
+word gosling_chanrecv1(gosling_chan *c) {
+  if (!c || !chan_can_recv(c))
+    gosling_deadlock();
+  word ok;
+  return chan_recv(c, &ok);
+}
+
+word gosling_chanrecv2(gosling_chan *c, word *ok) {
+  if (!c || !chan_can_recv(c))
+    gosling_deadlock();
+  return chan_recv(c, ok);
+}
+
+void gosling_closechan(gosling_chan *c) {
+  if (!c)
+    runtime_error("close of nil channel");
+  if (c->closed)
+    runtime_error("close of closed channel");
+  c->closed = 1;
+}
+
+// selectgo chooses the first ready case. The cases are (chan, value, kind)
+// triples on the caller's stack, pushed in order into 16 byte slots, so
+// the first one pushed is the deepest. The kind is 0 for send, 1 for
+// receive and 2 for receive into the address in value.
+word gosling_selectgo(word n, word has_default, word *sp) {
+  for (word i = 0; i < n; i++) {
+    word *slot = sp + (n - 1 - i) * 3 * 2;
+    word kind = slot[0];
+    word val = slot[2];
+    gosling_chan *c = (gosling_chan *)slot[4];
+    if (!c)
+      continue;
+
+    if (kind == 0) {
+      if (chan_can_send(c)) {
+        chan_send(c, val);
+        return i;
+      }
+    } else if (chan_can_recv(c)) {
+      word ok;
+      word v = chan_recv(c, &ok);
+      if (kind == 2)
+        *(word *)val = v;
+      return i;
+    }
+  }
+
+  if (!has_default)
+    gosling_deadlock();
+  return n;
+}
+
+// Deferred calls are kept in a linked list, innermost first, and are
+// identified with their frame by its frame pointer. Panics run the
+// deferred calls directly, and if one recovers, the frame which
+// deferred it is resumed at its deferreturn, as if it had returned
+// with a zero result.
+
+typedef word (*gosling_func)(word, word, word, word, word, word, word, word);
+
+typedef struct gosling_defer {
+  struct gosling_defer *next;
+  gosling_func fn;
+  word *fp;     // frame pointer of the deferring frame
+  word *sp;     // stack pointer to resume the frame with
+  void *resume; // the frame's deferreturn
+  int result;   // whether the frame pushes a result before deferreturn
+  word args[8];
+} gosling_defer;
+
+static gosling_defer *defers;
+
+static struct {
+  word value;
+  int active;
+  int recovered;
+  void *frame; // frame pointer of the caller of the running deferred call
+} panicking;
+
+// deferproc records a deferred call. The args were pushed in order
+// into 16 byte slots, so the last one is at args[0].
+void gosling_deferproc(gosling_func fn, word *fp, word *sp, void *resume, word nargs, word *args,
+                       word result) {
+  gosling_defer *d = calloc(1, sizeof(gosling_defer));
+  if (!d)
+    gosling_fatal("out of memory");
+  d->fn = fn;
+  d->fp = fp;
+  d->sp = sp;
+  d->resume = resume;
+  d->result = result;
+  for (word i = 0; i < nargs; i++)
+    d->args[i] = args[(nargs - 1 - i) * 2];
+  d->next = defers;
+  defers = d;
+}
+
+static void defer_call(gosling_defer *d) {
+  d->fn(d->args[0], d->args[1], d->args[2], d->args[3], d->args[4], d->args[5], d->args[6], d->args[7]);
+}
+
+void gosling_deferreturn(word *fp) {
+  while (defers && defers->fp == fp) {
+    gosling_defer *d = defers;
+    defers = d->next;
+    defer_call(d);
+    free(d);
+  }
+}
+
+// panic_call runs a deferred call for a panic. Its frame pointer is the
+// one the deferred function saves in its frame record, which is how
+// gorecover knows it is called directly by the deferred function.
+__attribute__((noinline)) static void panic_call(gosling_defer *d) {
+  panicking.frame = __builtin_frame_address(0);
+  d->fn(d->args[0], d->args[1], d->args[2], d->args[3], d->args[4], d->args[5], d->args[6], d->args[7]);
+}
+
+static void defer_resume(gosling_defer *d) {
+#if defined(__aarch64__)
+  word *sp = d->sp;
+  word *fp = d->fp;
+  void *pc = d->resume;
+  if (d->result)
+    sp[0] = 0;
+  free(d);
+
+  __asm__ volatile("mov sp, %0\n"
+                   "mov x29, %1\n"
+                   "br %2\n" ::"r"(sp),
+                   "r"(fp), "r"(pc));
+  __builtin_unreachable();
+#else
+  (void)d;
+  gosling_fatal("recover is not supported on this architecture");
+#endif
+}
+
+static void gopanic(word value, const char *err) {
+  panicking.value = value;
+  panicking.active = 1;
+  panicking.recovered = 0;
+
+  while (defers) {
+    gosling_defer *d = defers;
+    defers = d->next;
+    panic_call(d);
+
+    if (panicking.recovered) {
+      panicking.active = 0;
+      defer_resume(d);
+    }
+    free(d);
+  }
+
+  if (err)
+    fprintf(stderr, "panic: runtime error: %s\n", err);
+  else
+    fprintf(stderr, "panic: %lld\n", (long long)value);
+  exit(2);
+}
+
+void gosling_gopanic(word value) {
+  gopanic(value, NULL);
+}
+
+// gosling_panicdivide panics for a division by zero, which the code
+// checks for before each sdiv, since sdiv returns zero for it.
+void gosling_panicdivide(void) {
+  runtime_error("integer divide by zero");
+}
+
+// gorecover stops the panic, if it is called directly by the running
+// deferred call, as in Go. Each frame record holds the frame pointer of
+// the caller, so the frame which called gorecover must have been
+// called from panic_call.
+__attribute__((noinline)) word gosling_gorecover(void) {
+  if (!panicking.active || panicking.recovered)
+    return 0;
+  void **fp = __builtin_frame_address(0);
+  void **caller = fp[0];
+  if (caller[0] != panicking.frame)
+    return 0;
+  panicking.recovered = 1;
+  return panicking.value;
+}
@@ -2,6 +2,7 @@ package ast
 
 import (
 	"fmt"
+	"strconv"
 
 	"github.com/rj45/gosling/token"
 	"github.com/rj45/gosling/types"
@@ -28,7 +29,7 @@ const InvalidNode NodeID = 0
 // to identify child indices with a constant so the code is more
 // readable.
 type AST struct {
-	*token.File
+	*token.FileSet
 
 	// node is the list of nodes in post-order traversal order
 	node []node
@@ -39,12 +40,15 @@ type AST struct {
 
 	// typ is the type of each node indexed by NodeID
 	typ []types.Type
+
+	// consts is the value of each node which is a constant expression
+	consts map[NodeID]types.Const
 }
 
-// New creates a new AST from the source code
-func New(file *token.File) *AST {
+// New creates a new AST for source code from the files in the set
+func New(fset *token.FileSet) *AST {
 	return &AST{
-		File: file,
+		FileSet: fset,
 		node: []node{
 			// the first node is always an illegal node
 			newNode(IllegalNode, 0, 0),
@@ -83,6 +87,20 @@ func (a *AST) SetType(id NodeID, typ types.Type) {
 	a.typ[id] = typ
 }
 
+// Const returns the constant value of the given node, or nil if
+// it is not a constant expression
+func (a *AST) Const(id NodeID) types.Const {
+	return a.consts[id]
+}
+
+// SetConst sets the constant value of the given node
+func (a *AST) SetConst(id NodeID, c types.Const) {
+	if a.consts == nil {
+		a.consts = make(map[NodeID]types.Const)
+	}
+	a.consts[id] = c
+}
+
 // NumChildren returns the number of children for the given node
 func (a *AST) NumChildren(id NodeID) int {
 	start := a.node[id].firstChild()
@@ -126,21 +144,18 @@ func (a *AST) AddNode(kind Kind, token token.Token, children ...NodeID) NodeID {
 	return id
 }
 
-// PositionOf returns the line and column of the given token
-func (a *AST) PositionOf(tok token.Token) (line int, col int) {
-	offset := tok.Offset()
-	var lineoffset int
-	for i, ch := range a.Src {
-		if i >= offset {
-			break
-		}
-		if ch == '\n' {
-			line++
-			lineoffset = i
-		}
+// Clone adds a copy of the subtree at the given node to the AST and
+// returns the root of the copy
+func (a *AST) Clone(id NodeID) NodeID {
+	if id == InvalidNode {
+		return InvalidNode
+	}
+
+	children := make([]NodeID, a.NumChildren(id))
+	for i, child := range a.Children(id) {
+		children[i] = a.Clone(child)
 	}
-	col = offset - lineoffset
-	return line + 1, col + 1
+	return a.AddNode(a.Kind(id), a.Token(id), children...)
 }
 
 // NodeBytes returns a cheap byte slice of the node text
@@ -154,6 +169,13 @@ func (a *AST) NodeString(id NodeID) string {
 	return string(a.NodeBytes(id))
 }
 
+// ImportPath returns the path of an import spec or package path
+// without its quotes, and false if it is not a valid string literal.
+func (a *AST) ImportPath(id NodeID) (string, bool) {
+	path, err := strconv.Unquote(a.NodeString(id))
+	return path, err == nil
+}
+
 // String returns a string representation of the AST
 func (a *AST) String() string {
 	return a.nodeString(a.Root(), "")
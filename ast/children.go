@@ -1,20 +1,52 @@
 package ast
 
 const (
-	// DeclList has a list of Decl children
+	// Program has a list of Package children, with each package
+	// after the packages it imports, so the main package is last
 
-	// FuncDecl has Name child, FieldList of parameters, a Name of the return type, and a StmtList of the body
+	// Package has an optional Path Literal, which is nil for the main
+	// package, and the DeclList of each of its files
+	PackagePath  = 0
+	PackageFiles = 1
+
+	// DeclList has a list of Decl children, starting with the
+	// PackageClause and ImportDecls, if the file has them
+
+	// PackageClause has a Name child
+	PackageClauseName = 0
+
+	// ImportDecl has a list of string Literal children with import paths
+
+	// FuncDecl has Name child, FieldList of parameters, a Name of the return type, and a StmtList of the body,
+	// which is nil for an extern function defined outside of the program
 	FuncDeclName   = 0
 	FuncDeclParams = 1
 	FuncDeclRet    = 2
 	FuncDeclBody   = 3
 
+	// ConstDecl has a list of ConstSpec children
+
+	// ConstSpec has a Name child, an optional Type and a Value expression
+	ConstSpecName  = 0
+	ConstSpecType  = 1
+	ConstSpecValue = 2
+
 	// FieldList has a list of Field children
 
 	// Field has Name child and a Name of the type
 	FieldName = 0
 	FieldTyp  = 1
 
+	// MapType has a Key type child and an Elem type child
+	MapTypeKey  = 0
+	MapTypeElem = 1
+
+	// ChanType has an Elem type child
+	ChanTypeElem = 0
+
+	// PointerType has an Elem type child
+	PointerTypeElem = 0
+
 	// ExprList has a list of Expr children
 
 	// BinaryExpr has LHS and RHS children
@@ -57,4 +89,40 @@ const (
 	// CallExpr has Name child and an ExprList of arguments
 	CallExprName = 0
 	CallExprArgs = 1
+
+	// IndexExpr has Expr child and an Index child
+	IndexExprExpr  = 0
+	IndexExprIndex = 1
+
+	// CompositeLit has a Type child and an ExprList of KeyValueExpr elements
+	CompositeLitType  = 0
+	CompositeLitElems = 1
+
+	// KeyValueExpr has Key and Value children
+	KeyValueExprKey   = 0
+	KeyValueExprValue = 1
+
+	// SelectorExpr has an X child, which is the Name of a package, and the Sel Name
+	SelectorExprX   = 0
+	SelectorExprSel = 1
+
+	// RecvExpr has a Chan child
+	RecvExprChan = 0
+
+	// SendStmt has Chan and Value children
+	SendStmtChan  = 0
+	SendStmtValue = 1
+
+	// GoStmt has a CallExpr child
+	GoStmtCall = 0
+
+	// DeferStmt has a CallExpr child
+	DeferStmtCall = 0
+
+	// SelectStmt has a list of CommClause children
+
+	// CommClause has a Comm statement child, which is nil for the default
+	// case, and a StmtList of the Body
+	CommClauseComm = 0
+	CommClauseBody = 1
 )
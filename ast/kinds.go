@@ -9,18 +9,33 @@ const (
 	Literal
 	Name
 
+	Program
+	Package
 	DeclList
+	PackageClause
+	ImportDecl
 	FuncDecl
+	ConstDecl
+	ConstSpec
 
 	FieldList
 	Field
 
+	MapType
+	ChanType
+	PointerType
+
 	ExprList
 	BinaryExpr
 	UnaryExpr
 	DerefExpr
 	AddrExpr
 	CallExpr
+	IndexExpr
+	CompositeLit
+	KeyValueExpr
+	RecvExpr
+	SelectorExpr
 
 	StmtList
 	EmptyStmt
@@ -29,29 +44,53 @@ const (
 	ReturnStmt
 	IfExpr
 	ForStmt
+	SendStmt
+	GoStmt
+	DeferStmt
+	SelectStmt
+	CommClause
 )
 
 var kindNames = []string{
-	IllegalNode: "IllegalNode",
-	Literal:     "Literal",
-	Name:        "Name",
-	DeclList:    "DeclList",
-	FuncDecl:    "FuncDecl",
-	FieldList:   "FieldList",
-	Field:       "Field",
-	ExprList:    "ExprList",
-	BinaryExpr:  "BinaryExpr",
-	UnaryExpr:   "UnaryExpr",
-	DerefExpr:   "DerefExpr",
-	AddrExpr:    "AddrExpr",
-	CallExpr:    "CallExpr",
-	StmtList:    "StmtList",
-	EmptyStmt:   "EmptyStmt",
-	ExprStmt:    "ExprStmt",
-	AssignStmt:  "AssignStmt",
-	ReturnStmt:  "ReturnStmt",
-	IfExpr:      "IfExpr",
-	ForStmt:     "ForStmt",
+	IllegalNode:   "IllegalNode",
+	Literal:       "Literal",
+	Name:          "Name",
+	Program:       "Program",
+	Package:       "Package",
+	DeclList:      "DeclList",
+	PackageClause: "PackageClause",
+	ImportDecl:    "ImportDecl",
+	FuncDecl:      "FuncDecl",
+	ConstDecl:     "ConstDecl",
+	ConstSpec:     "ConstSpec",
+	FieldList:     "FieldList",
+	Field:         "Field",
+	MapType:       "MapType",
+	ChanType:      "ChanType",
+	PointerType:   "PointerType",
+	ExprList:      "ExprList",
+	BinaryExpr:    "BinaryExpr",
+	UnaryExpr:     "UnaryExpr",
+	DerefExpr:     "DerefExpr",
+	AddrExpr:      "AddrExpr",
+	CallExpr:      "CallExpr",
+	IndexExpr:     "IndexExpr",
+	CompositeLit:  "CompositeLit",
+	KeyValueExpr:  "KeyValueExpr",
+	RecvExpr:      "RecvExpr",
+	SelectorExpr:  "SelectorExpr",
+	StmtList:      "StmtList",
+	EmptyStmt:     "EmptyStmt",
+	ExprStmt:      "ExprStmt",
+	AssignStmt:    "AssignStmt",
+	ReturnStmt:    "ReturnStmt",
+	IfExpr:        "IfExpr",
+	ForStmt:       "ForStmt",
+	SendStmt:      "SendStmt",
+	GoStmt:        "GoStmt",
+	DeferStmt:     "DeferStmt",
+	SelectStmt:    "SelectStmt",
+	CommClause:    "CommClause",
 }
 
 func (k Kind) String() string {
@@ -11,6 +11,8 @@ const (
 	FuncSymbol
 	ConstSymbol
 	TypeSymbol
+	BuiltinSymbol
+	PackageSymbol
 )
 
 type Symbol struct {
@@ -21,6 +23,14 @@ type Symbol struct {
 	Type   types.Type
 	Const  types.Const
 	Offset int
+
+	// Pkg is the scope of the declarations of an imported package,
+	// and Path is its import path
+	Pkg  ScopeID
+	Path string
+
+	// Extern is whether a function is defined outside of the program
+	Extern bool
 }
 
 type SymbolID uint32
@@ -63,9 +73,19 @@ func NewSymTab() *SymTab {
 	symtab.NewSymbol("true", ConstSymbol, types.Bool).Const = types.BoolConst(true)
 	symtab.NewSymbol("false", ConstSymbol, types.Bool).Const = types.BoolConst(false)
 
+	// the value of iota depends on the constant declaration it is used in
+	symtab.NewSymbol("iota", ConstSymbol, types.UntypedInt)
+
 	symtab.NewSymbol("int", TypeSymbol, types.Int)
 	symtab.NewSymbol("bool", TypeSymbol, types.Bool)
 
+	symtab.NewSymbol("make", BuiltinSymbol, types.None)
+	symtab.NewSymbol("len", BuiltinSymbol, types.None)
+	symtab.NewSymbol("delete", BuiltinSymbol, types.None)
+	symtab.NewSymbol("close", BuiltinSymbol, types.None)
+	symtab.NewSymbol("panic", BuiltinSymbol, types.None)
+	symtab.NewSymbol("recover", BuiltinSymbol, types.None)
+
 	return symtab
 }
 
@@ -92,6 +112,12 @@ func (t *SymTab) EnterScope(node NodeID) {
 	t.scopes[scope].node = node
 }
 
+// NodeScope returns the scope which was entered for the node,
+// or InvalidScope if there is none.
+func (t *SymTab) NodeScope(node NodeID) ScopeID {
+	return t.nodeScope[node]
+}
+
 func (t *SymTab) LeaveScope() {
 	t.scope = t.scopes[t.scope].parent
 }
@@ -117,12 +143,7 @@ func (t *SymTab) ScopeNode(id ScopeID) NodeID {
 }
 
 func (t *SymTab) LocalScope() ScopeID {
-	for scope := t.scope; t.scopes[scope].level > InvalidScope; scope = t.scopes[scope].parent {
-		if t.scopes[scope].level == LocalScope {
-			return scope
-		}
-	}
-	return InvalidScope
+	return t.localScopeOf(t.scope)
 }
 
 func (t *SymTab) StackSize() int {
@@ -135,6 +156,33 @@ func (t *SymTab) StackSize() int {
 	return localScope.nextOffset
 }
 
+// LocalTypes returns the types of the locals in the current function,
+// indexed by their offset.
+func (t *SymTab) LocalTypes() []types.Type {
+	localScopeID := t.LocalScope()
+	if localScopeID == InvalidScope {
+		return nil
+	}
+
+	locals := make([]types.Type, t.scopes[localScopeID].nextOffset)
+	for _, sym := range t.sym {
+		if sym.Kind != VarSymbol || t.localScopeOf(sym.Scope) != localScopeID {
+			continue
+		}
+		locals[sym.Offset] = sym.Type
+	}
+	return locals
+}
+
+func (t *SymTab) localScopeOf(id ScopeID) ScopeID {
+	for scope := id; t.scopes[scope].level > InvalidScope; scope = t.scopes[scope].parent {
+		if t.scopes[scope].level == LocalScope {
+			return scope
+		}
+	}
+	return InvalidScope
+}
+
 func (t *SymTab) Lookup(name string) *Symbol {
 	for scope := &t.scopes[t.scope]; scope.level > InvalidScope; scope = &t.scopes[scope.parent] {
 		if id, ok := scope.nameSym[name]; ok {
@@ -145,12 +193,44 @@ func (t *SymTab) Lookup(name string) *Symbol {
 	return nil
 }
 
+// LookupQualified looks up a name declared by the imported package pkg.
+func (t *SymTab) LookupQualified(pkg string, name string) *Symbol {
+	sym := t.Lookup(pkg)
+	if sym == nil || sym.Kind != PackageSymbol {
+		return nil
+	}
+	if id, ok := t.scopes[sym.Pkg].nameSym[name]; ok {
+		return &t.sym[id]
+	}
+	return nil
+}
+
+// ScopeSymbols returns the symbols declared in a scope, in the
+// order they were declared.
+func (t *SymTab) ScopeSymbols(id ScopeID) []*Symbol {
+	var syms []*Symbol
+	for i := range t.sym {
+		if t.sym[i].Scope == id {
+			syms = append(syms, &t.sym[i])
+		}
+	}
+	return syms
+}
+
+// IsExported returns whether a name declared by a package can be
+// used by the packages which import it, which is the case if it
+// starts with an upper case letter.
+func IsExported(name string) bool {
+	return len(name) > 0 && name[0] >= 'A' && name[0] <= 'Z'
+}
+
 func (t *SymTab) NewSymbol(name string, kind SymbolKind, typ types.Type) *Symbol {
 	id := SymbolID(len(t.sym))
 
 	offset := 0
 	localScopeID := t.LocalScope()
-	if localScopeID != InvalidScope {
+	if localScopeID != InvalidScope && kind == VarSymbol {
+		// only variables take up space on the stack
 		localScope := &t.scopes[localScopeID]
 		offset = localScope.nextOffset
 		localScope.nextOffset++
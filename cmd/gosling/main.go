@@ -0,0 +1,193 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rj45/gosling/arch/aarch64"
+	"github.com/rj45/gosling/compile"
+	"github.com/rj45/gosling/ir"
+	"github.com/rj45/gosling/object"
+	"github.com/rj45/gosling/token"
+)
+
+func main() {
+	optLevel := flag.Int("O", 0, "optimization `level`: 1 optimizes the functions, and compiles them to registers for the vm")
+	verify := flag.Bool("verify", false, "verify the IR after each pass, to debug the compiler")
+	dumpBefore := flag.String("dump-before", "", "comma separated `passes` to dump the IR before")
+	dumpAfter := flag.String("dump-after", "", "comma separated `passes` to dump the IR after, such as regalloc")
+	timePasses := flag.Bool("time-passes", false, "report the time each pass takes")
+	flag.Parse()
+
+	opts := ir.PassOptions{
+		OptLevel:   *optLevel,
+		Verify:     *verify,
+		DumpBefore: passNames(*dumpBefore),
+		DumpAfter:  passNames(*dumpAfter),
+	}
+	if *timePasses {
+		opts.Times = &ir.PassTimes{}
+	}
+
+	args := flag.Args()
+	var errs []error
+	switch args[0] {
+	case "build":
+		errs = build(args[1:], opts)
+	case "link":
+		errs = link(args[1:])
+	default:
+		errs = compileAll(args[0], opts)
+	}
+
+	if times := opts.Times; times != nil {
+		times.Report(os.Stderr)
+	}
+
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+}
+
+// passNames splits a comma separated list of the names of passes.
+func passNames(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
+
+// compileAll compiles a whole program to aarch64 assembly, either from
+// the source given on the command line, or from the directory of a
+// module with the main package in it.
+func compileAll(arg string, opts ir.PassOptions) []error {
+	asm := &aarch64.Assembler{Out: os.Stdout}
+
+	if info, err := os.Stat(arg); err == nil && info.IsDir() {
+		return compile.CompilePackage(os.DirFS(arg), ".", asm, opts)
+	}
+
+	file := token.NewFile("test.gos", []byte(arg))
+	return compile.Compile(file, asm, opts)
+}
+
+// build compiles each package of the module in a directory into an
+// object, skipping the packages which are up to date.
+//
+//	gosling build [-target aarch64|vm] [-o objdir] [dir]
+func build(args []string, opts ir.PassOptions) []error {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	target := flags.String("target", object.AArch64, "target to compile for: aarch64 or vm")
+	objdir := flags.String("o", "obj", "directory to write the objects to")
+	flags.Parse(args)
+
+	dir := "."
+	if flags.NArg() > 0 {
+		dir = flags.Arg(0)
+	}
+
+	prev := make(map[string]*object.Object)
+	filepath.Walk(*objdir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".o" {
+			return nil
+		}
+		if obj, err := readObject(path); err == nil {
+			prev[obj.Path] = obj
+		}
+		return nil
+	})
+
+	objs, errs := compile.Build(os.DirFS(dir), ".", *target, prev, opts)
+	if errs != nil {
+		return errs
+	}
+
+	for path, obj := range objs {
+		if obj == prev[path] {
+			continue
+		}
+
+		filename := filepath.Join(*objdir, "main.o")
+		if path != "" {
+			filename = filepath.Join(*objdir, "pkg", filepath.FromSlash(path)+".o")
+		}
+		if err := writeFile(filename, obj.Write); err != nil {
+			return []error{err}
+		}
+	}
+	return nil
+}
+
+// link links objects into a program, which is assembly for the
+// aarch64 target, or a linked object for the vm target.
+//
+//	gosling link [-o out] objects...
+func link(args []string) []error {
+	flags := flag.NewFlagSet("link", flag.ExitOnError)
+	out := flags.String("o", "", "file to write the program to, instead of stdout")
+	flags.Parse(args)
+
+	var objs []*object.Object
+	for _, filename := range flags.Args() {
+		obj, err := readObject(filename)
+		if err != nil {
+			return []error{err}
+		}
+		objs = append(objs, obj)
+	}
+
+	linked, err := object.Link(objs...)
+	if err != nil {
+		return []error{err}
+	}
+
+	write := linked.Write
+	if linked.Target == object.AArch64 {
+		write = func(w io.Writer) error {
+			_, err := io.WriteString(w, linked.Asm)
+			return err
+		}
+	}
+
+	if *out == "" {
+		if err := write(os.Stdout); err != nil {
+			return []error{err}
+		}
+		return nil
+	}
+	if err := writeFile(*out, write); err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
+func readObject(filename string) (*object.Object, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return object.Read(f)
+}
+
+func writeFile(filename string, write func(io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -2,6 +2,7 @@ package codegen
 
 import (
 	"github.com/rj45/gosling/ast"
+	"github.com/rj45/gosling/token"
 	"github.com/rj45/gosling/types"
 )
 
@@ -10,7 +11,7 @@ type Assembly interface {
 
 	Types(*types.Universe)
 
-	Prologue(string, int)
+	Prologue(string, []types.Type)
 	Epilogue()
 
 	Push()
@@ -37,13 +38,44 @@ type Assembly interface {
 	Gt()
 	Ge()
 
+	MakeMap(types.Type)
+	MapIndex()
+	MapIndexOk()
+	MapAssign()
+	MapDelete()
+	MapLen()
+
+	MakeChan(types.Type)
+	ChanSend()
+	ChanRecv()
+	ChanRecv2()
+	ChanClose()
+	Select()
+
 	Call(string)
+	CallExtern(string)
+	Go(string)
+	Defer(string)
+	Panic()
+	Recover()
 	JumpToEpilogue()
 	JumpIf(string, string, int)
 	Jump(string, int)
 	Label(string, int)
 
+	// Pos sets the token of the source the following code is
+	// generated for.
+	Pos(token.Token)
+
+	// Stmt marks the start of a statement at the token.
+	Stmt(token.Token)
+
 	DeclareFunction(string, types.Type)
+	DeclareExtern(string, types.Type)
+
+	// NoInline marks the declared function as never to be inlined,
+	// for its //gosling:noinline directive.
+	NoInline(string)
 }
 
 type CodeGen struct {
@@ -52,6 +84,9 @@ type CodeGen struct {
 	asm    Assembly
 	types  *types.Universe
 	label  int
+
+	// tok is the token of the node code is being generated for
+	tok token.Token
 }
 
 func New(ast *ast.AST, symtab *ast.SymTab, types *types.Universe, asm Assembly) *CodeGen {
@@ -65,5 +100,31 @@ func New(ast *ast.AST, symtab *ast.SymTab, types *types.Universe, asm Assembly)
 
 func (g *CodeGen) Generate() {
 	g.asm.Types(g.types)
-	g.genDeclList(g.ast.Root())
+
+	root := g.ast.Root()
+	if g.ast.Kind(root) == ast.Program {
+		g.genProgram(root)
+		return
+	}
+	g.genDeclList(root)
+}
+
+// GeneratePackage generates the code of a single package of the program,
+// which was checked on its own against the exports of its imports.
+func (g *CodeGen) GeneratePackage(pkg ast.NodeID) {
+	g.asm.Types(g.types)
+	g.genPackage(pkg)
+}
+
+// at sets the position of the code generated for the node to its token,
+// and returns a function which restores the position of the enclosing
+// node, to be deferred until the node is generated.
+func (g *CodeGen) at(node ast.NodeID) func() {
+	prev := g.tok
+	g.tok = g.ast.Token(node)
+	g.asm.Pos(g.tok)
+	return func() {
+		g.tok = prev
+		g.asm.Pos(prev)
+	}
 }
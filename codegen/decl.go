@@ -1,54 +1,177 @@
 package codegen
 
-import "github.com/rj45/gosling/ast"
+import (
+	"github.com/rj45/gosling/ast"
+)
+
+// genProgram generates the functions of every package of the program.
+func (g *CodeGen) genProgram(node ast.NodeID) {
+	pkgs := g.ast.Children(node)
+
+	// declare all functions first, so they can be called from any package
+	for _, pkg := range pkgs {
+		g.symtab.EnterScope(pkg)
+		g.declareFuncs(g.ast.Children(pkg)[ast.PackageFiles:]...)
+		g.symtab.LeaveScope()
+	}
+
+	for _, pkg := range pkgs {
+		g.symtab.EnterScope(pkg)
+		g.genFuncs(g.ast.Children(pkg)[ast.PackageFiles:]...)
+		g.symtab.LeaveScope()
+	}
+}
+
+// genPackage generates the functions of a package compiled on its own.
+// The functions of the packages it imports are only declared, so they
+// can be called, and are linked with the package later.
+func (g *CodeGen) genPackage(node ast.NodeID) {
+	g.symtab.EnterScope(node)
+	defer g.symtab.LeaveScope()
+
+	for _, imp := range g.symtab.ScopeSymbols(g.symtab.Scope()) {
+		if imp.Kind != ast.PackageSymbol {
+			continue
+		}
+		for _, sym := range g.symtab.ScopeSymbols(imp.Pkg) {
+			switch {
+			case sym.Kind == ast.FuncSymbol && sym.Extern:
+				g.asm.DeclareExtern(sym.Name, sym.Type)
+			case sym.Kind == ast.FuncSymbol:
+				g.asm.DeclareFunction(imp.Path+"."+sym.Name, sym.Type)
+			}
+		}
+	}
+
+	files := g.ast.Children(node)[ast.PackageFiles:]
+	g.declareFuncs(files...)
+	g.genFuncs(files...)
+}
 
 func (g *CodeGen) genDeclList(node ast.NodeID) {
 	g.symtab.EnterScope(node)
 	defer g.symtab.LeaveScope()
 
-	decls := g.ast.Children(node)
+	g.declareFuncs(node)
+	g.genFuncs(node)
+}
 
-	// declare all functions first
-	for _, decl := range decls {
-		g.asm.DeclareFunction(g.ast.NodeString(g.ast.Child(decl, ast.FuncDeclName)), g.ast.Type(decl))
-	}
+func (g *CodeGen) declareFuncs(files ...ast.NodeID) {
+	for _, file := range files {
+		for _, decl := range g.ast.Children(file) {
+			if g.ast.Kind(decl) != ast.FuncDecl {
+				continue
+			}
+			name := g.funcName(g.ast.Child(decl, ast.FuncDeclName))
+			if g.ast.Child(decl, ast.FuncDeclBody) == ast.InvalidNode {
+				g.asm.DeclareExtern(name, g.ast.Type(decl))
+				continue
+			}
+			g.asm.DeclareFunction(name, g.ast.Type(decl))
 
-	// then generate main func
-	for _, decl := range decls {
-		if g.ast.Kind(decl) != ast.FuncDecl {
-			continue
+			tok := g.ast.Token(decl)
+			for _, directive := range g.ast.File(tok).Directives(tok) {
+				if directive == "noinline" {
+					g.asm.NoInline(name)
+				}
+			}
 		}
-		if g.ast.NodeString(g.ast.Child(decl, ast.FuncDeclName)) != "main" {
-			continue
+	}
+}
+
+func (g *CodeGen) genFuncs(files ...ast.NodeID) {
+	// generate main func first
+	for _, file := range files {
+		for _, decl := range g.ast.Children(file) {
+			if g.isMain(decl) {
+				g.genDecl(decl)
+			}
 		}
-		g.genDecl(decl)
 	}
 
-	// then generate other funcs
-	for _, decl := range decls {
-		if g.ast.Kind(decl) == ast.FuncDecl && g.ast.NodeString(g.ast.Child(decl, ast.FuncDeclName)) == "main" {
-			continue
+	// then generate other decls
+	for _, file := range files {
+		for _, decl := range g.ast.Children(file) {
+			if !g.isMain(decl) {
+				g.genDecl(decl)
+			}
 		}
-		g.genDecl(decl)
 	}
 }
 
+func (g *CodeGen) isMain(decl ast.NodeID) bool {
+	return g.ast.Kind(decl) == ast.FuncDecl && g.funcName(g.ast.Child(decl, ast.FuncDeclName)) == "main"
+}
+
+// funcName returns the name of the function in the program, which is
+// qualified by the import path of its package unless that is the main
+// package, or the function is an extern function, whose name is its
+// symbol. Packages may share a name, but not an import path.
+func (g *CodeGen) funcName(name ast.NodeID) string {
+	sym := g.funcSym(name)
+	if sym.Extern {
+		return sym.Name
+	}
+
+	if g.ast.Kind(name) == ast.SelectorExpr {
+		// the main package cannot be imported, so a selected
+		// function is always qualified by its package
+		x := g.ast.NodeString(g.ast.Child(name, ast.SelectorExprX))
+		return g.symtab.Lookup(x).Path + "." + sym.Name
+	}
+
+	// the main package has no import path
+	pkg := g.symtab.ScopeNode(sym.Scope)
+	if g.ast.Kind(pkg) != ast.Package {
+		return sym.Name
+	}
+	lit := g.ast.Child(pkg, ast.PackagePath)
+	if lit == ast.InvalidNode {
+		return sym.Name
+	}
+	path, _ := g.ast.ImportPath(lit)
+	return path + "." + sym.Name
+}
+
+// funcSym returns the symbol of a function, whose name may be
+// qualified by its package.
+func (g *CodeGen) funcSym(name ast.NodeID) *ast.Symbol {
+	if g.ast.Kind(name) == ast.SelectorExpr {
+		x := g.ast.NodeString(g.ast.Child(name, ast.SelectorExprX))
+		sel := g.ast.NodeString(g.ast.Child(name, ast.SelectorExprSel))
+		return g.symtab.LookupQualified(x, sel)
+	}
+	return g.symtab.Lookup(g.ast.NodeString(name))
+}
+
 func (g *CodeGen) genDecl(node ast.NodeID) {
 	switch g.ast.Kind(node) {
 	case ast.FuncDecl:
 		g.genFuncDecl(node)
+	case ast.PackageClause, ast.ImportDecl:
+		// packages are resolved by the type checker
+	case ast.ConstDecl:
+		// constants are folded into the expressions which use them
 	default:
 		panic("unknown decl kind")
 	}
 }
 
 func (g *CodeGen) genFuncDecl(node ast.NodeID) {
+	if g.ast.Child(node, ast.FuncDeclBody) == ast.InvalidNode {
+		// extern functions are only declared
+		return
+	}
+
+	// the name is looked up before a parameter can shadow it
+	name := g.funcName(g.ast.Child(node, ast.FuncDeclName))
+
+	defer g.at(node)()
+
 	g.symtab.EnterScope(node)
 	defer g.symtab.LeaveScope()
 
-	name := g.ast.Child(node, ast.FuncDeclName)
-
-	g.asm.Prologue(g.ast.NodeString(name), g.symtab.StackSize())
+	g.asm.Prologue(name, g.symtab.LocalTypes())
 
 	paramList := g.ast.Child(node, ast.FuncDeclParams)
 	for i, param := range g.ast.Children(paramList) {
@@ -1,6 +1,8 @@
 package codegen
 
 import (
+	"strconv"
+
 	"github.com/rj45/gosling/ast"
 	"github.com/rj45/gosling/token"
 	"github.com/rj45/gosling/types"
@@ -41,6 +43,14 @@ func (g *CodeGen) localOffset(node ast.NodeID) int {
 }
 
 func (g *CodeGen) genExpr(node ast.NodeID) {
+	defer g.at(node)()
+
+	if c := g.ast.Const(node); c != nil {
+		// constant expressions were evaluated by the type checker
+		g.genConst(c)
+		return
+	}
+
 	switch g.ast.Kind(node) {
 	case ast.BinaryExpr:
 		g.genExpr(g.ast.Child(node, ast.BinaryExprLHS))
@@ -85,20 +95,25 @@ func (g *CodeGen) genExpr(node ast.NodeID) {
 	case ast.Literal:
 		g.asm.LoadInt(g.ast.NodeString(node))
 	case ast.Name:
-		sym := g.symtab.Lookup(g.ast.NodeString(node))
-		if sym.Const != nil {
-			g.genConst(sym.Const)
-			return
-		}
 		g.asm.LoadLocal(g.localOffset(node))
 	case ast.CallExpr:
 		g.genCallExpr(node)
+	case ast.IndexExpr:
+		g.genArgs(g.ast.Child(node, ast.IndexExprExpr), g.ast.Child(node, ast.IndexExprIndex))
+		g.asm.MapIndex()
+	case ast.CompositeLit:
+		g.genCompositeLit(node)
+	case ast.RecvExpr:
+		g.genExpr(g.ast.Child(node, ast.RecvExprChan))
+		g.asm.ChanRecv()
 	default:
 		panic("unknown expr kind")
 	}
 }
 
 func (g *CodeGen) genAddr(node ast.NodeID) {
+	defer g.at(node)()
+
 	switch g.ast.Kind(node) {
 	case ast.Name:
 		g.asm.LocalAddr(g.localOffset(node))
@@ -129,14 +144,91 @@ func (g *CodeGen) genConst(c types.Const) {
 func (g *CodeGen) genCallExpr(node ast.NodeID) {
 	name := g.ast.Child(node, ast.CallExprName)
 	argList := g.ast.Child(node, ast.CallExprArgs)
-	for _, arg := range g.ast.Children(argList) {
-		g.genExpr(arg)
+
+	if g.ast.Kind(name) == ast.Name {
+		sym := g.symtab.Lookup(g.ast.NodeString(name))
+		if sym.Kind == ast.BuiltinSymbol {
+			g.genBuiltinCall(sym.Name, g.ast.Children(argList))
+			return
+		}
+	}
+
+	if g.funcSym(name).Extern {
+		// the arguments are left on the stack for the backend
+		for _, arg := range g.ast.Children(argList) {
+			g.genExpr(arg)
+			g.asm.Push()
+		}
+		g.asm.CallExtern(g.funcName(name))
+		return
+	}
+
+	g.genArgs(g.ast.Children(argList)...)
+
+	g.asm.Call(g.funcName(name))
+}
+
+// genArgs evaluates each node, leaving the results in registers 0 through n-1
+func (g *CodeGen) genArgs(nodes ...ast.NodeID) {
+	for _, node := range nodes {
+		g.genExpr(node)
 		g.asm.Push()
 	}
 
-	for i := len(g.ast.Children(argList)) - 1; i >= 0; i-- {
+	for i := len(nodes) - 1; i >= 0; i-- {
 		g.asm.Pop(i)
 	}
+}
 
-	g.asm.Call(g.ast.NodeString(name))
+func (g *CodeGen) genBuiltinCall(name string, args []ast.NodeID) {
+	switch name {
+	case "make":
+		if len(args) > 1 {
+			g.genExpr(args[1])
+		} else {
+			g.asm.LoadInt("0")
+		}
+		typ := g.ast.Type(args[0])
+		if g.types.IsChan(typ) {
+			g.asm.MakeChan(typ)
+		} else {
+			g.asm.MakeMap(typ)
+		}
+	case "len":
+		g.genExpr(args[0])
+		g.asm.MapLen()
+	case "delete":
+		g.genArgs(args...)
+		g.asm.MapDelete()
+	case "close":
+		g.genExpr(args[0])
+		g.asm.ChanClose()
+	case "panic":
+		g.genExpr(args[0])
+		g.asm.Panic()
+	case "recover":
+		g.asm.Recover()
+	default:
+		panic("unknown builtin " + name)
+	}
+}
+
+func (g *CodeGen) genCompositeLit(node ast.NodeID) {
+	elems := g.ast.Children(g.ast.Child(node, ast.CompositeLitElems))
+
+	g.asm.LoadInt(strconv.Itoa(len(elems)))
+	g.asm.MakeMap(g.ast.Type(node))
+
+	// MapAssign leaves the map in the first register for the next element
+	for _, elem := range elems {
+		g.asm.Push()
+		g.genExpr(g.ast.Child(elem, ast.KeyValueExprKey))
+		g.asm.Push()
+		g.genExpr(g.ast.Child(elem, ast.KeyValueExprValue))
+		g.asm.Push()
+		g.asm.Pop(2)
+		g.asm.Pop(1)
+		g.asm.Pop(0)
+		g.asm.MapAssign()
+	}
 }
@@ -1,6 +1,10 @@
 package codegen
 
-import "github.com/rj45/gosling/ast"
+import (
+	"strconv"
+
+	"github.com/rj45/gosling/ast"
+)
 
 func (g *CodeGen) genStmtList(node ast.NodeID, last bool) {
 	g.symtab.EnterScope(node)
@@ -13,7 +17,14 @@ func (g *CodeGen) genStmtList(node ast.NodeID, last bool) {
 }
 
 func (g *CodeGen) genStmt(node ast.NodeID, last bool) {
-	switch g.ast.Kind(node) {
+	defer g.at(node)()
+
+	kind := g.ast.Kind(node)
+	if kind != ast.StmtList && kind != ast.EmptyStmt && kind != ast.ConstDecl {
+		g.asm.Stmt(g.ast.Token(node))
+	}
+
+	switch kind {
 	case ast.ExprStmt:
 		g.genExpr(g.ast.Child(node, ast.ExprStmtExpr))
 	case ast.AssignStmt:
@@ -26,7 +37,16 @@ func (g *CodeGen) genStmt(node ast.NodeID, last bool) {
 		g.genForStmt(node)
 	case ast.StmtList:
 		g.genStmtList(node, last)
-	case ast.EmptyStmt:
+	case ast.SendStmt:
+		g.genArgs(g.ast.Child(node, ast.SendStmtChan), g.ast.Child(node, ast.SendStmtValue))
+		g.asm.ChanSend()
+	case ast.GoStmt:
+		g.genGoStmt(node)
+	case ast.DeferStmt:
+		g.genDeferStmt(node)
+	case ast.SelectStmt:
+		g.genSelectStmt(node)
+	case ast.EmptyStmt, ast.ConstDecl:
 		// do nothing
 	default:
 		panic("unknown stmt kind")
@@ -34,6 +54,19 @@ func (g *CodeGen) genStmt(node ast.NodeID, last bool) {
 }
 
 func (g *CodeGen) genAssignStmt(node ast.NodeID) {
+	lhs := g.ast.Child(node, ast.AssignStmtLHS)
+	rhs := g.ast.Child(node, ast.AssignStmtRHS)
+
+	switch g.ast.Kind(lhs) {
+	case ast.IndexExpr:
+		g.genArgs(g.ast.Child(lhs, ast.IndexExprExpr), g.ast.Child(lhs, ast.IndexExprIndex), rhs)
+		g.asm.MapAssign()
+		return
+	case ast.ExprList:
+		g.genCommaOk(node)
+		return
+	}
+
 	g.genAddr(g.ast.Child(node, ast.AssignStmtLHS))
 	g.asm.Push()
 	g.genExpr(g.ast.Child(node, ast.AssignStmtRHS))
@@ -41,6 +74,45 @@ func (g *CodeGen) genAssignStmt(node ast.NodeID) {
 	g.asm.Store()
 }
 
+// genCommaOk generates `v, ok = m[k]` or `v, ok = <-ch`, where the
+// address of ok is passed to the runtime to store whether the key was
+// found or the value was received
+func (g *CodeGen) genCommaOk(node ast.NodeID) {
+	lhs := g.ast.Children(g.ast.Child(node, ast.AssignStmtLHS))
+	rhs := g.ast.Child(node, ast.AssignStmtRHS)
+
+	g.genAddr(lhs[0])
+	g.asm.Push()
+
+	if g.ast.Kind(rhs) == ast.RecvExpr {
+		g.genExpr(g.ast.Child(rhs, ast.RecvExprChan))
+		g.asm.Push()
+		g.genAddr(lhs[1])
+		g.asm.Push()
+		g.asm.Pop(1)
+		g.asm.Pop(0)
+		g.asm.ChanRecv2()
+
+		g.asm.Pop(1)
+		g.asm.Store()
+		return
+	}
+
+	g.genExpr(g.ast.Child(rhs, ast.IndexExprExpr))
+	g.asm.Push()
+	g.genExpr(g.ast.Child(rhs, ast.IndexExprIndex))
+	g.asm.Push()
+	g.genAddr(lhs[1])
+	g.asm.Push()
+	g.asm.Pop(2)
+	g.asm.Pop(1)
+	g.asm.Pop(0)
+	g.asm.MapIndexOk()
+
+	g.asm.Pop(1)
+	g.asm.Store()
+}
+
 func (g *CodeGen) genReturnStmt(node ast.NodeID, last bool) {
 	for _, child := range g.ast.Children(node) {
 		g.genExpr(child)
@@ -80,3 +152,124 @@ func (g *CodeGen) genForStmt(node ast.NodeID) {
 	g.asm.Jump("loop", label)
 	g.asm.Label("endloop", label)
 }
+
+func (g *CodeGen) genGoStmt(node ast.NodeID) {
+	call := g.ast.Child(node, ast.GoStmtCall)
+	name := g.ast.Child(call, ast.CallExprName)
+
+	g.genArgs(g.ast.Children(g.ast.Child(call, ast.CallExprArgs))...)
+	g.asm.Go(g.funcName(name))
+}
+
+// genDeferStmt evaluates the arguments of the deferred call now,
+// and the call itself is made when the function returns or panics
+func (g *CodeGen) genDeferStmt(node ast.NodeID) {
+	call := g.ast.Child(node, ast.DeferStmtCall)
+	name := g.ast.Child(call, ast.CallExprName)
+
+	g.genArgs(g.ast.Children(g.ast.Child(call, ast.CallExprArgs))...)
+	g.asm.Defer(g.funcName(name))
+}
+
+// genSelectStmt pushes a (chan, value, kind) triple for each case,
+// where the value is either the value to send or the address to receive
+// into, and the kind is 0 for send, 1 for receive and 2 for receive into
+// the address. The runtime returns the index of the chosen case, or the
+// number of cases if the default was chosen.
+func (g *CodeGen) genSelectStmt(node ast.NodeID) {
+	label := g.label
+	g.label++
+
+	var cases []ast.NodeID
+	dflt := ast.InvalidNode
+	for _, clause := range g.ast.Children(node) {
+		if g.ast.Child(clause, ast.CommClauseComm) == ast.InvalidNode {
+			dflt = clause
+			continue
+		}
+		cases = append(cases, clause)
+	}
+
+	for _, clause := range cases {
+		g.genCommCase(clause)
+	}
+
+	g.asm.LoadInt(strconv.Itoa(len(cases)))
+	g.asm.Push()
+	if dflt != ast.InvalidNode {
+		g.asm.LoadInt("1")
+	} else {
+		g.asm.LoadInt("0")
+	}
+	g.asm.Push()
+	g.asm.Pop(1)
+	g.asm.Pop(0)
+	g.asm.Select()
+
+	for range cases {
+		g.asm.Pop(1)
+		g.asm.Pop(1)
+		g.asm.Pop(1)
+	}
+
+	// count the chosen index down to find the case to run
+	for i := range cases {
+		next := "select.next" + strconv.Itoa(i) + "."
+		g.asm.JumpIf(next, "select.case"+strconv.Itoa(i)+".", label)
+		g.asm.Label(next, label)
+		g.asm.Push()
+		g.asm.LoadInt("1")
+		g.asm.Pop(1)
+		g.asm.Sub()
+	}
+	if dflt != ast.InvalidNode {
+		g.genCommBody(dflt)
+	}
+	g.asm.Jump("endselect", label)
+
+	for i, clause := range cases {
+		g.asm.Label("select.case"+strconv.Itoa(i)+".", label)
+		g.genCommBody(clause)
+		g.asm.Jump("endselect", label)
+	}
+	g.asm.Label("endselect", label)
+}
+
+func (g *CodeGen) genCommCase(clause ast.NodeID) {
+	g.symtab.EnterScope(clause)
+	defer g.symtab.LeaveScope()
+
+	comm := g.ast.Child(clause, ast.CommClauseComm)
+	switch g.ast.Kind(comm) {
+	case ast.SendStmt:
+		g.genExpr(g.ast.Child(comm, ast.SendStmtChan))
+		g.asm.Push()
+		g.genExpr(g.ast.Child(comm, ast.SendStmtValue))
+		g.asm.Push()
+		g.asm.LoadInt("0")
+	case ast.ExprStmt:
+		recv := g.ast.Child(comm, ast.ExprStmtExpr)
+		g.genExpr(g.ast.Child(recv, ast.RecvExprChan))
+		g.asm.Push()
+		g.asm.LoadInt("0")
+		g.asm.Push()
+		g.asm.LoadInt("1")
+	case ast.AssignStmt:
+		recv := g.ast.Child(comm, ast.AssignStmtRHS)
+		g.genExpr(g.ast.Child(recv, ast.RecvExprChan))
+		g.asm.Push()
+		g.genAddr(g.ast.Child(comm, ast.AssignStmtLHS))
+		g.asm.Push()
+		g.asm.LoadInt("2")
+	default:
+		panic("unknown select case kind")
+	}
+	g.asm.Push()
+}
+
+func (g *CodeGen) genCommBody(clause ast.NodeID) {
+	g.symtab.EnterScope(clause)
+	defer g.symtab.LeaveScope()
+
+	g.genStmt(g.ast.Child(clause, ast.CommClauseBody), false)
+}
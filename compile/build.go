@@ -0,0 +1,184 @@
+package compile
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"sort"
+
+	"github.com/rj45/gosling/arch/aarch64"
+	"github.com/rj45/gosling/ast"
+	"github.com/rj45/gosling/codegen"
+	"github.com/rj45/gosling/hlir"
+	"github.com/rj45/gosling/ir"
+	"github.com/rj45/gosling/object"
+	"github.com/rj45/gosling/semantics"
+	"github.com/rj45/gosling/vm"
+)
+
+// Build compiles each package of the program in the directory dir of
+// fsys into an object for the target, which can be linked with
+// object.Link. The objects are returned by import path, with the main
+// package at the empty path.
+//
+// The objects of a previous build, by import path, are reused for the
+// packages whose sources have not changed, as long as the exports of
+// the packages they import have not changed either, and they were
+// built with the same optimization level.
+func Build(fsys fs.FS, dir string, target string, prev map[string]*object.Object, opts ir.PassOptions) (map[string]*object.Object, []error) {
+	if target != object.VM && target != object.AArch64 {
+		return nil, []error{fmt.Errorf("unknown target %s", target)}
+	}
+
+	a, errs := Load(fsys, dir)
+	if errs != nil {
+		return nil, errs
+	}
+
+	objs := make(map[string]*object.Object)
+
+	// packages come after the packages they import
+	for _, pkg := range a.Children(a.Root()) {
+		path := packagePath(a, pkg)
+		imports := packageImports(a, pkg)
+		hash := packageHash(a, pkg, target, imports, objs, opts)
+
+		if obj := prev[path]; obj != nil && obj.Target == target && obj.Hash == hash {
+			objs[path] = obj
+			continue
+		}
+
+		obj, errs := compilePackage(a, pkg, target, imports, objs, opts)
+		if errs != nil {
+			return nil, errs
+		}
+		obj.Path = path
+		obj.Hash = hash
+		objs[path] = obj
+	}
+
+	return objs, nil
+}
+
+// compilePackage type checks and generates the code of a package
+// on its own, against the objects of the packages it imports.
+func compilePackage(a *ast.AST, pkg ast.NodeID, target string, imports []string, objs map[string]*object.Object, opts ir.PassOptions) (*object.Object, []error) {
+	tc := semantics.NewTypeChecker(a)
+	for _, imp := range imports {
+		tc.Import(imp, objs[imp].Name, objs[imp].Exports)
+	}
+
+	symtab, errs := tc.Check(pkg)
+	if errs != nil {
+		return nil, errs
+	}
+
+	builder := hlir.NewBuilder(a.FileSet)
+	gen := codegen.New(a, symtab, tc.Universe(), builder)
+	gen.GeneratePackage(pkg)
+
+	obj := &object.Object{
+		Target:  target,
+		Name:    a.NodeString(pkg),
+		Imports: imports,
+		Exports: tc.Export(pkg),
+	}
+	obj.ExportHash = hashOf(obj.Exports)
+
+	for i := 0; i < builder.Program.NumFuncs(); i++ {
+		// functions of imported packages are declared without a body,
+		// while extern functions are outside of the program entirely
+		fn := builder.Program.Func(i)
+		switch {
+		case fn.Extern:
+		case fn.NumBlocks() == 0:
+			obj.Refs = append(obj.Refs, fn.Name)
+		default:
+			obj.Defines = append(obj.Defines, fn.Name)
+			opts.VerifyPass(fn, "codegen")
+		}
+	}
+
+	switch target {
+	case object.VM:
+		asm := vm.NewAsm()
+		if errs := assemble(builder.Program, asm, opts); errs != nil {
+			return nil, errs
+		}
+		obj.VM = asm.Program
+	case object.AArch64:
+		buf := &bytes.Buffer{}
+		if errs := assemble(builder.Program, &aarch64.Assembler{Out: buf}, opts); errs != nil {
+			return nil, errs
+		}
+		obj.Asm = buf.String()
+	}
+
+	return obj, nil
+}
+
+// packagePath returns the import path of a package, which is
+// empty for the main package.
+func packagePath(a *ast.AST, pkg ast.NodeID) string {
+	lit := a.Child(pkg, ast.PackagePath)
+	if lit == ast.InvalidNode {
+		return ""
+	}
+	path, _ := a.ImportPath(lit)
+	return path
+}
+
+// packageImports returns the sorted import paths of the packages
+// imported by the files of a package.
+func packageImports(a *ast.AST, pkg ast.NodeID) []string {
+	seen := make(map[string]bool)
+	var imports []string
+	for _, file := range a.Children(pkg)[ast.PackageFiles:] {
+		for _, decl := range a.Children(file) {
+			if a.Kind(decl) != ast.ImportDecl {
+				continue
+			}
+			for _, spec := range a.Children(decl) {
+				// Load reported the malformed paths
+				path, _ := a.ImportPath(spec)
+				if !seen[path] {
+					seen[path] = true
+					imports = append(imports, path)
+				}
+			}
+		}
+	}
+	sort.Strings(imports)
+	return imports
+}
+
+// packageHash hashes the sources of a package, along with the
+// exports of the packages it imports.
+func packageHash(a *ast.AST, pkg ast.NodeID, target string, imports []string, objs map[string]*object.Object, opts ir.PassOptions) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %q\n", target, packagePath(a, pkg))
+	// the optimizations change the assembly of the functions
+	fmt.Fprintf(h, "opt %d\n", opts.OptLevel)
+	for _, file := range a.Children(pkg)[ast.PackageFiles:] {
+		f := a.File(a.Token(file))
+		fmt.Fprintf(h, "file %q %d\n", f.Filename, len(f.Src))
+		h.Write(f.Src)
+	}
+	for _, imp := range imports {
+		fmt.Fprintf(h, "import %q %s\n", imp, objs[imp].ExportHash)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func hashOf(v interface{}) string {
+	// json sorts the keys of maps, so the encoding is deterministic
+	buf, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
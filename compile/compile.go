@@ -1,20 +1,42 @@
 package compile
 
 import (
+	"io/fs"
+
+	"github.com/rj45/gosling/ast"
 	"github.com/rj45/gosling/codegen"
 	"github.com/rj45/gosling/hlir"
+	"github.com/rj45/gosling/ir"
 	"github.com/rj45/gosling/parser"
 	"github.com/rj45/gosling/semantics"
 	"github.com/rj45/gosling/token"
 )
 
-func Compile(file *token.File, asm hlir.Assembler) []error {
+// Compile compiles a program made of a single file, running the passes
+// with the options.
+func Compile(file *token.File, asm hlir.Assembler, opts ir.PassOptions) []error {
 	parser := parser.New(file)
 	ast, errs := parser.Parse()
 	if errs != nil {
 		return errs
 	}
 
+	return generate(ast, asm, opts)
+}
+
+// CompilePackage compiles the main package in the directory dir of fsys,
+// along with the packages it imports. The root of fsys is the root of the
+// module, which import paths are relative to.
+func CompilePackage(fsys fs.FS, dir string, asm hlir.Assembler, opts ir.PassOptions) []error {
+	ast, errs := Load(fsys, dir)
+	if errs != nil {
+		return errs
+	}
+
+	return generate(ast, asm, opts)
+}
+
+func generate(ast *ast.AST, asm hlir.Assembler, opts ir.PassOptions) []error {
 	tc := semantics.NewTypeChecker(ast)
 
 	symtab, errs := tc.Check(ast.Root())
@@ -22,13 +44,32 @@ func Compile(file *token.File, asm hlir.Assembler) []error {
 		return errs
 	}
 
-	builder := hlir.NewBuilder(ast.File)
+	builder := hlir.NewBuilder(ast.FileSet)
 
 	gen := codegen.New(ast, symtab, tc.Universe(), builder)
 	gen.Generate()
 
-	hgen := hlir.New(builder.Program, asm)
-	hgen.Generate()
+	for i := 0; i < builder.Program.NumFuncs(); i++ {
+		opts.VerifyPass(builder.Program.Func(i), "codegen")
+	}
+
+	return assemble(builder.Program, asm, opts)
+}
+
+// assemble runs the passes the assembler adds over the program, and
+// then assembles the program.
+func assemble(prog *ir.Program, asm hlir.Assembler, opts ir.PassOptions) []error {
+	pm := ir.NewPassManager(opts)
+	if pa, ok := asm.(hlir.PassAssembler); ok {
+		pa.AddPasses(pm)
+	}
+	if err := pm.Run(prog); err != nil {
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			return joined.Unwrap()
+		}
+		return []error{err}
+	}
 
+	hlir.New(prog, asm).Generate()
 	return nil
 }
@@ -0,0 +1,171 @@
+package compile
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/rj45/gosling/ast"
+	"github.com/rj45/gosling/errors"
+	"github.com/rj45/gosling/parser"
+	"github.com/rj45/gosling/token"
+)
+
+// Load parses the main package in the directory dir of fsys, and the
+// packages it imports, whose import paths are directories relative to
+// the root of fsys. All of their files are parsed into one AST, whose
+// root is a Program with each package after the packages it imports.
+func Load(fsys fs.FS, dir string) (*ast.AST, []error) {
+	fset := token.NewFileSet()
+	l := &loader{
+		fsys: fsys,
+		ast:  ast.New(fset),
+		pkgs: make(map[string]ast.NodeID),
+	}
+
+	main := l.load(dir, ast.InvalidNode)
+	if l.errs != nil {
+		return nil, l.errs
+	}
+
+	l.ast.AddNode(ast.Program, l.ast.Token(main), l.order...)
+	return l.ast, nil
+}
+
+type loader struct {
+	fsys fs.FS
+	ast  *ast.AST
+
+	// pkgs are the packages by import path, which are
+	// InvalidNode while their imports are being loaded
+	pkgs map[string]ast.NodeID
+
+	// order is the packages with each after the packages it imports
+	order []ast.NodeID
+
+	errs []error
+}
+
+// load loads the package at path, which is imported by the import
+// spec, or is the main package if the spec is InvalidNode.
+func (l *loader) load(path string, spec ast.NodeID) ast.NodeID {
+	if pkg, ok := l.pkgs[path]; ok {
+		if pkg == ast.InvalidNode {
+			l.errorf(spec, "import cycle not allowed: %s", path)
+		}
+		return pkg
+	}
+	l.pkgs[path] = ast.InvalidNode
+
+	files := l.parseDir(path, spec)
+	if len(files) == 0 {
+		return ast.InvalidNode
+	}
+
+	var name ast.NodeID
+	for _, file := range files {
+		clause := l.ast.Child(file, 0)
+		if l.ast.Kind(clause) != ast.PackageClause {
+			l.errorf(file, "missing package clause")
+			continue
+		}
+
+		clauseName := l.ast.Child(clause, ast.PackageClauseName)
+		if name == ast.InvalidNode {
+			name = clauseName
+		} else if l.ast.NodeString(clauseName) != l.ast.NodeString(name) {
+			l.errorf(clause, "package %s; expected package %s", l.ast.NodeString(clauseName), l.ast.NodeString(name))
+		}
+	}
+	if name == ast.InvalidNode {
+		return ast.InvalidNode
+	}
+
+	pkgName := l.ast.NodeString(name)
+	switch {
+	case spec == ast.InvalidNode && pkgName != "main":
+		l.errorf(name, "package %s is not a main package", pkgName)
+	case spec != ast.InvalidNode && pkgName == "main":
+		l.errorf(spec, "import %s is a program, not an importable package", path)
+	}
+
+	// load the imported packages first, so they come before this one
+	for _, file := range files {
+		for _, decl := range l.ast.Children(file) {
+			if l.ast.Kind(decl) != ast.ImportDecl {
+				continue
+			}
+			for _, spec := range l.ast.Children(decl) {
+				imp, ok := l.ast.ImportPath(spec)
+				if !ok {
+					l.errorf(spec, "malformed import path %s", l.ast.NodeString(spec))
+					continue
+				}
+				if !fs.ValidPath(imp) || imp == "." {
+					l.errorf(spec, "invalid import path %s", imp)
+					continue
+				}
+				l.load(imp, spec)
+			}
+		}
+	}
+
+	// the main package has no import path
+	var pathNode ast.NodeID
+	if spec != ast.InvalidNode {
+		pathNode = l.ast.AddNode(ast.Literal, l.ast.Token(spec))
+	}
+
+	pkg := l.ast.AddNode(ast.Package, l.ast.Token(name), append([]ast.NodeID{pathNode}, files...)...)
+	l.pkgs[path] = pkg
+	l.order = append(l.order, pkg)
+	return pkg
+}
+
+// parseDir parses the source files in the directory of a package.
+func (l *loader) parseDir(dir string, spec ast.NodeID) []ast.NodeID {
+	entries, err := fs.ReadDir(l.fsys, dir)
+	if err != nil {
+		l.errorf(spec, "could not import %s: %v", dir, err)
+		return nil
+	}
+
+	var files []ast.NodeID
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".gos") {
+			continue
+		}
+
+		filename := path.Join(dir, entry.Name())
+		src, err := fs.ReadFile(l.fsys, filename)
+		if err != nil {
+			l.errorf(spec, "could not read %s: %v", filename, err)
+			continue
+		}
+
+		file := l.ast.AddFile(filename, src)
+		_, errs := parser.NewWithAST(l.ast, file).Parse()
+		if errs != nil {
+			l.errs = append(l.errs, errs...)
+			continue
+		}
+		files = append(files, l.ast.Root())
+	}
+
+	if len(files) == 0 && len(l.errs) == 0 {
+		l.errorf(spec, "no gosling files in %s", dir)
+	}
+	return files
+}
+
+// errorf reports an error at the node, or an error without a
+// position if the node is InvalidNode.
+func (l *loader) errorf(node ast.NodeID, msg string, args ...interface{}) {
+	if node == ast.InvalidNode {
+		l.errs = append(l.errs, fmt.Errorf(msg, args...))
+		return
+	}
+	tok := l.ast.Token(node)
+	l.errs = append(l.errs, errors.Newf(l.ast.File(tok), tok, msg, args...))
+}
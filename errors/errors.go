@@ -8,26 +8,26 @@ import (
 )
 
 type Err struct {
-	src []byte
-	tok token.Token
-	msg string
+	file *token.File
+	tok  token.Token
+	msg  string
 }
 
-func New(src []byte, tok token.Token, msg string) *Err {
-	return &Err{src: src, tok: tok, msg: msg}
+func New(file *token.File, tok token.Token, msg string) *Err {
+	return &Err{file: file, tok: tok, msg: msg}
 }
 
-func Newf(src []byte, tok token.Token, msg string, args ...interface{}) *Err {
-	return &Err{src: src, tok: tok, msg: fmt.Sprintf(msg, args...)}
+func Newf(file *token.File, tok token.Token, msg string, args ...interface{}) *Err {
+	return &Err{file: file, tok: tok, msg: fmt.Sprintf(msg, args...)}
 }
 
 func (e *Err) Error() string {
 	buf := &bytes.Buffer{}
-	line, col := e.positionOf(e.tok)
-	fmt.Fprintf(buf, "error %d:%d: %s\n", line, col, e.msg)
+	line, col := e.file.PositionOf(e.tok)
+	fmt.Fprintf(buf, "error %s:%d:%d: %s\n", e.file.Filename, line, col, e.msg)
 
 	// print a few lines before and after the error
-	lines := bytes.Split(e.src, []byte("\n"))
+	lines := bytes.Split(e.file.Src, []byte("\n"))
 	for i := line - 3; i <= line+3; i++ {
 		if i < 0 || i >= len(lines) {
 			continue
@@ -39,20 +39,3 @@ func (e *Err) Error() string {
 	}
 	return buf.String()
 }
-
-// PositionOf returns the line and column of the given token
-func (e *Err) positionOf(tok token.Token) (line int, col int) {
-	offset := tok.Offset()
-	var lineoffset int
-	for i, ch := range e.src {
-		if i >= offset {
-			break
-		}
-		if ch == '\n' {
-			line++
-			lineoffset = i
-		}
-	}
-	col = offset - lineoffset
-	return line + 1, col + 1
-}
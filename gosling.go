@@ -1,23 +1,123 @@
-package main
+// Package gosling compiles gosling programs to run in a virtual machine,
+// so they can be embedded in Go programs, for example as scripts.
+package gosling
 
 import (
-	"fmt"
-	"os"
+	"errors"
+	"io"
+	"sync"
 
-	"github.com/rj45/gosling/arch/aarch64"
 	"github.com/rj45/gosling/compile"
+	"github.com/rj45/gosling/ir"
 	"github.com/rj45/gosling/token"
+	"github.com/rj45/gosling/vm"
 )
 
-func main() {
-	src := []byte(os.Args[1])
-	file := token.NewFile("test.gos", src)
-	asm := &aarch64.Assembler{Out: os.Stdout}
-	errs := compile.Compile(file, asm)
-	if len(errs) > 0 {
-		for _, err := range errs {
-			fmt.Fprintln(os.Stderr, err)
-		}
-		os.Exit(1)
+// Program is a compiled gosling program.
+type Program struct {
+	cpu  *vm.CPU
+	file *token.File
+}
+
+// Options are the options a program is compiled with.
+type Options struct {
+	// OptLevel is the level the program is optimized at: 0 doesn't
+	// optimize it, and 1 runs the optimization passes over it.
+	OptLevel int
+
+	// Coverage counts how many times the statements of the program
+	// run, for WriteLCOV and WriteCoverHTML.
+	Coverage bool
+}
+
+// Compile compiles the source of a program. The program does not need a
+// main function if it is only called into with Call. All of the errors
+// in the source are joined into the returned error.
+func Compile(src string) (*Program, error) {
+	return CompileWithOptions(src, Options{})
+}
+
+// CompileWithOptions compiles the source of a program like Compile, with
+// the options.
+func CompileWithOptions(src string, opts Options) (*Program, error) {
+	file := token.NewFile("script.gos", []byte(src))
+	asm := vm.NewAsm()
+	asm.Registers = true
+	asm.Coverage = opts.Coverage
+	if errs := compile.Compile(file, asm, ir.PassOptions{OptLevel: opts.OptLevel}); errs != nil {
+		return nil, errors.Join(errs...)
+	}
+	return &Program{cpu: vm.NewCPU(asm.Program), file: file}, nil
+}
+
+// Run runs the main function of the program, and returns its result.
+func (p *Program) Run() (int, error) {
+	p.bindHosts()
+	return p.cpu.Run()
+}
+
+// Call calls the named function of the program with the args, and returns
+// its result. Functions of imported packages are qualified by the import
+// path of the package, like "mathx.Add" or "lib/mathx.Add".
+func (p *Program) Call(name string, args ...int) (int, error) {
+	p.bindHosts()
+	return p.cpu.Call(name, args...)
+}
+
+// CPU returns the virtual machine the program runs on, so that it
+// can be configured.
+func (p *Program) CPU() *vm.CPU {
+	return p.cpu
+}
+
+// WriteLCOV writes the coverage of the runs and calls of the program so
+// far as an LCOV tracefile. It must be compiled with Coverage.
+func (p *Program) WriteLCOV(w io.Writer) error {
+	if err := p.covered(); err != nil {
+		return err
+	}
+	return p.cpu.WriteLCOV(w)
+}
+
+// WriteCoverHTML writes the coverage of the runs and calls of the
+// program so far as an HTML page of its source. It must be compiled
+// with Coverage.
+func (p *Program) WriteCoverHTML(w io.Writer) error {
+	if err := p.covered(); err != nil {
+		return err
+	}
+	return p.cpu.WriteCoverHTML(w, p.file.FileSet())
+}
+
+func (p *Program) covered() error {
+	if len(p.cpu.Coverage) == 0 {
+		return errors.New("program was not compiled with coverage")
+	}
+	return nil
+}
+
+var (
+	hostsMu sync.RWMutex
+	hosts   = make(map[string]vm.HostFunc)
+)
+
+// RegisterHost registers a Go function, which programs call by declaring
+// an extern function with the same name, such as:
+//
+//	func log(value int)
+//
+// The function can be registered before or after the programs calling
+// it are compiled, as long as it is before they run.
+func RegisterHost(name string, fn func(args ...int) int) {
+	hostsMu.Lock()
+	defer hostsMu.Unlock()
+	hosts[name] = fn
+}
+
+func (p *Program) bindHosts() {
+	hostsMu.RLock()
+	defer hostsMu.RUnlock()
+	for name, fn := range hosts {
+		p.cpu.RegisterHost(name, fn)
 	}
 }
@@ -1,14 +1,25 @@
-package main_test
+package gosling_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/rj45/gosling"
 	"github.com/rj45/gosling/arch/aarch64"
 	"github.com/rj45/gosling/compile"
+	"github.com/rj45/gosling/ir"
+	"github.com/rj45/gosling/object"
 	"github.com/rj45/gosling/token"
 	"github.com/rj45/gosling/vm"
 )
@@ -58,6 +69,11 @@ var tests = []struct {
 		input:  `{return (3+5)/2}`,
 		output: 4,
 	},
+	{
+		name:   "wide constants",
+		input:  `{ a := -1099511627777; b := 4294967296; return a / 1099511627776 + b / 2147483648 + 3 }`,
+		output: 4,
+	},
 	{
 		name:   "return negative number",
 		input:  `{return -10+20}`,
@@ -323,95 +339,2311 @@ var tests = []struct {
 		`,
 		output: 36,
 	},
-}
-
-func TestCodegenWithVirtualMachine(t *testing.T) {
-	for _, tt := range tests {
-		tt := tt
-		t.Run(tt.name, func(t *testing.T) {
-			t.Parallel()
-			input := "func main() int " + tt.input
-			if strings.Contains(tt.input, "main()") {
-				input = tt.input
+	{
+		name:   "map assign and index",
+		input:  `{ m := make(map[int]int); m[1] = 10; m[2] = 20; return m[1] + m[2] }`,
+		output: 30,
+	},
+	{
+		name:   "map missing key is zero value",
+		input:  `{ m := make(map[int]int); m[1] = 10; return m[2] }`,
+		output: 0,
+	},
+	{
+		name:   "map literal",
+		input:  `{ m := map[int]int{1: 2, 3: 4, 5: 6}; return m[1] + m[3] * m[5] }`,
+		output: 26,
+	},
+	{
+		name:   "map len and delete",
+		input:  `{ m := map[int]int{1: 2, 3: 4, 5: 6}; delete(m, 3); delete(m, 7); return len(m) }`,
+		output: 2,
+	},
+	{
+		name: "map comma ok found",
+		input: `{
+			m := map[int]int{4: 2}
+			v, ok := m[4]
+			if ok { return v }
+			return 0
+		}`,
+		output: 2,
+	},
+	{
+		name: "map comma ok not found",
+		input: `{
+			m := map[int]int{4: 2}
+			v, ok := m[3]
+			if ok { return 1 }
+			return v + 3
+		}`,
+		output: 3,
+	},
+	{
+		name: "map grows",
+		input: `{
+			m := make(map[int]int)
+			i := 0
+			for i = 0; i < 100; i = i + 1 { m[i*7] = i }
+			for i = 0; i < 50; i = i + 1 { delete(m, i*7) }
+			j := 0
+			for i = 50; i < 100; i = i + 1 { j = j + m[i*7] - i + 1 }
+			return len(m) + j
+		}`,
+		output: 100,
+	},
+	{
+		name: "map passed to function",
+		input: `
+			func main() int {
+				m := make(map[int]int, 4)
+				set(m, 3, 9)
+				return m[3]
 			}
-			file := token.NewFile("test.gos", []byte(input))
-			asm := vm.NewAsm()
-			errs := compile.Compile(file, asm)
-
-			if len(errs) > 0 {
-				for _, err := range errs {
-					t.Errorf("Expected no error, but got\n%s", err)
+			func set(m map[int]int, k int, v int) {
+				m[k] = v
+			}
+		`,
+		output: 9,
+	},
+	{
+		name: "nested map literal",
+		input: `{
+			m := map[int]map[int]int{1: map[int]int{2: 3}, 4: map[int]int{5: 6}}
+			return m[1][2] + m[4][5]
+		}`,
+		output: 9,
+	},
+	{
+		name: "maps as arguments",
+		input: `
+			func main() int {
+				return both(make(map[int]int), map[int]int{1: 4})
+			}
+			func both(a map[int]int, b map[int]int) int {
+				a[1] = 3
+				return a[1] + b[1]
+			}
+		`,
+		output: 7,
+	},
+	{
+		name: "deferred calls run in reverse order",
+		input: `
+			func main() int {
+				m := map[int]int{}
+				f(m)
+				return m[0]
+			}
+			func f(m map[int]int) {
+				defer push(m, 1)
+				defer push(m, 2)
+				push(m, 3)
+			}
+			func push(m map[int]int, d int) {
+				m[0] = m[0] * 10 + d
+			}
+		`,
+		output: 321,
+	},
+	{
+		name: "deferred args are evaluated at defer",
+		input: `
+			func main() int {
+				m := map[int]int{}
+				f(m)
+				return m[0]
+			}
+			func f(m map[int]int) {
+				x := 1
+				defer push(m, x)
+				x = 5
+				push(m, x)
+			}
+			func push(m map[int]int, d int) {
+				m[0] = m[0] * 10 + d
+			}
+		`,
+		output: 51,
+	},
+	{
+		name: "deferred calls run on every return",
+		input: `
+			func main() int {
+				m := map[int]int{}
+				a := f(m, true)
+				b := f(m, false)
+				return m[0] * 100 + a * 10 + b
+			}
+			func f(m map[int]int, early bool) int {
+				defer push(m, 1)
+				if early {
+					return 2
 				}
+				defer push(m, 3)
+				return 4
 			}
-
-			vm := vm.NewCPU(asm.Program)
-			// vm.Trace = true
-			actual := vm.Run()
-			if actual != tt.output {
-				t.Errorf("Expected: %d; but got: %d", tt.output, actual)
+			func push(m map[int]int, d int) {
+				m[0] = m[0] * 10 + d
+			}
+		`,
+		output: 13124,
+	},
+	{
+		name: "recover from panic",
+		input: `
+			func main() int {
+				m := map[int]int{}
+				r := safe(m)
+				return m[0] + r
+			}
+			func safe(m map[int]int) int {
+				defer catch(m)
+				explode()
+				return 1
+			}
+			func explode() {
+				panic(42)
+			}
+			func catch(m map[int]int) {
+				m[0] = recover()
+			}
+		`,
+		output: 42,
+	},
+	{
+		name:   "recover without panic",
+		input:  `{ return recover() + 3 }`,
+		output: 3,
+	},
+	{
+		name: "panic runs deferred calls of outer frames",
+		input: `
+			func main() int {
+				m := map[int]int{}
+				outer(m)
+				return m[0]
+			}
+			func outer(m map[int]int) {
+				defer catch(m)
+				inner(m)
+				push(m, 9)
+			}
+			func inner(m map[int]int) {
+				defer push(m, 7)
+				panic(5)
+			}
+			func catch(m map[int]int) {
+				v := recover()
+				m[0] = m[0] * 100 + v
+			}
+			func push(m map[int]int, d int) {
+				m[0] = m[0] * 10 + d
+			}
+		`,
+		output: 705,
+	},
+	{
+		name: "recovered function runs remaining deferred calls",
+		input: `
+			func main() int {
+				m := map[int]int{}
+				r := f(m) + 10
+				return m[0] * 100 + r
+			}
+			func f(m map[int]int) int {
+				defer push(m, 1)
+				defer catch(m)
+				panic(3)
+			}
+			func catch(m map[int]int) {
+				m[0] = recover()
+			}
+			func push(m map[int]int, d int) {
+				m[0] = m[0] * 10 + d
+			}
+		`,
+		output: 3110,
+	},
+	{
+		name: "recover from runtime errors",
+		input: `
+			func main() int {
+				m := map[int]int{}
+				assign(m)
+				closeTwice(m)
+				sendClosed(m)
+				divide(m, 0)
+				return m[0]
+			}
+			func assign(m map[int]int) {
+				defer catch(m)
+				n := map[int]map[int]int{}[1]
+				n[1] = 2
+			}
+			func closeTwice(m map[int]int) {
+				defer catch(m)
+				c := make(chan int)
+				close(c)
+				close(c)
+			}
+			func sendClosed(m map[int]int) {
+				defer catch(m)
+				c := make(chan int, 1)
+				close(c)
+				c <- 1
+			}
+			func divide(m map[int]int, d int) {
+				defer catch(m)
+				m[1] = 10 / d
+			}
+			func catch(m map[int]int) {
+				m[0] = m[0] * 10 - recover()
+			}
+		`,
+		output: 1111,
+	},
+	{
+		name: "recover only stops a panic in the deferred call",
+		input: `
+			func main() int {
+				m := map[int]int{}
+				f(m)
+				return m[0] * 10 + m[1]
+			}
+			func f(m map[int]int) {
+				defer g(m)
+				panic(7)
+			}
+			func g(m map[int]int) {
+				m[0] = h()
+				m[1] = recover()
+			}
+			func h() int {
+				return recover()
+			}
+		`,
+		output: 7,
+	},
+	{
+		name:   "untyped constants overflow int in between",
+		input:  `{ const a = 9223372036854775807 * 4; const b = a / 8; x := b; return x / 4611686018427387903 + 1 }`,
+		output: 2,
+	},
+	{
+		name: "const declarations with iota",
+		input: `
+			const (
+				a = iota * 10
+				b
+				c
+			)
+			func main() int {
+				return a + b + c
+			}
+		`,
+		output: 30,
+	},
+	{
+		name: "local typed const",
+		input: `
+			func main() int {
+				const limit int = 4
+				n := 0
+				for i := 0; i < limit; i = i + 1 {
+					n = n + limit
+				}
+				return n
+			}
+		`,
+		output: 16,
+	},
+	{
+		name: "untyped constant expression larger than int",
+		input: `
+			const big = 10000000000 * 10000000000
+			func main() int {
+				return big / 1000000000000000000 + 1
+			}
+		`,
+		output: 101,
+	},
+	{
+		name: "inlined helpers",
+		input: `
+			func add(a int, b int) int {
+				return a + b
+			}
+			func larger(a int, b int) int {
+				if a > b {
+					return a
+				}
+				return b
+			}
+			func sum(n int) int {
+				s := 0
+				for i := 0; i < n; i = i + 1 {
+					s = add(s, i)
+				}
+				return s
+			}
+			func bump(a int) int {
+				x := a
+				p := &x
+				*p = *p + 1
+				return x
 			}
+			//gosling:noinline
+			func keep(a int) int {
+				return a
+			}
+			func fact(n int) int {
+				if n <= 1 {
+					return 1
+				}
+				return n * fact(n-1)
+			}
+			func main() int {
+				x := larger(add(1, 4), 3)
+				return bump(add(x, keep(2))) + fact(3) + sum(4)
+			}
+		`,
+		output: 20,
+	},
+}
+
+// vmMode is a way the tests are run on the VM.
+type vmMode struct {
+	name      string
+	registers bool
+	gcStress  bool
+	opts      ir.PassOptions
+
+	// coverage instruments the program for coverage reports
+	coverage bool
+}
+
+// vmModes are the ways the tests are run on the VM: with the stack and
+// register instructions, collecting garbage at every safepoint, and
+// with the optimizations, which compile the functions to registers.
+var vmModes = []vmMode{
+	{name: "stack"},
+	{name: "registers", registers: true, gcStress: true},
+	{name: "gc stress", gcStress: true},
+	{name: "optimized", gcStress: true, opts: ir.PassOptions{OptLevel: 1, Verify: true}},
+}
+
+// unstressedModes are the modes which don't stress the garbage collector,
+// for the tests which count the collections or time the runs.
+var unstressedModes = []vmMode{
+	{name: "stack"},
+	{name: "registers", registers: true},
+	{name: "optimized", opts: ir.PassOptions{OptLevel: 1, Verify: true}},
+}
+
+// runModes runs a parallel subtest for each of the modes, which compiles
+// the source as the mode does, and calls run with a CPU for the program
+// to configure, run and check the results of.
+func runModes(t *testing.T, src string, modes []vmMode, run func(t *testing.T, cpu *vm.CPU)) {
+	for _, mode := range modes {
+		mode := mode
+		t.Run(mode.name, func(t *testing.T) {
+			t.Parallel()
+			run(t, compileMode(t, src, mode))
 		})
 	}
 }
 
-func TestCodegenNativeAssembly(t *testing.T) {
-	for _, tt := range tests {
-		tt := tt
-		t.Run(tt.name, func(t *testing.T) {
-			t.Parallel()
-			input := "func main() int " + tt.input
-			if strings.Contains(tt.input, "main()") {
-				input = tt.input
-			}
-			file := token.NewFile("test.gos", []byte(input))
+// compileMode compiles the source as the mode does, and returns a CPU for
+// the program, which collects garbage at every safepoint if the mode
+// stresses the garbage collector.
+func compileMode(tb testing.TB, src string, mode vmMode) *vm.CPU {
+	tb.Helper()
+	file := token.NewFile("test.gos", []byte(src))
+	asm := vm.NewAsm()
+	asm.Registers = mode.registers
+	asm.Coverage = mode.coverage
+	for _, err := range compile.Compile(file, asm, mode.opts) {
+		tb.Fatalf("Expected no error, but got\n%s", err)
+	}
+	cpu := vm.NewCPU(asm.Program)
+	cpu.GCStress = mode.gcStress
+	return cpu
+}
 
-			tmp, err := os.CreateTemp("", "gosling_*.s")
-			if err != nil {
-				t.Fatal(err)
+func TestCodegenWithVirtualMachine(t *testing.T) {
+	for _, mode := range vmModes {
+		mode := mode
+		t.Run(mode.name, func(t *testing.T) {
+			for _, tt := range tests {
+				tt := tt
+				t.Run(tt.name, func(t *testing.T) {
+					t.Parallel()
+					input := "func main() int " + tt.input
+					if strings.Contains(tt.input, "main()") {
+						input = tt.input
+					}
+					actual, err := compileMode(t, input, mode).Run()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if actual != tt.output {
+						t.Errorf("Expected: %d; but got: %d", tt.output, actual)
+					}
+				})
 			}
-			defer os.Remove(tmp.Name())
-
-			asm := &aarch64.Assembler{Out: tmp}
+		})
+	}
+}
 
-			errs := compile.Compile(file, asm)
-			if len(errs) > 0 {
-				for _, err := range errs {
-					t.Errorf("Expected no error, but got %s", err)
-				}
-				return
+func TestVirtualMachineGC(t *testing.T) {
+	input := `
+		func main() int {
+			keep := make(map[int]map[int]int)
+			i := 0
+			for i = 0; i < 2000; i = i + 1 {
+				m := map[int]int{1: i}
+				if i / 100 * 100 == i { keep[i] = m }
 			}
+			sum := 0
+			for i = 0; i < 2000; i = i + 100 { sum = sum + keep[i][1] }
+			return sum
+		}
+	`
+	tests := []struct {
+		name      string
+		gcPercent int
+		collects  bool
+	}{
+		{"default", 100, true},
+		{"aggressive", 10, true},
+		{"disabled", -1, false},
+	}
 
-			cmd := exec.Command("gcc", "-o", tmp.Name()+".out", tmp.Name())
-			err = cmd.Run()
-			defer os.Remove(tmp.Name() + ".out")
-			if err != nil {
-				buf, err := os.ReadFile(tmp.Name())
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			runModes(t, input, unstressedModes, func(t *testing.T, cpu *vm.CPU) {
+				cpu.GCPercent = tt.gcPercent
+				actual, err := cpu.Run()
 				if err != nil {
 					t.Fatal(err)
 				}
-				fmt.Println(string(buf))
-				t.Errorf("Expected no error, but got %s", err)
-			}
+				if actual != 19000 {
+					t.Errorf("Expected: %d; but got: %d", 19000, actual)
+				}
 
-			cmd = exec.Command(tmp.Name() + ".out")
-			_, err = cmd.Output()
-			if err != nil {
-				if tt.output == 0 {
-					t.Errorf("Expected no error, but got %s", err)
-					return
+				stats := cpu.HeapStats()
+				if stats.Mallocs != 2001 {
+					t.Errorf("Expected 2001 mallocs, but got %d", stats.Mallocs)
 				}
-				if ee, ok := err.(*exec.ExitError); ok {
-					actual := ee.ExitCode()
-					if actual != tt.output {
-						t.Errorf("Expected: %d; but got: %d", tt.output, actual)
-					}
-					return
+				if (stats.NumGC > 0) != tt.collects {
+					t.Errorf("Expected collections: %v, but got %d", tt.collects, stats.NumGC)
 				}
-				t.Errorf("Expected no error, but got %s", err)
+				if tt.collects && stats.Objects >= stats.Mallocs/2 {
+					t.Errorf("Expected garbage to be freed, but %d of %d objects are live", stats.Objects, stats.Mallocs)
+				}
+				if stats.Objects != stats.Mallocs-stats.Frees {
+					t.Errorf("Expected %d objects, but got %d", stats.Mallocs-stats.Frees, stats.Objects)
+				}
+
+				cpu.GC()
+				stats = cpu.HeapStats()
+				if stats.Objects != 0 || stats.Words != 0 {
+					t.Errorf("Expected empty heap after exit, but got %d objects in %d words", stats.Objects, stats.Words)
+				}
+			})
+		})
+	}
+}
+
+var concurrencyTests = []struct {
+	name   string
+	input  string
+	output int
+}{
+	{
+		name: "unbuffered ping pong",
+		input: `
+			func main() int {
+				ping := make(chan int)
+				pong := make(chan int)
+				go player(ping, pong)
+				sum := 0
+				i := 0
+				for i = 0; i < 10; i = i + 1 {
+					ping <- i
+					sum = sum + <-pong
+				}
+				return sum
 			}
-			if tt.output != 0 && err == nil {
-				t.Errorf("Expected error, but got none")
+			func player(ping chan int, pong chan int) {
+				for {
+					n := <-ping
+					pong <- n * 2
+				}
 			}
-		})
+		`,
+		output: 90,
+	},
+	{
+		name: "buffered channel",
+		input: `
+			func main() int {
+				ch := make(chan int, 3)
+				ch <- 1
+				ch <- 2
+				ch <- 3
+				return <-ch * 100 + <-ch * 10 + <-ch
+			}
+		`,
+		output: 123,
+	},
+	{
+		name: "blocked senders fill buffer in order",
+		input: `
+			func main() int {
+				ch := make(chan int, 1)
+				go send(ch, 1)
+				go send(ch, 2)
+				go send(ch, 3)
+				return <-ch * 100 + <-ch * 10 + <-ch
+			}
+			func send(ch chan int, v int) {
+				ch <- v
+			}
+		`,
+		output: 123,
+	},
+	{
+		name: "close and comma ok",
+		input: `
+			func main() int {
+				ch := make(chan int)
+				go produce(ch, 5)
+				sum := 0
+				v, ok := <-ch
+				for ok {
+					sum = sum + v
+					v, ok = <-ch
+				}
+				return sum
+			}
+			func produce(ch chan int, n int) {
+				i := 0
+				for i = 1; i <= n; i = i + 1 {
+					ch <- i
+				}
+				close(ch)
+			}
+		`,
+		output: 15,
+	},
+	{
+		name: "select default",
+		input: `
+			func main() int {
+				ch := make(chan int)
+				select {
+				case v := <-ch:
+					return v
+				default:
+					return 7
+				}
+				return 0
+			}
+		`,
+		output: 7,
+	},
+	{
+		name: "select send and receive",
+		input: `
+			func main() int {
+				in := make(chan int)
+				out := make(chan int)
+				quit := make(chan bool)
+				go double(in, out, quit)
+				sum := 0
+				i := 0
+				for i = 1; i <= 4; i = i + 1 {
+					in <- i
+					sum = sum + <-out
+				}
+				quit <- true
+				return sum
+			}
+			func double(in chan int, out chan int, quit chan bool) {
+				for {
+					select {
+					case v := <-in:
+						out <- v * 2
+					case <-quit:
+						return
+					}
+				}
+			}
+		`,
+		output: 20,
+	},
+	{
+		name: "select chooses among ready cases",
+		input: `
+			func main() int {
+				a := make(chan int, 100)
+				b := make(chan int, 100)
+				i := 0
+				for i = 0; i < 100; i = i + 1 {
+					select {
+					case a <- i:
+					case b <- i:
+					}
+				}
+				na := 0
+				v := 0
+				ok := true
+				close(a)
+				for ok {
+					v, ok = <-a
+					if ok { na = na + 1 }
+				}
+				if na > 20 { if na < 80 { return 1 } }
+				return 0
+			}
+		`,
+		output: 1,
+	},
+	{
+		name: "preemption",
+		input: `
+			func main() int {
+				done := make(chan bool, 1)
+				stop := make(chan bool, 1)
+				go spin(stop, done)
+				i := 0
+				for i = 0; i < 10000; i = i + 1 {}
+				stop <- true
+				<-done
+				return i
+			}
+			func spin(stop chan bool, done chan bool) {
+				for {
+					select {
+					case <-stop:
+						done <- true
+						return
+					default:
+					}
+				}
+			}
+		`,
+		output: 10000,
+	},
+	{
+		name: "maps through channels",
+		input: `
+			func main() int {
+				ch := make(chan map[int]int, 2)
+				go fill(ch, 10)
+				sum := 0
+				i := 0
+				for i = 0; i < 10; i = i + 1 {
+					m := <-ch
+					sum = sum + m[1] + m[2]
+				}
+				return sum
+			}
+			func fill(ch chan map[int]int, n int) {
+				i := 0
+				for i = 0; i < n; i = i + 1 {
+					ch <- map[int]int{1: i, 2: make(map[int]int)[0] + 1}
+				}
+			}
+		`,
+		output: 55,
+	},
+}
+
+func TestVirtualMachineGoroutines(t *testing.T) {
+	for _, tt := range concurrencyTests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			runModes(t, tt.input, vmModes, func(t *testing.T, cpu *vm.CPU) {
+				cpu.TimeSlice = 50
+				actual, err := cpu.Run()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if actual != tt.output {
+					t.Errorf("Expected: %d; but got: %d", tt.output, actual)
+				}
+			})
+		})
+	}
+}
+
+func TestVirtualMachineDeadlock(t *testing.T) {
+	input := `
+		func main() int {
+			ch := make(chan int)
+			go wait(ch)
+			return <-ch
+		}
+		func wait(ch chan int) {
+			select {
+			case ch <- <-ch:
+			}
+		}
+	`
+	file := token.NewFile("test.gos", []byte(input))
+	asm := vm.NewAsm()
+	errs := compile.Compile(file, asm, ir.PassOptions{})
+	for _, err := range errs {
+		t.Fatalf("Expected no error, but got\n%s", err)
+	}
+
+	_, err := vm.NewCPU(asm.Program).Run()
+	deadlock, ok := err.(*vm.DeadlockError)
+	if !ok {
+		t.Fatalf("Expected a deadlock error, but got %v", err)
+	}
+
+	expected := []vm.BlockedThread{
+		{ID: 1, Func: "main", Reason: "chan receive"},
+		{ID: 2, Func: "wait", Reason: "chan receive"},
+	}
+	if len(deadlock.Threads) != len(expected) {
+		t.Fatalf("Expected %d blocked threads, but got %v", len(expected), deadlock.Threads)
+	}
+	for i, blocked := range deadlock.Threads {
+		blocked.PC = 0
+		if blocked != expected[i] {
+			t.Errorf("Expected thread %v, but got %v", expected[i], blocked)
+		}
+	}
+	if !strings.HasPrefix(err.Error(), "all goroutines are asleep - deadlock!") {
+		t.Errorf("Unexpected error message %q", err.Error())
+	}
+}
+
+func TestVirtualMachineLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		limits vm.Limits
+		err    string
+	}{
+		{
+			name:   "instruction limit",
+			input:  `func main() int { for {} return 0 }`,
+			limits: vm.Limits{MaxInstructions: 1000},
+			err:    "instruction limit of 1000 exceeded",
+		},
+		{
+			name: "call depth limit",
+			input: `
+				func main() int { return down(0) }
+				func down(n int) int { return down(n+1) }
+			`,
+			limits: vm.Limits{MaxCallDepth: 50},
+			err:    "goroutine 1 exceeded call depth limit of 50 calling down",
+		},
+		{
+			name: "stack limit",
+			input: `
+				func main() int { return down(0) }
+				func down(n int) int { return 1 + down(n+1) }
+			`,
+			limits: vm.Limits{MaxStack: 100},
+			err:    "goroutine 1 exceeded stack limit of 100 words",
+		},
+		{
+			name: "heap limit",
+			input: `func main() int {
+				m := make(map[int]int)
+				i := 0
+				for { m[i] = i; i = i + 1 }
+				return 0
+			}`,
+			limits: vm.Limits{MaxHeap: 5000},
+			err:    "exceeds limit of 5000 words",
+		},
+		{
+			name: "within limits",
+			input: `
+				func main() int { return down(10) }
+				func down(n int) int { if n == 0 { return 0 }; return 1 + down(n-1) }
+			`,
+			limits: vm.Limits{MaxInstructions: 1000, MaxCallDepth: 20, MaxStack: 20, MaxHeap: 10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := token.NewFile("test.gos", []byte(tt.input))
+			asm := vm.NewAsm()
+			errs := compile.Compile(file, asm, ir.PassOptions{})
+			for _, err := range errs {
+				t.Fatalf("Expected no error, but got\n%s", err)
+			}
+
+			cpu := vm.NewCPU(asm.Program)
+			cpu.Limits = tt.limits
+			_, err := cpu.Run()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("Expected no error, but got %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("Expected error %q, but got %v", tt.err, err)
+			}
+		})
+	}
+
+	t.Run("context", func(t *testing.T) {
+		file := token.NewFile("test.gos", []byte(`func main() int { for {} return 0 }`))
+		asm := vm.NewAsm()
+		errs := compile.Compile(file, asm, ir.PassOptions{})
+		for _, err := range errs {
+			t.Fatalf("Expected no error, but got\n%s", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := vm.NewCPU(asm.Program).RunContext(ctx)
+		var canceled *vm.CanceledError
+		if !errors.As(err, &canceled) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected the run to be canceled, but got %v", err)
+		}
+	})
+}
+
+func TestVirtualMachineProfiler(t *testing.T) {
+	src := `
+		func main() int {
+			return fib(15) + loop()
+		}
+		// loop stays a function of its own when optimized
+		//gosling:noinline
+		func loop() int {
+			s := 0
+			for i := 0; i < 100; i = i + 1 {
+				s = s + i
+			}
+			return s
+		}
+		func fib(n int) int {
+			if n < 2 {
+				return n
+			}
+			return fib(n-1) + fib(n-2)
+		}
+	`
+	runModes(t, src, vmModes, func(t *testing.T, cpu *vm.CPU) {
+		cpu.Profiler = vm.NewProfiler(1)
+		actual, err := cpu.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != 5560 {
+			t.Fatalf("Expected: 5560; but got: %d", actual)
+		}
+		if cpu.Profiler.Samples() == 0 {
+			t.Fatal("Expected samples to be taken")
+		}
+
+		var report strings.Builder
+		if err := cpu.Profiler.WriteReport(&report); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(report.String(), "\n")
+		if len(lines) < 4 || !strings.HasSuffix(lines[1], "  fib") || !strings.HasSuffix(lines[3], "  main") {
+			t.Errorf("Expected fib to be the hottest function and main to be last, but got\n%s", report.String())
+		}
+
+		var profile bytes.Buffer
+		if err := cpu.Profiler.WriteProfile(&profile); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(&profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prof := decodeProfile(t, data)
+
+		if types := strings.Join(prof.sampleTypes, " "); types != "samples/count instructions/count" {
+			t.Errorf("Expected samples and instructions sample types, but got %s", types)
+		}
+		samples := 0
+		for _, values := range prof.samples {
+			if len(values) != 2 || values[1] != values[0]*cpu.Profiler.Rate() {
+				t.Errorf("Expected a sample count and its instructions, but got %v", values)
+			}
+			samples += values[0]
+		}
+		if samples == 0 || samples != cpu.Profiler.Samples() {
+			t.Errorf("Expected %d samples, but got %d", cpu.Profiler.Samples(), samples)
+		}
+		for _, name := range []string{"fib", "main"} {
+			if prof.locations[name] == 0 {
+				t.Errorf("Expected a function and locations for %s, but got %v", name, prof.locations)
+			}
+		}
+	})
+}
+
+// profile is the part of a decoded profile.proto the tests check.
+type profile struct {
+	sampleTypes []string
+	samples     [][]int
+
+	// the number of locations in each function, by name
+	locations map[string]int
+}
+
+// decodeProfile decodes the sample types, samples, and the locations of
+// the functions of a profile.proto.
+func decodeProfile(t *testing.T, data []byte) profile {
+	var strs []string
+	var sampleTypes [][]int
+	var samples [][]int
+	var locationFuncs []int
+	funcNames := make(map[int]int)
+	for _, f := range protoFields(t, data) {
+		switch f.num {
+		case 1: // sample_type
+			vt := protoInts(t, f.data)
+			sampleTypes = append(sampleTypes, []int{vt[1], vt[2]})
+		case 2: // sample
+			for _, sf := range protoFields(t, f.data) {
+				if sf.num == 2 { // value
+					samples = append(samples, protoPacked(t, sf.data))
+				}
+			}
+		case 4: // location
+			for _, lf := range protoFields(t, f.data) {
+				if lf.num == 4 { // line
+					locationFuncs = append(locationFuncs, protoInts(t, lf.data)[1])
+				}
+			}
+		case 5: // function
+			fn := protoInts(t, f.data)
+			funcNames[fn[1]] = fn[2]
+		case 6: // string_table
+			strs = append(strs, string(f.data))
+		}
+	}
+
+	prof := profile{samples: samples, locations: make(map[string]int)}
+	for _, vt := range sampleTypes {
+		prof.sampleTypes = append(prof.sampleTypes, strs[vt[0]]+"/"+strs[vt[1]])
+	}
+	for _, id := range locationFuncs {
+		prof.locations[strs[funcNames[id]]]++
+	}
+	return prof
+}
+
+// protoField is a varint or length delimited field of a protocol
+// buffer message.
+type protoField struct {
+	num  int
+	x    uint64
+	data []byte
+}
+
+// protoFields decodes the fields of a protocol buffer message.
+func protoFields(t *testing.T, data []byte) []protoField {
+	var fields []protoField
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		data = data[n:]
+		f := protoField{num: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			f.x, n = binary.Uvarint(data)
+			data = data[n:]
+		case 2:
+			size, n := binary.Uvarint(data)
+			f.data = data[n : n+int(size)]
+			data = data[n+int(size):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// protoInts decodes the varint fields of a message by number, which
+// are zero if left out.
+func protoInts(t *testing.T, data []byte) map[int]int {
+	ints := make(map[int]int)
+	for _, f := range protoFields(t, data) {
+		ints[f.num] = int(f.x)
+	}
+	return ints
+}
+
+// protoPacked decodes a packed repeated varint field.
+func protoPacked(t *testing.T, data []byte) []int {
+	var xs []int
+	for len(data) > 0 {
+		x, n := binary.Uvarint(data)
+		if n <= 0 {
+			t.Fatal("malformed packed field")
+		}
+		xs = append(xs, int(x))
+		data = data[n:]
+	}
+	return xs
+}
+
+func TestCoverage(t *testing.T) {
+	src := `func main() int {
+	x := abs(3)
+	for i := 0; i < 3; i = i + 1 {
+		x = x + i
+	}
+	return x
+}
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+func unused() int {
+	return 1
+}
+`
+	lcov := `TN:
+SF:test.gos
+FN:2,main
+FN:9,abs
+FN:15,unused
+FNDA:1,main
+FNDA:1,abs
+FNDA:0,unused
+FNF:3
+FNH:2
+DA:2,1
+DA:3,3
+DA:4,3
+DA:6,1
+DA:9,1
+DA:10,0
+DA:12,1
+DA:15,0
+LF:8
+LH:6
+end_of_record
+`
+	// the optimized mode inlines abs, which is still covered
+	modes := make([]vmMode, len(vmModes))
+	for i, mode := range vmModes {
+		mode.coverage = true
+		modes[i] = mode
+	}
+	runModes(t, src, modes, func(t *testing.T, cpu *vm.CPU) {
+		actual, err := cpu.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != 6 {
+			t.Fatalf("Expected: 6; but got: %d", actual)
+		}
+
+		var report strings.Builder
+		if err := cpu.WriteLCOV(&report); err != nil {
+			t.Fatal(err)
+		}
+		if report.String() != lcov {
+			t.Errorf("Expected LCOV report:\n%s\nbut got:\n%s", lcov, report.String())
+		}
+
+		var page strings.Builder
+		if err := cpu.WriteCoverHTML(&page, token.NewFile("test.gos", []byte(src)).FileSet()); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(page.String(), `<span class="line miss"><span class="num">10</span><span class="count">0</span>		return -n</span>`) {
+			t.Errorf("Expected line 10 to be marked as not covered, but got:\n%s", page.String())
+		}
+	})
+}
+
+func TestVirtualMachinePanic(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		err    vm.PanicError
+		output int
+	}{
+		{
+			name: "unrecovered panic",
+			input: `
+				func main() int {
+					f()
+					return 0
+				}
+				func f() {
+					defer g()
+					panic(7)
+				}
+				func g() {}
+			`,
+			err: vm.PanicError{Value: 7, Thread: 1, Stack: []string{"f", "main"}},
+		},
+		{
+			name: "recover must be called by the deferred call",
+			input: `
+				func main() int {
+					f()
+					return 0
+				}
+				func f() {
+					defer g()
+					panic(7)
+				}
+				func g() {
+					h()
+				}
+				func h() {
+					recover()
+				}
+			`,
+			err: vm.PanicError{Value: 7, Thread: 1, Stack: []string{"f", "main"}},
+		},
+		{
+			name: "panic in goroutine",
+			input: `
+				func main() int {
+					ch := make(chan int)
+					go f(ch)
+					return <-ch
+				}
+				func f(ch chan int) {
+					panic(3)
+				}
+			`,
+			err: vm.PanicError{Value: 3, Thread: 2, Stack: []string{"f"}},
+		},
+		{
+			name: "assignment to entry in nil map",
+			input: `
+				func main() int {
+					m := map[int]map[int]int{}
+					n := m[1]
+					n[1] = 2
+					return 0
+				}
+			`,
+			err: vm.PanicError{Value: -1, Thread: 1, Err: "assignment to entry in nil map", Stack: []string{"main"}},
+		},
+		{
+			name: "close of closed channel",
+			input: `
+				func main() int {
+					c := make(chan int)
+					close(c)
+					close(c)
+					return 0
+				}
+			`,
+			err: vm.PanicError{Value: -1, Thread: 1, Err: "close of closed channel", Stack: []string{"main"}},
+		},
+		{
+			name: "close of nil channel",
+			input: `
+				func main() int {
+					m := map[int]chan int{}
+					c := m[0]
+					close(c)
+					return 0
+				}
+			`,
+			err: vm.PanicError{Value: -1, Thread: 1, Err: "close of nil channel", Stack: []string{"main"}},
+		},
+		{
+			name: "send on closed channel",
+			input: `
+				func main() int {
+					c := make(chan int, 1)
+					close(c)
+					c <- 1
+					return 0
+				}
+			`,
+			err: vm.PanicError{Value: -1, Thread: 1, Err: "send on closed channel", Stack: []string{"main"}},
+		},
+		{
+			name: "blocked send on channel closed",
+			input: `
+				func main() int {
+					c := make(chan int)
+					done := make(chan int)
+					go send(c)
+					close(c)
+					return <-done
+				}
+				func send(c chan int) {
+					c <- 1
+				}
+			`,
+			err: vm.PanicError{Value: -1, Thread: 2, Err: "send on closed channel", Stack: []string{"send"}},
+		},
+		{
+			name: "integer divide by zero",
+			input: `
+				func main() int {
+					a := 0
+					return 10 / divide(a)
+				}
+				func divide(a int) int {
+					return 10 / a
+				}
+			`,
+			err: vm.PanicError{Value: -1, Thread: 1, Err: "integer divide by zero", Stack: []string{"divide", "main"}},
+		},
+		{
+			name: "recover from runtime errors",
+			input: `
+				func main() int {
+					m := map[int]int{}
+					divide(m, 0)
+					assign(m)
+					closeTwice(m)
+					return m[0]
+				}
+				func divide(m map[int]int, d int) int {
+					defer catch(m)
+					return 10 / d
+				}
+				func assign(m map[int]int) {
+					defer catch(m)
+					n := map[int]map[int]int{}[1]
+					n[1] = 2
+				}
+				func closeTwice(m map[int]int) {
+					defer catch(m)
+					c := make(chan int)
+					close(c)
+					close(c)
+				}
+				func catch(m map[int]int) {
+					m[0] = m[0] * 10 - recover()
+				}
+			`,
+			output: 111,
+		},
+	}
+
+	for _, tt := range tests {
+		for _, registers := range []bool{false, true} {
+			name := tt.name
+			if registers {
+				name += " registers"
+			}
+			t.Run(name, func(t *testing.T) {
+				file := token.NewFile("test.gos", []byte(tt.input))
+				asm := vm.NewAsm()
+				asm.Registers = registers
+				errs := compile.Compile(file, asm, ir.PassOptions{})
+				for _, err := range errs {
+					t.Fatalf("Expected no error, but got\n%s", err)
+				}
+
+				cpu := vm.NewCPU(asm.Program)
+				cpu.GCStress = true
+				actual, err := cpu.Run()
+				if tt.err.Thread == 0 {
+					if err != nil {
+						t.Fatal(err)
+					}
+					if actual != tt.output {
+						t.Errorf("Expected: %d; but got: %d", tt.output, actual)
+					}
+					return
+				}
+				perr, ok := err.(*vm.PanicError)
+				if !ok {
+					t.Fatalf("Expected a panic error, but got %v", err)
+				}
+				if perr.Error() != tt.err.Error() {
+					t.Errorf("Expected %q, but got %q", tt.err.Error(), perr.Error())
+				}
+			})
+		}
+	}
+}
+
+func TestPackages(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  fstest.MapFS
+		output int
+		err    string
+	}{
+		{
+			name: "main package in several files",
+			files: fstest.MapFS{
+				"main.gos":  {Data: []byte("package main\nfunc main() int { return twice(limit) }")},
+				"twice.gos": {Data: []byte("package main\nconst limit = 21\nfunc twice(a int) int { return a * 2 }")},
+			},
+			output: 42,
+		},
+		{
+			name: "imported package",
+			files: fstest.MapFS{
+				"main.gos": {Data: []byte(`package main
+					import "mathx"
+					func main() int { return mathx.Add(mathx.Ten, 5) }`)},
+				"mathx/add.gos": {Data: []byte(`package mathx
+					const Ten = 10
+					func Add(a int, b int) int { return add(a, b) }
+					func add(a int, b int) int { return a + b }`)},
+			},
+			output: 15,
+		},
+		{
+			name: "packages can reuse unexported names",
+			files: fstest.MapFS{
+				"main.gos": {Data: []byte(`package main
+					import (
+						"a"
+						"lib/b"
+					)
+					func main() int { return a.Get() * 10 + b.Get() + get() }
+					func get() int { return 100 }`)},
+				"a/a.gos":     {Data: []byte("package a\nimport \"lib/b\"\nfunc Get() int { return get() + b.Get() }\nfunc get() int { return 1 }")},
+				"lib/b/b.gos": {Data: []byte("package b\nfunc Get() int { return get() }\nfunc get() int { return 2 }")},
+			},
+			output: 132,
+		},
+		{
+			name: "packages can share a name",
+			files: fstest.MapFS{
+				"main.gos":        {Data: []byte("package main\nimport (\n\"a/util\"\n\"lib\"\n)\nfunc main() int { return util.Get() * 10 + lib.Get() }")},
+				"lib/lib.gos":     {Data: []byte("package lib\nimport \"b/util\"\nfunc Get() int { return util.Get() }")},
+				"a/util/util.gos": {Data: []byte("package util\nfunc Get() int { return 1 }")},
+				"b/util/util.gos": {Data: []byte("package util\nfunc Get() int { return 2 }")},
+			},
+			output: 12,
+		},
+		{
+			name: "unexported name",
+			files: fstest.MapFS{
+				"main.gos": {Data: []byte("package main\nimport \"a\"\nfunc main() int { return a.get() }")},
+				"a/a.gos":  {Data: []byte("package a\nfunc get() int { return 1 }")},
+			},
+			err: "main.gos:3:27: name get not exported by package a",
+		},
+		{
+			name: "import only applies to its own file",
+			files: fstest.MapFS{
+				"main.gos": {Data: []byte("package main\nimport \"a\"\nfunc main() int { return f() }")},
+				"f.gos":    {Data: []byte("package main\nfunc f() int { return a.Get() }")},
+				"a/a.gos":  {Data: []byte("package a\nfunc Get() int { return 1 }")},
+			},
+			err: "f.gos:2:23: undefined name a",
+		},
+		{
+			name: "import cycle",
+			files: fstest.MapFS{
+				"main.gos": {Data: []byte("package main\nimport \"a\"\nfunc main() int { return a.Get() }")},
+				"a/a.gos":  {Data: []byte("package a\nimport \"b\"\nfunc Get() int { return b.Get() }")},
+				"b/b.gos":  {Data: []byte("package b\nimport \"a\"\nfunc Get() int { return a.Get() }")},
+			},
+			err: "b/b.gos:2:8: import cycle not allowed: a",
+		},
+		{
+			name: "missing package",
+			files: fstest.MapFS{
+				"main.gos": {Data: []byte("package main\nimport \"a\"\nfunc main() int { return 0 }")},
+			},
+			err: "main.gos:2:8: could not import a",
+		},
+		{
+			name: "malformed import path",
+			files: fstest.MapFS{
+				"main.gos": {Data: []byte("package main\nimport \"a\\q\"\nfunc main() int { return 0 }")},
+			},
+			err: "main.gos:2:8: malformed import path \"a\\q\"",
+		},
+		{
+			name: "mismatched package names",
+			files: fstest.MapFS{
+				"main.gos":  {Data: []byte("package main\nfunc main() int { return 0 }")},
+				"other.gos": {Data: []byte("package other")},
+			},
+			err: "other.gos:1:1: package other; expected package main",
+		},
+		{
+			name: "missing package clause",
+			files: fstest.MapFS{
+				"main.gos": {Data: []byte("func main() int { return 0 }")},
+			},
+			err: "main.gos:1:1: missing package clause",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asm := vm.NewAsm()
+			errs := compile.CompilePackage(tt.files, ".", asm, ir.PassOptions{})
+
+			if tt.err != "" {
+				if len(errs) == 0 {
+					t.Fatalf("Expected error %q, but got none", tt.err)
+				}
+				if !strings.Contains(errs[0].Error(), tt.err) {
+					t.Errorf("Expected error %q, but got\n%s", tt.err, errs[0])
+				}
+				return
+			}
+			for _, err := range errs {
+				t.Fatalf("Expected no error, but got\n%s", err)
+			}
+
+			actual, err := vm.NewCPU(asm.Program).Run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != tt.output {
+				t.Errorf("Expected: %d; but got: %d", tt.output, actual)
+			}
+		})
+	}
+}
+
+// TestPackagesSharingName checks the functions of packages with the
+// same name are distinct symbols in the native assembly, which are
+// quoted since their import paths have slashes.
+func TestPackagesSharingName(t *testing.T) {
+	files := fstest.MapFS{
+		"main.gos":        {Data: []byte("package main\nimport (\n\"a/util\"\n\"lib\"\n)\nfunc main() int { return util.Get() + lib.Get() }")},
+		"lib/lib.gos":     {Data: []byte("package lib\nimport \"b/util\"\nfunc Get() int { return util.Get() }")},
+		"a/util/util.gos": {Data: []byte("package util\nfunc Get() int { return 1 }")},
+		"b/util/util.gos": {Data: []byte("package util\nfunc Get() int { return 2 }")},
+	}
+
+	out := &bytes.Buffer{}
+	errs := compile.CompilePackage(files, ".", &aarch64.Assembler{Out: out}, ir.PassOptions{})
+	for _, err := range errs {
+		t.Fatalf("Expected no error, but got\n%s", err)
+	}
+	for _, label := range []string{"\n\"_a/util.Get\":", "\n\"_b/util.Get\":", "bl \"_a/util.Get\"", "bl \"_b/util.Get\""} {
+		if !strings.Contains(out.String(), label) {
+			t.Errorf("Expected %q in assembly:\n%s", label, out)
+		}
+	}
+}
+
+func TestExternFunctions(t *testing.T) {
+	input := `
+		func add(a int, b int) int
+		func sum(a int, b int, c int, d int, e int, f int, g int, h int, i int, j int) int
+		func record(p *int)
+		func main() int {
+			x := 5
+			record(&x)
+			return add(sum(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), x) + add(0, 1)
+		}
+	`
+	file := token.NewFile("test.gos", []byte(input))
+	asm := vm.NewAsm()
+	errs := compile.Compile(file, asm, ir.PassOptions{})
+	for _, err := range errs {
+		t.Fatalf("Expected no error, but got\n%s", err)
+	}
+
+	cpu := vm.NewCPU(asm.Program)
+	if _, err := cpu.Run(); err == nil || err.Error() != "no host function for extern function record" {
+		t.Errorf("Expected error for unbound extern function, but got %v", err)
+	}
+
+	var calls []string
+	cpu.RegisterHost("add", func(args ...int) int {
+		calls = append(calls, fmt.Sprint("add", args))
+		return args[0] + args[1]
+	})
+	cpu.RegisterHost("sum", func(args ...int) int {
+		calls = append(calls, fmt.Sprint("sum", args))
+		sum := 0
+		for i, arg := range args {
+			// weight the args so they must be in order
+			sum += arg * (i + 1)
+		}
+		return sum
+	})
+	cpu.RegisterHost("record", func(args ...int) int {
+		calls = append(calls, "record")
+		return 0
+	})
+
+	actual, err := cpu.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual != 391 {
+		t.Errorf("Expected: %d; but got: %d", 391, actual)
+	}
+	expected := "[record sum[1 2 3 4 5 6 7 8 9 10] add[385 5] add[0 1]]"
+	if fmt.Sprint(calls) != expected {
+		t.Errorf("Expected calls %s, but got %s", expected, calls)
+	}
+
+	// natively, args beyond the eighth are passed on the stack
+	out := &bytes.Buffer{}
+	errs = compile.Compile(file, &aarch64.Assembler{Out: out}, ir.PassOptions{})
+	for _, err := range errs {
+		t.Fatalf("Expected no error, but got\n%s", err)
+	}
+	call := `
+  mov x9, #9
+  str x9, [sp, #0]
+  mov x9, #10
+  str x9, [sp, #8]
+  mov x9, #1
+  mov x10, #2
+  mov x11, #3
+  mov x12, #4
+  mov x13, #5
+  mov x14, #6
+  mov x15, #7
+  mov x20, #8
+  mov x0, x9
+  mov x1, x10
+  mov x2, x11
+  mov x3, x12
+  mov x4, x13
+  mov x5, x14
+  mov x6, x15
+  mov x7, x20
+  bl _sum
+`
+	if !strings.Contains(out.String(), call) {
+		t.Errorf("Expected call:%s\nin assembly:\n%s", call, out)
+	}
+}
+
+// TestExternFunctionsStackArgs checks an extern function can be called
+// with more args than there are registers to allocate, as the args
+// passed on the stack are stored before the call, so natively only the
+// first eight need registers at the call.
+func TestExternFunctionsStackArgs(t *testing.T) {
+	var params, args []string
+	for i := 0; i < 20; i++ {
+		params = append(params, fmt.Sprintf("p%d int", i))
+		args = append(args, fmt.Sprintf("a+%d", i))
+	}
+	input := fmt.Sprintf(`
+		func sum(%s) int
+		func main() int {
+			a := 1
+			return sum(%s)
+		}
+	`, strings.Join(params, ", "), strings.Join(args, ", "))
+	file := token.NewFile("test.gos", []byte(input))
+
+	for _, mode := range vmModes {
+		asm := vm.NewAsm()
+		asm.Registers = mode.registers
+		for _, err := range compile.Compile(file, asm, mode.opts) {
+			t.Fatalf("%s: expected no error, but got\n%s", mode.name, err)
+		}
+		cpu := vm.NewCPU(asm.Program)
+		cpu.RegisterHost("sum", func(args ...int) int {
+			sum := 0
+			for i, arg := range args {
+				// weight the args so they must be in order
+				sum += arg * (i + 1)
+			}
+			return sum
+		})
+		actual, err := cpu.Run()
+		if err != nil {
+			t.Fatalf("%s: %s", mode.name, err)
+		}
+		if actual != 2870 {
+			t.Errorf("%s: expected: 2870; but got: %d", mode.name, actual)
+		}
+	}
+
+	for _, opts := range []ir.PassOptions{{}, {OptLevel: 1}} {
+		out := &strings.Builder{}
+		for _, err := range compile.Compile(file, &aarch64.Assembler{Out: out}, opts) {
+			t.Fatalf("Expected no error, but got\n%s", err)
+		}
+		// the last arg is stored to the bottom of the frame
+		if !strings.Contains(out.String(), ", [sp, #88]\n") || !strings.Contains(out.String(), "bl _sum\n") {
+			t.Errorf("Expected the stack args to be stored before the call in assembly:\n%s", out)
+		}
+	}
+}
+
+func TestEmbedding(t *testing.T) {
+	src := `
+		func scale(x int) int
+		func fib(n int) int {
+			if n < 2 {
+				return n
+			}
+			return fib(n-1) + fib(n-2)
+		}
+		func scaled(a int, b int) int {
+			return scale(a) + b
+		}
+		func main() int {
+			return fib(10)
+		}
+	`
+	prog, err := gosling.Compile(src)
+	if err != nil {
+		t.Fatalf("Expected no error, but got\n%s", err)
+	}
+
+	if _, err := prog.Call("scaled", 2, 3); err == nil || err.Error() != "no host function for extern function scale" {
+		t.Errorf("Expected error for unregistered host function, but got %v", err)
+	}
+
+	gosling.RegisterHost("scale", func(args ...int) int {
+		return args[0] * 10
+	})
+
+	tests := []struct {
+		name   string
+		args   []int
+		result int
+		err    string
+	}{
+		{"fib", []int{20}, 6765, ""},
+		{"scaled", []int{2, 3}, 23, ""},
+		{"main", nil, 55, ""},
+		{"fib", nil, 0, "wrong number of arguments to fib: expected 1, got 0"},
+		{"missing", nil, 0, "undefined function missing"},
+	}
+	for _, tt := range tests {
+		result, err := prog.Call(tt.name, tt.args...)
+		switch {
+		case tt.err != "" && (err == nil || err.Error() != tt.err):
+			t.Errorf("Call(%q): expected error %q, but got %v", tt.name, tt.err, err)
+		case tt.err == "" && err != nil:
+			t.Errorf("Call(%q): expected no error, but got %v", tt.name, err)
+		case result != tt.result:
+			t.Errorf("Call(%q): expected %d, but got %d", tt.name, tt.result, result)
+		}
+	}
+
+	if result, err := prog.Run(); err != nil || result != 55 {
+		t.Errorf("Expected main to return 55, but got %d, %v", result, err)
+	}
+
+	// each program is compiled with its own options
+	optimized, err := gosling.CompileWithOptions(src, gosling.Options{OptLevel: 1})
+	if err != nil {
+		t.Fatalf("Expected no error, but got\n%s", err)
+	}
+	if result, err := optimized.Call("fib", 20); err != nil || result != 6765 {
+		t.Errorf("Expected the optimized fib to return 6765, but got %d, %v", result, err)
+	}
+
+	if err := prog.WriteLCOV(io.Discard); err == nil {
+		t.Errorf("Expected an error writing the coverage of a program compiled without it")
+	}
+	covered, err := gosling.CompileWithOptions(src, gosling.Options{Coverage: true})
+	if err != nil {
+		t.Fatalf("Expected no error, but got\n%s", err)
+	}
+	if _, err := covered.Call("fib", 1); err != nil {
+		t.Fatal(err)
+	}
+	var report strings.Builder
+	if err := covered.WriteLCOV(&report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(report.String(), "FNDA:1,fib\n") || !strings.Contains(report.String(), "FNDA:0,main\n") {
+		t.Errorf("Expected fib to be called once and main never, but got:\n%s", report.String())
+	}
+	var page strings.Builder
+	if err := covered.WriteCoverHTML(&page); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(page.String(), "script.gos") {
+		t.Errorf("Expected the page to show script.gos, but got:\n%s", page.String())
+	}
+
+	if _, err := gosling.Compile("func main() int { return x }"); err == nil || !strings.Contains(err.Error(), "undefined name x") {
+		t.Errorf("Expected compile error, but got %v", err)
+	}
+}
+
+// TestVirtualMachineWideConstants checks constants wider than the
+// immediates of the instructions are loaded in parts.
+func TestVirtualMachineWideConstants(t *testing.T) {
+	prog, err := gosling.Compile("func main() int { return 1099511627776 }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := prog.Run(); err != nil || result != 1099511627776 {
+		t.Errorf("Expected main to return 1099511627776, but got %d, %v", result, err)
+	}
+
+	prog, err = gosling.Compile("func main() int { const a = 9223372036854775807 * 4; const b = a / 8; return b }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := prog.Run(); err != nil || result != 4611686018427387903 {
+		t.Errorf("Expected main to return 4611686018427387903, but got %d, %v", result, err)
+	}
+
+	tests := []struct {
+		input  string
+		output int
+	}{
+		{"{ a := 4294967296; return a / 65536 }", 65536},
+		{"{ a := 1099511627776; return a / 1048576 }", 1048576},
+		{"{ a := -1099511627777; return a / 1048576 }", -1048576},
+		{"{ a := 9223372036854775807; return a / 4611686018427387904 }", 1},
+		{"{ a := -9223372036854775807; return a / 4611686018427387904 }", -1},
+	}
+	for _, tt := range tests {
+		for _, registers := range []bool{false, true} {
+			file := token.NewFile("test.gos", []byte("func main() int "+tt.input))
+			asm := vm.NewAsm()
+			asm.Registers = registers
+			for _, err := range compile.Compile(file, asm, ir.PassOptions{}) {
+				t.Fatalf("%s: expected no error, but got %s", tt.input, err)
+			}
+			actual, err := vm.NewCPU(asm.Program).Run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != tt.output {
+				t.Errorf("%s (registers %v): expected: %d; but got: %d", tt.input, registers, tt.output, actual)
+			}
+		}
+	}
+}
+
+func TestSeparateCompilation(t *testing.T) {
+	files := fstest.MapFS{
+		"main.gos": {Data: []byte(`package main
+			import (
+				"a"
+				"b"
+				"lib/c"
+			)
+			func main() int { return a.Get() * 10 + b.Get() + c.Get() }`)},
+		"a/a.gos":     {Data: []byte("package a\nimport \"b\"\nfunc Get() int { return b.Get() + b.One }")},
+		"b/b.gos":     {Data: []byte("package b\nconst One = 1\nfunc Get() int { return 2 }")},
+		"lib/c/c.gos": {Data: []byte("package c\nfunc Get() int { return 100 }")},
+	}
+
+	build := func(prev map[string]*object.Object) map[string]*object.Object {
+		t.Helper()
+		objs, errs := compile.Build(files, ".", object.VM, prev, ir.PassOptions{})
+		for _, err := range errs {
+			t.Fatalf("Expected no error, but got\n%s", err)
+		}
+		return objs
+	}
+
+	run := func(objs map[string]*object.Object, expected int) {
+		t.Helper()
+		var list []*object.Object
+		for _, obj := range objs {
+			// objects are written out and read back in between builds
+			buf := &bytes.Buffer{}
+			if err := obj.Write(buf); err != nil {
+				t.Fatal(err)
+			}
+			obj, err := object.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			list = append(list, obj)
+		}
+
+		linked, err := object.Link(list...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := vm.NewCPU(linked.VM).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != expected {
+			t.Errorf("Expected: %d; but got: %d", expected, actual)
+		}
+	}
+
+	recompiled := func(prev, objs map[string]*object.Object, expected ...string) {
+		t.Helper()
+		var actual []string
+		for _, path := range []string{"", "a", "b", "lib/c"} {
+			if objs[path] != prev[path] {
+				actual = append(actual, path)
+			}
+		}
+		if fmt.Sprint(actual) != fmt.Sprint(expected) {
+			t.Errorf("Expected %q to be recompiled, but got %q", expected, actual)
+		}
+	}
+
+	objs := build(nil)
+	run(objs, 132)
+
+	prev := objs
+	objs = build(prev)
+	recompiled(prev, objs)
+
+	// changing a function body does not change the exports
+	files["b/b.gos"] = &fstest.MapFile{Data: []byte("package b\nconst One = 1\nfunc Get() int { return 3 }")}
+	prev = objs
+	objs = build(prev)
+	recompiled(prev, objs, "b")
+	run(objs, 143)
+
+	// but changing an exported constant does
+	files["b/b.gos"] = &fstest.MapFile{Data: []byte("package b\nconst One = 2\nfunc Get() int { return 3 }")}
+	prev = objs
+	objs = build(prev)
+	recompiled(prev, objs, "", "a", "b")
+	run(objs, 153)
+
+	delete(objs, "a")
+	var list []*object.Object
+	for _, obj := range objs {
+		list = append(list, obj)
+	}
+	if _, err := object.Link(list...); err == nil || err.Error() != "undefined: a.Get" {
+		t.Errorf("Expected undefined symbol error, but got %v", err)
+	}
+
+	// the native target is linked by the assembler
+	objs, errs := compile.Build(files, ".", object.AArch64, nil, ir.PassOptions{})
+	for _, err := range errs {
+		t.Fatalf("Expected no error, but got\n%s", err)
+	}
+	linked, err := object.Link(objs[""], objs["a"], objs["b"], objs["lib/c"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, label := range []string{"\n_main:", "\n_a.Get:", "\n_b.Get:", "bl _a.Get", "\n\"_lib/c.Get\":", "bl \"_lib/c.Get\""} {
+		if !strings.Contains(linked.Asm, label) {
+			t.Errorf("Expected %q in assembly:\n%s", label, linked.Asm)
+		}
+	}
+}
+
+func TestCodegenNativeAssembly(t *testing.T) {
+	testNativeAssembly(t, ir.PassOptions{})
+}
+
+// TestCodegenNativeAssemblyOptimized runs each test compiled to native
+// assembly with the optimizations.
+func TestCodegenNativeAssemblyOptimized(t *testing.T) {
+	testNativeAssembly(t, ir.PassOptions{OptLevel: 1})
+}
+
+func testNativeAssembly(t *testing.T, opts ir.PassOptions) {
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			input := "func main() int " + tt.input
+			if strings.Contains(tt.input, "main()") {
+				input = tt.input
+			}
+			file := token.NewFile("test.gos", []byte(input))
+
+			tmp, err := os.CreateTemp("", "gosling_*.s")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(tmp.Name())
+
+			asm := &aarch64.Assembler{Out: tmp}
+
+			errs := compile.Compile(file, asm, opts)
+			if len(errs) > 0 {
+				for _, err := range errs {
+					t.Errorf("Expected no error, but got %s", err)
+				}
+				return
+			}
+
+			runtime := tmp.Name() + ".runtime.c"
+			err = os.WriteFile(runtime, aarch64.Runtime, 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(runtime)
+
+			cmd := exec.Command("gcc", "-o", tmp.Name()+".out", tmp.Name(), runtime)
+			err = cmd.Run()
+			defer os.Remove(tmp.Name() + ".out")
+			if err != nil {
+				buf, err := os.ReadFile(tmp.Name())
+				if err != nil {
+					t.Fatal(err)
+				}
+				fmt.Println(string(buf))
+				t.Errorf("Expected no error, but got %s", err)
+			}
+
+			cmd = exec.Command(tmp.Name() + ".out")
+			_, err = cmd.Output()
+			if err != nil {
+				if tt.output == 0 {
+					t.Errorf("Expected no error, but got %s", err)
+					return
+				}
+				if ee, ok := err.(*exec.ExitError); ok {
+					actual := ee.ExitCode()
+					if actual != tt.output {
+						t.Errorf("Expected: %d; but got: %d", tt.output, actual)
+					}
+					return
+				}
+				t.Errorf("Expected no error, but got %s", err)
+			}
+			if tt.output != 0 && err == nil {
+				t.Errorf("Expected error, but got none")
+			}
+		})
+	}
+}
+
+// TestCodegenNativeAssemblyUnsupported checks the features the native
+// backend doesn't support are reported as errors.
+func TestCodegenNativeAssemblyUnsupported(t *testing.T) {
+	file := token.NewFile("test.gos", []byte(`
+		func main() int {
+			go f()
+			go f()
+			return 0
+		}
+		func f() {}
+	`))
+	errs := compile.Compile(file, &aarch64.Assembler{Out: io.Discard}, ir.PassOptions{})
+	if len(errs) != 2 {
+		t.Fatalf("Expected 2 errors, but got %v", errs)
+	}
+	for i, err := range errs {
+		expected := fmt.Sprintf("error test.gos:%d:4: go statements are not supported by the aarch64 backend yet", i+3)
+		if !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("Expected %q, but got %q", expected, err)
+		}
+	}
+}
+
+// TestCodegenNativeAssemblyFlags checks a comparison is only fused into
+// the branch on it when nothing in between can change the flags, like
+// the call between them here.
+func TestCodegenNativeAssemblyFlags(t *testing.T) {
+	file := token.NewFile("test.gos", []byte(`
+		func f() int { return 3 }
+		func main() int { a := 1; b := f(); c := a < b; x := f(); if c { return x }; return 0 }
+	`))
+	out := &strings.Builder{}
+	for _, err := range compile.Compile(file, &aarch64.Assembler{Out: out}, ir.PassOptions{}) {
+		t.Fatalf("Expected no error, but got %s", err)
+	}
+
+	expected := `
+  cmp x10, x9
+  cset x19, lt
+  bl _f
+  mov x9, x0
+  cmp x19, #0
+  b.ne .L.then0
+`
+	if !strings.Contains(out.String(), expected) {
+		t.Errorf("Expected:%s\nin assembly:\n%s", expected, out)
+	}
+}
+
+// TestCodegenOptimized compiles each test to native assembly with the
+// optimizations, verifying the IR after each pass.
+func TestCodegenOptimized(t *testing.T) {
+	opts := ir.PassOptions{OptLevel: 1, Verify: true}
+
+	for _, tt := range tests {
+		input := "func main() int " + tt.input
+		if strings.Contains(tt.input, "main()") {
+			input = tt.input
+		}
+		file := token.NewFile("test.gos", []byte(input))
+		errs := compile.Compile(file, &aarch64.Assembler{Out: io.Discard}, opts)
+		for _, err := range errs {
+			t.Errorf("%s: expected no error, but got %s", tt.name, err)
+		}
+	}
+}
+
+// TestVirtualMachineOptimizedConstants checks the optimizations fold
+// the constants of a function compiled to the VM, which are computed
+// from locals, so the type checker can't fold them first.
+func TestVirtualMachineOptimizedConstants(t *testing.T) {
+	file := token.NewFile("test.gos", []byte("func main() int { a := 1; b := a + 2; return b - 2 }"))
+
+	// without the optimizations the additions are run
+	asm := vm.NewAsm()
+	for _, err := range compile.Compile(file, asm, ir.PassOptions{}) {
+		t.Fatalf("Expected no error, but got %s", err)
+	}
+	if ops := opcodes(asm.Program, "main"); !ops[vm.PopAdd] || !ops[vm.PopSub] {
+		t.Errorf("Expected the unoptimized code to add and subtract, but got %v", ops)
+	}
+
+	dump := &strings.Builder{}
+	opts := ir.PassOptions{
+		OptLevel:   1,
+		Verify:     true,
+		DumpBefore: []string{"sccp"},
+		DumpAfter:  []string{"sccp"},
+		Out:        dump,
+	}
+	asm = vm.NewAsm()
+	for _, err := range compile.Compile(file, asm, opts) {
+		t.Fatalf("Expected no error, but got %s", err)
+	}
+
+	expected := trimLines(`
+		// before sccp: main
+		func main() int {
+		main.entry0:
+			Prologue 2
+			v8 = LoadInt 1
+			v15 = LoadInt 2
+			v17 = Add v8, v15
+			v22 = LoadInt 2
+			v24 = Sub v17, v22
+			Jump main.epilogue0
+		main.epilogue0:
+			Epilogue
+			Return v24
+		}
+		// after sccp: main
+		func main() int {
+		main.entry0:
+			Prologue 2
+			Jump main.epilogue0
+		main.epilogue0:
+			Epilogue
+			Return 1
+		}
+	`)
+	if actual := trimLines(ir.StripPositions(dump.String())); actual != expected {
+		t.Errorf("Expected:\n%s\nbut got:\n%s", expected, actual)
+	}
+
+	ops := opcodes(asm.Program, "main")
+	for _, op := range []vm.Opcode{vm.RAdd, vm.RSub, vm.RAddImm, vm.RSubImm, vm.PopAdd, vm.PopSub, vm.Add, vm.Sub} {
+		if ops[op] {
+			t.Errorf("Expected the constants to be folded, but got %s", op)
+		}
+	}
+
+	actual, err := vm.NewCPU(asm.Program).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual != 1 {
+		t.Errorf("Expected: 1; but got: %d", actual)
+	}
+}
+
+// TestVirtualMachineRegisterCalls checks the calls of a function in
+// register mode take the argument into its slot in the prologue, and
+// use the constants as immediates rather than loading them into slots
+// on every call.
+func TestVirtualMachineRegisterCalls(t *testing.T) {
+	file := token.NewFile("test.gos", []byte(`
+		func main() int { return fib(10) }
+
+		func fib(n int) int {
+			if n < 2 {
+				return n
+			}
+			return fib(n-1) + fib(n-2)
+		}
+	`))
+
+	asm := vm.NewAsm()
+	asm.Registers = true
+	for _, err := range compile.Compile(file, asm, ir.PassOptions{}) {
+		t.Fatalf("Expected no error, but got %s", err)
+	}
+
+	expected := trimLines(`
+		rprologue 4, s2, 1
+		rltimm s1, s2, 2
+		rjumpiffalse s1, 11
+		jump 20
+		rsubimm s3, s2, 1
+		rput s0, s3
+		call 7
+		rtake s3, s0
+		rsubimm s2, s2, 2
+		rput s0, s2
+		call 7
+		rtake s2, s0
+		radd s2, s3, s2
+		rput s0, s2
+		return
+	`)
+	if actual := listing(asm.Program, "fib"); actual != expected {
+		t.Errorf("Expected:\n%s\nbut got:\n%s", expected, actual)
+	}
+
+	actual, err := vm.NewCPU(asm.Program).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual != 55 {
+		t.Errorf("Expected: 55; but got: %d", actual)
+	}
+}
+
+// listing returns the instructions of the named function, one a line.
+func listing(prog *vm.Program, name string) string {
+	fn := prog.FuncNamed(name)
+	var lines []string
+	for pc := fn.Entry; pc < len(prog.Code) && prog.FuncFor(pc) == fn; pc++ {
+		lines = append(lines, prog.Code[pc].String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// trimLines trims the space around each line, and drops the empty lines.
+func trimLines(s string) string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// opcodes returns the set of the opcodes of the named function.
+func opcodes(prog *vm.Program, name string) map[vm.Opcode]bool {
+	fn := prog.FuncNamed(name)
+	ops := make(map[vm.Opcode]bool)
+	for pc := fn.Entry; pc < len(prog.Code) && prog.FuncFor(pc) == fn; pc++ {
+		ops[prog.Code[pc].Opcode()] = true
+	}
+	return ops
+}
+
+// TestCodegenInlining checks the optimizations inline the calls to small
+// functions, but not to functions marked //gosling:noinline, and that a
+// recursive function is only inlined into its caller once.
+func TestCodegenInlining(t *testing.T) {
+	opts := ir.PassOptions{OptLevel: 1, Verify: true}
+
+	var input string
+	for _, tt := range tests {
+		if tt.name == "inlined helpers" {
+			input = tt.input
+		}
+	}
+	file := token.NewFile("test.gos", []byte(input))
+	out := &strings.Builder{}
+	for _, err := range compile.Compile(file, &aarch64.Assembler{Out: out}, opts) {
+		t.Errorf("expected no error, but got %s", err)
+	}
+
+	asm := out.String()
+	for _, callee := range []string{"add", "larger", "sum", "bump"} {
+		if strings.Contains(asm, "bl _"+callee+"\n") {
+			t.Errorf("expected the calls to %s to be inlined", callee)
+		}
+	}
+	if !strings.Contains(asm, "bl _keep\n") {
+		t.Errorf("expected the call to keep not to be inlined")
+	}
+	if strings.Count(asm, "bl _fact\n") != 2 {
+		t.Errorf("expected fact to be inlined into main once, leaving a call in each")
+	}
+}
+
+var benchmarks = []struct {
+	name   string
+	input  string
+	output int
+}{
+	{
+		name: "fib",
+		input: `
+			func main() int {
+				return fib(20)
+			}
+			func fib(n int) int {
+				if n < 2 {
+					return n
+				}
+				return fib(n-1) + fib(n-2)
+			}
+		`,
+		output: 6765,
+	},
+	{
+		name: "loop",
+		input: `
+			func main() int {
+				s := 0
+				for i := 0; i < 100000; i = i + 1 {
+					s = s + i * 2
+				}
+				return s
+			}
+		`,
+		output: 9999900000,
+	},
+	{
+		name: "nested loops",
+		input: `
+			func main() int {
+				n := 0
+				for i := 0; i < 300; i = i + 1 {
+					for j := 0; j < 300; j = j + 1 {
+						if i / 3 * 3 == j { n = n + 1 }
+					}
+				}
+				return n
+			}
+		`,
+		output: 300,
+	},
+}
+
+// BenchmarkVirtualMachine measures the time each program takes to run,
+// and the interpreter's throughput in instructions per second.
+func BenchmarkVirtualMachine(b *testing.B) {
+	for _, bm := range benchmarks {
+		for _, mode := range unstressedModes {
+			b.Run(bm.name+"/"+mode.name, func(b *testing.B) {
+				cpu := compileMode(b, bm.input, mode)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					actual, err := cpu.Run()
+					if err != nil {
+						b.Fatal(err)
+					}
+					if actual != bm.output {
+						b.Fatalf("Expected: %d; but got: %d", bm.output, actual)
+					}
+				}
+
+				steps := float64(cpu.Steps())
+				b.ReportMetric(steps, "instrs/op")
+				b.ReportMetric(steps*float64(b.N)/b.Elapsed().Seconds()/1e6, "Minstrs/s")
+			})
+		}
 	}
 }
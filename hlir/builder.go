@@ -27,16 +27,31 @@ type Builder struct {
 	a ir.Value
 	b ir.Value
 
+	// the values most recently popped into each register,
+	// which are the operands of runtime calls
+	args [8]ir.Value
+
+	// the values currently pushed on the stack, so that popped
+	// values keep the type of the value that was pushed
+	stack []ir.Value
+
 	labels map[string]ir.BlockID
 	refs   map[string][]ref
 
 	// previous block to have a jump
 	pjump ir.BlockID
+
+	// whether the current function has deferred calls
+	// which need to be run before it returns
+	defers bool
+
+	// the token of the source the values are being built for
+	tok token.Token
 }
 
-func NewBuilder(file *token.File) *Builder {
+func NewBuilder(fset *token.FileSet) *Builder {
 	return &Builder{
-		Program: ir.NewProgram(file),
+		Program: ir.NewProgram(fset),
 	}
 }
 
@@ -54,28 +69,61 @@ func (b *Builder) DeclareFunction(fnname string, sig types.Type) {
 	fn.Sig = sig
 }
 
-func (b *Builder) Prologue(name string, numLocals int) {
+// NoInline marks the declared function as never to be inlined.
+func (b *Builder) NoInline(fnname string) {
+	b.Program.FuncNamed(fnname).NoInline = true
+}
+
+// DeclareExtern declares a function defined outside of the program.
+// Several packages can declare the same extern function.
+func (b *Builder) DeclareExtern(fnname string, sig types.Type) {
+	if b.Program.FuncNamed(fnname) != nil {
+		return
+	}
+	fn := b.Program.NewFunc()
+	fn.Name = fnname
+	fn.Sig = sig
+	fn.Extern = true
+}
+
+func (b *Builder) Prologue(name string, locals []types.Type) {
 	b.Func = b.Program.FuncNamed(name)
+	b.Func.SetLocals(locals)
 
 	b.Label(name+".entry", 0)
 
-	b.Block.AddValueAny(Prologue, 0, types.Void, numLocals)
+	b.Block.AddValueAny(Prologue, b.tok, types.Void, len(locals))
 
 	b.a = ir.Value{}
 	b.b = ir.Value{}
 
 	b.pjump = ir.InvalidBlock
+	b.defers = false
 }
 
 func (b *Builder) Epilogue() {
 	b.Label(b.Func.Name+".epilogue", 0)
-	b.Block.AddValue(Epilogue, 0, types.Void)
 	ft := b.Program.Types().Func(b.Func.Sig)
+
+	// run the deferred calls, keeping the result on the stack
+	if b.defers {
+		if ft.ReturnType() != types.Void {
+			b.Push()
+		}
+		b.Block.AddValue(DeferReturn, b.tok, types.Void)
+		if ft.ReturnType() != types.Void {
+			b.Pop(0)
+			b.a = b.b
+		}
+	}
+
+	b.Block.AddValue(Epilogue, b.tok, types.Void)
 	if ft.ReturnType() == types.Void {
 		b.Block.UpdateTerminator(Return)
 	} else {
 		b.Block.UpdateTerminator(Return, b.a)
 	}
+	b.Block.Terminator().SetToken(b.tok)
 
 	if len(b.refs) != 0 {
 		panic("unresolved references")
@@ -85,91 +133,188 @@ func (b *Builder) Epilogue() {
 	b.Func = nil
 	b.a = ir.Value{}
 	b.b = ir.Value{}
+	b.stack = nil
 	b.labels = nil
 	b.refs = nil
 }
 
 func (b *Builder) Push() {
-	b.Block.AddValue(Push, 0, types.Void, b.a)
+	b.Block.AddValue(Push, b.tok, types.Void, b.a)
+	b.stack = append(b.stack, b.a)
 }
 
 func (b *Builder) Pop(reg int) {
-	b.b = b.Block.AddValue(Pop, 0, types.Int).AddReg(ir.RegID(reg))
+	typ := b.stack[len(b.stack)-1].Type()
+	b.stack = b.stack[:len(b.stack)-1]
+
+	b.b = b.Block.AddValue(Pop, b.tok, typ).AddReg(ir.RegID(reg))
+	b.args[reg] = b.b
 }
 
 func (b *Builder) LoadLocal(index int) {
-	b.a = b.Block.AddValueAny(LoadLocal, 0, types.Int, index).AddReg(ir.R0)
+	b.a = b.Block.AddValueAny(LoadLocal, b.tok, b.Func.LocalType(index), index).AddReg(ir.R0)
 }
 
 func (b *Builder) StoreLocal(index int) {
-	b.Block.AddValueAny(StoreLocal, 0, types.Void, ir.RegID(index), index)
+	b.Block.AddValueAny(StoreLocal, b.tok, types.Void, ir.RegID(index), index)
 }
 
 func (b *Builder) LoadInt(value string) {
 	ival, _ := strconv.ParseInt(value, 10, 64)
-	b.a = b.Block.AddValueAny(LoadInt, 0, types.Int, ival).AddReg(ir.R0)
+	b.a = b.Block.AddValueAny(LoadInt, b.tok, types.Int, ival).AddReg(ir.R0)
 }
 
 func (b *Builder) Load() {
-	b.a = b.Block.AddValue(Load, 0, types.Int, b.a).AddReg(ir.R0)
+	b.a = b.Block.AddValue(Load, b.tok, b.a.Type().Deref(), b.a).AddReg(ir.R0)
 }
 
 func (b *Builder) Store() {
-	b.Block.AddValue(Store, 0, types.Void, b.a, b.b)
+	b.Block.AddValue(Store, b.tok, types.Void, b.a, b.b)
 }
 
 func (b *Builder) LocalAddr(index int) {
-	b.a = b.Block.AddValueAny(LocalAddr, 0, types.Int, index).AddReg(ir.R0)
+	b.a = b.Block.AddValueAny(LocalAddr, b.tok, b.Func.LocalType(index).Pointer(), index).AddReg(ir.R0)
 }
 
 func (b *Builder) Add() {
-	b.a = b.Block.AddValue(Add, 0, types.Int, b.b, b.a).AddReg(ir.R0)
+	b.a = b.Block.AddValue(Add, b.tok, types.Int, b.b, b.a).AddReg(ir.R0)
 }
 
 func (b *Builder) Sub() {
-	b.a = b.Block.AddValue(Sub, 0, types.Int, b.b, b.a).AddReg(ir.R0)
+	b.a = b.Block.AddValue(Sub, b.tok, types.Int, b.b, b.a).AddReg(ir.R0)
 }
 
 func (b *Builder) Mul() {
-	b.a = b.Block.AddValue(Mul, 0, types.Int, b.b, b.a).AddReg(ir.R0)
+	b.a = b.Block.AddValue(Mul, b.tok, types.Int, b.b, b.a).AddReg(ir.R0)
 }
 
 func (b *Builder) Div() {
-	b.a = b.Block.AddValue(Div, 0, types.Int, b.b, b.a).AddReg(ir.R0)
+	b.a = b.Block.AddValue(Div, b.tok, types.Int, b.b, b.a).AddReg(ir.R0)
 }
 
 func (b *Builder) Neg() {
-	b.a = b.Block.AddValue(Neg, 0, types.Int, b.a).AddReg(ir.R0)
+	b.a = b.Block.AddValue(Neg, b.tok, types.Int, b.a).AddReg(ir.R0)
 }
 
 func (b *Builder) Eq() {
-	b.a = b.Block.AddValue(Eq, 0, types.Bool, b.b, b.a).AddReg(ir.R0)
+	b.a = b.Block.AddValue(Eq, b.tok, types.Bool, b.b, b.a).AddReg(ir.R0)
 }
 
 func (b *Builder) Ne() {
-	b.a = b.Block.AddValue(Ne, 0, types.Bool, b.b, b.a).AddReg(ir.R0)
+	b.a = b.Block.AddValue(Ne, b.tok, types.Bool, b.b, b.a).AddReg(ir.R0)
 }
 
 func (b *Builder) Lt() {
-	b.a = b.Block.AddValue(Lt, 0, types.Bool, b.b, b.a).AddReg(ir.R0)
+	b.a = b.Block.AddValue(Lt, b.tok, types.Bool, b.b, b.a).AddReg(ir.R0)
 }
 
 func (b *Builder) Gt() {
-	b.a = b.Block.AddValue(Gt, 0, types.Bool, b.b, b.a).AddReg(ir.R0)
+	b.a = b.Block.AddValue(Gt, b.tok, types.Bool, b.b, b.a).AddReg(ir.R0)
 }
 
 func (b *Builder) Le() {
-	b.a = b.Block.AddValue(Le, 0, types.Bool, b.b, b.a).AddReg(ir.R0)
+	b.a = b.Block.AddValue(Le, b.tok, types.Bool, b.b, b.a).AddReg(ir.R0)
 }
 
 func (b *Builder) Ge() {
-	b.a = b.Block.AddValue(Ge, 0, types.Bool, b.b, b.a).AddReg(ir.R0)
+	b.a = b.Block.AddValue(Ge, b.tok, types.Bool, b.b, b.a).AddReg(ir.R0)
 }
 
 func (b *Builder) Call(fnname string) {
 	fn := b.Program.FuncNamed(fnname)
 	rettype := b.Program.Types().Func(fn.Sig).ReturnType()
-	b.a = b.Block.AddValueAny(Call, 0, rettype, fn).AddReg(ir.R0)
+	b.a = b.Block.AddValueAny(Call, b.tok, rettype, fn).AddReg(ir.R0)
+}
+
+// CallExtern calls an extern function with the arguments on the stack,
+// rather than in registers, so the backend can pass them however the
+// calling convention of the target needs. The arguments are popped.
+func (b *Builder) CallExtern(fnname string) {
+	fn := b.Program.FuncNamed(fnname)
+	sig := b.Program.Types().Func(fn.Sig)
+	b.stack = b.stack[:len(b.stack)-len(sig.ParamTypes())]
+	b.a = b.Block.AddValueAny(CallExtern, b.tok, sig.ReturnType(), fn).AddReg(ir.R0)
+}
+
+func (b *Builder) MakeMap(typ types.Type) {
+	b.a = b.Block.AddValue(MakeMap, b.tok, typ, b.a).AddReg(ir.R0)
+}
+
+func (b *Builder) mapElem(m ir.Value) types.Type {
+	return b.Program.Types().Map(m.Type()).Elem()
+}
+
+func (b *Builder) MapIndex() {
+	b.a = b.Block.AddValue(MapIndex, b.tok, b.mapElem(b.args[0]), b.args[0], b.args[1]).AddReg(ir.R0)
+}
+
+func (b *Builder) MapIndexOk() {
+	b.a = b.Block.AddValue(MapIndexOk, b.tok, b.mapElem(b.args[0]), b.args[0], b.args[1], b.args[2]).AddReg(ir.R0)
+}
+
+// MapAssign stores a value into a map, and results in the map itself
+// so that consecutive elements of a map literal can be assigned.
+func (b *Builder) MapAssign() {
+	b.a = b.Block.AddValue(MapAssign, b.tok, b.args[0].Type(), b.args[0], b.args[1], b.args[2]).AddReg(ir.R0)
+}
+
+func (b *Builder) MapDelete() {
+	b.Block.AddValue(MapDelete, b.tok, types.Void, b.args[0], b.args[1])
+}
+
+func (b *Builder) MapLen() {
+	b.a = b.Block.AddValue(MapLen, b.tok, types.Int, b.a).AddReg(ir.R0)
+}
+
+func (b *Builder) MakeChan(typ types.Type) {
+	b.a = b.Block.AddValue(MakeChan, b.tok, typ, b.a).AddReg(ir.R0)
+}
+
+func (b *Builder) chanElem(ch ir.Value) types.Type {
+	return b.Program.Types().Chan(ch.Type()).Elem()
+}
+
+func (b *Builder) ChanSend() {
+	b.Block.AddValue(ChanSend, b.tok, types.Void, b.args[0], b.args[1])
+}
+
+func (b *Builder) ChanRecv() {
+	b.a = b.Block.AddValue(ChanRecv, b.tok, b.chanElem(b.a), b.a).AddReg(ir.R0)
+}
+
+func (b *Builder) ChanRecv2() {
+	b.a = b.Block.AddValue(ChanRecv2, b.tok, b.chanElem(b.args[0]), b.args[0], b.args[1]).AddReg(ir.R0)
+}
+
+func (b *Builder) ChanClose() {
+	b.Block.AddValue(ChanClose, b.tok, types.Void, b.a)
+}
+
+// Select chooses a ready case from the cases on the stack, and
+// results in the index of the chosen case.
+func (b *Builder) Select() {
+	b.a = b.Block.AddValue(Select, b.tok, types.Int, b.args[0], b.args[1]).AddReg(ir.R0)
+}
+
+func (b *Builder) Go(fnname string) {
+	fn := b.Program.FuncNamed(fnname)
+	b.Block.AddValueAny(Go, b.tok, types.Void, fn)
+}
+
+// Defer records a call to be made when the function returns,
+// with the arguments currently in the registers.
+func (b *Builder) Defer(fnname string) {
+	fn := b.Program.FuncNamed(fnname)
+	b.Block.AddValueAny(Defer, b.tok, types.Void, fn)
+	b.defers = true
+}
+
+func (b *Builder) Panic() {
+	b.Block.AddValue(Panic, b.tok, types.Void, b.a)
+}
+
+func (b *Builder) Recover() {
+	b.a = b.Block.AddValue(Recover, b.tok, types.Int).AddReg(ir.R0)
 }
 
 func (b *Builder) Jump(label string, id int) {
@@ -179,6 +324,7 @@ func (b *Builder) Jump(label string, id int) {
 func (b *Builder) jump(op Op, label string, id int, ops ...ir.Value) {
 	b.pjump = b.Block.ID()
 	b.Block.UpdateTerminator(op, ops...)
+	b.Block.Terminator().SetToken(b.tok)
 	b.insertSuccessor(0, label, id)
 }
 
@@ -204,10 +350,22 @@ func (b *Builder) JumpToEpilogue() {
 	b.jump(Jump, b.Func.Name+".epilogue", 0)
 }
 
+// Pos sets the token of the source the following values, blocks and
+// terminators are built for.
+func (b *Builder) Pos(tok token.Token) {
+	b.tok = tok
+}
+
+// Stmt records that a statement starts at the token in the
+// current block.
+func (b *Builder) Stmt(tok token.Token) {
+	b.Block.AddStmt(tok)
+}
+
 func (b *Builder) Label(label string, id int) {
 	name := label + strconv.Itoa(id)
 
-	blk := b.Func.NewBlock(name, Jump, 0)
+	blk := b.Func.NewBlock(name, Jump, b.tok)
 
 	if b.Block != nil && b.pjump != b.Block.ID() {
 		// technically p.Block was invalidated by the call to NewBlock,
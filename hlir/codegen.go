@@ -2,13 +2,15 @@ package hlir
 
 import (
 	"github.com/rj45/gosling/ir"
+	"github.com/rj45/gosling/token"
+	"github.com/rj45/gosling/types"
 )
 
 type Assembler interface {
-	Prologue(string, int)
+	Prologue(*ir.Func)
 	Epilogue()
 
-	Push(ir.RegMask)
+	Push(ir.RegMask, types.Type)
 	Pop(ir.RegMask)
 	LoadLocal(ir.RegMask, int)
 	StoreLocal(ir.RegMask, int)
@@ -33,17 +35,64 @@ type Assembler interface {
 	Ge(ir.RegMask, ir.RegMask, ir.RegMask)
 
 	Call(string)
+	CallExtern(*ir.Func)
+	Go(string)
+	Defer(*ir.Func)
+	DeferReturn()
+	CallRuntime(string, types.Type)
 	If(ir.RegMask, string, string)
 	Jump(string)
 	Label(string)
 	Return()
 }
 
+// FuncAssembler is an Assembler which can also assemble whole functions
+// from their values, rather than from the stack operations one at a time.
+type FuncAssembler interface {
+	Assembler
+
+	// AssembleFunc assembles the function, or returns false if it
+	// should be assembled from its stack operations instead.
+	AssembleFunc(*ir.Func) bool
+}
+
+// PassAssembler is an Assembler which transforms the functions of the
+// program before they are assembled, such as to allocate registers.
+type PassAssembler interface {
+	Assembler
+
+	// AddPasses adds the passes the assembler needs to the pass
+	// manager which runs over the program before it is assembled.
+	AddPasses(*ir.PassManager)
+}
+
+// PosAssembler is an Assembler which records the source position
+// of the instructions it assembles, for debug info.
+type PosAssembler interface {
+	Assembler
+
+	// Pos sets the source position of the following instructions.
+	Pos(file *token.File, line int, col int)
+}
+
+// CoverAssembler is an Assembler which can instrument blocks to count
+// how many times they run, for coverage.
+type CoverAssembler interface {
+	Assembler
+
+	// Cover counts the runs of the block. It is called at the
+	// start of the block, after the prologue if there is one.
+	Cover(*ir.Block)
+}
+
 type CodeGen struct {
 	*ir.Program
 	asm Assembler
 
 	fn *ir.Func
+
+	// tok is the token of the last position given to the assembler
+	tok token.Token
 }
 
 func New(program *ir.Program, asm Assembler) *CodeGen {
@@ -70,6 +119,10 @@ func (c *CodeGen) Generate() {
 }
 
 func (c *CodeGen) generateFunction() {
+	if fa, ok := c.asm.(FuncAssembler); ok && fa.AssembleFunc(c.fn) {
+		return
+	}
+
 	for i := 0; i < c.fn.NumBlocks(); i++ {
 		c.generateBlock(c.fn.BlockAt(i))
 	}
@@ -77,14 +130,35 @@ func (c *CodeGen) generateFunction() {
 
 func (c *CodeGen) generateBlock(blk *ir.Block) {
 	c.asm.Label(blk.Name)
+	covered := false
 	for i := 0; i < blk.NumValues(); i++ {
-		c.generateInstr(blk.ValueAt(i))
+		v := blk.ValueAt(i)
+		if !covered && v.Op() != Prologue {
+			c.cover(blk)
+			covered = true
+		}
+		c.generateInstr(v)
+	}
+	if !covered {
+		c.cover(blk)
 	}
 	c.generateInstr(blk.Terminator())
 }
 
+func (c *CodeGen) cover(blk *ir.Block) {
+	if ca, ok := c.asm.(CoverAssembler); ok {
+		ca.Cover(blk)
+	}
+}
+
 func (c *CodeGen) generateInstr(instr ir.Value) {
-	reg := [3]ir.RegMask{}
+	if pa, ok := c.asm.(PosAssembler); ok && instr.Token() != 0 && instr.Token() != c.tok {
+		c.tok = instr.Token()
+		file, line, col := c.fn.PositionOf(c.tok)
+		pa.Pos(file, line, col)
+	}
+
+	reg := [4]ir.RegMask{}
 	ri := 0
 	if instr.HasRegister() {
 		reg[0] = instr.Regs()
@@ -100,12 +174,11 @@ func (c *CodeGen) generateInstr(instr ir.Value) {
 
 	switch instr.Op() {
 	case Prologue:
-		v := instr.Operand(0).Constant()
-		c.asm.Prologue(c.fn.Name, int(v.Value().(int64)))
+		c.asm.Prologue(c.fn)
 	case Epilogue:
 		c.asm.Epilogue()
 	case Push:
-		c.asm.Push(reg[0])
+		c.asm.Push(reg[0], instr.Operand(0).Type())
 	case Pop:
 		c.asm.Pop(reg[0])
 	case LoadLocal:
@@ -149,6 +222,45 @@ func (c *CodeGen) generateInstr(instr ir.Value) {
 	case Call:
 		cfn := instr.Operand(0).Constant()
 		c.asm.Call(cfn.String())
+	case CallExtern:
+		cfn, _ := ir.FuncValue(instr.Operand(0).Constant())
+		c.asm.CallExtern(cfn)
+	case MakeMap:
+		c.asm.CallRuntime("makemap", instr.Type())
+	case MapIndex:
+		c.asm.CallRuntime("mapaccess1", instr.Type())
+	case MapIndexOk:
+		c.asm.CallRuntime("mapaccess2", instr.Type())
+	case MapAssign:
+		c.asm.CallRuntime("mapassign", instr.Type())
+	case MapDelete:
+		c.asm.CallRuntime("mapdelete", instr.Type())
+	case MapLen:
+		c.asm.CallRuntime("maplen", instr.Type())
+	case MakeChan:
+		c.asm.CallRuntime("makechan", instr.Type())
+	case ChanSend:
+		c.asm.CallRuntime("chansend", instr.Type())
+	case ChanRecv:
+		c.asm.CallRuntime("chanrecv1", instr.Type())
+	case ChanRecv2:
+		c.asm.CallRuntime("chanrecv2", instr.Type())
+	case ChanClose:
+		c.asm.CallRuntime("closechan", instr.Type())
+	case Select:
+		c.asm.CallRuntime("selectgo", instr.Type())
+	case Go:
+		cfn := instr.Operand(0).Constant()
+		c.asm.Go(cfn.String())
+	case Defer:
+		cfn, _ := ir.FuncValue(instr.Operand(0).Constant())
+		c.asm.Defer(cfn)
+	case DeferReturn:
+		c.asm.DeferReturn()
+	case Panic:
+		c.asm.CallRuntime("gopanic", instr.Type())
+	case Recover:
+		c.asm.CallRuntime("gorecover", instr.Type())
 	case Jump:
 		b := instr.Block().Successor(0)
 		dest := b.Name
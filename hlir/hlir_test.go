@@ -1,11 +1,13 @@
 package hlir_test
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 
 	"github.com/rj45/gosling/codegen"
 	"github.com/rj45/gosling/hlir"
+	"github.com/rj45/gosling/ir"
 	"github.com/rj45/gosling/parser"
 	"github.com/rj45/gosling/semantics"
 	"github.com/rj45/gosling/token"
@@ -25,13 +27,13 @@ var tests = []struct {
 		`,
 		ir: `
 			func main() int {
-			main.entry0:
-				Prologue 0
-				r0 = LoadInt 42
-				Jump main.epilogue0
-			main.epilogue0:
-				Epilogue
-				Return r0
+			main.entry0: // 2:4
+				Prologue 0 // 2:4
+				r0 = LoadInt 42 // 3:12
+				Jump main.epilogue0 // 3:5
+			main.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return r0 // 2:4
 			}
 		`,
 	},
@@ -45,18 +47,18 @@ var tests = []struct {
 		`,
 		ir: `
 			func main() int {
-			main.entry0:
-				Prologue 1
-				r0 = LocalAddr 0
-				Push r0
-				r0 = LoadInt 42
-				r1 = Pop
-				Store r0, r1
-				r0 = LoadLocal 0
-				Jump main.epilogue0
-			main.epilogue0:
-				Epilogue
-				Return r0
+			main.entry0: // 2:4
+				Prologue 1 // 2:4
+				r0 *int = LocalAddr 0 // 3:5
+				Push r0 // 3:7
+				r0 = LoadInt 42 // 3:10
+				r1 *int = Pop // 3:7
+				Store r0, r1 // 3:7
+				r0 = LoadLocal 0 // 4:12
+				Jump main.epilogue0 // 4:5
+			main.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return r0 // 2:4
 			}
 		`,
 	},
@@ -69,14 +71,14 @@ var tests = []struct {
 		`,
 		ir: `
 			func main(r0 int) int {
-			main.entry0:
-				Prologue 1
-				StoreLocal r0, 0
-				r0 = LoadLocal 0
-				Jump main.epilogue0
-			main.epilogue0:
-				Epilogue
-				Return r0
+			main.entry0: // 2:4
+				Prologue 1 // 2:4
+				StoreLocal r0, 0 // 2:4
+				r0 = LoadLocal 0 // 3:12
+				Jump main.epilogue0 // 3:5
+			main.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return r0 // 2:4
 			}
 		`,
 	},
@@ -84,34 +86,40 @@ var tests = []struct {
 		name: "function with add, sub, mul, div",
 		src: `
 			func main() int {
-				return 1 + 2 - 3 * 4 / 5
+				a := 1
+				return a + 2 - 3 * a / 5
 			}
 		`,
 		ir: `
 			func main() int {
-			main.entry0:
-				Prologue 0
-				r0 = LoadInt 1
-				Push r0
-				r0 = LoadInt 2
-				r1 = Pop
-				r0 = Add r1, r0
-				Push r0
-				r0 = LoadInt 3
-				Push r0
-				r0 = LoadInt 4
-				r1 = Pop
-				r0 = Mul r1, r0
-				Push r0
-				r0 = LoadInt 5
-				r1 = Pop
-				r0 = Div r1, r0
-				r1 = Pop
-				r0 = Sub r1, r0
-				Jump main.epilogue0
-			main.epilogue0:
-				Epilogue
-				Return r0
+			main.entry0: // 2:4
+				Prologue 1 // 2:4
+				r0 *int = LocalAddr 0 // 3:5
+				Push r0 // 3:7
+				r0 = LoadInt 1 // 3:10
+				r1 *int = Pop // 3:7
+				Store r0, r1 // 3:7
+				r0 = LoadLocal 0 // 4:12
+				Push r0 // 4:14
+				r0 = LoadInt 2 // 4:16
+				r1 = Pop // 4:14
+				r0 = Add r1, r0 // 4:14
+				Push r0 // 4:18
+				r0 = LoadInt 3 // 4:20
+				Push r0 // 4:22
+				r0 = LoadLocal 0 // 4:24
+				r1 = Pop // 4:22
+				r0 = Mul r1, r0 // 4:22
+				Push r0 // 4:26
+				r0 = LoadInt 5 // 4:28
+				r1 = Pop // 4:26
+				r0 = Div r1, r0 // 4:26
+				r1 = Pop // 4:18
+				r0 = Sub r1, r0 // 4:18
+				Jump main.epilogue0 // 4:5
+			main.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return r0 // 2:4
 			}
 		`,
 	},
@@ -119,19 +127,48 @@ var tests = []struct {
 		name: "function with neg",
 		src: `
 			func main() int {
-				return -42
+				a := 42
+				return -a
 			}
 		`,
 		ir: `
 			func main() int {
-			main.entry0:
-				Prologue 0
-				r0 = LoadInt 42
-				r0 = Neg r0
-				Jump main.epilogue0
-			main.epilogue0:
-				Epilogue
-				Return r0
+			main.entry0: // 2:4
+				Prologue 1 // 2:4
+				r0 *int = LocalAddr 0 // 3:5
+				Push r0 // 3:7
+				r0 = LoadInt 42 // 3:10
+				r1 *int = Pop // 3:7
+				Store r0, r1 // 3:7
+				r0 = LoadLocal 0 // 4:13
+				r0 = Neg r0 // 4:12
+				Jump main.epilogue0 // 4:5
+			main.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return r0 // 2:4
+			}
+		`,
+	},
+	{
+		name: "function with constant expression",
+		src: `
+			const (
+				A = iota + 1
+				B
+			)
+			func main() int {
+				return A + B*10 - 3/2
+			}
+		`,
+		ir: `
+			func main() int {
+			main.entry0: // 6:4
+				Prologue 0 // 6:4
+				r0 = LoadInt 20 // 7:21
+				Jump main.epilogue0 // 7:5
+			main.epilogue0: // 6:4
+				Epilogue // 6:4
+				Return r0 // 6:4
 			}
 		`,
 	},
@@ -139,10 +176,11 @@ var tests = []struct {
 		name: "function with eq, ne, lt, gt, le, ge",
 		src: `
 			func main() int {
-				a := 1 < 2
-				b := 1 > 2
-				c := 1 <= 2
-				d := 1 >= 2
+				x := 1
+				a := x < 2
+				b := x > 2
+				c := x <= 2
+				d := x >= 2
 				e := a == b
 				f := c != d
 				if e == f {
@@ -153,80 +191,85 @@ var tests = []struct {
 		`,
 		ir: `
 			func main() int {
-            main.entry0:
-            	Prologue 6
-            	r0 = LocalAddr 0
-            	Push r0
-            	r0 = LoadInt 1
-            	Push r0
-            	r0 = LoadInt 2
-            	r1 = Pop
-            	r0 = Lt r1, r0
-            	r1 = Pop
-            	Store r0, r1
-            	r0 = LocalAddr 1
-            	Push r0
-            	r0 = LoadInt 1
-            	Push r0
-            	r0 = LoadInt 2
-            	r1 = Pop
-            	r0 = Gt r1, r0
-            	r1 = Pop
-            	Store r0, r1
-            	r0 = LocalAddr 2
-            	Push r0
-            	r0 = LoadInt 1
-            	Push r0
-            	r0 = LoadInt 2
-            	r1 = Pop
-            	r0 = Le r1, r0
-            	r1 = Pop
-            	Store r0, r1
-            	r0 = LocalAddr 3
-            	Push r0
-            	r0 = LoadInt 1
-            	Push r0
-            	r0 = LoadInt 2
-            	r1 = Pop
-            	r0 = Ge r1, r0
-            	r1 = Pop
-            	Store r0, r1
-            	r0 = LocalAddr 4
-            	Push r0
-            	r0 = LoadLocal 0
-            	Push r0
-            	r0 = LoadLocal 1
-            	r1 = Pop
-            	r0 = Eq r1, r0
-            	r1 = Pop
-            	Store r0, r1
-            	r0 = LocalAddr 5
-            	Push r0
-            	r0 = LoadLocal 2
-            	Push r0
-            	r0 = LoadLocal 3
-            	r1 = Pop
-            	r0 = Ne r1, r0
-            	r1 = Pop
-            	Store r0, r1
-            	r0 = LoadLocal 4
-            	Push r0
-            	r0 = LoadLocal 5
-            	r1 = Pop
-            	r0 = Eq r1, r0
-            	If r0, then0, endif0
-			then0:
-				r0 = LoadInt 1
-				Jump main.epilogue0
-			post.return2:
-				Jump endif0
-            endif0:
-            	r0 = LoadInt 0
-            	Jump main.epilogue0
-            main.epilogue0:
-            	Epilogue
-            	Return r0
-            }
+			main.entry0: // 2:4
+				Prologue 7 // 2:4
+				r0 *int = LocalAddr 0 // 3:5
+				Push r0 // 3:7
+				r0 = LoadInt 1 // 3:10
+				r1 *int = Pop // 3:7
+				Store r0, r1 // 3:7
+				r0 *bool = LocalAddr 1 // 4:5
+				Push r0 // 4:7
+				r0 = LoadLocal 0 // 4:10
+				Push r0 // 4:12
+				r0 = LoadInt 2 // 4:14
+				r1 = Pop // 4:12
+				r0 bool = Lt r1, r0 // 4:12
+				r1 *bool = Pop // 4:7
+				Store r0, r1 // 4:7
+				r0 *bool = LocalAddr 2 // 5:5
+				Push r0 // 5:7
+				r0 = LoadLocal 0 // 5:10
+				Push r0 // 5:12
+				r0 = LoadInt 2 // 5:14
+				r1 = Pop // 5:12
+				r0 bool = Gt r1, r0 // 5:12
+				r1 *bool = Pop // 5:7
+				Store r0, r1 // 5:7
+				r0 *bool = LocalAddr 3 // 6:5
+				Push r0 // 6:7
+				r0 = LoadLocal 0 // 6:10
+				Push r0 // 6:12
+				r0 = LoadInt 2 // 6:15
+				r1 = Pop // 6:12
+				r0 bool = Le r1, r0 // 6:12
+				r1 *bool = Pop // 6:7
+				Store r0, r1 // 6:7
+				r0 *bool = LocalAddr 4 // 7:5
+				Push r0 // 7:7
+				r0 = LoadLocal 0 // 7:10
+				Push r0 // 7:12
+				r0 = LoadInt 2 // 7:15
+				r1 = Pop // 7:12
+				r0 bool = Ge r1, r0 // 7:12
+				r1 *bool = Pop // 7:7
+				Store r0, r1 // 7:7
+				r0 *bool = LocalAddr 5 // 8:5
+				Push r0 // 8:7
+				r0 bool = LoadLocal 1 // 8:10
+				Push r0 // 8:12
+				r0 bool = LoadLocal 2 // 8:15
+				r1 bool = Pop // 8:12
+				r0 bool = Eq r1, r0 // 8:12
+				r1 *bool = Pop // 8:7
+				Store r0, r1 // 8:7
+				r0 *bool = LocalAddr 6 // 9:5
+				Push r0 // 9:7
+				r0 bool = LoadLocal 3 // 9:10
+				Push r0 // 9:12
+				r0 bool = LoadLocal 4 // 9:15
+				r1 bool = Pop // 9:12
+				r0 bool = Ne r1, r0 // 9:12
+				r1 *bool = Pop // 9:7
+				Store r0, r1 // 9:7
+				r0 bool = LoadLocal 5 // 10:8
+				Push r0 // 10:10
+				r0 bool = LoadLocal 6 // 10:13
+				r1 bool = Pop // 10:10
+				r0 bool = Eq r1, r0 // 10:10
+				If r0, then0, endif0 // 10:5
+			then0: // 10:5
+				r0 = LoadInt 1 // 11:13
+				Jump main.epilogue0 // 11:6
+			post.return2: // 11:6
+				Jump endif0 // 10:5
+			endif0: // 10:5
+				r0 = LoadInt 0 // 13:12
+				Jump main.epilogue0 // 13:5
+			main.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return r0 // 2:4
+			}
 		`,
 	},
 	{
@@ -240,23 +283,23 @@ var tests = []struct {
 		`,
 		ir: `
 			func foo() {
-			foo.entry0:
-				Prologue 0
-				Jump foo.epilogue0
-			foo.epilogue0:
-				Epilogue
-				Return
+			foo.entry0: // 2:4
+				Prologue 0 // 2:4
+				Jump foo.epilogue0 // 2:4
+			foo.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return // 2:4
 			}
 
 			func main() int {
-			main.entry0:
-				Prologue 0
-				Call foo
-				r0 = LoadInt 0
-				Jump main.epilogue0
-			main.epilogue0:
-				Epilogue
-				Return r0
+			main.entry0: // 3:4
+				Prologue 0 // 3:4
+				Call foo // 4:10
+				r0 = LoadInt 0 // 5:12
+				Jump main.epilogue0 // 5:5
+			main.epilogue0: // 3:4
+				Epilogue // 3:4
+				Return r0 // 3:4
 			}
 		`,
 	},
@@ -270,16 +313,75 @@ var tests = []struct {
 		`,
 		ir: `
 			func main() int {
-			main.entry0:
-				Prologue 0
-				r0 = LoadInt 1
-				Jump main.epilogue0
-			post.return1:
-				r0 = LoadInt 2
-            	Jump main.epilogue0
-            main.epilogue0:
-            	Epilogue
-            	Return r0
+			main.entry0: // 2:4
+				Prologue 0 // 2:4
+				r0 = LoadInt 1 // 3:13
+				Jump main.epilogue0 // 3:6
+			post.return1: // 3:6
+				r0 = LoadInt 2 // 4:12
+				Jump main.epilogue0 // 4:5
+			main.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return r0 // 2:4
+			}
+		`,
+	},
+	{
+		name: "map operations",
+		src: `
+			func main() int {
+				m := map[int]bool{1: true}
+				v, ok := m[1]
+				delete(m, 1)
+				return len(m)
+			}
+		`,
+		ir: `
+			func main() int {
+			main.entry0: // 2:4
+				Prologue 3 // 2:4
+				r0 *map[int]bool = LocalAddr 0 // 3:5
+				Push r0 // 3:7
+				r0 = LoadInt 1 // 3:10
+				r0 map[int]bool = MakeMap r0 // 3:10
+				Push r0 // 3:10
+				r0 = LoadInt 1 // 3:23
+				Push r0 // 3:10
+				r0 = LoadInt 1 // 3:26
+				Push r0 // 3:10
+				r2 = Pop // 3:10
+				r1 = Pop // 3:10
+				r0 map[int]bool = Pop // 3:10
+				r0 map[int]bool = MapAssign r0, r1, r2 // 3:10
+				r1 *map[int]bool = Pop // 3:7
+				Store r0, r1 // 3:7
+				r0 *bool = LocalAddr 1 // 4:5
+				Push r0 // 4:11
+				r0 map[int]bool = LoadLocal 0 // 4:14
+				Push r0 // 4:11
+				r0 = LoadInt 1 // 4:16
+				Push r0 // 4:11
+				r0 *bool = LocalAddr 2 // 4:8
+				Push r0 // 4:11
+				r2 *bool = Pop // 4:11
+				r1 = Pop // 4:11
+				r0 map[int]bool = Pop // 4:11
+				r0 bool = MapIndexOk r0, r1, r2 // 4:11
+				r1 *bool = Pop // 4:11
+				Store r0, r1 // 4:11
+				r0 map[int]bool = LoadLocal 0 // 5:12
+				Push r0 // 5:17
+				r0 = LoadInt 1 // 5:15
+				Push r0 // 5:17
+				r1 = Pop // 5:17
+				r0 map[int]bool = Pop // 5:17
+				MapDelete r0, r1 // 5:17
+				r0 map[int]bool = LoadLocal 0 // 6:16
+				r0 = MapLen r0 // 6:18
+				Jump main.epilogue0 // 6:5
+			main.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return r0 // 2:4
 			}
 		`,
 	},
@@ -290,26 +392,260 @@ func TestAssembler(t *testing.T) {
 		test := test
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
-			file := token.NewFile("test.gos", []byte(test.src))
+			asm := build(t, test.src)
+
+			actual := asm.Program.Dump()
 
-			parser := parser.New(file)
-			ast, errs := parser.Parse()
-			for _, err := range errs {
-				t.Error(err)
+			if trim(actual) != trim(test.ir) {
+				t.Errorf("expected:\n%s\nactual:\n%s", test.ir, actual)
 			}
+		})
+	}
+}
 
-			tc := semantics.NewTypeChecker(ast)
+var ssaTests = []struct {
+	name string
+	src  string
+	ir   string
+}{
+	{
+		name: "locals",
+		src: `
+			func main() int {
+				a := 1
+				b := a + 2
+				return a * b
+			}
+		`,
+		ir: `
+			func main() int {
+			main.entry0: // 2:4
+				Prologue 2 // 2:4
+				v8 = LoadInt 1 // 3:10
+				v15 = LoadInt 2 // 4:14
+				v17 = Add v8, v15 // 4:12
+				v24 = Mul v8, v17 // 5:14
+				Jump main.epilogue0 // 5:5
+			main.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return v24 // 2:4
+			}
+		`,
+	},
+	{
+		name: "args and calls",
+		src: `
+			func add(a int, b int) int {
+				return a + b
+			}
+			func main() int {
+				return add(1, 2)
+			}
+		`,
+		ir: `
+			func add(r0 int, r1 int) int {
+			add.entry0: // 2:4
+				v17 = Arg 0 // 2:4
+				v18 = Arg 1 // 2:4
+				Prologue 2 // 2:4
+				v14 = Add v17, v18 // 3:14
+				Jump add.epilogue0 // 3:5
+			add.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return v14 // 2:4
+			}
 
-			symtab, errs := tc.Check(ast.Root())
-			for _, err := range errs {
-				t.Error(err)
+			func main() int {
+			main.entry0: // 5:4
+				Prologue 0 // 5:4
+				v5 = LoadInt 1 // 6:16
+				v8 = LoadInt 2 // 6:19
+				v13 = Call add, v5, v8 // 6:21
+				Jump main.epilogue0 // 6:5
+			main.epilogue0: // 5:4
+				Epilogue // 5:4
+				Return v13 // 5:4
+			}
+		`,
+	},
+	{
+		name: "loop",
+		src: `
+			func main() int {
+				s := 0
+				for i := 0; i < 10; i = i + 1 {
+					s = s + i
+				}
+				return s
+			}
+		`,
+		ir: `
+			func main() int {
+			main.entry0: // 2:4
+				Prologue 2 // 2:4
+				v7 = LoadInt 0 // 3:10
+				v13 = LoadInt 0 // 4:14
+				Jump loop0 // 2:4
+			loop0: <- main.entry0, loopbody0 // 4:5
+				v46 = Phi v13, v39 // 4:5
+				v47 = Phi v7, v30 // 4:5
+				v20 = LoadInt 10 // 4:21
+				v22 bool = Lt v46, v20 // 4:19
+				If v22, loopbody0, endloop0 // 4:5
+			loopbody0: // 4:5
+				v30 = Add v47, v46 // 5:12
+				v37 = LoadInt 1 // 4:33
+				v39 = Add v46, v37 // 4:31
+				Jump loop0 // 4:5
+			endloop0: // 4:5
+				Jump main.epilogue0 // 7:5
+			main.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return v47 // 2:4
+			}
+		`,
+	},
+	{
+		name: "if expression",
+		src: `
+			func abs(x int) int {
+				return if x < 0 { -x } else { x }
+			}
+		`,
+		ir: `
+			func abs(r0 int) int {
+			abs.entry0: // 2:4
+				v20 = Arg 0 // 2:4
+				Prologue 1 // 2:4
+				v9 = LoadInt 0 // 3:19
+				v11 bool = Lt v20, v9 // 3:17
+				If v11, then0, else0 // 3:12
+			then0: // 3:12
+				v14 = Neg v20 // 3:23
+				Jump endif0 // 3:12
+			else0: // 3:12
+				Jump endif0 // 3:12
+			endif0: <- else0, then0 // 3:12
+				v21 = Phi v20, v14 // 3:12
+				Jump abs.epilogue0 // 3:5
+			abs.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return v21 // 2:4
+			}
+		`,
+	},
+	{
+		name: "address taken",
+		src: `
+			func main() int {
+				x := 3
+				p := &x
+				*p = 4
+				if x > 3 {
+					return 1
+				}
+				return 2
+			}
+		`,
+		ir: `
+			func main() int {
+			main.entry0: // 2:4
+				Prologue 2 // 2:4
+				v5 *int = LocalAddr 0 // 3:5
+				v8 = LoadInt 3 // 3:10
+				Store v8, v5 // 3:7
+				v14 *int = LocalAddr 0 // 4:11
+				v20 = LoadInt 4 // 5:10
+				Store v20, v14 // 5:8
+				v23 = LoadLocal 0 // 6:8
+				v25 = LoadInt 3 // 6:12
+				v27 bool = Gt v23, v25 // 6:10
+				If v27, then0, endif0 // 6:5
+			then0: // 6:5
+				v29 = LoadInt 1 // 7:13
+				Jump main.epilogue0 // 7:6
+			endif0: // 6:5
+				v32 = LoadInt 2 // 9:12
+				Jump main.epilogue0 // 9:5
+			main.epilogue0: <- then0, endif0 // 2:4
+				v35 = Phi v29, v32 // 2:4
+				Epilogue // 2:4
+				Return v35 // 2:4
+			}
+		`,
+	},
+	{
+		name: "maps, channels and void calls",
+		src: `
+			func send(c chan int) {
+				c <- 1
+			}
+			func main() int {
+				m := map[int]bool{}
+				m[1] = true
+				c := make(chan int, 1)
+				send(c)
+				if m[1] {
+					return <-c
+				}
+				return 0
+			}
+		`,
+		ir: `
+			func send(r0 chan int) {
+			send.entry0: // 2:4
+				v16 chan int = Arg 0 // 2:4
+				Prologue 1 // 2:4
+				v9 = LoadInt 1 // 3:10
+				ChanSend v16, v9 // 3:7
+				Jump send.epilogue0 // 2:4
+			send.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return // 2:4
 			}
 
-			asm := hlir.NewBuilder(file)
+			func main() int {
+			main.entry0: // 5:4
+				Prologue 2 // 5:4
+				v7 = LoadInt 0 // 6:10
+				v8 map[int]bool = MakeMap v7 // 6:10
+				v14 = LoadInt 1 // 7:7
+				v16 = LoadInt 1 // 7:12
+				v21 map[int]bool = MapAssign v8, v14, v16 // 7:10
+				v24 = LoadInt 1 // 8:25
+				v25 chan int = MakeChan v24 // 8:27
+				Call send, v25 // 9:12
+				v35 = LoadInt 1 // 10:10
+				v39 bool = MapIndex v8, v35 // 10:9
+				If v39, then0, endif0 // 10:5
+			then0: // 10:5
+				v42 = ChanRecv v25 // 11:13
+				Jump main.epilogue0 // 11:6
+			endif0: // 10:5
+				v45 = LoadInt 0 // 13:12
+				Jump main.epilogue0 // 13:5
+			main.epilogue0: <- then0, endif0 // 5:4
+				v48 = Phi v42, v45 // 5:4
+				Epilogue // 5:4
+				Return v48 // 5:4
+			}
+		`,
+	},
+}
 
-			gen := codegen.New(ast, symtab, tc.Universe(), asm)
-			gen.Generate()
+func TestSSA(t *testing.T) {
+	for _, test := range ssaTests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			asm := build(t, test.src)
 
+			for i := 0; i < asm.Program.NumFuncs(); i++ {
+				hlir.BuildSSA(asm.Program.Func(i))
+				for _, err := range ir.Verify(asm.Program.Func(i)) {
+					t.Error(err)
+				}
+			}
 			actual := asm.Program.Dump()
 
 			if trim(actual) != trim(test.ir) {
@@ -319,6 +655,88 @@ func TestAssembler(t *testing.T) {
 	}
 }
 
+func TestParse(t *testing.T) {
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			expected := trim(ir.StripPositions(test.ir))
+
+			prog, err := ir.Parse(expected, ir.HLIR)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := prog.Dump(); trim(actual) != expected {
+				t.Errorf("expected:\n%s\nactual:\n%s", expected, actual)
+			}
+		})
+	}
+
+	for _, test := range ssaTests {
+		test := test
+		t.Run("ssa "+test.name, func(t *testing.T) {
+			t.Parallel()
+			expected := trim(ir.StripPositions(test.ir))
+
+			prog, err := ir.Parse(expected, ir.HLIR)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := prog.Dump(); trim(actual) != expected {
+				t.Errorf("expected:\n%s\nactual:\n%s", expected, actual)
+			}
+
+			// the phis depend on the order of the predecessors
+			asm := build(t, test.src)
+			for i := 0; i < asm.Program.NumFuncs(); i++ {
+				fn := asm.Program.Func(i)
+				hlir.BuildSSA(fn)
+				if preds(fn) != preds(prog.FuncNamed(fn.Name)) {
+					t.Errorf("expected predecessors:\n%s\nactual:\n%s", preds(fn), preds(prog.FuncNamed(fn.Name)))
+				}
+			}
+		})
+	}
+}
+
+// preds lists the predecessors of each block of the function.
+func preds(fn *ir.Func) string {
+	w := &strings.Builder{}
+	for i := 0; i < fn.NumBlocks(); i++ {
+		blk := fn.BlockAt(i)
+		fmt.Fprintf(w, "%s:", blk.Name)
+		for j := 0; j < blk.NumPredecessors(); j++ {
+			fmt.Fprintf(w, " %s", blk.Predecessor(j).Name)
+		}
+		fmt.Fprintln(w)
+	}
+	return w.String()
+}
+
+func build(t *testing.T, src string) *hlir.Builder {
+	file := token.NewFile("test.gos", []byte(src))
+
+	parser := parser.New(file)
+	ast, errs := parser.Parse()
+	for _, err := range errs {
+		t.Error(err)
+	}
+
+	tc := semantics.NewTypeChecker(ast)
+
+	symtab, errs := tc.Check(ast.Root())
+	for _, err := range errs {
+		t.Error(err)
+	}
+
+	asm := hlir.NewBuilder(file.FileSet())
+
+	gen := codegen.New(ast, symtab, tc.Universe(), asm)
+	gen.Generate()
+
+	return asm
+}
+
 func trim(s string) string {
 	lines := strings.Split(strings.TrimSpace(s), "\n")
 	for i, line := range lines {
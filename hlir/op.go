@@ -44,6 +44,30 @@ const (
 	Addr
 	Deref
 	Call
+	CallExtern
+
+	// Map operators
+	MakeMap
+	MapIndex
+	MapIndexOk
+	MapAssign
+	MapDelete
+	MapLen
+
+	// Channel operators
+	MakeChan
+	ChanSend
+	ChanRecv
+	ChanRecv2
+	ChanClose
+	Select
+	Go
+
+	// Defer operators
+	Defer
+	DeferReturn
+	Panic
+	Recover
 
 	// Control flow operators
 	Jump
@@ -52,35 +76,53 @@ const (
 )
 
 var opNames = [...]string{
-	Invalid:    "Invalid",
-	Prologue:   "Prologue",
-	Epilogue:   "Epilogue",
-	Push:       "Push",
-	Pop:        "Pop",
-	LoadLocal:  "LoadLocal",
-	StoreLocal: "StoreLocal",
-	Load:       "Load",
-	Store:      "Store",
-	LocalAddr:  "LocalAddr",
-	LoadInt:    "LoadInt",
-	Add:        "Add",
-	Sub:        "Sub",
-	Mul:        "Mul",
-	Div:        "Div",
-	Neg:        "Neg",
-	Move:       "Move",
-	Eq:         "Eq",
-	Ne:         "Ne",
-	Lt:         "Lt",
-	Gt:         "Gt",
-	Le:         "Le",
-	Ge:         "Ge",
-	Addr:       "Addr",
-	Deref:      "Deref",
-	Call:       "Call",
-	Jump:       "Jump",
-	If:         "If",
-	Return:     "Return",
+	Invalid:     "Invalid",
+	Prologue:    "Prologue",
+	Epilogue:    "Epilogue",
+	Push:        "Push",
+	Pop:         "Pop",
+	LoadLocal:   "LoadLocal",
+	StoreLocal:  "StoreLocal",
+	Load:        "Load",
+	Store:       "Store",
+	LocalAddr:   "LocalAddr",
+	LoadInt:     "LoadInt",
+	Add:         "Add",
+	Sub:         "Sub",
+	Mul:         "Mul",
+	Div:         "Div",
+	Neg:         "Neg",
+	Move:        "Move",
+	Eq:          "Eq",
+	Ne:          "Ne",
+	Lt:          "Lt",
+	Gt:          "Gt",
+	Le:          "Le",
+	Ge:          "Ge",
+	Addr:        "Addr",
+	Deref:       "Deref",
+	Call:        "Call",
+	CallExtern:  "CallExtern",
+	MakeMap:     "MakeMap",
+	MapIndex:    "MapIndex",
+	MapIndexOk:  "MapIndexOk",
+	MapAssign:   "MapAssign",
+	MapDelete:   "MapDelete",
+	MapLen:      "MapLen",
+	MakeChan:    "MakeChan",
+	ChanSend:    "ChanSend",
+	ChanRecv:    "ChanRecv",
+	ChanRecv2:   "ChanRecv2",
+	ChanClose:   "ChanClose",
+	Select:      "Select",
+	Go:          "Go",
+	Defer:       "Defer",
+	DeferReturn: "DeferReturn",
+	Panic:       "Panic",
+	Recover:     "Recover",
+	Jump:        "Jump",
+	If:          "If",
+	Return:      "Return",
 }
 
 func (op Op) String() string {
@@ -102,6 +144,6 @@ func (op Op) OpID() ir.OpID {
 	return ir.OpID(op)
 }
 
-var _ = ir.RegisterOpSet(ir.HLIR, func(oi ir.OpID) ir.Op {
+var _ = ir.RegisterOpSet(ir.HLIR, opNames[Invalid:], func(oi ir.OpID) ir.Op {
 	return Op(oi)
 })
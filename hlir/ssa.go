@@ -0,0 +1,484 @@
+package hlir
+
+import (
+	"github.com/rj45/gosling/ir"
+	"github.com/rj45/gosling/types"
+)
+
+// BuildSSA converts the function from the stack operations the Builder
+// emits to SSA form, using the algorithm from "Simple and Efficient
+// Construction of Static Single Assignment Form" by Braun et al.
+//
+// The values passed through the registers and the stack become direct
+// operands, so the Push and Pop operations are removed, and the
+// implicit arguments of calls become explicit operands. Then the locals
+// which never have their address taken are promoted to SSA values,
+// removing their loads and stores. Values which flow in from several
+// predecessors become Phi parameters of the block, and the arguments
+// of the function become Arg parameters of the entry block.
+//
+// Unreachable blocks are removed, the values which were removed no
+// longer use their operands, and the values are left without registers,
+// to be allocated after lowering.
+func BuildSSA(fn *ir.Func) {
+	if fn.Extern {
+		return
+	}
+
+	s := &ssa{fn: fn, replace: make(ir.Replacements)}
+	s.removeUnreachable()
+	s.blocks = fn.ReversePostorder()
+
+	entry := fn.BlockAt(0)
+	for i, typ := range fn.Types().Func(fn.Sig).ParamTypes() {
+		s.args = append(s.args, entry.AddParam(ir.Arg, entry.Token(), typ, fn.ValueForConst(ir.IntConst(int64(i)))))
+	}
+
+	s.depth = make([]int, fn.NumBlocks()+1)
+	for i := range s.depth {
+		s.depth[i] = -1
+	}
+	s.depth[entry.ID()] = 0
+
+	s.run(s.fillStack)
+	s.replace.Resolve(s.fn)
+
+	s.findPromoted()
+	s.run(s.fillLocals)
+	s.replace.Resolve(s.fn)
+
+	s.removeTrivialPhis()
+	s.inferTypes()
+	s.replace.Resolve(s.fn)
+
+	s.clearRemoved()
+
+	for i := 0; i < fn.NumValues(); i++ {
+		fn.ValueAt(i).SetRegs(0)
+	}
+	fn.SSA = true
+}
+
+// varKind is the kind of storage a variable is kept in before the
+// function is in SSA form.
+type varKind uint8
+
+const (
+	regVar varKind = iota
+	stackVar
+	localVar
+)
+
+// variable is a register, stack slot or local which is assigned
+// values, and is read to find the value it was last assigned.
+type variable struct {
+	kind  varKind
+	index int
+}
+
+type incompletePhi struct {
+	v   variable
+	phi ir.Value
+}
+
+type ssa struct {
+	fn *ir.Func
+
+	// the blocks in reverse postorder, so each block is filled
+	// after at least one of its predecessors
+	blocks []ir.BlockID
+
+	// the current definition of each variable in each block
+	defs []map[variable]ir.Value
+
+	// filled blocks have had all their values visited, and sealed
+	// blocks have all their predecessors filled
+	filled []bool
+	sealed []bool
+
+	// the phis created in blocks before they were sealed, which
+	// are missing their operands
+	incomplete [][]incompletePhi
+
+	// values which were removed, and the value replacing them
+	replace ir.Replacements
+
+	// the arguments of the function
+	args []ir.Value
+
+	// the depth of the stack at the start of each block
+	depth []int
+
+	// the locals which can be promoted to SSA values
+	promoted []bool
+}
+
+// removeUnreachable removes the blocks which can't be reached from
+// the entry block, such as the blocks after a return, so that their
+// edges don't contribute values to the blocks they jump to.
+func (s *ssa) removeUnreachable() {
+	reachable := make([]bool, s.fn.NumBlocks()+1)
+	for _, id := range s.fn.ReversePostorder() {
+		reachable[id] = true
+	}
+	for i := s.fn.NumBlocks() - 1; i >= 0; i-- {
+		if blk := s.fn.BlockAt(i); !reachable[blk.ID()] {
+			s.fn.RemoveBlock(blk)
+		}
+	}
+}
+
+// run visits the blocks in reverse postorder with fill, which reads
+// and writes variables, sealing each block once its predecessors
+// have been filled.
+func (s *ssa) run(fill func(*ir.Block)) {
+	n := s.fn.NumBlocks() + 1
+	s.defs = make([]map[variable]ir.Value, n)
+	s.incomplete = make([][]incompletePhi, n)
+	s.filled = make([]bool, n)
+	s.sealed = make([]bool, n)
+
+	for _, id := range s.blocks {
+		blk := s.fn.Block(id)
+		s.trySeal(blk)
+		fill(blk)
+		s.filled[id] = true
+		for i := 0; i < blk.NumSuccessors(); i++ {
+			s.trySeal(blk.Successor(i))
+		}
+	}
+}
+
+func (s *ssa) trySeal(blk *ir.Block) {
+	id := blk.ID()
+	if s.sealed[id] {
+		return
+	}
+	for i := 0; i < blk.NumPredecessors(); i++ {
+		if !s.filled[blk.Predecessor(i).ID()] {
+			return
+		}
+	}
+
+	for _, inc := range s.incomplete[id] {
+		s.addPhiOperands(inc.v, inc.phi)
+	}
+	s.incomplete[id] = nil
+	s.sealed[id] = true
+}
+
+func (s *ssa) write(v variable, blk *ir.Block, val ir.Value) {
+	id := blk.ID()
+	if s.defs[id] == nil {
+		s.defs[id] = make(map[variable]ir.Value)
+	}
+	s.defs[id][v] = val
+}
+
+func (s *ssa) read(v variable, blk *ir.Block) ir.Value {
+	if val, found := s.defs[blk.ID()][v]; found {
+		return val
+	}
+	return s.readRecursive(v, blk)
+}
+
+func (s *ssa) readRecursive(v variable, blk *ir.Block) ir.Value {
+	id := blk.ID()
+
+	var val ir.Value
+	switch {
+	case !s.sealed[id]:
+		// the value from some predecessors is not known yet
+		val = blk.AddParam(ir.Phi, blk.Token(), s.typeOf(v))
+		s.incomplete[id] = append(s.incomplete[id], incompletePhi{v, val})
+	case blk.NumPredecessors() == 0:
+		val = s.undefined(v)
+	case blk.NumPredecessors() == 1:
+		val = s.read(v, blk.Predecessor(0))
+	default:
+		// write the phi first to break cycles through loops
+		val = blk.AddParam(ir.Phi, blk.Token(), s.typeOf(v))
+		s.write(v, blk, val)
+		s.addPhiOperands(v, val)
+	}
+
+	s.write(v, blk, val)
+	return val
+}
+
+func (s *ssa) addPhiOperands(v variable, phi ir.Value) {
+	blk := phi.Block()
+	operands := make([]ir.Value, blk.NumPredecessors())
+	for i := range operands {
+		operands[i] = s.read(v, blk.Predecessor(i))
+	}
+	phi.SetOperands(operands...)
+}
+
+// typeOf returns the type of the variable, if it is known before
+// any of the values assigned to it are read.
+func (s *ssa) typeOf(v variable) types.Type {
+	if v.kind == localVar {
+		return s.fn.LocalType(v.index)
+	}
+	return types.None
+}
+
+// undefined returns the value of a variable read before it is ever
+// assigned. Locals start out zero, but registers and the stack are
+// only read after they have been assigned.
+func (s *ssa) undefined(v variable) ir.Value {
+	if v.kind == localVar {
+		return s.fn.ValueForConst(ir.IntConst(0))
+	}
+	panic("read of unassigned register or stack slot")
+}
+
+func (s *ssa) reg(reg ir.RegID) variable {
+	return variable{kind: regVar, index: int(reg)}
+}
+
+func (s *ssa) slot(depth int) variable {
+	return variable{kind: stackVar, index: depth}
+}
+
+func (s *ssa) local(index ir.Value) variable {
+	i, _ := ir.Int64Value(index.Constant())
+	return variable{kind: localVar, index: int(i)}
+}
+
+// fillStack replaces the values read from registers and the stack
+// with the values which were written to them.
+func (s *ssa) fillStack(blk *ir.Block) {
+	if blk.ID() == s.fn.BlockAt(0).ID() {
+		for i, arg := range s.args {
+			s.write(s.reg(ir.RegID(i)), blk, arg)
+		}
+	}
+
+	depth := s.depth[blk.ID()]
+	for i := 0; i < blk.NumValues(); i++ {
+		v := blk.ValueAt(i)
+		if v.IsNil() {
+			continue
+		}
+
+		switch v.Op() {
+		case Push:
+			s.write(s.slot(depth), blk, s.read(s.reg(v.Operand(0).Regs().Peek()), blk))
+			depth++
+			blk.RemoveValue(v)
+			continue
+		case Pop:
+			depth--
+			val := s.read(s.slot(depth), blk)
+			s.write(s.reg(v.Regs().Peek()), blk, val)
+			s.replace[v.ID()] = val
+			blk.RemoveValue(v)
+			continue
+		case StoreLocal:
+			// the argument in the register is stored in the local
+			reg, _ := ir.RegValue(v.Operand(0).Constant())
+			v.SetOperands(s.read(s.reg(reg.Peek()), blk), v.Operand(1))
+		case Call, Go, Defer:
+			// the arguments are in the registers
+			callee := v.Operand(0)
+			fn, _ := ir.FuncValue(callee.Constant())
+			operands := []ir.Value{callee}
+			for i := range s.fn.Types().Func(fn.Sig).ParamTypes() {
+				operands = append(operands, s.read(s.reg(ir.RegID(i)), blk))
+			}
+			v = v.SetOperands(operands...)
+		case CallExtern:
+			// the arguments are on the stack
+			callee := v.Operand(0)
+			fn, _ := ir.FuncValue(callee.Constant())
+			depth -= len(s.fn.Types().Func(fn.Sig).ParamTypes())
+			operands := []ir.Value{callee}
+			for i := range s.fn.Types().Func(fn.Sig).ParamTypes() {
+				operands = append(operands, s.read(s.slot(depth+i), blk))
+			}
+			v = v.SetOperands(operands...)
+		case Select:
+			// the cases are on the stack, three slots for each, and
+			// are popped after the select
+			s.readOperands(v, blk)
+			n, _ := ir.Int64Value(v.Operand(0).Operand(0).Constant())
+			operands := v.Operands()
+			for i := depth - 3*int(n); i < depth; i++ {
+				operands = append(operands, s.read(s.slot(i), blk))
+			}
+			v = v.SetOperands(operands...)
+		default:
+			s.readOperands(v, blk)
+		}
+
+		if v.HasRegister() {
+			s.write(s.reg(v.Regs().Peek()), blk, v)
+		}
+	}
+	s.readOperands(blk.Terminator(), blk)
+
+	for i := 0; i < blk.NumSuccessors(); i++ {
+		if succ := blk.Successor(i).ID(); s.depth[succ] < 0 {
+			s.depth[succ] = depth
+		}
+	}
+}
+
+// readOperands replaces the operands in registers with the values
+// which were last written to the registers.
+func (s *ssa) readOperands(v ir.Value, blk *ir.Block) {
+	for i := 0; i < v.NumOperands(); i++ {
+		op := v.Operand(i)
+		if op.IsConstant() || !op.HasRegister() {
+			continue
+		}
+		v.SetOperand(i, s.read(s.reg(op.Regs().Peek()), blk))
+	}
+}
+
+// findPromoted finds the locals which can be promoted, which are the
+// locals whose address is only ever loaded from or stored to.
+func (s *ssa) findPromoted() {
+	s.promoted = make([]bool, s.fn.NumLocals())
+	for i := range s.promoted {
+		s.promoted[i] = true
+	}
+
+	s.fn.EachValue(func(v ir.Value) {
+		for i := 0; i < v.NumOperands(); i++ {
+			op := v.Operand(i)
+			if op.IsConstant() || op.Op() != LocalAddr {
+				continue
+			}
+			if (v.Op() == Load && i == 0) || (v.Op() == Store && i == 1) {
+				continue
+			}
+			s.promoted[s.local(op.Operand(0)).index] = false
+		}
+	})
+}
+
+// fillLocals replaces the loads of promoted locals with the values
+// which were stored to them.
+func (s *ssa) fillLocals(blk *ir.Block) {
+	for i := 0; i < blk.NumValues(); i++ {
+		v := blk.ValueAt(i)
+		if v.IsNil() {
+			continue
+		}
+
+		switch v.Op() {
+		case LoadLocal:
+			if local := s.local(v.Operand(0)); s.promoted[local.index] {
+				s.replace[v.ID()] = s.read(local, blk)
+				blk.RemoveValue(v)
+			}
+		case StoreLocal:
+			if local := s.local(v.Operand(1)); s.promoted[local.index] {
+				s.write(local, blk, v.Operand(0))
+				blk.RemoveValue(v)
+			}
+		case Load:
+			if addr := v.Operand(0); s.isPromoted(addr) {
+				s.replace[v.ID()] = s.read(s.local(addr.Operand(0)), blk)
+				blk.RemoveValue(v)
+			}
+		case Store:
+			if addr := v.Operand(1); s.isPromoted(addr) {
+				s.write(s.local(addr.Operand(0)), blk, v.Operand(0))
+				blk.RemoveValue(v)
+			}
+		case LocalAddr:
+			if s.isPromoted(v) {
+				blk.RemoveValue(v)
+			}
+		}
+	}
+}
+
+// isPromoted returns whether the value is the address of a promoted local.
+func (s *ssa) isPromoted(addr ir.Value) bool {
+	return !addr.IsConstant() && addr.Op() == LocalAddr && s.promoted[s.local(addr.Operand(0)).index]
+}
+
+// clearRemoved clears the operands of the values which were removed,
+// so they are no longer counted as uses of their operands.
+func (s *ssa) clearRemoved() {
+	inBlock := make([]bool, s.fn.NumValues()+1)
+	s.fn.EachValue(func(v ir.Value) {
+		inBlock[v.ID()] = true
+	})
+	for i := 0; i < s.fn.NumValues(); i++ {
+		if v := s.fn.ValueAt(i); !inBlock[v.ID()] && v.NumOperands() > 0 {
+			v.SetOperands()
+		}
+	}
+}
+
+// removeTrivialPhis removes the phis which only have one value other
+// than themselves, replacing them with that value, until there are no
+// more to remove.
+func (s *ssa) removeTrivialPhis() {
+	for changed := true; changed; {
+		changed = false
+		for b := 0; b < s.fn.NumBlocks(); b++ {
+			blk := s.fn.BlockAt(b)
+			for i := 0; i < blk.NumParams(); i++ {
+				phi := blk.Param(i)
+				if phi.Op() != ir.Phi {
+					continue
+				}
+
+				same, ok := s.trivial(phi)
+				if !ok {
+					continue
+				}
+				s.replace[phi.ID()] = same
+				blk.RemoveParam(phi)
+				i--
+				changed = true
+			}
+		}
+	}
+}
+
+// trivial returns the only value of the phi other than itself.
+func (s *ssa) trivial(phi ir.Value) (ir.Value, bool) {
+	var same ir.Value
+	for i := 0; i < phi.NumOperands(); i++ {
+		op := s.replace.Find(phi.Operand(i))
+		if op.ID() == phi.ID() || (!same.IsNil() && op.ID() == same.ID()) {
+			continue
+		}
+		if !same.IsNil() {
+			return ir.Value{}, false
+		}
+		same = op
+	}
+	if same.IsNil() {
+		panic("phi without a value")
+	}
+	return same, true
+}
+
+// inferTypes gives the phis of registers and stack slots the type of
+// the values flowing into them.
+func (s *ssa) inferTypes() {
+	for changed := true; changed; {
+		changed = false
+		s.fn.EachValue(func(v ir.Value) {
+			if v.Op() != ir.Phi || v.Type() != types.None {
+				return
+			}
+			for i := 0; i < v.NumOperands(); i++ {
+				if typ := s.replace.Find(v.Operand(i)).Type(); typ != types.None {
+					v.SetType(typ)
+					changed = true
+					return
+				}
+			}
+		})
+	}
+}
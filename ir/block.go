@@ -23,7 +23,8 @@ const InvalidBlock BlockID = 0
 // when the IR is in SSA form. These act as parallel copies, which
 // means that all the phi instructions are executed at the same time
 // just before the block begins execution, and so their order does
-// not matter.
+// not matter. The parameters of the entry block are the arguments
+// of the function.
 type Block struct {
 	*Func
 
@@ -32,6 +33,9 @@ type Block struct {
 	// Name or label of the block.
 	Name string
 
+	// The list of parameters of the block.
+	params []ValueID
+
 	// The list of values in the block. These are not in any
 	// specific order until the block is scheduled. This list
 	// may include InvalidValue values where values have been
@@ -48,12 +52,26 @@ type Block struct {
 
 	// The list of successors of the block.
 	succs []BlockID
+
+	// The token of the source the block was created for.
+	token token.Token
+
+	// The tokens of the statements which start in the block, in
+	// order, which give the source positions the block covers.
+	stmts []token.Token
+
+	// The function the block was inlined from, if it was, and
+	// whether it is a copy of the entry block of that function.
+	inlinedFrom  string
+	inlinedEntry bool
 }
 
-// NewBlock creates a new block in the function.
+// NewBlock creates a new block in the function. The token is the
+// position of the block, and of its terminator until it is updated.
 // Warning: this will invalidate previous *Block pointers. Writes to previous
 // pointers should redirect to the new block, but reads may return stale data.
 func (fn *Func) NewBlock(name string, op Op, token token.Token, args ...Value) *Block {
+	fn.invalidate()
 	id := BlockID(len(fn.block))
 	terminator := fn.addValue(op, id, fn.lookupType(types.Void), token, args...)
 	fn.block = append(fn.block, Block{
@@ -61,12 +79,90 @@ func (fn *Func) NewBlock(name string, op Op, token token.Token, args ...Value) *
 		Func:       fn,
 		Name:       name,
 		terminator: terminator.id(),
+		token:      token,
 	})
 	block := &fn.block[id]
 
 	return block
 }
 
+// RemoveBlock removes the block, its values and its edges from the
+// function. The values no longer use their operands, the Phi parameters
+// of its successors lose the operand for the removed edge, and the
+// blocks after it are renumbered.
+// Warning: this will invalidate previous *Block pointers and the
+// BlockIDs of the blocks after the removed block.
+func (fn *Func) RemoveBlock(blk *Block) {
+	fn.invalidate()
+	id := blk.ID()
+	b := &fn.block[id]
+
+	for len(b.succs) > 0 {
+		fn.block[b.succs[0]].removePredecessor(id)
+		b.succs = b.succs[1:]
+	}
+	for len(b.preds) > 0 {
+		pred := &fn.block[b.preds[0]]
+		for i, succ := range pred.succs {
+			if succ == id {
+				pred.succs = append(pred.succs[:i], pred.succs[i+1:]...)
+				break
+			}
+		}
+		b.preds = b.preds[1:]
+	}
+
+	// the values of the removed block are no longer in any block
+	fn.moveValues(b, InvalidBlock)
+	for _, v := range b.params {
+		fn.valueForID(v).SetOperands()
+	}
+	for _, v := range b.value {
+		if v != InvalidValue {
+			fn.valueForID(v).SetOperands()
+		}
+	}
+	fn.valueForID(b.terminator).SetOperands()
+
+	fn.block = append(fn.block[:id], fn.block[id+1:]...)
+
+	renumber := func(ids []BlockID) {
+		for i, bid := range ids {
+			if bid > id {
+				ids[i] = bid - 1
+			}
+		}
+	}
+	for i := range fn.block {
+		b := &fn.block[i]
+		renumber(b.preds)
+		renumber(b.succs)
+		if i >= int(id) {
+			b.id = BlockID(i)
+			fn.moveValues(b, b.id)
+		}
+	}
+}
+
+// moveValues sets the block of all the values in the block.
+func (fn *Func) moveValues(b *Block, id BlockID) {
+	move := func(v ValueID) {
+		n := fn.value[v]
+		fn.value[v] = newValue(n.opID(), n.typeID(), id, n.numOperands(), n.firstOperand())
+	}
+	for _, v := range b.params {
+		move(v)
+	}
+	for _, v := range b.value {
+		if v != InvalidValue {
+			move(v)
+		}
+	}
+	if b.terminator != InvalidValue {
+		move(b.terminator)
+	}
+}
+
 // NumBlocks returns the number of blocks in the function.
 func (fn *Func) NumBlocks() int {
 	return len(fn.block) - 1
@@ -94,6 +190,18 @@ func (b *Block) ValueAt(index int) Value {
 	return b.Func.valueForID(b.value[index])
 }
 
+// IndexOf returns the index of the value in the block. It panics if the
+// value is not in the block.
+func (b *Block) IndexOf(v Value) int {
+	b = &b.Func.block[b.ID()] // fix invalid *Block pointers
+	for i, id := range b.value {
+		if id == v.id() {
+			return i
+		}
+	}
+	panic("value not in block")
+}
+
 // ID returns the ID of the block.
 func (b *Block) ID() BlockID {
 	return b.id
@@ -123,11 +231,28 @@ func (b *Block) AddValueAny(op Op, token token.Token, typ types.Type, operands .
 	return val
 }
 
+// InsertValue adds a new value to the block before the value at
+// the given index.
+func (b *Block) InsertValue(index int, op Op, token token.Token, typ types.Type, args ...Value) Value {
+	val := b.Func.addValue(op, b.ID(), b.Func.lookupType(typ), token, args...)
+	b = &b.Func.block[b.ID()] // fix invalid *Block pointers
+	b.value = append(b.value, InvalidValue)
+	copy(b.value[index+1:], b.value[index:])
+	b.value[index] = val.id()
+	return val
+}
+
 func (b *Block) appendValue(id ValueID) {
 	b = &b.Func.block[b.ID()] // fix invalid *Block pointers
 	b.value = append(b.value, id)
 }
 
+// RemoveValue removes the value from the block, leaving an
+// InvalidValue in its place.
+func (b *Block) RemoveValue(v Value) {
+	b.removeValue(v.id())
+}
+
 // removeValue removes the value from the block.
 func (b *Block) removeValue(id ValueID) {
 	b = &b.Func.block[b.ID()] // fix invalid *Block pointers
@@ -139,6 +264,66 @@ func (b *Block) removeValue(id ValueID) {
 	}
 }
 
+// NumParams returns the number of parameters of the block.
+func (b *Block) NumParams() int {
+	return len(b.Func.block[b.ID()].params)
+}
+
+// Param returns the parameter at the given index.
+func (b *Block) Param(index int) Value {
+	return b.Func.valueForID(b.Func.block[b.ID()].params[index])
+}
+
+// AddParam adds a new parameter to the block.
+func (b *Block) AddParam(op Op, token token.Token, typ types.Type, args ...Value) Value {
+	val := b.Func.addValue(op, b.ID(), b.Func.lookupType(typ), token, args...)
+	b = &b.Func.block[b.ID()] // fix invalid *Block pointers
+	b.params = append(b.params, val.id())
+	return val
+}
+
+// RemoveParam removes the parameter from the block.
+func (b *Block) RemoveParam(v Value) {
+	b = &b.Func.block[b.ID()] // fix invalid *Block pointers
+	for i, p := range b.params {
+		if p == v.id() {
+			b.params = append(b.params[:i], b.params[i+1:]...)
+			return
+		}
+	}
+}
+
+// Token returns the token of the source the block was created for.
+func (b *Block) Token() token.Token {
+	return b.Func.block[b.ID()].token
+}
+
+// Stmts returns the tokens of the statements which start in the block.
+func (b *Block) Stmts() []token.Token {
+	return b.Func.block[b.ID()].stmts
+}
+
+// AddStmt records that a statement starts in the block.
+func (b *Block) AddStmt(tok token.Token) {
+	b = &b.Func.block[b.ID()] // fix invalid *Block pointers
+	b.stmts = append(b.stmts, tok)
+}
+
+// InlinedFrom returns the name of the function the block was inlined
+// from, which its statements are in, or "" if it was not inlined, and
+// whether it is a copy of the entry block of that function.
+func (b *Block) InlinedFrom() (string, bool) {
+	b = &b.Func.block[b.ID()]
+	return b.inlinedFrom, b.inlinedEntry
+}
+
+// SetInlinedFrom records the function the block was inlined from, and
+// whether it is a copy of the entry block of that function.
+func (b *Block) SetInlinedFrom(name string, entry bool) {
+	b = &b.Func.block[b.ID()] // fix invalid *Block pointers
+	b.inlinedFrom, b.inlinedEntry = name, entry
+}
+
 // Terminator returns the terminator of the block.
 func (b *Block) Terminator() Value {
 	return b.Func.valueForID(b.terminator)
@@ -184,6 +369,78 @@ func (b *Block) InsertSuccessor(index int, succ *Block) {
 	b.Func.block[succ.ID()].addPredecessor(b)
 }
 
+// SplitEdge inserts a new block on the edge from the block to its
+// successor at the given index, which continues to the successor with
+// a terminator of the given op. The new block takes the place of the
+// block in the predecessors of the successor, so the Phi parameters of
+// the successor keep their operands.
+// Warning: this will invalidate previous *Block pointers.
+func (b *Block) SplitEdge(index int, name string, op Op) *Block {
+	fn := b.Func
+	id := b.ID()
+	succ := fn.block[id].succs[index]
+
+	blk := fn.NewBlock(name, op, fn.block[id].Terminator().Token())
+	blk.preds = []BlockID{id}
+	blk.succs = []BlockID{succ}
+	fn.block[id].succs[index] = blk.id
+
+	s := &fn.block[succ]
+	for i, pred := range s.preds {
+		if pred == id {
+			s.preds[i] = blk.id
+			break
+		}
+	}
+	fn.invalidate()
+	return blk
+}
+
+// SplitBlock splits the block before the value at the given index. The
+// values from the index on, the terminator and the successor edges are
+// moved to a new block, and the block continues to the new block with a
+// new terminator of the given op. The new block takes the place of the
+// block in the predecessors of its successors, so the Phi parameters of
+// the successors keep their operands.
+// Warning: this will invalidate previous *Block pointers.
+func (b *Block) SplitBlock(index int, name string, op Op) *Block {
+	fn := b.Func
+	id := b.ID()
+
+	blk := fn.NewBlock(name, op, fn.block[id].Terminator().Token())
+	old := &fn.block[id]
+	old.terminator, blk.terminator = blk.terminator, old.terminator
+	blk.value = append([]ValueID(nil), old.value[index:]...)
+	old.value = old.value[:index]
+	blk.succs = old.succs
+	old.succs = []BlockID{blk.id}
+	blk.preds = []BlockID{id}
+
+	for _, succ := range blk.succs {
+		s := &fn.block[succ]
+		for i, pred := range s.preds {
+			if pred == id {
+				s.preds[i] = blk.id
+				break
+			}
+		}
+	}
+	fn.moveValues(old, id)
+	fn.moveValues(blk, blk.id)
+	fn.invalidate()
+	return blk
+}
+
+// RemoveSuccessor removes the edge to the successor at the given index,
+// along with its predecessor edge, and the operand for the edge of each
+// of its Phi parameters.
+func (b *Block) RemoveSuccessor(index int) {
+	b = &b.Func.block[b.ID()] // fix invalid *Block pointers
+	succ := b.succs[index]
+	b.succs = append(b.succs[:index], b.succs[index+1:]...)
+	b.Func.block[succ].removePredecessor(b.id)
+}
+
 // NumPredecessors returns the number of predecessors of the block.
 func (b *Block) NumPredecessors() int {
 	return len(b.preds)
@@ -196,9 +453,32 @@ func (b *Block) Predecessor(index int) *Block {
 
 func (b *Block) addPredecessor(pred *Block) {
 	b = &b.Func.block[b.ID()] // fix invalid *Block pointers
+	b.Func.invalidate()
 	b.preds = append(b.preds, pred.ID())
 }
 
+// removePredecessor removes the first edge from the predecessor,
+// along with its operand of each Phi parameter.
+func (b *Block) removePredecessor(pred BlockID) {
+	b = &b.Func.block[b.ID()] // fix invalid *Block pointers
+	b.Func.invalidate()
+	for i, p := range b.preds {
+		if p != pred {
+			continue
+		}
+		b.preds = append(b.preds[:i], b.preds[i+1:]...)
+		for _, id := range b.params {
+			param := b.Func.valueForID(id)
+			if param.Op() != Phi {
+				continue
+			}
+			operands := param.Operands()
+			param.SetOperands(append(operands[:i], operands[i+1:]...)...)
+		}
+		return
+	}
+}
+
 // Dump emits the IR for the block as a string.
 func (b *Block) Dump() string {
 	w := &strings.Builder{}
@@ -207,7 +487,24 @@ func (b *Block) Dump() string {
 }
 
 func (b *Block) dump(w io.Writer) {
-	fmt.Fprintln(w, b.Name+":")
+	// the operands of Phi parameters are in the order of the
+	// predecessors, so it is shown for them
+	preds := ""
+	for _, v := range b.params {
+		if b.Func.valueForID(v).Op() == Phi {
+			names := make([]string, len(b.preds))
+			for i, pred := range b.preds {
+				names[i] = b.Func.block[pred].Name
+			}
+			preds = " <- " + strings.Join(names, ", ")
+			break
+		}
+	}
+
+	fmt.Fprintln(w, b.Name+":"+preds+b.Func.pos(b.Token()))
+	for _, v := range b.params {
+		b.Func.valueForID(v).dump(w)
+	}
 	for _, v := range b.value {
 		if v == InvalidValue {
 			continue
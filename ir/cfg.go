@@ -0,0 +1,89 @@
+package ir
+
+// analyses are the analyses of the control flow graph of a function,
+// which are computed when they are first needed, and kept until the
+// blocks or their edges change.
+type analyses struct {
+	rpo   []BlockID
+	dom   *DomTree
+	pdom  *DomTree
+	loops *LoopForest
+}
+
+// invalidate drops the analyses of the function, when its blocks change.
+func (fn *Func) invalidate() {
+	fn.cfg = nil
+}
+
+func (fn *Func) analyses() *analyses {
+	if fn.cfg == nil {
+		fn.cfg = &analyses{}
+	}
+	return fn.cfg
+}
+
+// ReversePostorder returns the blocks reachable from the entry block in
+// reverse postorder, so each block comes before its successors, except
+// along the back edges of loops. The list is shared, so it must not be
+// changed.
+func (fn *Func) ReversePostorder() []BlockID {
+	a := fn.analyses()
+	if a.rpo == nil {
+		a.rpo = reversePostorder(len(fn.block), 1, func(id BlockID) []BlockID {
+			return fn.block[id].succs
+		})
+	}
+	return a.rpo
+}
+
+// reversePostorder returns the nodes of a graph of n nodes reachable
+// from the root, in reverse postorder.
+func reversePostorder(n int, root BlockID, succs func(BlockID) []BlockID) []BlockID {
+	if int(root) >= n {
+		return []BlockID{}
+	}
+
+	visited := make([]bool, n)
+	order := make([]BlockID, 0, n)
+
+	var visit func(id BlockID)
+	visit = func(id BlockID) {
+		visited[id] = true
+		for _, succ := range succs(id) {
+			if !visited[succ] {
+				visit(succ)
+			}
+		}
+		order = append(order, id)
+	}
+	visit(root)
+
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order
+}
+
+// IsCriticalEdge returns whether the edge from the block to its successor
+// at the index is critical: it leaves a block with several successors for
+// a block with several predecessors, so there is no block which only runs
+// along the edge.
+func (b *Block) IsCriticalEdge(index int) bool {
+	return b.NumSuccessors() > 1 && b.Successor(index).NumPredecessors() > 1
+}
+
+// SplitCriticalEdges splits the critical edges into the blocks which
+// split returns true for, or all of them if split is nil, with a block
+// on each which continues to the successor with a terminator of the
+// given op, and is named after the blocks of the edge.
+func (fn *Func) SplitCriticalEdges(op Op, split func(succ *Block) bool) {
+	for b := 0; b < fn.NumBlocks(); b++ {
+		for i := 0; i < fn.BlockAt(b).NumSuccessors(); i++ {
+			blk := fn.BlockAt(b)
+			succ := blk.Successor(i)
+			if blk.IsCriticalEdge(i) && (split == nil || split(succ)) {
+				blk.SplitEdge(i, blk.Name+"."+succ.Name, op)
+			}
+		}
+	}
+}
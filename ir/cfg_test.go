@@ -0,0 +1,248 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// loopFunc is a pair of nested loops, with an edge back to each header,
+// and a block which can't be reached.
+const loopFunc = `
+func f() {
+entry:
+	Jump outer
+outer:
+	If 1, 2, inner, exit
+inner:
+	If 1, 2, body, outer
+body:
+	If 1, 2, inner, skip
+skip:
+	Jump inner
+exit:
+	Return
+dead:
+	Jump exit
+}
+`
+
+func parseFunc(t *testing.T, src string) *Func {
+	t.Helper()
+	prog, err := Parse(src, ASM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog.Func(0)
+}
+
+// names lists the blocks by name, with InvalidBlock as "-".
+func names(fn *Func, ids ...BlockID) string {
+	list := make([]string, len(ids))
+	for i, id := range ids {
+		if id == InvalidBlock {
+			list[i] = "-"
+		} else {
+			list[i] = fn.Block(id).Name
+		}
+	}
+	return strings.Join(list, " ")
+}
+
+// tree lists the immediate dominator and the frontier of each block.
+func tree(fn *Func, t *DomTree) string {
+	w := &strings.Builder{}
+	for i := 0; i < fn.NumBlocks(); i++ {
+		id := fn.BlockAt(i).ID()
+		if !t.Contains(id) {
+			continue
+		}
+		fmt.Fprintf(w, "%s: %s [%s]\n", names(fn, id), names(fn, t.Idom(id)), names(fn, t.Frontier(id)...))
+	}
+	return w.String()
+}
+
+func TestReversePostorder(t *testing.T) {
+	fn := parseFunc(t, loopFunc)
+	if actual := names(fn, fn.ReversePostorder()...); actual != "entry outer exit inner body skip" {
+		t.Errorf("unexpected order %s", actual)
+	}
+}
+
+func TestDominators(t *testing.T) {
+	fn := parseFunc(t, loopFunc)
+	dom := fn.Dominators()
+
+	expected := `entry: - []
+outer: entry [outer]
+inner: outer [outer inner]
+body: inner [inner]
+skip: body [inner]
+exit: outer []
+`
+	if actual := tree(fn, dom); actual != expected {
+		t.Errorf("expected:\n%s\nactual:\n%s", expected, actual)
+	}
+
+	id := func(name string) BlockID {
+		for i := 0; i < fn.NumBlocks(); i++ {
+			if fn.BlockAt(i).Name == name {
+				return fn.BlockAt(i).ID()
+			}
+		}
+		t.Fatalf("no block %s", name)
+		return InvalidBlock
+	}
+	tests := []struct {
+		a, b      string
+		dominates bool
+	}{
+		{"entry", "skip", true},
+		{"outer", "exit", true},
+		{"inner", "inner", true},
+		{"inner", "exit", false},
+		{"skip", "body", false},
+		{"dead", "exit", false},
+		{"entry", "dead", false},
+	}
+	for _, test := range tests {
+		if actual := dom.Dominates(id(test.a), id(test.b)); actual != test.dominates {
+			t.Errorf("expected %s dominates %s to be %v", test.a, test.b, test.dominates)
+		}
+	}
+	if dom.StrictlyDominates(id("inner"), id("inner")) {
+		t.Errorf("expected a block not to strictly dominate itself")
+	}
+	if actual := names(fn, dom.Children(id("outer"))...); actual != "exit inner" {
+		t.Errorf("unexpected children of outer %s", actual)
+	}
+}
+
+func TestPostDominators(t *testing.T) {
+	fn := parseFunc(t, loopFunc)
+
+	expected := `entry: outer []
+outer: exit [outer]
+inner: outer [outer inner]
+body: inner [inner]
+skip: inner [body]
+exit: - []
+dead: exit []
+`
+	if actual := tree(fn, fn.PostDominators()); actual != expected {
+		t.Errorf("expected:\n%s\nactual:\n%s", expected, actual)
+	}
+}
+
+func TestLoops(t *testing.T) {
+	fn := parseFunc(t, loopFunc)
+	loops := fn.Loops()
+
+	w := &strings.Builder{}
+	for i := 0; i < loops.NumLoops(); i++ {
+		loop := loops.LoopAt(i)
+		parent := InvalidBlock
+		if loop.Parent != nil {
+			parent = loop.Parent.Header
+		}
+		fmt.Fprintf(w, "%s: %s [%s] %d\n", names(fn, loop.Header), names(fn, parent), names(fn, loop.Blocks...), loop.Depth)
+	}
+	expected := `outer: - [outer inner body skip] 1
+inner: outer [inner body skip] 2
+`
+	if actual := w.String(); actual != expected {
+		t.Errorf("expected:\n%s\nactual:\n%s", expected, actual)
+	}
+
+	var depths []string
+	for i := 0; i < fn.NumBlocks(); i++ {
+		blk := fn.BlockAt(i)
+		depths = append(depths, fmt.Sprintf("%s %d", blk.Name, loops.Depth(blk.ID())))
+	}
+	if actual := strings.Join(depths, ", "); actual != "entry 0, outer 1, inner 2, body 2, skip 2, exit 0, dead 0" {
+		t.Errorf("unexpected depths %s", actual)
+	}
+}
+
+func TestSplitCriticalEdges(t *testing.T) {
+	fn := parseFunc(t, loopFunc)
+	dom := fn.Dominators()
+	if fn.Dominators() != dom {
+		t.Errorf("expected the dominators to be kept")
+	}
+
+	fn.SplitCriticalEdges(testJump, nil)
+
+	if fn.Dominators() == dom {
+		t.Errorf("expected the dominators to be rebuilt after the blocks changed")
+	}
+	var split []string
+	for i := 0; i < fn.NumBlocks(); i++ {
+		blk := fn.BlockAt(i)
+		for j := 0; j < blk.NumSuccessors(); j++ {
+			if blk.IsCriticalEdge(j) {
+				t.Errorf("the edge from %s to %s is still critical", blk.Name, blk.Successor(j).Name)
+			}
+		}
+		if strings.Contains(blk.Name, ".") {
+			split = append(split, blk.Name)
+		}
+	}
+	if actual := strings.Join(split, " "); actual != "outer.inner outer.exit inner.outer body.inner" {
+		t.Errorf("unexpected split edges %s", actual)
+	}
+	for _, err := range Verify(fn) {
+		t.Error(err)
+	}
+}
+
+func TestSplitBlock(t *testing.T) {
+	fn := parseFunc(t, `
+func f() {
+entry:
+	v1 = Add 1, 2
+	If v1, v1, loop, exit
+loop: <- entry, loop
+	v2 = Phi v1, v3
+	v3 = Add v2, 1
+	v4 = Add v3, 2
+	If v4, v4, loop, exit
+exit: <- loop, entry
+	v5 = Phi v4, v1
+	Return
+}
+`)
+	dom := fn.Dominators()
+
+	latch := fn.BlockAt(1).SplitBlock(1, "latch", testJump)
+
+	expected := strings.TrimSpace(`
+func f() {
+entry:
+	v1 = Add 1, 2
+	If v1, v1, loop, exit
+loop: <- entry, latch
+	v2 = Phi v1, v3
+	v3 = Add v2, 1
+	Jump latch
+exit: <- latch, entry
+	v5 = Phi v4, v1
+	Return
+latch:
+	v4 = Add v3, 2
+	If v4, v4, loop, exit
+}
+`)
+	if actual := strings.TrimSpace(fn.Dump()); actual != expected {
+		t.Errorf("expected:\n%s\nactual:\n%s", expected, actual)
+	}
+	if v := fn.Value(4); v.Block().ID() != latch.ID() {
+		t.Errorf("expected v4 to be moved to latch, but it is in %s", v.Block().Name)
+	}
+	if fn.Dominators() == dom {
+		t.Errorf("expected the dominators to be rebuilt after the blocks changed")
+	}
+	for _, err := range Verify(fn) {
+		t.Error(err)
+	}
+}
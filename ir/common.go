@@ -6,12 +6,23 @@ const (
 	Invalid CommonOp = iota
 	Const
 	Reg
+
+	// Phi is a parameter of a block in SSA form, with the value
+	// from each predecessor of the block, in the same order as
+	// the predecessors.
+	Phi
+
+	// Arg is a parameter of the entry block in SSA form, which is
+	// the argument of the function at the index of its operand.
+	Arg
 )
 
 var opNames = [...]string{
 	Invalid: "Invalid",
 	Const:   "Const",
 	Reg:     "Reg",
+	Phi:     "Phi",
+	Arg:     "Arg",
 }
 
 func (op CommonOp) String() string {
@@ -36,6 +47,6 @@ func (op CommonOp) OpID() OpID {
 	return OpID(op)
 }
 
-var _ = RegisterOpSet(Common, func(oi OpID) Op {
+var _ = RegisterOpSet(Common, opNames[:], func(oi OpID) Op {
 	return CommonOp(oi)
 })
@@ -8,6 +8,9 @@
 // identify values, and are simply an index into the current
 // function's value list. This ValueID can be used in other
 // arrays to store additional information about the value.
+// The same goes for operands: the uses of each value are linked
+// together through arrays parallel to the operand list, so the
+// users of a value can be found without scanning the function.
 //
 // If a value has a constant number of operands, it is preferable
 // to identify operand indices with a constant so the code is more
@@ -0,0 +1,226 @@
+package ir
+
+// DomTree is the dominator tree of a function, or its post-dominator
+// tree. A block dominates another if every path from the entry block
+// to the other block goes through it, and post-dominates it if every
+// path from the other block to an exit goes through it.
+type DomTree struct {
+	root BlockID
+
+	// the immediate dominator of each block, which is InvalidBlock
+	// for the root and the blocks which can't be reached from it
+	idom     []BlockID
+	children [][]BlockID
+	frontier [][]BlockID
+
+	// the order each block is entered and left in a walk of the tree,
+	// so a block dominates the blocks it is entered before and left after
+	pre, post []int
+}
+
+// Dominators returns the dominator tree of the function, rooted at the
+// entry block.
+func (fn *Func) Dominators() *DomTree {
+	a := fn.analyses()
+	if a.dom == nil {
+		a.dom = fn.dominators()
+	}
+	return a.dom
+}
+
+func (fn *Func) dominators() *DomTree {
+	return newDomTree(len(fn.block), 1,
+		func(id BlockID) []BlockID { return fn.block[id].succs },
+		func(id BlockID) []BlockID { return fn.block[id].preds })
+}
+
+// PostDominators returns the post-dominator tree of the function. The
+// root of the tree is InvalidBlock, which stands for a single exit that
+// all the blocks without successors lead to, so those blocks have it as
+// their immediate post-dominator. The blocks which never reach an exit,
+// such as those in infinite loops, are not in the tree.
+func (fn *Func) PostDominators() *DomTree {
+	a := fn.analyses()
+	if a.pdom == nil {
+		var exits []BlockID
+		for i := 1; i < len(fn.block); i++ {
+			if len(fn.block[i].succs) == 0 {
+				exits = append(exits, BlockID(i))
+			}
+		}
+		exit := []BlockID{InvalidBlock}
+
+		a.pdom = newDomTree(len(fn.block), InvalidBlock,
+			func(id BlockID) []BlockID {
+				if id == InvalidBlock {
+					return exits
+				}
+				return fn.block[id].preds
+			},
+			func(id BlockID) []BlockID {
+				if id == InvalidBlock {
+					return nil
+				}
+				if len(fn.block[id].succs) == 0 {
+					return exit
+				}
+				return fn.block[id].succs
+			})
+	}
+	return a.pdom
+}
+
+// newDomTree builds the dominator tree of a graph of n nodes, using the
+// algorithm from "A Simple, Fast Dominance Algorithm" by Cooper, Harvey
+// and Kennedy, along with the dominance frontiers.
+func newDomTree(n int, root BlockID, succs, preds func(BlockID) []BlockID) *DomTree {
+	t := &DomTree{
+		root:     root,
+		idom:     make([]BlockID, n),
+		children: make([][]BlockID, n),
+		frontier: make([][]BlockID, n),
+		pre:      make([]int, n),
+		post:     make([]int, n),
+	}
+	for i := range t.pre {
+		t.pre[i] = -1
+	}
+	rpo := reversePostorder(n, root, succs)
+	if len(rpo) == 0 {
+		return t
+	}
+
+	// the number of each block in reverse postorder, or -1 if it can't
+	// be reached, so blocks nearer the root have lower numbers
+	order := make([]int, n)
+	for i := range order {
+		order[i] = -1
+	}
+	for i, id := range rpo {
+		order[id] = i
+	}
+
+	intersect := func(a, b BlockID) BlockID {
+		for a != b {
+			for order[a] > order[b] {
+				a = t.idom[a]
+			}
+			for order[b] > order[a] {
+				b = t.idom[b]
+			}
+		}
+		return a
+	}
+
+	// the root is its own immediate dominator until the tree is built,
+	// so the walks up the tree stop there
+	t.idom[root] = root
+	known := make([]bool, n)
+	known[root] = true
+	for changed := true; changed; {
+		changed = false
+		for _, id := range rpo[1:] {
+			var dom BlockID
+			found := false
+			for _, pred := range preds(id) {
+				if !known[pred] {
+					continue
+				}
+				if !found {
+					dom, found = pred, true
+				} else {
+					dom = intersect(pred, dom)
+				}
+			}
+			if !known[id] || t.idom[id] != dom {
+				t.idom[id] = dom
+				known[id] = true
+				changed = true
+			}
+		}
+	}
+
+	for _, id := range rpo[1:] {
+		t.children[t.idom[id]] = append(t.children[t.idom[id]], id)
+	}
+
+	// number the blocks in a walk of the tree
+	num := 0
+	var walk func(id BlockID)
+	walk = func(id BlockID) {
+		t.pre[id] = num
+		num++
+		for _, child := range t.children[id] {
+			walk(child)
+		}
+		t.post[id] = num
+		num++
+	}
+	walk(root)
+
+	// each block is in the frontier of the blocks which dominate one of
+	// its predecessors, up to but not including its immediate dominator
+	for _, id := range rpo[1:] {
+		if len(preds(id)) < 2 {
+			continue
+		}
+		for _, pred := range preds(id) {
+			if order[pred] < 0 {
+				continue
+			}
+			for runner := pred; runner != t.idom[id]; runner = t.idom[runner] {
+				if f := t.frontier[runner]; len(f) == 0 || f[len(f)-1] != id {
+					t.frontier[runner] = append(f, id)
+				}
+			}
+		}
+	}
+
+	t.idom[root] = InvalidBlock
+	return t
+}
+
+// Root returns the root of the tree.
+func (t *DomTree) Root() BlockID {
+	return t.root
+}
+
+// Contains returns whether the block is in the tree, which it isn't if
+// it can't be reached from the root.
+func (t *DomTree) Contains(b BlockID) bool {
+	return int(b) < len(t.pre) && t.pre[b] >= 0
+}
+
+// Idom returns the immediate dominator of the block, the closest block
+// which strictly dominates it, or InvalidBlock if it has none.
+func (t *DomTree) Idom(b BlockID) BlockID {
+	if !t.Contains(b) {
+		return InvalidBlock
+	}
+	return t.idom[b]
+}
+
+// Dominates returns whether a dominates b. Each block dominates itself,
+// and the blocks which aren't in the tree dominate nothing.
+func (t *DomTree) Dominates(a, b BlockID) bool {
+	return t.Contains(a) && t.Contains(b) && t.pre[a] <= t.pre[b] && t.post[b] <= t.post[a]
+}
+
+// StrictlyDominates returns whether a dominates b, and is not b.
+func (t *DomTree) StrictlyDominates(a, b BlockID) bool {
+	return a != b && t.Dominates(a, b)
+}
+
+// Children returns the blocks which the block immediately dominates.
+// The list is shared, so it must not be changed.
+func (t *DomTree) Children(b BlockID) []BlockID {
+	return t.children[b]
+}
+
+// Frontier returns the dominance frontier of the block: the blocks
+// where its dominance ends, which it doesn't strictly dominate, but
+// does dominate a predecessor of. The list is shared, so it must not be
+// changed.
+func (t *DomTree) Frontier(b BlockID) []BlockID {
+	return t.frontier[b]
+}
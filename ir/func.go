@@ -11,10 +11,9 @@ import (
 
 // Func is a function.
 type Func struct {
-	// The file the func is in, which is also a reference
-	// to the source code of the file, which all tokens
-	// reference.
-	*token.File
+	// The files of the program, which hold the source
+	// code that all tokens reference.
+	*token.FileSet
 
 	// The program the function is in.
 	*Program
@@ -25,6 +24,19 @@ type Func struct {
 	// Sig is the type signature of the function.
 	Sig types.Type
 
+	// Extern is whether the function is defined outside of the
+	// program, in C or by the host of the vm, so it has no body.
+	Extern bool
+
+	// NoInline is whether calls to the function must not be inlined,
+	// which a //gosling:noinline directive above it asks for.
+	NoInline bool
+
+	// SSA is whether the function is in SSA form, where each value
+	// is defined once, before its uses, rather than being a register
+	// which is assigned in several places.
+	SSA bool
+
 	// The list of values in the block, indexed by ValueID
 	value []value
 
@@ -37,6 +49,17 @@ type Func struct {
 	// The list of operands for values.
 	operand []ValueID
 
+	// The value each operand belongs to, and the links between the
+	// operands using the same value, indexed like operand. See use.go.
+	user    []ValueID
+	nextUse []uint32
+	prevUse []uint32
+
+	// The first operand using each value, and the number of operands
+	// using it, indexed by ValueID.
+	firstUse []uint32
+	numUses  []uint32
+
 	// The list of tokens indexed by ValueID. Tokens also represent
 	// the position of the value in the source code, as well as any
 	// name or literal associated with the value.
@@ -45,18 +68,24 @@ type Func struct {
 	// The list of basic blocks in the function.
 	block []Block
 
+	// The types of the local variable slots in the stack frame.
+	locals []types.Type
+
 	// The list of constants
 	constantValue map[Constant]ValueID
 	valueConstant map[ValueID]Constant
+
+	// The analyses of the blocks, until they change.
+	cfg *analyses
 }
 
 // NewFunc creates a new Func.
-func NewFunc(file *token.File) *Func {
-	if file == nil {
-		panic("file cannot be nil")
+func NewFunc(fset *token.FileSet) *Func {
+	if fset == nil {
+		panic("file set cannot be nil")
 	}
 	return &Func{
-		File: file,
+		FileSet: fset,
 		value: []value{
 			// the first value is always an invalid value
 			newValue(0, 0, 0, 0, 0),
@@ -92,6 +121,54 @@ func (fn *Func) ValueAt(index int) Value {
 	return fn.valueForID(ValueID(index + 1))
 }
 
+// EachValue calls visit with each parameter, value and terminator of
+// every block, in the order of the blocks.
+func (fn *Func) EachValue(visit func(Value)) {
+	for b := 0; b < fn.NumBlocks(); b++ {
+		blk := fn.BlockAt(b)
+		for i := 0; i < blk.NumParams(); i++ {
+			visit(blk.Param(i))
+		}
+		for i := 0; i < blk.NumValues(); i++ {
+			if v := blk.ValueAt(i); !v.IsNil() {
+				visit(v)
+			}
+		}
+		visit(blk.Terminator())
+	}
+}
+
+// HasOp returns whether any parameter, value or terminator of the
+// function has any of the ops.
+func (fn *Func) HasOp(ops ...Op) bool {
+	for b := 0; b < fn.NumBlocks(); b++ {
+		blk := fn.BlockAt(b)
+		for i := 0; i < blk.NumParams(); i++ {
+			if hasOp(blk.Param(i), ops) {
+				return true
+			}
+		}
+		for i := 0; i < blk.NumValues(); i++ {
+			if v := blk.ValueAt(i); !v.IsNil() && hasOp(v, ops) {
+				return true
+			}
+		}
+		if hasOp(blk.Terminator(), ops) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasOp(v Value, ops []Op) bool {
+	for _, op := range ops {
+		if v.Op() == op {
+			return true
+		}
+	}
+	return false
+}
+
 func (fn *Func) valueForID(id ValueID) Value {
 	val := fn.value[id]
 	val &^= value(0xfffff) << 44
@@ -119,7 +196,7 @@ func (fn *Func) addValue(op Op, block BlockID, typ typeID, token token.Token, op
 
 	firstOperand := len(fn.operand)
 	for i, oper := range operands {
-		fn.operand = append(fn.operand, oper.id())
+		fn.appendOperand(id, oper.id())
 		if fn.operand[firstOperand+i] != oper.id() {
 			panic("invalid operand")
 		}
@@ -179,6 +256,62 @@ func (fn *Func) lookupType(typ types.Type) typeID {
 	return id
 }
 
+// NumLocals returns the number of local variable slots in the stack frame.
+func (fn *Func) NumLocals() int {
+	return len(fn.locals)
+}
+
+// LocalType returns the type of the local variable slot at the given index.
+func (fn *Func) LocalType(index int) types.Type {
+	return fn.locals[index]
+}
+
+// SetLocals sets the types of the local variable slots in the stack frame.
+func (fn *Func) SetLocals(locals []types.Type) {
+	fn.locals = locals
+}
+
+// Clone returns a copy of the function, which can be changed without
+// changing the function.
+func (fn *Func) Clone() *Func {
+	clone := *fn
+	clone.cfg = nil
+	clone.value = append([]value(nil), fn.value...)
+	clone.typ = append([]types.Type(nil), fn.typ...)
+	clone.regs = append([]RegMask(nil), fn.regs...)
+	clone.operand = append([]ValueID(nil), fn.operand...)
+	clone.user = append([]ValueID(nil), fn.user...)
+	clone.nextUse = append([]uint32(nil), fn.nextUse...)
+	clone.prevUse = append([]uint32(nil), fn.prevUse...)
+	clone.firstUse = append([]uint32(nil), fn.firstUse...)
+	clone.numUses = append([]uint32(nil), fn.numUses...)
+	clone.token = append([]token.Token(nil), fn.token...)
+	clone.locals = append([]types.Type(nil), fn.locals...)
+
+	clone.block = make([]Block, len(fn.block))
+	for i, b := range fn.block {
+		b.Func = &clone
+		b.params = append([]ValueID(nil), b.params...)
+		b.value = append([]ValueID(nil), b.value...)
+		b.preds = append([]BlockID(nil), b.preds...)
+		b.succs = append([]BlockID(nil), b.succs...)
+		b.stmts = append([]token.Token(nil), b.stmts...)
+		clone.block[i] = b
+	}
+
+	if fn.constantValue != nil {
+		clone.constantValue = make(map[Constant]ValueID, len(fn.constantValue))
+		for c, id := range fn.constantValue {
+			clone.constantValue[c] = id
+		}
+		clone.valueConstant = make(map[ValueID]Constant, len(fn.valueConstant))
+		for id, c := range fn.valueConstant {
+			clone.valueConstant[id] = c
+		}
+	}
+	return &clone
+}
+
 // String returns the name of the function.
 func (fn *Func) String() string {
 	return fn.Name
@@ -200,6 +333,20 @@ func (fn *Func) ValueForConst(c Constant) Value {
 	return val
 }
 
+// pos returns the line:col comment a dump annotates a token's position
+// with, or an empty string if the token has no position.
+func (fn *Func) pos(tok token.Token) string {
+	if tok == 0 || fn.FileSet == nil {
+		return ""
+	}
+	file := fn.FileSet.File(tok)
+	if file == nil {
+		return ""
+	}
+	line, col := file.PositionOf(tok)
+	return fmt.Sprintf(" // %d:%d", line, col)
+}
+
 // Dump returns a string representation of the function.
 func (fn *Func) Dump() string {
 	w := &strings.Builder{}
@@ -217,6 +364,14 @@ func (fn *Func) dump(w io.Writer) {
 	if sig.ReturnType() != types.Void {
 		pstr += " " + fn.Types().StringOf(sig.ReturnType())
 	}
+	if fn.Extern {
+		fmt.Fprintln(w, "extern func", fn.Name+pstr)
+		fmt.Fprintln(w)
+		return
+	}
+	if fn.NoInline {
+		fmt.Fprintln(w, "//gosling:noinline")
+	}
 	fmt.Fprintln(w, "func", fn.Name+pstr, "{")
 	for _, block := range fn.block {
 		if block.id == InvalidBlock {
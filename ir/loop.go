@@ -0,0 +1,130 @@
+package ir
+
+import "sort"
+
+// Loop is a natural loop: a header block which dominates the blocks of
+// the loop, and the blocks which can reach a back edge to the header
+// without going through it.
+type Loop struct {
+	// Header is the block each entry into the loop goes through.
+	Header BlockID
+
+	// Blocks are the blocks in the loop, including the header and the
+	// blocks of nested loops, in order of their IDs.
+	Blocks []BlockID
+
+	// Parent is the loop this loop is nested in, or nil if it is not
+	// nested.
+	Parent *Loop
+
+	// Depth is the number of loops this loop is nested in, plus one.
+	Depth int
+}
+
+// Contains returns whether the block is in the loop.
+func (l *Loop) Contains(b BlockID) bool {
+	i := sort.Search(len(l.Blocks), func(i int) bool { return l.Blocks[i] >= b })
+	return i < len(l.Blocks) && l.Blocks[i] == b
+}
+
+// LoopForest is the natural loops of a function, and how they nest.
+// Loops which can be entered at more than one block are irreducible,
+// and not found.
+type LoopForest struct {
+	// the loops from the outermost in, in reverse postorder of their
+	// headers where they don't nest
+	loops []*Loop
+
+	// the innermost loop of each block
+	loop []*Loop
+}
+
+// Loops returns the natural loops of the function.
+func (fn *Func) Loops() *LoopForest {
+	a := fn.analyses()
+	if a.loops == nil {
+		a.loops = fn.findLoops()
+	}
+	return a.loops
+}
+
+func (fn *Func) findLoops() *LoopForest {
+	dom := fn.Dominators()
+	lf := &LoopForest{loop: make([]*Loop, len(fn.block))}
+
+	// an edge to a block which dominates the block it leaves is a back
+	// edge, and the loop is the blocks which reach it backwards, without
+	// going through the header
+	for _, header := range fn.ReversePostorder() {
+		var work []BlockID
+		for _, pred := range fn.block[header].preds {
+			if dom.Dominates(header, pred) {
+				work = append(work, pred)
+			}
+		}
+		if len(work) == 0 {
+			continue
+		}
+
+		in := map[BlockID]bool{header: true}
+		loop := &Loop{Header: header, Blocks: []BlockID{header}}
+		for len(work) > 0 {
+			b := work[len(work)-1]
+			work = work[:len(work)-1]
+			if in[b] || !dom.Contains(b) {
+				continue
+			}
+			in[b] = true
+			loop.Blocks = append(loop.Blocks, b)
+			work = append(work, fn.block[b].preds...)
+		}
+		sort.Slice(loop.Blocks, func(i, j int) bool { return loop.Blocks[i] < loop.Blocks[j] })
+		lf.loops = append(lf.loops, loop)
+	}
+
+	// a loop nested in another is smaller than it, so the loops are
+	// visited from the outermost in, and each block ends up in the
+	// innermost loop which contains it
+	sort.SliceStable(lf.loops, func(i, j int) bool {
+		return len(lf.loops[i].Blocks) > len(lf.loops[j].Blocks)
+	})
+	for _, loop := range lf.loops {
+		loop.Parent = lf.loop[loop.Header]
+		loop.Depth = 1
+		if loop.Parent != nil {
+			loop.Depth = loop.Parent.Depth + 1
+		}
+		for _, b := range loop.Blocks {
+			lf.loop[b] = loop
+		}
+	}
+	return lf
+}
+
+// NumLoops returns the number of loops in the function.
+func (lf *LoopForest) NumLoops() int {
+	return len(lf.loops)
+}
+
+// LoopAt returns the loop at the given index. Loops come before the
+// loops nested in them.
+func (lf *LoopForest) LoopAt(index int) *Loop {
+	return lf.loops[index]
+}
+
+// Loop returns the innermost loop the block is in, or nil if it is not
+// in a loop.
+func (lf *LoopForest) Loop(b BlockID) *Loop {
+	if int(b) >= len(lf.loop) {
+		return nil
+	}
+	return lf.loop[b]
+}
+
+// Depth returns the number of loops the block is in.
+func (lf *LoopForest) Depth(b BlockID) int {
+	if loop := lf.Loop(b); loop != nil {
+		return loop.Depth
+	}
+	return 0
+}
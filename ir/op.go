@@ -79,10 +79,19 @@ type Op interface {
 
 var opSets = [4][256]Op{}
 
-// RegisterOpSet registers a set of operations at the given level.
-func RegisterOpSet(level OpLevel, f func(OpID) Op) int {
+// opsNamed are the ops of each level by name, which the IR is parsed with.
+var opsNamed = [4]map[string]Op{}
+
+// RegisterOpSet registers a set of operations at the given level, with
+// the names of the operations by their index. Indexes without a name
+// are not operations of the set.
+func RegisterOpSet(level OpLevel, names []string, f func(OpID) Op) int {
+	opsNamed[level] = make(map[string]Op)
 	for i := 0; i < 256; i++ {
 		opSets[level][i] = f(OpID(int(level)*256 + i))
+		if i < len(names) && names[i] != "" && names[i] != "Invalid" {
+			opsNamed[level][names[i]] = opSets[level][i]
+		}
 	}
 	return 0
 }
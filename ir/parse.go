@@ -0,0 +1,576 @@
+package ir
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rj45/gosling/token"
+	"github.com/rj45/gosling/types"
+)
+
+// Parse reads a program in the form Program.Dump writes it, with the
+// ops of the given level, so tests can start from IR written by hand.
+//
+// The values with results are ints unless their type follows their name,
+// as in v1 bool = Eq v2, v3. The dump has no locals, so they are left for
+// the caller to set. The positions in the comments are skipped. The
+// values named vN keep their IDs, so the program dumps the same as it was
+// read, and the function is taken to be in SSA form if it has any. The
+// Phi and Arg values at the start of a block are its parameters, and the
+// last value is its terminator, with the names of its successors after
+// its operands. The predecessors of each block are in the order they are
+// listed after its label, if they are, or the order their edges are
+// read. A //gosling:noinline line before a function marks it NoInline.
+func Parse(src string, level OpLevel) (*Program, error) {
+	p := &parser{prog: NewProgram(token.NewFileSet()), level: level}
+	if err := p.parse(src); err != nil {
+		return nil, err
+	}
+	return p.prog, nil
+}
+
+// positions matches the positions Program.Dump annotates values with.
+var positions = regexp.MustCompile(` // \d+:\d+`)
+
+// StripPositions removes the positions from a dump, which Parse skips,
+// so it can be compared with the dump of the program parsed from it.
+func StripPositions(dump string) string {
+	return positions.ReplaceAllString(dump, "")
+}
+
+type parser struct {
+	prog  *Program
+	level OpLevel
+	ops   map[string]Op
+
+	funcs []*parsedFunc
+
+	// the values named vN in the function being built
+	named map[ValueID]bool
+
+	// whether the next function is marked NoInline
+	noinline bool
+
+	// the line being parsed, for errors
+	line int
+}
+
+type parsedFunc struct {
+	fn     *Func
+	blocks []*parsedBlock
+}
+
+type parsedBlock struct {
+	line   int
+	name   string
+	preds  []string
+	values []*parsedValue
+}
+
+type parsedValue struct {
+	line     int
+	name     string
+	typ      types.Type
+	op       Op
+	operands []string
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) parse(src string) error {
+	p.ops = make(map[string]Op)
+	for _, level := range []OpLevel{Common, p.level} {
+		for name, op := range opsNamed[level] {
+			p.ops[name] = op
+		}
+	}
+
+	// read all the functions first, so calls can refer to the
+	// functions after them
+	var fn *parsedFunc
+	var blk *parsedBlock
+	for i, line := range strings.Split(src, "\n") {
+		p.line = i + 1
+		if fn == nil && strings.TrimSpace(line) == "//gosling:noinline" {
+			p.noinline = true
+			continue
+		}
+		if c := strings.Index(line, "//"); c >= 0 {
+			line = line[:c]
+		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "":
+		case fn == nil && strings.HasPrefix(line, "extern func "):
+			if _, err := p.header(strings.TrimPrefix(line, "extern func "), true); err != nil {
+				return err
+			}
+		case fn == nil && strings.HasPrefix(line, "func "):
+			f, err := p.header(strings.TrimPrefix(line, "func "), false)
+			if err != nil {
+				return err
+			}
+			fn = &parsedFunc{fn: f}
+			p.funcs = append(p.funcs, fn)
+			blk = nil
+		case fn == nil:
+			return p.errorf("expected a function")
+		case line == "}":
+			fn = nil
+		case isLabel(line):
+			name, preds, _ := strings.Cut(line, ":")
+			blk = &parsedBlock{line: p.line, name: name}
+			if preds = strings.TrimSpace(preds); preds != "" {
+				if !strings.HasPrefix(preds, "<- ") {
+					return p.errorf("expected <- before the predecessors of %s", name)
+				}
+				for _, pred := range strings.Split(preds[len("<- "):], ",") {
+					blk.preds = append(blk.preds, strings.TrimSpace(pred))
+				}
+			}
+			fn.blocks = append(fn.blocks, blk)
+		case blk == nil:
+			return p.errorf("expected a block")
+		default:
+			v, err := p.value(line)
+			if err != nil {
+				return err
+			}
+			blk.values = append(blk.values, v)
+		}
+	}
+	if fn != nil {
+		return p.errorf("missing } at the end of %s", fn.fn.Name)
+	}
+
+	for _, fn := range p.funcs {
+		if err := p.build(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isLabel returns whether the line starts a block, with its name, and
+// maybe its predecessors, before a colon.
+func isLabel(line string) bool {
+	name, _, found := strings.Cut(line, ":")
+	return found && name != "" && !strings.ContainsAny(name, " \t")
+}
+
+// header reads the name and signature of a function, after the func
+// keyword, and adds it to the program.
+func (p *parser) header(line string, extern bool) (*Func, error) {
+	paren := strings.IndexByte(line, '(')
+	if paren <= 0 {
+		return nil, p.errorf("expected a function name")
+	}
+	name := line[:paren]
+	rest := line[paren+1:]
+
+	var params []types.Type
+	for !strings.HasPrefix(rest, ")") {
+		if len(params) > 0 {
+			if !strings.HasPrefix(rest, ", ") {
+				return nil, p.errorf("expected , between params")
+			}
+			rest = rest[2:]
+		}
+		space := strings.IndexByte(rest, ' ')
+		if space < 0 {
+			return nil, p.errorf("expected a param type")
+		}
+		typ, r, err := p.typ(rest[space+1:])
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, typ)
+		rest = r
+	}
+	rest = strings.TrimSpace(rest[1:])
+
+	switch body := strings.HasSuffix(rest, "{"); {
+	case extern && body:
+		return nil, p.errorf("extern function %s has a body", name)
+	case !extern && !body:
+		return nil, p.errorf("expected { after the signature of %s", name)
+	}
+	rest = strings.TrimSpace(strings.TrimSuffix(rest, "{"))
+
+	ret := types.Void
+	if rest != "" {
+		typ, r, err := p.typ(rest)
+		if err != nil {
+			return nil, err
+		}
+		if r != "" {
+			return nil, p.errorf("unexpected %q after the signature", r)
+		}
+		ret = typ
+	}
+
+	if p.prog.FuncNamed(name) != nil {
+		return nil, p.errorf("function %s is defined twice", name)
+	}
+	fn := p.prog.NewFunc()
+	fn.Name = name
+	fn.Sig = p.prog.Types().FuncFor(params, ret)
+	fn.Extern = extern
+	fn.NoInline = p.noinline
+	p.noinline = false
+	return fn, nil
+}
+
+// typ reads a type from the start of s, in the form the universe writes
+// it, and returns the rest of s.
+func (p *parser) typ(s string) (types.Type, string, error) {
+	ptrs := 0
+	for strings.HasPrefix(s, "*") {
+		ptrs++
+		s = s[1:]
+	}
+
+	var typ types.Type
+	switch {
+	case strings.HasPrefix(s, "map["):
+		key, rest, err := p.typ(s[len("map["):])
+		if err != nil {
+			return 0, "", err
+		}
+		if !strings.HasPrefix(rest, "]") {
+			return 0, "", p.errorf("expected ] after the key type of a map")
+		}
+		elem, rest, err := p.typ(rest[1:])
+		if err != nil {
+			return 0, "", err
+		}
+		typ, s = p.prog.Types().MapFor(key, elem), rest
+
+	case strings.HasPrefix(s, "chan "):
+		elem, rest, err := p.typ(s[len("chan "):])
+		if err != nil {
+			return 0, "", err
+		}
+		typ, s = p.prog.Types().ChanFor(elem), rest
+
+	case strings.HasPrefix(s, "func("):
+		s = s[len("func("):]
+		var params []types.Type
+		for !strings.HasPrefix(s, ")") {
+			if len(params) > 0 {
+				if !strings.HasPrefix(s, ", ") {
+					return 0, "", p.errorf("expected , between param types")
+				}
+				s = s[2:]
+			}
+			param, rest, err := p.typ(s)
+			if err != nil {
+				return 0, "", err
+			}
+			params = append(params, param)
+			s = rest
+		}
+		s = s[1:]
+
+		// the return type follows a space, where a brace or
+		// another param could also follow
+		ret := types.Void
+		if rest := strings.TrimPrefix(s, " "); rest != s && startsType(rest) {
+			r, rest, err := p.typ(rest)
+			if err != nil {
+				return 0, "", err
+			}
+			ret, s = r, rest
+		}
+		typ = p.prog.Types().FuncFor(params, ret)
+
+	default:
+		basic := map[string]types.Type{
+			"none": types.None,
+			"void": types.Void,
+			"int":  types.Int,
+			"bool": types.Bool,
+		}
+		end := strings.IndexAny(s, " ,)]{")
+		if end < 0 {
+			end = len(s)
+		}
+		t, found := basic[s[:end]]
+		if !found {
+			return 0, "", p.errorf("unknown type %q", s[:end])
+		}
+		typ, s = t, s[end:]
+	}
+
+	for i := 0; i < ptrs; i++ {
+		typ = typ.Pointer()
+	}
+	return typ, s, nil
+}
+
+func startsType(s string) bool {
+	for _, prefix := range []string{"*", "map[", "chan ", "func(", "none", "void", "int", "bool"} {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// value reads a value, with its name if it has a result, and the
+// names of its operands.
+func (p *parser) value(line string) (*parsedValue, error) {
+	v := &parsedValue{line: p.line, typ: types.Void}
+	if eq := strings.Index(line, " = "); eq >= 0 {
+		v.name, v.typ = line[:eq], types.Int
+		if name, typ, found := strings.Cut(v.name, " "); found {
+			t, rest, err := p.typ(typ)
+			if err != nil {
+				return nil, err
+			}
+			if rest != "" {
+				return nil, p.errorf("unexpected %q after the type of %s", rest, name)
+			}
+			v.name, v.typ = name, t
+		}
+		line = line[eq+len(" = "):]
+	}
+
+	name, operands, _ := strings.Cut(line, " ")
+	op, found := p.ops[name]
+	if !found {
+		return nil, p.errorf("unknown op %s", name)
+	}
+	v.op = op
+
+	if operands != "" {
+		for _, oper := range strings.Split(operands, ",") {
+			v.operands = append(v.operands, strings.TrimSpace(oper))
+		}
+	}
+	return v, nil
+}
+
+// build adds the blocks and values read for a function to it.
+func (p *parser) build(pf *parsedFunc) error {
+	fn := pf.fn
+
+	// the values named vN are given the ID N, so the IDs up to the
+	// largest are kept for them, and the other values come after
+	named := make(map[ValueID]bool)
+	p.named = named
+	max := ValueID(0)
+	for _, blk := range pf.blocks {
+		for _, v := range blk.values {
+			if id, ok := valueName(v.name); ok {
+				p.line = v.line
+				if id == InvalidValue || named[id] {
+					return p.errorf("value %s is defined twice", v.name)
+				}
+				named[id] = true
+				if id > max {
+					max = id
+				}
+			}
+		}
+	}
+	for ValueID(len(fn.value)) <= max {
+		fn.value = append(fn.value, 0)
+		fn.token = append(fn.token, 0)
+		fn.regs = append(fn.regs, 0)
+	}
+	fn.SSA = len(named) > 0
+
+	blocks := make(map[string]BlockID)
+	for _, blk := range pf.blocks {
+		if _, found := blocks[blk.name]; found {
+			return p.errorf("block %s is defined twice", blk.name)
+		}
+		id := BlockID(len(fn.block))
+		blocks[blk.name] = id
+		fn.block = append(fn.block, Block{id: id, Func: fn, Name: blk.name})
+	}
+
+	var succs [][]BlockID
+	for _, pb := range pf.blocks {
+		if len(pb.values) == 0 {
+			return p.errorf("block %s has no terminator", pb.name)
+		}
+		blk := &fn.block[blocks[pb.name]]
+
+		var bsuccs []BlockID
+		for i, v := range pb.values {
+			p.line = v.line
+			operands := v.operands
+			if i == len(pb.values)-1 {
+				// the successors are at the end of the terminator
+				for len(operands) > 0 {
+					succ, found := blocks[operands[len(operands)-1]]
+					if !found {
+						break
+					}
+					bsuccs = append([]BlockID{succ}, bsuccs...)
+					operands = operands[:len(operands)-1]
+				}
+			}
+
+			id, err := p.add(fn, blk.id, v, operands)
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case i == len(pb.values)-1:
+				blk.terminator = id
+			case len(blk.value) == 0 && (v.op == Phi || v.op == Arg):
+				blk.params = append(blk.params, id)
+			default:
+				blk.value = append(blk.value, id)
+			}
+		}
+		succs = append(succs, bsuccs)
+	}
+
+	for i, pb := range pf.blocks {
+		for _, succ := range succs[i] {
+			fn.Block(blocks[pb.name]).AddSuccessor(fn.Block(succ))
+		}
+	}
+
+	// the predecessors which are listed are put in that order
+	for _, pb := range pf.blocks {
+		if pb.preds == nil {
+			continue
+		}
+		p.line = pb.line
+		blk := &fn.block[blocks[pb.name]]
+		preds := make([]BlockID, len(pb.preds))
+		for i, name := range pb.preds {
+			preds[i] = blocks[name]
+		}
+		if !samePreds(blk.preds, preds) {
+			return p.errorf("the predecessors of %s are not %s", pb.name, strings.Join(pb.preds, ", "))
+		}
+		blk.preds = preds
+	}
+
+	return nil
+}
+
+// samePreds returns whether the lists have the same blocks, the same
+// number of times, in any order.
+func samePreds(a, b []BlockID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := make(map[BlockID]int)
+	for _, id := range a {
+		count[id]++
+	}
+	for _, id := range b {
+		count[id]--
+		if count[id] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// add adds the value to the function in the block, with the operands,
+// which are names of values or constants.
+func (p *parser) add(fn *Func, block BlockID, v *parsedValue, operands []string) (ValueID, error) {
+	ids := make([]ValueID, len(operands))
+	for i, oper := range operands {
+		if id, ok := valueName(oper); ok {
+			if !p.named[id] {
+				return 0, p.errorf("value %s is not defined", oper)
+			}
+			ids[i] = id
+			continue
+		}
+		c, err := p.constant(oper)
+		if err != nil {
+			return 0, err
+		}
+		ids[i] = fn.ValueForConst(c).id()
+	}
+
+	var regs RegMask
+	id, named := valueName(v.name)
+	switch {
+	case v.name == "":
+	case !named:
+		r, ok := regNames(v.name)
+		if !ok {
+			return 0, p.errorf("invalid value name %s", v.name)
+		}
+		regs = r
+	}
+	if !named {
+		id = ValueID(len(fn.value))
+		fn.value = append(fn.value, 0)
+		fn.token = append(fn.token, 0)
+		fn.regs = append(fn.regs, 0)
+	}
+
+	first := len(fn.operand)
+	for _, oper := range ids {
+		fn.appendOperand(id, oper)
+	}
+	fn.value[id] = newValue(v.op.OpID(), fn.lookupType(v.typ), block, len(ids), first)
+	fn.regs[id] = regs
+	return id, nil
+}
+
+// constant reads an operand which is a constant: a register, an int, a
+// bool, or the name of a function.
+func (p *parser) constant(s string) (Constant, error) {
+	if regs, ok := regNames(s); ok {
+		return RegConst(regs), nil
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return IntConst(i), nil
+	}
+	switch s {
+	case "true":
+		return BoolConst(true), nil
+	case "false":
+		return BoolConst(false), nil
+	}
+	if fn := p.prog.FuncNamed(s); fn != nil {
+		return FuncConst(fn), nil
+	}
+	return nil, p.errorf("unknown operand %s", s)
+}
+
+// valueName returns the ID of a value named vN.
+func valueName(s string) (ValueID, bool) {
+	if !strings.HasPrefix(s, "v") {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(s[1:], 10, 20)
+	if err != nil {
+		return 0, false
+	}
+	return ValueID(id), true
+}
+
+// regNames returns the registers named by s, in the form RegMask.String
+// writes them, such as r0r1.
+func regNames(s string) (RegMask, bool) {
+	var regs RegMask
+	for _, name := range strings.SplitAfter(s, "r")[1:] {
+		name = strings.TrimSuffix(name, "r")
+		reg, err := strconv.ParseUint(name, 10, 5)
+		if err != nil {
+			return 0, false
+		}
+		regs.SetReg(RegID(reg))
+	}
+	return regs, strings.HasPrefix(s, "r") && regs != 0
+}
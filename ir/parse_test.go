@@ -0,0 +1,179 @@
+package ir
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	expected := maxFunc().Program.Dump()
+
+	prog, err := Parse(expected, ASM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := prog.Dump(); actual != expected {
+		t.Errorf("expected:\n%s\nactual:\n%s", expected, actual)
+	}
+	for _, err := range Verify(prog.Func(0)) {
+		t.Error(err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{
+			name: "unknown op",
+			src: `
+				func f() {
+				entry:
+					Sub 1, 2
+				}`,
+			err: "line 4: unknown op Sub",
+		},
+		{
+			name: "undefined value",
+			src: `
+				func f() int {
+				entry:
+					v1 = Add v2, 1
+					Return v1
+				}`,
+			err: "line 4: value v2 is not defined",
+		},
+		{
+			name: "value defined twice",
+			src: `
+				func f() int {
+				entry:
+					v1 = Add 1, 2
+					v1 = Add 1, 2
+					Return v1
+				}`,
+			err: "line 5: value v1 is defined twice",
+		},
+		{
+			name: "unknown operand",
+			src: `
+				func f() int {
+				entry:
+					v1 = Add 1, g
+					Return v1
+				}`,
+			err: "line 4: unknown operand g",
+		},
+		{
+			name: "unknown type",
+			src: `
+				func f(r0 string) {
+				entry:
+					Return
+				}`,
+			err: `line 2: unknown type "string"`,
+		},
+		{
+			name: "unknown value type",
+			src: `
+				func f() {
+				entry:
+					v1 string = Add 1, 2
+					Return
+				}`,
+			err: `line 4: unknown type "string"`,
+		},
+		{
+			name: "wrong predecessors",
+			src: `
+				func f() {
+				entry:
+					Jump done
+				done: <- entry, entry
+					Return
+				}`,
+			err: "line 5: the predecessors of done are not entry, entry",
+		},
+		{
+			name: "missing brace",
+			src: `
+				func f() {
+				entry:
+					Return`,
+			err: "line 4: missing } at the end of f",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(test.src, ASM)
+			if err == nil || err.Error() != test.err {
+				t.Errorf("expected error %q, but got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestParseCalls(t *testing.T) {
+	src := strings.TrimSpace(`
+extern func print(r0 int)
+
+func main() {
+entry:
+	v1 = Add 40, 2
+	If v1, v1, then, done
+then:
+	Jump done
+done: <- then, entry
+	v4 = Phi v1, 1
+	Add print, v4
+	Return
+}
+`)
+	prog, err := Parse(src, ASM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := strings.TrimSpace(prog.Dump()); actual != src {
+		t.Errorf("expected:\n%s\nactual:\n%s", src, actual)
+	}
+
+	fn := prog.FuncNamed("main")
+	done := fn.BlockAt(2)
+	if done.NumPredecessors() != 2 || done.Predecessor(0).Name != "then" || done.Predecessor(1).Name != "entry" {
+		t.Errorf("expected done to have the predecessors then and entry")
+	}
+	if callee, _ := FuncValue(done.ValueAt(0).Operand(0).Constant()); callee != prog.FuncNamed("print") {
+		t.Errorf("expected a reference to print, but got %v", callee)
+	}
+	for _, err := range Verify(fn) {
+		t.Error(err)
+	}
+}
+
+func TestParseNoInline(t *testing.T) {
+	src := strings.TrimSpace(`
+//gosling:noinline
+func f() {
+entry:
+	Return
+}
+
+func g() {
+entry:
+	Return
+}
+`)
+	prog, err := Parse(src, ASM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !prog.FuncNamed("f").NoInline || prog.FuncNamed("g").NoInline {
+		t.Errorf("expected only f to be marked NoInline")
+	}
+	if actual := strings.TrimSpace(prog.Dump()); actual != src {
+		t.Errorf("expected:\n%s\nactual:\n%s", src, actual)
+	}
+}
@@ -0,0 +1,201 @@
+package ir
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// PassOptions are the options for the passes a PassManager runs, and
+// for debugging them.
+type PassOptions struct {
+	// OptLevel is the level of optimization, which decides the
+	// passes the compiler adds. Zero adds no optimization passes.
+	OptLevel int
+
+	// Verify is whether to verify the IR after each pass, panicking
+	// if the pass left it invalid.
+	Verify bool
+
+	// DumpBefore and DumpAfter are the names of the passes to dump
+	// the IR before and after, to Out, or to stderr if it is nil.
+	DumpBefore []string
+	DumpAfter  []string
+	Out        io.Writer
+
+	// Times totals the time each pass takes, if it is set.
+	Times *PassTimes
+}
+
+// PassManager runs a list of named passes over the IR in order, which
+// change either each function, or the whole program.
+type PassManager struct {
+	PassOptions
+	passes []pass
+}
+
+type pass struct {
+	name    string
+	fn      func(*Func) error
+	program func(*Program) error
+}
+
+// NewPassManager returns a pass manager with the options.
+func NewPassManager(opts PassOptions) *PassManager {
+	if opts.Out == nil {
+		opts.Out = os.Stderr
+	}
+	return &PassManager{PassOptions: opts}
+}
+
+// AddFuncPass adds a pass which is run on each function with a body.
+func (pm *PassManager) AddFuncPass(name string, fn func(*Func) error) {
+	pm.passes = append(pm.passes, pass{name: name, fn: fn})
+}
+
+// AddProgramPass adds a pass which is run on the whole program.
+func (pm *PassManager) AddProgramPass(name string, program func(*Program) error) {
+	pm.passes = append(pm.passes, pass{name: name, program: program})
+}
+
+// Run runs the passes over the program, stopping at the first error.
+// Function passes run over each function with a body in turn, before
+// the next pass runs.
+func (pm *PassManager) Run(prog *Program) error {
+	for _, p := range pm.passes {
+		var err error
+		if p.program != nil {
+			err = pm.runProgram(p, prog)
+		} else {
+			err = pm.runFuncs(p, prog)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pm *PassManager) runProgram(p pass, prog *Program) error {
+	if hasName(pm.DumpBefore, p.name) {
+		fmt.Fprintf(pm.Out, "// before %s\n%s", p.name, prog.Dump())
+	}
+
+	start := time.Now()
+	err := p.program(prog)
+	pm.time(p.name, time.Since(start))
+	if err != nil {
+		return err
+	}
+
+	if hasName(pm.DumpAfter, p.name) {
+		fmt.Fprintf(pm.Out, "// after %s\n%s", p.name, prog.Dump())
+	}
+	if pm.Verify {
+		for i := 0; i < prog.NumFuncs(); i++ {
+			mustVerify(prog.Func(i), p.name)
+		}
+	}
+	return nil
+}
+
+// runFuncs runs a function pass over each function, and times the
+// functions together as one run of the pass.
+func (pm *PassManager) runFuncs(p pass, prog *Program) error {
+	var elapsed time.Duration
+	defer func() {
+		pm.time(p.name, elapsed)
+	}()
+
+	for i := 0; i < prog.NumFuncs(); i++ {
+		fn := prog.Func(i)
+		if fn.Extern || fn.NumBlocks() == 0 {
+			continue
+		}
+
+		if hasName(pm.DumpBefore, p.name) {
+			fmt.Fprintf(pm.Out, "// before %s: %s\n%s", p.name, fn.Name, fn.Dump())
+		}
+
+		start := time.Now()
+		err := p.fn(fn)
+		elapsed += time.Since(start)
+		if err != nil {
+			return err
+		}
+
+		if hasName(pm.DumpAfter, p.name) {
+			fmt.Fprintf(pm.Out, "// after %s: %s\n%s", p.name, fn.Name, fn.Dump())
+		}
+		if pm.Verify {
+			mustVerify(fn, p.name)
+		}
+	}
+	return nil
+}
+
+func (pm *PassManager) time(name string, d time.Duration) {
+	if pm.Times != nil {
+		pm.Times.add(name, d)
+	}
+}
+
+func hasName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyPass panics with the errors Verify finds in the function after
+// the named pass, if the options verify passes. It is for the passes
+// which are run outside of a pass manager.
+func (opts PassOptions) VerifyPass(fn *Func, pass string) {
+	if opts.Verify {
+		mustVerify(fn, pass)
+	}
+}
+
+func mustVerify(fn *Func, pass string) {
+	errs := Verify(fn)
+	if len(errs) == 0 {
+		return
+	}
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	panic(fmt.Sprintf("invalid IR after %s:\n%s\n%s", pass, strings.Join(msgs, "\n"), fn.Dump()))
+}
+
+// PassTimes totals the time each pass takes, over all the times it runs.
+// A function pass runs once for all of the functions of a program.
+type PassTimes struct {
+	names []string
+	runs  map[string]int
+	total map[string]time.Duration
+}
+
+func (t *PassTimes) add(name string, d time.Duration) {
+	if t.total == nil {
+		t.runs = make(map[string]int)
+		t.total = make(map[string]time.Duration)
+	}
+	if _, found := t.total[name]; !found {
+		t.names = append(t.names, name)
+	}
+	t.runs[name]++
+	t.total[name] += d
+}
+
+// Report writes the number of runs and the total time of each pass, in
+// the order the passes first ran.
+func (t *PassTimes) Report(w io.Writer) {
+	for _, name := range t.names {
+		fmt.Fprintf(w, "%-12s %6d runs %12s\n", name, t.runs[name], t.total[name])
+	}
+}
@@ -0,0 +1,133 @@
+package ir
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPassManager(t *testing.T) {
+	prog := maxFunc().Program
+
+	var order []string
+	pm := &PassManager{PassOptions: PassOptions{
+		DumpBefore: []string{"rename"},
+		DumpAfter:  []string{"relabel"},
+		Out:        &bytes.Buffer{},
+		Times:      &PassTimes{},
+		Verify:     true,
+	}}
+	pm.AddFuncPass("relabel", func(fn *Func) error {
+		order = append(order, "relabel "+fn.Name)
+		fn.BlockAt(0).Name = "start"
+		return nil
+	})
+	pm.AddProgramPass("rename", func(prog *Program) error {
+		order = append(order, "rename")
+		prog.Func(0).Name = "maximum"
+		return nil
+	})
+	pm.AddFuncPass("skipped", func(fn *Func) error {
+		order = append(order, "skipped "+fn.Name)
+		return fmt.Errorf("%s: failed", fn.Name)
+	})
+	pm.AddFuncPass("never", func(fn *Func) error {
+		order = append(order, "never "+fn.Name)
+		return nil
+	})
+
+	err := pm.Run(prog)
+	if err == nil || err.Error() != "maximum: failed" {
+		t.Errorf("expected the error of the failing pass, but got %v", err)
+	}
+	if actual := strings.Join(order, ", "); actual != "relabel max, rename, skipped maximum" {
+		t.Errorf("expected the passes to run in order, but got %s", actual)
+	}
+
+	dumps := pm.Out.(*bytes.Buffer).String()
+	expected := `
+// after relabel: max
+func max(r0 int, r1 int) int {
+start:
+	v3 = Arg 0
+	v5 = Arg 1
+	If v3, v5, then, join
+then:
+	Jump join
+join: <- start, then
+	v8 = Phi v3, v5
+	v9 = Add v8, 1
+	Return v9
+}
+
+// before rename
+func max(r0 int, r1 int) int {
+start:
+	v3 = Arg 0
+	v5 = Arg 1
+	If v3, v5, then, join
+then:
+	Jump join
+join: <- start, then
+	v8 = Phi v3, v5
+	v9 = Add v8, 1
+	Return v9
+}
+`
+	if strings.TrimSpace(dumps) != strings.TrimSpace(expected) {
+		t.Errorf("expected dumps:\n%s\nactual:\n%s", expected, dumps)
+	}
+
+	report := &strings.Builder{}
+	pm.Times.Report(report)
+	for _, name := range []string{"relabel", "rename", "skipped"} {
+		if !strings.Contains(report.String(), name+" ") {
+			t.Errorf("expected the time of %s in the report:\n%s", name, report)
+		}
+	}
+	if strings.Contains(report.String(), "never") {
+		t.Errorf("expected no time for the pass which never ran:\n%s", report)
+	}
+}
+
+func TestPassManagerVerify(t *testing.T) {
+	pm := &PassManager{PassOptions: PassOptions{Verify: true}}
+	pm.AddFuncPass("corrupt", func(fn *Func) error {
+		join := fn.BlockAt(2)
+		join.ValueAt(0).SetOperand(1, join.ValueAt(0))
+		return nil
+	})
+
+	defer func() {
+		r := recover()
+		if r == nil || !strings.HasPrefix(fmt.Sprint(r), "invalid IR after corrupt:\nmax: join: v9 uses v9 before it is defined\n") {
+			t.Errorf("expected the pass to fail verification, but got %v", r)
+		}
+	}()
+	pm.Run(maxFunc().Program)
+}
+
+func TestPassTimesFuncPass(t *testing.T) {
+	prog := maxFunc().Program
+	other := prog.Func(0).Clone()
+	other.Name = "other"
+	prog.fn = append(prog.fn, other)
+
+	var names []string
+	pm := &PassManager{PassOptions: PassOptions{Times: &PassTimes{}}}
+	pm.AddFuncPass("names", func(fn *Func) error {
+		names = append(names, fn.Name)
+		return nil
+	})
+	if err := pm.Run(prog); err != nil {
+		t.Fatal(err)
+	}
+
+	if actual := strings.Join(names, " "); actual != "max other" {
+		t.Errorf("expected the pass to run on each function, but got %s", actual)
+	}
+	if runs := pm.Times.runs["names"]; runs != 1 {
+		t.Errorf("expected the pass to run once over the program, but got %d runs", runs)
+	}
+}
@@ -10,19 +10,21 @@ import (
 
 // Program represents a Go program, and is the root of the IR.
 type Program struct {
-	*token.File
+	*token.FileSet
 	fn    []*Func
 	types *types.Universe
 }
 
 // NewProgram creates a new Program.
-func NewProgram(file *token.File) *Program {
-	return &Program{File: file, types: types.NewUniverse()}
+// The program can be made from several packages, whose files
+// are all in the file set.
+func NewProgram(fset *token.FileSet) *Program {
+	return &Program{FileSet: fset, types: types.NewUniverse()}
 }
 
 // NewFunc creates a new function in the program.
 func (p *Program) NewFunc() *Func {
-	fn := NewFunc(p.File)
+	fn := NewFunc(p.FileSet)
 	fn.Program = p
 	p.fn = append(p.fn, fn)
 	return fn
@@ -58,11 +60,6 @@ func (p *Program) SetTypes(uni *types.Universe) {
 	p.types = uni
 }
 
-// String returns the filename.
-func (p *Program) String() string {
-	return p.File.String()
-}
-
 func (p *Program) Dump() string {
 	w := &strings.Builder{}
 	p.dump(w)
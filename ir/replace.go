@@ -0,0 +1,30 @@
+package ir
+
+// Replacements are the values which were removed from a function, and
+// the values replacing them, for passes which remove values before they
+// have replaced all of their uses. A replacing value may itself be
+// removed and replaced later.
+type Replacements map[ValueID]Value
+
+// Find returns the value which replaces the value, if it was removed.
+func (r Replacements) Find(v Value) Value {
+	for {
+		found, ok := r[v.ID()]
+		if !ok {
+			return v
+		}
+		v = found
+	}
+}
+
+// Resolve replaces the operands of the function which were removed with
+// the values which replace them.
+func (r Replacements) Resolve(fn *Func) {
+	fn.EachValue(func(v Value) {
+		for i := 0; i < v.NumOperands(); i++ {
+			if found, ok := r[v.OperandID(i)]; ok {
+				v.SetOperand(i, r.Find(found))
+			}
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package ir
+
+// The uses of each value are kept as a doubly linked list threaded
+// through the operands of the function, so that a value's users can be
+// found without scanning every value. Links are the index of an operand
+// plus one, so that zero ends a list, and the arrays stay parallel to
+// the operand and value lists.
+
+// appendOperand appends an operand of the user to the operand list,
+// linking it into the uses of the operand.
+func (fn *Func) appendOperand(user, id ValueID) {
+	fn.operand = append(fn.operand, id)
+	fn.user = append(fn.user, user)
+	fn.nextUse = append(fn.nextUse, 0)
+	fn.prevUse = append(fn.prevUse, 0)
+	fn.link(len(fn.operand) - 1)
+}
+
+// setOperand changes the operand at the index of the operand list,
+// moving it from the uses of the old value to those of the new one.
+func (fn *Func) setOperand(index int, id ValueID) {
+	if fn.operand[index] == id {
+		return
+	}
+	fn.unlink(index)
+	fn.operand[index] = id
+	fn.link(index)
+}
+
+// link adds the operand at the index to the front of the uses of its
+// value.
+func (fn *Func) link(index int) {
+	id := fn.operand[index]
+	if id == InvalidValue {
+		return
+	}
+	for int(id) >= len(fn.firstUse) {
+		fn.firstUse = append(fn.firstUse, 0)
+		fn.numUses = append(fn.numUses, 0)
+	}
+
+	next := fn.firstUse[id]
+	fn.nextUse[index] = next
+	fn.prevUse[index] = 0
+	if next != 0 {
+		fn.prevUse[next-1] = uint32(index + 1)
+	}
+	fn.firstUse[id] = uint32(index + 1)
+	fn.numUses[id]++
+}
+
+// unlink removes the operand at the index from the uses of its value.
+func (fn *Func) unlink(index int) {
+	id := fn.operand[index]
+	if id == InvalidValue {
+		return
+	}
+
+	next, prev := fn.nextUse[index], fn.prevUse[index]
+	if prev != 0 {
+		fn.nextUse[prev-1] = next
+	} else {
+		fn.firstUse[id] = next
+	}
+	if next != 0 {
+		fn.prevUse[next-1] = prev
+	}
+	fn.nextUse[index] = 0
+	fn.prevUse[index] = 0
+	fn.numUses[id]--
+}
+
+// NumUses returns the number of operands which use the value. Each
+// operand counts, so a value used twice by the same user has two uses.
+// The operands of values which were removed from their block still
+// count until they are cleared with SetOperands.
+func (v Value) NumUses() int {
+	if int(v.id()) >= len(v.fn.numUses) {
+		return 0
+	}
+	return int(v.fn.numUses[v.id()])
+}
+
+// Users returns the values which use the value as an operand, once for
+// each operand, with the most recent uses first.
+func (v Value) Users() []Value {
+	fn := v.fn
+	users := make([]Value, 0, v.NumUses())
+	if int(v.id()) >= len(fn.firstUse) {
+		return users
+	}
+	for use := fn.firstUse[v.id()]; use != 0; use = fn.nextUse[use-1] {
+		users = append(users, fn.valueForID(fn.user[use-1]))
+	}
+	return users
+}
+
+// ReplaceAllUsesWith changes each operand which uses the value to use
+// the replacement instead, leaving the value without uses.
+func (v Value) ReplaceAllUsesWith(replacement Value) {
+	fn := v.fn
+	if replacement.id() == v.id() || int(v.id()) >= len(fn.firstUse) {
+		return
+	}
+	for fn.firstUse[v.id()] != 0 {
+		fn.setOperand(int(fn.firstUse[v.id()]-1), replacement.id())
+	}
+}
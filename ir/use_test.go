@@ -0,0 +1,77 @@
+package ir
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rj45/gosling/types"
+)
+
+func TestUses(t *testing.T) {
+	fn := maxFunc()
+	join := fn.BlockAt(2)
+	a := fn.BlockAt(0).Param(0)
+	phi := join.Param(0)
+	sum := join.ValueAt(0)
+	one := fn.ValueForConst(IntConst(1))
+
+	users := func(v Value) string {
+		var list []string
+		for _, user := range v.Users() {
+			list = append(list, user.String())
+		}
+		return strings.Join(list, " ")
+	}
+
+	// a is used by the If, and by the Phi
+	if a.NumUses() != 2 || users(a) != "v8 v1" {
+		t.Errorf("expected a to be used by v8 and v1, but got %d uses by %s", a.NumUses(), users(a))
+	}
+	if sum.NumUses() != 1 || users(sum) != "v7" {
+		t.Errorf("expected the sum to be used by v7, but got %d uses by %s", sum.NumUses(), users(sum))
+	}
+
+	// a value used twice by the same user has two uses
+	twice := join.InsertValue(1, testAdd, 0, types.Int, sum, sum)
+	if sum.NumUses() != 3 || users(sum) != "v10 v10 v7" {
+		t.Errorf("expected the sum to be used twice by v10, but got %d uses by %s", sum.NumUses(), users(sum))
+	}
+
+	// the operands which are set move between the uses
+	twice.SetOperand(0, phi)
+	if sum.NumUses() != 2 || phi.NumUses() != 2 {
+		t.Errorf("expected the operand to move, but got %d and %d uses", sum.NumUses(), phi.NumUses())
+	}
+
+	// more operands than the value had are moved to the end of the
+	// operand list, dropping the old ones, and 1 is also the index of
+	// the second Arg
+	twice.SetOperands(one, one, one)
+	if sum.NumUses() != 1 || phi.NumUses() != 1 || one.NumUses() != 5 {
+		t.Errorf("expected the old operands to be dropped, but got %d, %d and %d uses", sum.NumUses(), phi.NumUses(), one.NumUses())
+	}
+
+	twice.SetOperands()
+	join.RemoveValue(twice)
+	if twice.NumUses() != 0 || one.NumUses() != 2 {
+		t.Errorf("expected the cleared operands to be dropped, but got %d uses of one", one.NumUses())
+	}
+
+	phi.ReplaceAllUsesWith(a)
+	if phi.NumUses() != 0 || sum.Operand(0) != a || a.NumUses() != 3 {
+		t.Errorf("expected the phi to be replaced with a, but got %s", sum.Dump())
+	}
+
+	for _, err := range Verify(fn) {
+		t.Error(err)
+	}
+
+	clone := fn.Clone()
+	clone.Value(a.ID()).ReplaceAllUsesWith(clone.ValueForConst(IntConst(2)))
+	if a.NumUses() != 3 || clone.Value(a.ID()).NumUses() != 0 {
+		t.Errorf("expected the uses of the clone to be separate")
+	}
+	for _, err := range Verify(clone) {
+		t.Error(err)
+	}
+}
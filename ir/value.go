@@ -144,7 +144,7 @@ func (v Value) setOperandID(index int, operand ValueID) {
 	if index < 0 || index >= n.numOperands() {
 		panic("invalid index")
 	}
-	v.fn.operand[n.firstOperand()+index] = operand
+	v.fn.setOperand(n.firstOperand()+index, operand)
 }
 
 // SetOperands replaces the operands of the value.
@@ -156,11 +156,11 @@ func (v Value) SetOperands(operands ...Value) Value {
 	if len(operands) <= n.numOperands() {
 		// zero out excess operands
 		for i := len(operands); i < n.numOperands(); i++ {
-			fn.operand[n.firstOperand()+i] = InvalidValue
+			fn.setOperand(n.firstOperand()+i, InvalidValue)
 			// todo: add to free list
 		}
 		for i := range operands {
-			fn.operand[n.firstOperand()+i] = operands[i].id()
+			fn.setOperand(n.firstOperand()+i, operands[i].id())
 		}
 		fn.value[id] = newValue(n.opID(), n.typeID(), n.block(), len(operands), n.firstOperand())
 		return fn.valueForID(id)
@@ -170,14 +170,14 @@ func (v Value) SetOperands(operands ...Value) Value {
 
 	firstOperand := len(fn.operand)
 	for _, oper := range operands {
-		fn.operand = append(fn.operand, oper.id())
+		fn.appendOperand(id, oper.id())
 	}
 
 	// zero out the former operands
 	// important: do this after appending to fn.operand or
 	// the passed in operands could be zeroed out
 	for i := 0; i < n.numOperands(); i++ {
-		fn.operand[n.firstOperand()+i] = InvalidValue
+		fn.setOperand(n.firstOperand()+i, InvalidValue)
 		// todo: add to free list
 	}
 
@@ -273,12 +273,20 @@ func (v Value) dump(w io.Writer) {
 		ostr = " " + strings.Join(oper, ", ")
 	}
 
-	if v.fn.typ[v.typeID()] == types.Void {
-		fmt.Fprintf(w, "\t%s%s\n", v.op(), ostr)
+	pos := v.fn.pos(v.Token())
+
+	typ := v.fn.typ[v.typeID()]
+	if typ == types.Void {
+		fmt.Fprintf(w, "\t%s%s%s\n", v.op(), ostr, pos)
 		return
 	}
 
-	fmt.Fprintf(w, "\t%s = %s%s\n", v.String(), v.Op(), ostr)
+	// most values are ints, so only the other types are written
+	name := v.String()
+	if typ != types.Int {
+		name += " " + v.fn.Types().StringOf(typ)
+	}
+	fmt.Fprintf(w, "\t%s = %s%s%s\n", name, v.Op(), ostr, pos)
 }
 
 // value represents the most frequently required data about an AST node
@@ -0,0 +1,257 @@
+package ir
+
+import (
+	"fmt"
+
+	"github.com/rj45/gosling/types"
+)
+
+// Verify checks the function is well formed, and returns the errors it
+// finds:
+//
+//   - each block has a terminator, and the predecessors of each block
+//     match the successors of the blocks before it
+//   - each value is in one block, and the block it says it is in
+//   - each operand is a constant or a value in a block, which has a result
+//   - each value has a type, and each Phi has an operand of its type for
+//     each predecessor
+//   - the uses listed for each value are the operands which use it
+//   - once the function is in SSA form, each operand which is a value is
+//     defined before it is used, in a block which dominates the use, or
+//     for the Phi operands, the end of the predecessor they come from
+func Verify(fn *Func) []error {
+	if fn.Extern {
+		return nil
+	}
+
+	v := &verifier{fn: fn, where: make([]BlockID, len(fn.value))}
+	v.blocks()
+	v.edges()
+	v.values()
+	v.uses()
+	if fn.SSA && len(v.errs) == 0 {
+		v.dominance()
+	}
+	return v.errs
+}
+
+type verifier struct {
+	fn   *Func
+	errs []error
+
+	// the block each value is listed in, and its index in the block,
+	// counting the params first and the terminator last
+	where []BlockID
+	index []int
+}
+
+func (v *verifier) errorf(blk *Block, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if blk != nil {
+		msg = blk.Name + ": " + msg
+	}
+	v.errs = append(v.errs, fmt.Errorf("%s: %s", v.fn.Name, msg))
+}
+
+func (v *verifier) isConst(id ValueID) bool {
+	_, found := v.fn.valueConstant[id]
+	return found
+}
+
+// blocks checks the terminators, and finds the block each value is in.
+func (v *verifier) blocks() {
+	fn := v.fn
+	v.index = make([]int, len(fn.value))
+
+	list := func(blk *Block, id ValueID, index int) {
+		if id == InvalidValue {
+			return
+		}
+		if int(id) >= len(fn.value) {
+			v.errorf(blk, "value v%d does not exist", id)
+			return
+		}
+		if v.where[id] != InvalidBlock {
+			v.errorf(blk, "value v%d is also in %s", id, fn.block[v.where[id]].Name)
+			return
+		}
+		v.where[id] = blk.id
+		v.index[id] = index
+		if fn.value[id].block() != blk.id {
+			v.errorf(blk, "value v%d says it is in block %d", id, fn.value[id].block())
+		}
+	}
+
+	for i := 1; i < len(fn.block); i++ {
+		blk := &fn.block[i]
+		if blk.id != BlockID(i) {
+			v.errorf(blk, "block %d has the id %d", i, blk.id)
+		}
+		index := 0
+		for _, id := range blk.params {
+			list(blk, id, index)
+			index++
+		}
+		for _, id := range blk.value {
+			list(blk, id, index)
+			index++
+		}
+		if blk.terminator == InvalidValue {
+			v.errorf(blk, "missing terminator")
+			continue
+		}
+		list(blk, blk.terminator, index)
+	}
+}
+
+// edges checks each edge is in both the successors of the block it
+// leaves and the predecessors of the block it enters.
+func (v *verifier) edges() {
+	fn := v.fn
+	count := func(ids []BlockID, id BlockID) int {
+		n := 0
+		for _, i := range ids {
+			if i == id {
+				n++
+			}
+		}
+		return n
+	}
+	valid := func(blk *Block, id BlockID) bool {
+		if id == InvalidBlock || int(id) >= len(fn.block) {
+			v.errorf(blk, "edge to block %d which does not exist", id)
+			return false
+		}
+		return true
+	}
+
+	for i := 1; i < len(fn.block); i++ {
+		blk := &fn.block[i]
+		for _, succ := range blk.succs {
+			if valid(blk, succ) && count(blk.succs, succ) != count(fn.block[succ].preds, blk.id) {
+				v.errorf(blk, "successor %s does not have it as a predecessor", fn.block[succ].Name)
+			}
+		}
+		for _, pred := range blk.preds {
+			if valid(blk, pred) && count(blk.preds, pred) != count(fn.block[pred].succs, blk.id) {
+				v.errorf(blk, "predecessor %s does not have it as a successor", fn.block[pred].Name)
+			}
+		}
+	}
+}
+
+// values checks the operands and types of the values in the blocks.
+func (v *verifier) values() {
+	fn := v.fn
+	for id := ValueID(1); int(id) < len(fn.value); id++ {
+		if v.where[id] == InvalidBlock {
+			continue
+		}
+		blk := &fn.block[v.where[id]]
+		val := fn.valueForID(id)
+
+		if int(val.typeID()) >= len(fn.typ) {
+			v.errorf(blk, "value v%d has type %d which does not exist", id, val.typeID())
+			continue
+		}
+
+		first := fn.value[id].firstOperand()
+		if first+val.numOperands() > len(fn.operand) {
+			v.errorf(blk, "operands of v%d do not exist", id)
+			continue
+		}
+		for i := 0; i < val.numOperands(); i++ {
+			oper := fn.operand[first+i]
+			switch {
+			case oper == InvalidValue || int(oper) >= len(fn.value):
+				v.errorf(blk, "operand %d of v%d does not exist", i, id)
+			case v.isConst(oper):
+			case v.where[oper] == InvalidBlock:
+				v.errorf(blk, "operand %d of v%d is v%d, which is not in a block", i, id, oper)
+			case int(fn.value[oper].typeID()) < len(fn.typ) && fn.typ[fn.value[oper].typeID()] == types.Void:
+				v.errorf(blk, "operand %d of v%d is v%d, which has no result", i, id, oper)
+			}
+		}
+
+		if val.Op() == Phi {
+			if val.numOperands() != len(blk.preds) {
+				v.errorf(blk, "phi v%d has %d operands for %d predecessors", id, val.numOperands(), len(blk.preds))
+			}
+			for i := 0; i < val.numOperands(); i++ {
+				oper := fn.operand[first+i]
+				if int(oper) < len(fn.value) && !v.isConst(oper) && fn.value[oper].typeID() != val.typeID() {
+					v.errorf(blk, "phi v%d of type %s has operand v%d of type %s", id,
+						fn.Types().StringOf(val.Type()), oper, fn.Types().StringOf(fn.valueForID(oper).Type()))
+				}
+			}
+		}
+	}
+}
+
+// uses checks the list of uses of each value has each operand which
+// uses it, once, and its count of uses matches.
+func (v *verifier) uses() {
+	fn := v.fn
+	count := make([]int, len(fn.value))
+	for _, id := range fn.operand {
+		if id != InvalidValue && int(id) < len(fn.value) {
+			count[id]++
+		}
+	}
+
+	for id := ValueID(1); int(id) < len(fn.value); id++ {
+		listed := 0
+		val := fn.valueForID(id)
+		if int(id) < len(fn.firstUse) {
+			for use := fn.firstUse[id]; use != 0 && listed <= len(fn.operand); use = fn.nextUse[use-1] {
+				if fn.operand[use-1] != id {
+					v.errorf(nil, "v%d has a use by v%d which uses v%d", id, fn.user[use-1], fn.operand[use-1])
+				}
+				listed++
+			}
+		}
+		if listed != count[id] || val.NumUses() != count[id] {
+			v.errorf(nil, "v%d has %d uses listed and counts %d, but is used %d times", id, listed, val.NumUses(), count[id])
+		}
+	}
+}
+
+// dominance checks each value is defined before it is used, and in a
+// block which dominates the use. Only the blocks reachable from the
+// entry block are checked, since they have no dominators otherwise.
+func (v *verifier) dominance() {
+	fn := v.fn
+
+	// the tree is built afresh, since a pass which changed the blocks
+	// without going through the methods of Block would leave a stale one
+	dom := fn.dominators()
+
+	for id := ValueID(1); int(id) < len(fn.value); id++ {
+		use := v.where[id]
+		if use == InvalidBlock || !dom.Contains(use) {
+			continue
+		}
+		blk := &fn.block[use]
+		val := fn.valueForID(id)
+		for i := 0; i < val.numOperands(); i++ {
+			oper := val.Operand(i).id()
+			if v.isConst(oper) {
+				continue
+			}
+			def := v.where[oper]
+
+			if val.Op() == Phi {
+				// the operand flows in at the end of the predecessor
+				if pred := blk.preds[i]; dom.Contains(pred) && !dom.Dominates(def, pred) {
+					v.errorf(blk, "phi v%d uses v%d, which does not dominate %s", id, oper, fn.block[pred].Name)
+				}
+				continue
+			}
+			if def == use && v.index[oper] >= v.index[id] {
+				v.errorf(blk, "v%d uses v%d before it is defined", id, oper)
+			} else if def != use && !dom.Dominates(def, use) {
+				v.errorf(blk, "v%d uses v%d, which does not dominate it", id, oper)
+			}
+		}
+	}
+}
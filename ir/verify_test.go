@@ -0,0 +1,190 @@
+package ir
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rj45/gosling/token"
+	"github.com/rj45/gosling/types"
+)
+
+// testOp is a small set of ops to build functions with, since the op
+// sets of the compiler are in packages which import this one.
+type testOp uint8
+
+const (
+	testInvalid testOp = iota
+	testAdd
+	testJump
+	testIf
+	testReturn
+)
+
+var testOpNames = [...]string{
+	testInvalid: "Invalid",
+	testAdd:     "Add",
+	testJump:    "Jump",
+	testIf:      "If",
+	testReturn:  "Return",
+}
+
+func (op testOp) String() string       { return testOpNames[op] }
+func (op testOp) Category() OpCategory { return InvalidOp }
+func (op testOp) OpID() OpID           { return NewOpID(ASM, uint8(op)) }
+
+var _ = RegisterOpSet(ASM, testOpNames[:], func(oi OpID) Op {
+	return testOp(oi.Index())
+})
+
+// maxFunc builds the SSA form of:
+//
+//	func max(a, b int) int {
+//		if a < b {
+//			a = b
+//		}
+//		return a + 1
+//	}
+func maxFunc() *Func {
+	prog := NewProgram(token.NewFileSet())
+	fn := prog.NewFunc()
+	fn.Name = "max"
+	fn.Sig = prog.Types().FuncFor([]types.Type{types.Int, types.Int}, types.Int)
+	fn.SSA = true
+
+	entry := fn.NewBlock("entry", testIf, 0)
+	a := entry.AddParam(Arg, 0, types.Int, fn.ValueForConst(IntConst(0)))
+	b := entry.AddParam(Arg, 0, types.Int, fn.ValueForConst(IntConst(1)))
+	entry.Terminator().SetOperands(a, b)
+
+	then := fn.NewBlock("then", testJump, 0)
+	join := fn.NewBlock("join", testReturn, 0)
+	fn.Block(entry.ID()).AddSuccessor(fn.Block(then.ID()))
+	fn.Block(entry.ID()).AddSuccessor(fn.Block(join.ID()))
+	fn.Block(then.ID()).AddSuccessor(fn.Block(join.ID()))
+
+	join = fn.Block(join.ID())
+	phi := join.AddParam(Phi, 0, types.Int, a, b)
+	sum := join.AddValue(testAdd, 0, types.Int, phi, fn.ValueForConst(IntConst(1)))
+	join.Terminator().SetOperands(sum)
+
+	return fn
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(fn *Func)
+		errs    []string
+	}{
+		{
+			name:    "valid",
+			corrupt: func(fn *Func) {},
+		},
+		{
+			name: "missing predecessor",
+			corrupt: func(fn *Func) {
+				join := fn.BlockAt(2)
+				join.preds = join.preds[:1]
+			},
+			errs: []string{
+				"max: then: successor join does not have it as a predecessor",
+				"max: join: phi v8 has 2 operands for 1 predecessors",
+			},
+		},
+		{
+			name: "missing terminator",
+			corrupt: func(fn *Func) {
+				fn.BlockAt(1).terminator = InvalidValue
+			},
+			errs: []string{
+				"max: then: missing terminator",
+			},
+		},
+		{
+			name: "dangling operand",
+			corrupt: func(fn *Func) {
+				fn.BlockAt(2).ValueAt(0).SetOperandID(0, 1000)
+			},
+			errs: []string{
+				"max: join: operand 0 of v9 does not exist",
+			},
+		},
+		{
+			name: "operand not in a block",
+			corrupt: func(fn *Func) {
+				v := fn.AddValue(testAdd, 0, types.Int)
+				fn.BlockAt(2).ValueAt(0).SetOperand(0, v)
+			},
+			errs: []string{
+				"max: join: operand 0 of v9 is v10, which is not in a block",
+			},
+		},
+		{
+			name: "uses",
+			corrupt: func(fn *Func) {
+				fn.operand[fn.value[9].firstOperand()] = 5
+			},
+			errs: []string{
+				"max: v5 has 2 uses listed and counts 2, but is used 3 times",
+				"max: v8 has a use by v9 which uses v5",
+				"max: v8 has 1 uses listed and counts 1, but is used 0 times",
+			},
+		},
+		{
+			name: "phi operands",
+			corrupt: func(fn *Func) {
+				phi := fn.BlockAt(2).Param(0)
+				phi.SetOperands(phi.Operand(0))
+			},
+			errs: []string{
+				"max: join: phi v8 has 1 operands for 2 predecessors",
+			},
+		},
+		{
+			name: "phi types",
+			corrupt: func(fn *Func) {
+				fn.BlockAt(0).Param(1).SetType(types.Bool)
+			},
+			errs: []string{
+				"max: join: phi v8 of type int has operand v5 of type bool",
+			},
+		},
+		{
+			name: "used before defined",
+			corrupt: func(fn *Func) {
+				phi := fn.BlockAt(2).Param(0)
+				phi.SetOperand(0, fn.BlockAt(2).ValueAt(0))
+				fn.BlockAt(0).Terminator().SetOperand(0, fn.BlockAt(2).ValueAt(0))
+			},
+			errs: []string{
+				"max: entry: v1 uses v9, which does not dominate it",
+				"max: join: phi v8 uses v9, which does not dominate entry",
+			},
+		},
+		{
+			name: "used before defined in the block",
+			corrupt: func(fn *Func) {
+				join := fn.BlockAt(2)
+				join.ValueAt(0).SetOperand(1, join.ValueAt(0))
+			},
+			errs: []string{
+				"max: join: v9 uses v9 before it is defined",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fn := maxFunc()
+			test.corrupt(fn)
+
+			var actual []string
+			for _, err := range Verify(fn) {
+				actual = append(actual, err.Error())
+			}
+			if strings.Join(actual, "\n") != strings.Join(test.errs, "\n") {
+				t.Errorf("expected:\n%s\nactual:\n%s\n%s", strings.Join(test.errs, "\n"), strings.Join(actual, "\n"), fn.Dump())
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package llir_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rj45/gosling/codegen"
+	"github.com/rj45/gosling/hlir"
+	"github.com/rj45/gosling/ir"
+	"github.com/rj45/gosling/llir"
+	"github.com/rj45/gosling/parser"
+	"github.com/rj45/gosling/semantics"
+	"github.com/rj45/gosling/token"
+)
+
+// target is a machine with 8 byte words, unsigned immediates which fit
+// in 8 bits, offsets which only reach the first two locals, and C
+// functions which take two args in registers.
+var target = &llir.Target{
+	WordSize:  8,
+	MinImm:    0,
+	MaxImm:    255,
+	MinOffset: -16,
+	MaxOffset: 16,
+	ArgRegs:   2,
+}
+
+var tests = []struct {
+	name string
+	src  string
+	ir   string
+}{
+	{
+		name: "immediates",
+		src: `
+			func main() int {
+				a := 1
+				b := a + 2
+				c := b - 1000
+				return 3 * c
+			}
+		`,
+		ir: `
+			func main() int {
+			main.entry0: // 2:4
+				Prologue 3 // 2:4
+				v38 = LoadImm 1 // 4:12
+				v18 = Add v38, 2 // 4:12
+				v39 = LoadImm 1000 // 5:12
+				v28 = Sub v18, v39 // 5:12
+				v40 = LoadImm 3 // 6:14
+				v35 = Mul v40, v28 // 6:14
+				Jump main.epilogue0 // 6:5
+			main.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return v35 // 2:4
+			}
+		`,
+	},
+	{
+		name: "branches",
+		src: `
+			func max(a int, b int) int {
+				if a > b {
+					return a
+				}
+				return b
+			}
+		`,
+		ir: `
+			func max(r0 int, r1 int) int {
+			max.entry0: // 2:4
+				v22 = Arg 0 // 2:4
+				v23 = Arg 1 // 2:4
+				Prologue 2 // 2:4
+				v25 none = Cmp v22, v23 // 3:10
+				BranchGt v25, then0, endif0 // 3:5
+			then0: // 3:5
+				Jump max.epilogue0 // 4:6
+			endif0: // 3:5
+				Jump max.epilogue0 // 6:5
+			max.epilogue0: <- then0, endif0 // 2:4
+				v24 = Phi v22, v23 // 2:4
+				Epilogue // 2:4
+				Return v24 // 2:4
+			}
+		`,
+	},
+	{
+		name: "conditions",
+		src: `
+			func main() int {
+				x := 1
+				c := x < 2
+				if c {
+					return 1
+				}
+				d := x == 3
+				if c == d {
+					return 2
+				}
+				return 0
+			}
+		`,
+		ir: `
+			func main() int {
+			main.entry0: // 2:4
+				Prologue 3 // 2:4
+				v52 = LoadImm 1 // 4:12
+				v48 none = Cmp v52, 2 // 4:12
+				v18 bool = SetLt v48 // 4:12
+				v49 none = Cmp v18, 0 // 5:5
+				BranchNe v49, then0, endif0 // 5:5
+			then0: // 5:5
+				v54 = LoadImm 1 // 6:6
+				Jump main.epilogue0 // 6:6
+			endif0: // 5:5
+				v53 = LoadImm 1 // 8:12
+				v50 none = Cmp v53, 3 // 8:12
+				v32 bool = SetEq v50 // 8:12
+				v51 none = Cmp v18, v32 // 9:10
+				BranchEq v51, then2, endif2 // 9:5
+			then2: // 9:5
+				v55 = LoadImm 2 // 10:6
+				Jump main.epilogue0 // 10:6
+			endif2: // 9:5
+				v56 = LoadImm 0 // 12:5
+				Jump main.epilogue0 // 12:5
+			main.epilogue0: <- then0, then2, endif2 // 2:4
+				v47 = Phi v54, v55, v56 // 2:4
+				Epilogue // 2:4
+				Return v47 // 2:4
+			}
+		`,
+	},
+	{
+		name: "loop",
+		src: `
+			func main() int {
+				s := 0
+				for i := 0; i < 10; i = i + 1 {
+					s = s + i
+				}
+				return s
+			}
+		`,
+		ir: `
+			func main() int {
+			main.entry0: // 2:4
+				Prologue 2 // 2:4
+				v49 = LoadImm 0 // 2:4
+				v50 = LoadImm 0 // 2:4
+				Jump loop0 // 2:4
+			loop0: <- main.entry0, loopbody0 // 4:5
+				v46 = Phi v49, v39 // 4:5
+				v47 = Phi v50, v30 // 4:5
+				v48 none = Cmp v46, 10 // 4:19
+				BranchLt v48, loopbody0, endloop0 // 4:5
+			loopbody0: // 4:5
+				v30 = Add v47, v46 // 5:12
+				v39 = Add v46, 1 // 4:31
+				Jump loop0 // 4:5
+			endloop0: // 4:5
+				Jump main.epilogue0 // 7:5
+			main.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return v47 // 2:4
+			}
+		`,
+	},
+	{
+		name: "address taken",
+		src: `
+			func main() int {
+				x := 3
+				p := &x
+				*p = 4
+				return x
+			}
+		`,
+		ir: `
+			func main() int {
+			main.entry0: // 2:4
+				Prologue 2 // 2:4
+				v26 = FramePtr // 2:4
+				v28 = LoadImm -8 // 3:5
+				v5 *int = Add v26, v28 // 3:5
+				v29 = LoadImm 3 // 3:7
+				Store v29, v5, 0 // 3:7
+				v30 = LoadImm -8 // 4:11
+				v14 *int = Add v26, v30 // 4:11
+				v31 = LoadImm 4 // 5:8
+				Store v31, v14, 0 // 5:8
+				v23 = Load v26, -8 // 6:12
+				Jump main.epilogue0 // 6:5
+			main.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return v23 // 2:4
+			}
+		`,
+	},
+	{
+		name: "large frame offsets",
+		src: `
+			func main(a int) int {
+				b := 1
+				c := 2
+				p := &c
+				return *p + c + a + b
+			}
+		`,
+		ir: `
+			func main(r0 int) int {
+			main.entry0: // 2:4
+				v41 = Arg 0 // 2:4
+				Prologue 4 // 2:4
+				v42 = FramePtr // 2:4
+				v44 = LoadImm -24 // 4:5
+				v14 *int = Add v42, v44 // 4:5
+				v45 = LoadImm 2 // 4:7
+				Store v45, v14, 0 // 4:7
+				v46 = LoadImm -24 // 5:11
+				v22 *int = Add v42, v46 // 5:11
+				v26 = Load v22, 0 // 6:12
+				v48 = LoadImm -24 // 6:17
+				v47 = Add v42, v48 // 6:17
+				v28 = Load v47, 0 // 6:17
+				v30 = Add v26, v28 // 6:15
+				v34 = Add v30, v41 // 6:19
+				v38 = Add v34, 1 // 6:23
+				Jump main.epilogue0 // 6:5
+			main.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return v38 // 2:4
+			}
+		`,
+	},
+	{
+		name: "stack args",
+		src: `
+			func sum(a int, b int, c int, d int) int
+			func main() int {
+				a := 1
+				return sum(a, a+1, a+2, a+3)
+			}
+		`,
+		ir: `
+			extern func sum(r0 int, r1 int, r2 int, r3 int) int
+
+			func main() int {
+			main.entry0: // 3:4
+				Prologue 1 // 3:4
+				v38 = LoadImm 1 // 5:20
+				v16 = Add v38, 1 // 5:20
+				v39 = LoadImm 1 // 5:25
+				v23 = Add v39, 2 // 5:25
+				v40 = LoadImm 1 // 5:30
+				v30 = Add v40, 3 // 5:30
+				StoreArg v23, 0 // 5:33
+				StoreArg v30, 1 // 5:33
+				v41 = LoadImm 1 // 5:33
+				v33 = CallExtern sum, v41, v16 // 5:33
+				Jump main.epilogue0 // 5:5
+			main.epilogue0: // 3:4
+				Epilogue // 3:4
+				Return v33 // 3:4
+			}
+		`,
+	},
+}
+
+func TestLower(t *testing.T) {
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			file := token.NewFile("test.gos", []byte(test.src))
+
+			parser := parser.New(file)
+			ast, errs := parser.Parse()
+			for _, err := range errs {
+				t.Error(err)
+			}
+
+			tc := semantics.NewTypeChecker(ast)
+
+			symtab, errs := tc.Check(ast.Root())
+			for _, err := range errs {
+				t.Error(err)
+			}
+
+			asm := hlir.NewBuilder(file.FileSet())
+
+			gen := codegen.New(ast, symtab, tc.Universe(), asm)
+			gen.Generate()
+
+			for i := 0; i < asm.Program.NumFuncs(); i++ {
+				hlir.BuildSSA(asm.Program.Func(i))
+				llir.Lower(asm.Program.Func(i), target)
+				for _, err := range ir.Verify(asm.Program.Func(i)) {
+					t.Error(err)
+				}
+			}
+			actual := asm.Program.Dump()
+
+			if trim(actual) != trim(test.ir) {
+				t.Errorf("expected:\n%s\nactual:\n%s", test.ir, actual)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			expected := trim(ir.StripPositions(test.ir))
+
+			prog, err := ir.Parse(expected, ir.LLIR)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := prog.Dump(); trim(actual) != expected {
+				t.Errorf("expected:\n%s\nactual:\n%s", expected, actual)
+			}
+			for i := 0; i < prog.NumFuncs(); i++ {
+				for _, err := range ir.Verify(prog.Func(i)) {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func trim(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,346 @@
+package llir
+
+import (
+	"github.com/rj45/gosling/hlir"
+	"github.com/rj45/gosling/ir"
+	"github.com/rj45/gosling/types"
+)
+
+// Target describes the machine the IR is lowered for.
+//
+// The frame pointer points just above the locals, so local i is
+// at an offset of -(i+1)*WordSize from the frame pointer, unless the
+// locals are above it.
+type Target struct {
+	// WordSize is the size of a word, and of each local, in the
+	// units of addresses.
+	WordSize int
+
+	// LocalsAbove is whether the frame pointer points at the first
+	// local instead, so local i is at an offset of i*WordSize.
+	LocalsAbove bool
+
+	// MinImm and MaxImm are the range of the constants which can
+	// be the second operand of an Add, Sub or Cmp.
+	MinImm, MaxImm int64
+
+	// MinOffset and MaxOffset are the range of the constant offsets
+	// of a Load or Store.
+	MinOffset, MaxOffset int64
+
+	// ArgRegs is the number of args of a CallExtern which are passed
+	// in registers. The rest are stored to the stack with a StoreArg
+	// before the call, so they need no registers at the call. If it is
+	// zero, all the args are passed in registers.
+	ArgRegs int
+}
+
+// ops are the LLIR ops which HLIR ops are renamed to.
+var ops = map[hlir.Op]Op{
+	hlir.Prologue:    Prologue,
+	hlir.Epilogue:    Epilogue,
+	hlir.Add:         Add,
+	hlir.Sub:         Sub,
+	hlir.Mul:         Mul,
+	hlir.Div:         Div,
+	hlir.Neg:         Neg,
+	hlir.Move:        Copy,
+	hlir.Call:        Call,
+	hlir.Go:          Go,
+	hlir.Defer:       Defer,
+	hlir.DeferReturn: DeferReturn,
+	hlir.MakeMap:     MakeMap,
+	hlir.MapIndex:    MapIndex,
+	hlir.MapIndexOk:  MapIndexOk,
+	hlir.MapAssign:   MapAssign,
+	hlir.MapDelete:   MapDelete,
+	hlir.MapLen:      MapLen,
+	hlir.MakeChan:    MakeChan,
+	hlir.ChanSend:    ChanSend,
+	hlir.ChanRecv:    ChanRecv,
+	hlir.ChanRecv2:   ChanRecv2,
+	hlir.ChanClose:   ChanClose,
+	hlir.Select:      Select,
+	hlir.Panic:       Panic,
+	hlir.Recover:     Recover,
+	hlir.Jump:        Jump,
+	hlir.Return:      Return,
+}
+
+// sets are the Set ops for each HLIR comparison.
+var sets = map[hlir.Op]Op{
+	hlir.Eq: SetEq,
+	hlir.Ne: SetNe,
+	hlir.Lt: SetLt,
+	hlir.Le: SetLe,
+	hlir.Gt: SetGt,
+	hlir.Ge: SetGe,
+}
+
+// branches are the Branch ops for each Set op.
+var branches = map[Op]Op{
+	SetEq: BranchEq,
+	SetNe: BranchNe,
+	SetLt: BranchLt,
+	SetLe: BranchLe,
+	SetGt: BranchGt,
+	SetGe: BranchGe,
+}
+
+// Lower rewrites the HLIR values of the function, which must be in
+// SSA form, to LLIR values for the target, in place.
+//
+// Locals and their addresses become explicit arithmetic on the frame
+// pointer, comparisons are split into a Cmp which is used by either a
+// Set to get the result, or a Branch on the result. Constants are made
+// operands of the values which use them, and then the operands are
+// legalized, so constants which the target can't take as an immediate
+// operand are loaded with LoadImm first.
+func Lower(fn *ir.Func, target *Target) {
+	if fn.Extern {
+		return
+	}
+
+	l := &lowering{fn: fn, target: target, replace: make(ir.Replacements)}
+	l.countUses()
+
+	for b := 0; b < fn.NumBlocks(); b++ {
+		blk := fn.BlockAt(b)
+		for i := 0; i < blk.NumValues(); i++ {
+			if v := blk.ValueAt(i); !v.IsNil() {
+				i = l.lowerValue(blk, i, v)
+			}
+		}
+		l.lowerTerminator(blk)
+	}
+	l.replace.Resolve(l.fn)
+
+	for b := 0; b < fn.NumBlocks(); b++ {
+		blk := fn.BlockAt(b)
+		for i := 0; i < blk.NumParams(); i++ {
+			l.legalizePhi(blk.Param(i))
+		}
+		for i := 0; i < blk.NumValues(); i++ {
+			if v := blk.ValueAt(i); !v.IsNil() {
+				i = l.legalize(blk, i, v)
+			}
+		}
+		l.legalize(blk, blk.NumValues(), blk.Terminator())
+	}
+}
+
+type lowering struct {
+	fn     *ir.Func
+	target *Target
+
+	// the number of uses of each value
+	uses map[ir.ValueID]int
+
+	// the frame pointer, once it is needed
+	fp ir.Value
+
+	// values which were removed, and the value replacing them
+	replace ir.Replacements
+}
+
+func (l *lowering) countUses() {
+	l.uses = make(map[ir.ValueID]int)
+	l.fn.EachValue(func(v ir.Value) {
+		for i := 0; i < v.NumOperands(); i++ {
+			l.uses[v.OperandID(i)]++
+		}
+	})
+}
+
+// framePtr returns the frame pointer, which is taken at the start
+// of the entry block, after the prologue.
+func (l *lowering) framePtr() ir.Value {
+	if l.fp.IsNil() {
+		entry := l.fn.BlockAt(0)
+		l.fp = entry.InsertValue(1, FramePtr, entry.Token(), types.Int)
+	}
+	return l.fp
+}
+
+// local returns the offset of the local from the frame pointer.
+func (l *lowering) local(index ir.Value) ir.Value {
+	i, _ := ir.Int64Value(index.Constant())
+	if l.target.LocalsAbove {
+		return l.fn.ValueForConst(ir.IntConst(i * int64(l.target.WordSize)))
+	}
+	return l.fn.ValueForConst(ir.IntConst(-(i + 1) * int64(l.target.WordSize)))
+}
+
+func (l *lowering) zero() ir.Value {
+	return l.fn.ValueForConst(ir.IntConst(0))
+}
+
+// lowerValue lowers the value at index i of the block, and returns the
+// index of the value, which moves if values are inserted before it.
+func (l *lowering) lowerValue(blk *ir.Block, i int, v ir.Value) int {
+	op, _ := v.Op().(hlir.Op)
+
+	if op == hlir.LoadLocal || op == hlir.StoreLocal || op == hlir.LocalAddr {
+		// the frame pointer may be inserted before the value
+		if l.fp.IsNil() && blk.ID() == l.fn.BlockAt(0).ID() {
+			l.framePtr()
+			i++
+		}
+	}
+
+	switch op {
+	case hlir.LoadInt:
+		l.replace[v.ID()] = v.Operand(0)
+		blk.RemoveValue(v)
+	case hlir.LoadLocal:
+		v.SetOp(Load).SetOperands(l.framePtr(), l.local(v.Operand(0)))
+	case hlir.StoreLocal:
+		v.SetOp(Store).SetOperands(v.Operand(0), l.framePtr(), l.local(v.Operand(1)))
+	case hlir.LocalAddr:
+		v.SetOp(Add).SetOperands(l.framePtr(), l.local(v.Operand(0)))
+	case hlir.Load:
+		v.SetOp(Load).SetOperands(v.Operand(0), l.zero())
+	case hlir.Store:
+		v.SetOp(Store).SetOperands(v.Operand(0), v.Operand(1), l.zero())
+	case hlir.Eq, hlir.Ne, hlir.Lt, hlir.Le, hlir.Gt, hlir.Ge:
+		flags := blk.InsertValue(i, Cmp, v.Token(), types.None, v.Operand(0), v.Operand(1))
+		v.SetOp(sets[op]).SetOperands(flags)
+		i++
+	case hlir.CallExtern:
+		v.SetOp(CallExtern)
+		i = l.stackArgs(blk, i, v)
+	default:
+		lop, found := ops[op]
+		if !found {
+			panic("unknown op: " + v.Op().String())
+		}
+		v.SetOp(lop)
+	}
+	return i
+}
+
+// stackArgs stores the args of the CallExtern at index i of the block
+// which are passed on the stack, right before the call, and returns the
+// index of the call.
+func (l *lowering) stackArgs(blk *ir.Block, i int, call ir.Value) int {
+	n := l.target.ArgRegs
+	if n == 0 || call.NumOperands()-1 <= n {
+		return i
+	}
+
+	args := call.Operands()
+	for j, arg := range args[1+n:] {
+		blk.InsertValue(i, StoreArg, call.Token(), types.Void, arg, l.fn.ValueForConst(ir.IntConst(int64(j))))
+		i++
+	}
+	call.SetOperands(args[:1+n]...)
+	return i
+}
+
+// lowerTerminator lowers the terminator of the block. An If branches
+// on the Cmp of the condition, if it is a comparison which is not used
+// anywhere else and is the last value of the block, or otherwise on the
+// Cmp of the condition with zero. The values between a comparison and
+// the terminator, like calls, may change the flags.
+func (l *lowering) lowerTerminator(blk *ir.Block) {
+	term := blk.Terminator()
+	if term.Op() != hlir.If {
+		op, found := ops[term.Op().(hlir.Op)]
+		if !found {
+			panic("unknown terminator: " + term.Op().String())
+		}
+		term.SetOp(op)
+		return
+	}
+
+	cond := term.Operand(0)
+	op, _ := cond.Op().(Op)
+	if br, found := branches[op]; found && l.uses[cond.ID()] == 1 && lastValue(blk).ID() == cond.ID() {
+		blk.RemoveValue(cond)
+		term.SetOp(br).SetOperands(cond.Operand(0))
+		return
+	}
+
+	flags := blk.AddValue(Cmp, term.Token(), types.None, cond, l.zero())
+	term.SetOp(BranchNe).SetOperands(flags)
+}
+
+// lastValue returns the last value of the block before the terminator,
+// or a nil value if it has none.
+func lastValue(blk *ir.Block) ir.Value {
+	for i := blk.NumValues() - 1; i >= 0; i-- {
+		if v := blk.ValueAt(i); !v.IsNil() {
+			return v
+		}
+	}
+	return ir.Value{}
+}
+
+// legalize loads the constant operands of the value at index i of the
+// block which can't be immediates, and returns the index of the value.
+// The terminator is at the index past the last value.
+func (l *lowering) legalize(blk *ir.Block, i int, v ir.Value) int {
+	switch v.Op() {
+	case Add:
+		if v.Operand(0).IsConstant() && !v.Operand(1).IsConstant() {
+			// add is commutative, so the constant can be the immediate
+			v.SetOperands(v.Operand(1), v.Operand(0))
+		}
+	case Load, Store:
+		// the offset is always an immediate, but if it is out of range,
+		// it is added to the address first
+		off := v.NumOperands() - 1
+		if c, _ := ir.Int64Value(v.Operand(off).Constant()); c < l.target.MinOffset || c > l.target.MaxOffset {
+			addr := blk.InsertValue(i, Add, v.Token(), types.Int, v.Operand(off-1), v.Operand(off))
+			i = l.legalize(blk, i, addr) + 1
+			v.SetOperand(off-1, addr)
+			v.SetOperand(off, l.zero())
+		}
+	}
+
+	for j := 0; j < v.NumOperands(); j++ {
+		op := v.Operand(j)
+		if !op.IsConstant() || l.immediate(v, j) {
+			continue
+		}
+		v.SetOperand(j, blk.InsertValue(i, LoadImm, v.Token(), op.Type(), op))
+		i++
+	}
+	return i
+}
+
+// legalizePhi loads the constant operands of the phi at the end of
+// the predecessor they come from.
+func (l *lowering) legalizePhi(phi ir.Value) {
+	if phi.Op() != ir.Phi {
+		return
+	}
+	blk := phi.Block()
+	for j := 0; j < phi.NumOperands(); j++ {
+		if op := phi.Operand(j); op.IsConstant() {
+			pred := blk.Predecessor(j)
+			phi.SetOperand(j, pred.InsertValue(pred.NumValues(), LoadImm, pred.Terminator().Token(), op.Type(), op))
+		}
+	}
+}
+
+// immediate returns whether the operand at index j of the value can
+// be a constant.
+func (l *lowering) immediate(v ir.Value, j int) bool {
+	switch v.Op() {
+	case Prologue, LoadImm, ir.Arg:
+		return true
+	case StoreArg:
+		// the index of the arg
+		return j == 1
+	case Call, CallExtern, Go, Defer:
+		// the function called
+		return j == 0
+	case Load, Store:
+		return j == v.NumOperands()-1
+	case Add, Sub, Cmp:
+		c, ok := ir.Int64Value(v.Operand(j).Constant())
+		return j == 1 && ok && c >= l.target.MinImm && c <= l.target.MaxImm
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package llir
+
+import "github.com/rj45/gosling/ir"
+
+type Op uint16
+
+const (
+	Invalid Op = iota + Op(ir.LLIR)<<8
+
+	Prologue
+	Epilogue
+
+	// Constant operators
+	LoadImm
+
+	// Move operators
+	Copy
+
+	// Memory operators
+	FramePtr
+	Load
+	Store
+
+	// Spill operators
+	Spill
+	Reload
+
+	// Binary operators
+	Add
+	Sub
+	Mul
+	Div
+
+	// Unary operators
+	Neg
+
+	// Comparison operators
+	Cmp
+	SetEq
+	SetNe
+	SetLt
+	SetLe
+	SetGt
+	SetGe
+
+	// Call operators
+	StoreArg
+	Call
+	CallExtern
+	Go
+	Defer
+	DeferReturn
+
+	// Runtime operators
+	MakeMap
+	MapIndex
+	MapIndexOk
+	MapAssign
+	MapDelete
+	MapLen
+	MakeChan
+	ChanSend
+	ChanRecv
+	ChanRecv2
+	ChanClose
+	Select
+	Panic
+	Recover
+
+	// Control flow operators
+	Jump
+	BranchEq
+	BranchNe
+	BranchLt
+	BranchLe
+	BranchGt
+	BranchGe
+	Return
+)
+
+var opNames = [...]string{
+	Invalid:     "Invalid",
+	Prologue:    "Prologue",
+	Epilogue:    "Epilogue",
+	LoadImm:     "LoadImm",
+	Copy:        "Copy",
+	FramePtr:    "FramePtr",
+	Load:        "Load",
+	Store:       "Store",
+	Spill:       "Spill",
+	Reload:      "Reload",
+	Add:         "Add",
+	Sub:         "Sub",
+	Mul:         "Mul",
+	Div:         "Div",
+	Neg:         "Neg",
+	Cmp:         "Cmp",
+	SetEq:       "SetEq",
+	SetNe:       "SetNe",
+	SetLt:       "SetLt",
+	SetLe:       "SetLe",
+	SetGt:       "SetGt",
+	SetGe:       "SetGe",
+	StoreArg:    "StoreArg",
+	Call:        "Call",
+	CallExtern:  "CallExtern",
+	Go:          "Go",
+	Defer:       "Defer",
+	DeferReturn: "DeferReturn",
+	MakeMap:     "MakeMap",
+	MapIndex:    "MapIndex",
+	MapIndexOk:  "MapIndexOk",
+	MapAssign:   "MapAssign",
+	MapDelete:   "MapDelete",
+	MapLen:      "MapLen",
+	MakeChan:    "MakeChan",
+	ChanSend:    "ChanSend",
+	ChanRecv:    "ChanRecv",
+	ChanRecv2:   "ChanRecv2",
+	ChanClose:   "ChanClose",
+	Select:      "Select",
+	Panic:       "Panic",
+	Recover:     "Recover",
+	Jump:        "Jump",
+	BranchEq:    "BranchEq",
+	BranchNe:    "BranchNe",
+	BranchLt:    "BranchLt",
+	BranchLe:    "BranchLe",
+	BranchGt:    "BranchGt",
+	BranchGe:    "BranchGe",
+	Return:      "Return",
+}
+
+func (op Op) String() string {
+	if op >= Op(len(opNames)) {
+		return "Invalid"
+	}
+	name := opNames[op]
+	if name == "" {
+		panic("missing name for op")
+	}
+	return name
+}
+
+func (op Op) Category() ir.OpCategory {
+	return 0
+}
+
+func (op Op) OpID() ir.OpID {
+	return ir.OpID(op)
+}
+
+var _ = ir.RegisterOpSet(ir.LLIR, opNames[Invalid:], func(oi ir.OpID) ir.Op {
+	return Op(oi)
+})
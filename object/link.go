@@ -0,0 +1,90 @@
+package object
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rj45/gosling/vm"
+)
+
+// Link links the objects of a program into a single object, checking
+// that each function the objects call is defined exactly once. The
+// program starts at the beginning of the code of the main package,
+// so it is placed first.
+func Link(objs ...*Object) (*Object, error) {
+	var main *Object
+	for _, obj := range objs {
+		if obj.Path == "" {
+			if main != nil {
+				return nil, fmt.Errorf("more than one main package")
+			}
+			main = obj
+		}
+	}
+	if main == nil {
+		return nil, fmt.Errorf("no main package")
+	}
+
+	sorted := []*Object{main}
+	for _, obj := range objs {
+		if obj != main {
+			sorted = append(sorted, obj)
+		}
+	}
+
+	linked := &Object{Target: main.Target, Name: main.Name}
+	defined := make(map[string]bool)
+	for _, obj := range sorted {
+		if obj.Target != main.Target {
+			return nil, fmt.Errorf("package %s compiled for %s, not %s", obj.Name, obj.Target, main.Target)
+		}
+		for _, sym := range obj.Defines {
+			if defined[sym] {
+				return nil, fmt.Errorf("duplicate symbol %s", sym)
+			}
+			defined[sym] = true
+		}
+		linked.Defines = append(linked.Defines, obj.Defines...)
+	}
+
+	var undefined []string
+	for _, obj := range sorted {
+		for _, sym := range obj.Refs {
+			if !defined[sym] {
+				undefined = append(undefined, sym)
+			}
+		}
+	}
+	if undefined != nil {
+		sort.Strings(undefined)
+		return nil, fmt.Errorf("undefined: %s", strings.Join(undefined, ", "))
+	}
+
+	switch main.Target {
+	case AArch64:
+		// the assembler resolves the symbols, so the assembly of
+		// each package only needs to be put together
+		var asm strings.Builder
+		for _, obj := range sorted {
+			asm.WriteString(obj.Asm)
+		}
+		linked.Asm = asm.String()
+
+	case VM:
+		progs := make([]*vm.Program, len(sorted))
+		for i, obj := range sorted {
+			progs[i] = obj.VM
+		}
+		prog, err := vm.Link(progs...)
+		if err != nil {
+			return nil, err
+		}
+		linked.VM = prog
+
+	default:
+		return nil, fmt.Errorf("unknown target %s", main.Target)
+	}
+
+	return linked, nil
+}
@@ -0,0 +1,69 @@
+// Package object holds packages which were compiled separately, and
+// links them into a program.
+package object
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/rj45/gosling/semantics"
+	"github.com/rj45/gosling/vm"
+)
+
+// The targets an object can be compiled for.
+const (
+	VM      = "vm"
+	AArch64 = "aarch64"
+)
+
+// Object is a compiled package.
+type Object struct {
+	// Target is the target the package was compiled for.
+	Target string
+
+	// Path is the import path of the package, which is empty
+	// for the main package, and Name is its name.
+	Path string `json:",omitempty"`
+	Name string
+
+	// Imports are the import paths of the packages it imports.
+	Imports []string `json:",omitempty"`
+
+	// Hash identifies the sources of the package and the exports of
+	// the packages it imports, so the package is only compiled again
+	// if one of them changes.
+	Hash string
+
+	// Exports are the exported declarations of the package, and
+	// ExportHash identifies them, so the packages importing it are
+	// only compiled again if they change.
+	Exports    *semantics.Exports
+	ExportHash string
+
+	// Defines are the functions the package defines, and Refs are the
+	// functions of other packages it was compiled against.
+	Defines []string `json:",omitempty"`
+	Refs    []string `json:",omitempty"`
+
+	// Asm is the assembly of the package, for the aarch64 target.
+	Asm string `json:",omitempty"`
+
+	// VM is the program of the package, for the vm target.
+	VM *vm.Program `json:",omitempty"`
+}
+
+// Read reads an object.
+func Read(r io.Reader) (*Object, error) {
+	obj := &Object{}
+	if err := json.NewDecoder(r).Decode(obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// Write writes the object.
+func (o *Object) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(o)
+}
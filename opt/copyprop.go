@@ -0,0 +1,67 @@
+package opt
+
+import (
+	"github.com/rj45/gosling/hlir"
+	"github.com/rj45/gosling/ir"
+)
+
+// CopyProp is copy propagation: the uses of a Move are replaced with
+// the value it copies, and the uses of a Phi which only has one value
+// other than itself are replaced with that value. Then the Moves and
+// Phis are removed. Replacing a Phi can make the Phis which use it
+// trivial, so they are visited again.
+func CopyProp(fn *ir.Func) {
+	if fn.Extern {
+		return
+	}
+
+	var work []ir.Value
+	fn.EachValue(func(v ir.Value) {
+		if v.Op() == hlir.Move || v.Op() == ir.Phi {
+			work = append(work, v)
+		}
+	})
+
+	removed := make(map[ir.ValueID]bool)
+	for len(work) > 0 {
+		v := work[len(work)-1]
+		work = work[:len(work)-1]
+		if removed[v.ID()] {
+			continue
+		}
+
+		same, ok := copied(v)
+		if !ok {
+			continue
+		}
+		for _, user := range v.Users() {
+			if user.Op() == ir.Phi && user.ID() != v.ID() {
+				work = append(work, user)
+			}
+		}
+		v.ReplaceAllUsesWith(same)
+		remove(v)
+		removed[v.ID()] = true
+	}
+}
+
+// copied returns the value the value is a copy of, if it is a Move or
+// a Phi which only has one value other than itself.
+func copied(v ir.Value) (ir.Value, bool) {
+	if v.Op() == hlir.Move {
+		return v.Operand(0), true
+	}
+
+	var same ir.Value
+	for i := 0; i < v.NumOperands(); i++ {
+		op := v.Operand(i)
+		if op.ID() == v.ID() || (!same.IsNil() && op.ID() == same.ID()) {
+			continue
+		}
+		if !same.IsNil() {
+			return ir.Value{}, false
+		}
+		same = op
+	}
+	return same, !same.IsNil()
+}
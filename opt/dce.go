@@ -0,0 +1,53 @@
+package opt
+
+import (
+	"github.com/rj45/gosling/hlir"
+	"github.com/rj45/gosling/ir"
+)
+
+// DCE is dead code elimination: the values which only compute their
+// result, or load a local, are removed unless their result is needed.
+// The values which are needed are found by marking the rest of the
+// values, and then the operands of the marked values, so a cycle of
+// values which only use each other, like a Phi and the value which
+// increments it in a loop whose result is unused, is removed too.
+func DCE(fn *ir.Func) {
+	if fn.Extern {
+		return
+	}
+
+	live := make([]bool, fn.NumValues()+1)
+	var work []ir.Value
+	var candidates []ir.Value
+	fn.EachValue(func(v ir.Value) {
+		if removable(v) {
+			candidates = append(candidates, v)
+			return
+		}
+		live[v.ID()] = true
+		work = append(work, v)
+	})
+
+	for len(work) > 0 {
+		v := work[len(work)-1]
+		work = work[:len(work)-1]
+		for i := 0; i < v.NumOperands(); i++ {
+			if op := v.Operand(i); !live[op.ID()] {
+				live[op.ID()] = true
+				work = append(work, op)
+			}
+		}
+	}
+
+	for _, v := range candidates {
+		if !live[v.ID()] {
+			remove(v)
+		}
+	}
+}
+
+// removable returns whether the value can be removed if its result is
+// not needed.
+func removable(v ir.Value) bool {
+	return pure(v) || v.Op() == hlir.LoadLocal
+}
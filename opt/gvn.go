@@ -0,0 +1,81 @@
+package opt
+
+import (
+	"github.com/rj45/gosling/hlir"
+	"github.com/rj45/gosling/ir"
+	"github.com/rj45/gosling/types"
+)
+
+// GVN is global value numbering, which eliminates common
+// subexpressions: a pure value which computes the same op on the same
+// operands as a value in a block which dominates it is replaced with
+// that value. The blocks are visited down the dominator tree, so the
+// values which are available in a block are those of the blocks above
+// it. The operands of commutative ops are put in order of their IDs
+// first, so a+b and b+a are the same.
+func GVN(fn *ir.Func) {
+	if fn.Extern {
+		return
+	}
+
+	g := &gvn{fn: fn, dom: fn.Dominators(), avail: make(map[key]ir.Value)}
+	g.visit(fn.BlockAt(0).ID())
+}
+
+// key is what identifies the result of a pure value.
+type key struct {
+	op   ir.OpID
+	typ  types.Type
+	args [2]ir.ValueID
+}
+
+type gvn struct {
+	fn    *ir.Func
+	dom   *ir.DomTree
+	avail map[key]ir.Value
+}
+
+func (g *gvn) visit(id ir.BlockID) {
+	blk := g.fn.Block(id)
+
+	var added []key
+	for i := 0; i < blk.NumValues(); i++ {
+		v := blk.ValueAt(i)
+		if v.IsNil() || v.Op() == ir.Phi || !pure(v) || v.NumOperands() > 2 {
+			continue
+		}
+
+		k := key{op: v.OpID(), typ: v.Type()}
+		for j := 0; j < v.NumOperands(); j++ {
+			k.args[j] = v.OperandID(j)
+		}
+		if commutative(v.Op()) && k.args[0] > k.args[1] {
+			k.args[0], k.args[1] = k.args[1], k.args[0]
+		}
+
+		if same, found := g.avail[k]; found {
+			v.ReplaceAllUsesWith(same)
+			remove(v)
+			continue
+		}
+		g.avail[k] = v
+		added = append(added, k)
+	}
+
+	for _, child := range g.dom.Children(id) {
+		g.visit(child)
+	}
+
+	// the values are not available outside of the blocks it dominates
+	for _, k := range added {
+		delete(g.avail, k)
+	}
+}
+
+func commutative(op ir.Op) bool {
+	switch op {
+	case hlir.Add, hlir.Mul, hlir.Eq, hlir.Ne:
+		return true
+	}
+	return false
+}
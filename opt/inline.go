@@ -0,0 +1,311 @@
+package opt
+
+import (
+	"fmt"
+
+	"github.com/rj45/gosling/hlir"
+	"github.com/rj45/gosling/ir"
+	"github.com/rj45/gosling/types"
+)
+
+// maxInlineSize is the most values a function can have, other than its
+// Prologue and Epilogue, for calls to it to be inlined.
+const maxInlineSize = 30
+
+// maxInlineGrowth is the most values inlining can add to a function, so
+// functions which call each other many times don't grow without bound.
+const maxInlineGrowth = 10 * maxInlineSize
+
+// Inline replaces the calls to small functions with copies of their
+// blocks. The block of the call is split after it, and continues to a
+// copy of the entry block of the callee, whose arguments are replaced
+// with the operands of the call. The copies of the blocks which return
+// jump to the rest of the block of the call, and the result of the call
+// is replaced with the result they return, through a Phi if there are
+// several. The locals of the callee get slots of their own in the frame.
+//
+// The functions are inlined into in the order of the call graph, the
+// callees before their callers, so each callee is copied as it is once
+// the calls in it are inlined, and the calls in the copies are left as
+// they are. A function is never inlined into itself, so recursion stops.
+// Only functions in SSA form are inlined into or copied. Functions marked
+// NoInline are never inlined, nor are functions which defer calls, start
+// goroutines or recover from panics, which need a frame of their own.
+func Inline(prog *ir.Program) {
+	for _, fn := range callOrder(prog) {
+		if fn.Extern || !fn.SSA {
+			continue
+		}
+
+		in := &inliner{fn: fn}
+		var calls []ir.ValueID
+		fn.EachValue(func(v ir.Value) {
+			if v.Op() == hlir.Call {
+				calls = append(calls, v.ID())
+			}
+		})
+
+		grown := 0
+		for _, id := range calls {
+			call := fn.Value(id)
+			callee := in.callee(call)
+			if callee == nil || grown+size(callee) > maxInlineGrowth {
+				continue
+			}
+			grown += size(callee)
+			in.inline(call, callee)
+		}
+	}
+}
+
+// callOrder returns the functions of the program in the order of the
+// call graph, with each function after the functions it calls, other
+// than the ones it calls through recursion.
+func callOrder(prog *ir.Program) []*ir.Func {
+	var order []*ir.Func
+	visited := make(map[*ir.Func]bool)
+	var visit func(fn *ir.Func)
+	visit = func(fn *ir.Func) {
+		if visited[fn] {
+			return
+		}
+		visited[fn] = true
+		fn.EachValue(func(v ir.Value) {
+			if v.Op() == hlir.Call && v.Operand(0).IsConstant() {
+				if callee, ok := ir.FuncValue(v.Operand(0).Constant()); ok {
+					visit(callee)
+				}
+			}
+		})
+		order = append(order, fn)
+	}
+	for i := 0; i < prog.NumFuncs(); i++ {
+		visit(prog.Func(i))
+	}
+	return order
+}
+
+type inliner struct {
+	fn *ir.Func
+
+	// the number of calls inlined, which names the copied blocks
+	inlined int
+}
+
+// callee returns the function the call calls, or nil if the call
+// shouldn't be inlined.
+func (in *inliner) callee(call ir.Value) *ir.Func {
+	if !call.Operand(0).IsConstant() {
+		return nil
+	}
+	callee, ok := ir.FuncValue(call.Operand(0).Constant())
+	switch {
+	case !ok || callee == in.fn || callee.Extern || callee.NoInline || !callee.SSA:
+		return nil
+	case size(callee) > maxInlineSize || callee.BlockAt(0).NumPredecessors() > 0:
+		return nil
+	case !callee.HasOp(hlir.Return) || callee.HasOp(hlir.Defer, hlir.DeferReturn, hlir.Go, hlir.Recover):
+		return nil
+	}
+	return callee
+}
+
+// inline replaces the call with a copy of the blocks of the callee.
+func (in *inliner) inline(call ir.Value, callee *ir.Func) {
+	fn := in.fn
+	prefix := fmt.Sprintf("%s.inline%d.", fn.Name, in.inlined)
+	in.inlined++
+
+	id := call.Block().ID()
+	cont := call.Block().SplitBlock(call.Block().IndexOf(call)+1, prefix+"cont", hlir.Jump).ID()
+	fn.Block(id).RemoveSuccessor(0)
+
+	// the blocks and values are made first, so the operands can refer to
+	// values in any block
+	blocks := make(map[ir.BlockID]ir.BlockID)
+	values := make(map[ir.ValueID]ir.Value)
+	for i := 0; i < callee.NumBlocks(); i++ {
+		b := callee.BlockAt(i)
+		term := b.Terminator()
+		nb := fn.NewBlock(prefix+b.Name, term.Op(), term.Token())
+		blocks[b.ID()] = nb.ID()
+		for _, stmt := range b.Stmts() {
+			nb.AddStmt(stmt)
+		}
+		from, entry := b.InlinedFrom()
+		if from == "" {
+			from, entry = callee.Name, i == 0
+		}
+		nb.SetInlinedFrom(from, entry)
+		values[term.ID()] = nb.Terminator()
+
+		for j := 0; j < b.NumParams(); j++ {
+			p := b.Param(j)
+			if p.Op() == ir.Arg {
+				index, _ := intConst(p.Operand(0))
+				values[p.ID()] = call.Operand(int(index) + 1)
+				continue
+			}
+			values[p.ID()] = nb.AddParam(p.Op(), p.Token(), p.Type())
+		}
+		for j := 0; j < b.NumValues(); j++ {
+			v := b.ValueAt(j)
+			if v.IsNil() || v.Op() == hlir.Prologue || v.Op() == hlir.Epilogue {
+				continue
+			}
+			values[v.ID()] = nb.AddValue(v.Op(), v.Token(), v.Type())
+		}
+	}
+
+	// the edges are added in the order of the successors, which keeps If
+	// branching the same way, but the predecessors may be in a different
+	// order, so the operands of Phis are put in their order below
+	fn.Block(id).AddSuccessor(fn.Block(blocks[callee.BlockAt(0).ID()]))
+	var results []ir.Value
+	for i := 0; i < callee.NumBlocks(); i++ {
+		b := callee.BlockAt(i)
+		nb := fn.Block(blocks[b.ID()])
+		for j := 0; j < b.NumSuccessors(); j++ {
+			nb.AddSuccessor(fn.Block(blocks[b.Successor(j).ID()]))
+		}
+		if term := b.Terminator(); term.Op() == hlir.Return {
+			nb.AddSuccessor(fn.Block(cont))
+			if term.NumOperands() > 0 {
+				results = append(results, term.Operand(0))
+			}
+		}
+	}
+
+	slots := make(map[int64]int64)
+	operands := func(v ir.Value) []ir.Value {
+		ops := make([]ir.Value, v.NumOperands())
+		for i := range ops {
+			op := v.Operand(i)
+			switch {
+			case i == localOperand(v.Op()):
+				ops[i] = in.local(callee, op, slots)
+			case op.IsConstant():
+				ops[i] = fn.ValueForConst(op.Constant())
+			default:
+				ops[i] = values[op.ID()]
+			}
+		}
+		return ops
+	}
+	for i := 0; i < callee.NumBlocks(); i++ {
+		b := callee.BlockAt(i)
+		nb := fn.Block(blocks[b.ID()])
+
+		order := predOrder(b, nb, blocks)
+		for j := 0; j < b.NumParams(); j++ {
+			p := b.Param(j)
+			if p.Op() == ir.Arg {
+				continue
+			}
+			ops := operands(p)
+			phi := make([]ir.Value, len(ops))
+			for k, pred := range order {
+				phi[k] = ops[pred]
+			}
+			values[p.ID()].SetOperands(phi...)
+		}
+		for j := 0; j < b.NumValues(); j++ {
+			if v := b.ValueAt(j); !v.IsNil() && v.Op() != hlir.Prologue && v.Op() != hlir.Epilogue {
+				values[v.ID()].SetOperands(operands(v)...)
+			}
+		}
+		if term := b.Terminator(); term.Op() == hlir.Return {
+			nb.UpdateTerminator(hlir.Jump)
+		} else {
+			nb.UpdateTerminator(term.Op(), operands(term)...)
+		}
+	}
+
+	if call.Type() != types.Void {
+		for i, result := range results {
+			if result.IsConstant() {
+				results[i] = fn.ValueForConst(result.Constant())
+			} else {
+				results[i] = values[result.ID()]
+			}
+		}
+		result := results[0]
+		if len(results) > 1 {
+			result = fn.Block(cont).AddParam(ir.Phi, call.Token(), call.Type(), results...)
+		}
+		call.ReplaceAllUsesWith(result)
+	}
+	remove(call)
+}
+
+// local returns the slot in the frame of the function for the local of
+// the callee, adding one if it has none yet, and making the Prologue
+// make room for it.
+func (in *inliner) local(callee *ir.Func, index ir.Value, slots map[int64]int64) ir.Value {
+	fn := in.fn
+	i, _ := intConst(index)
+	slot, found := slots[i]
+	if !found {
+		slot = int64(fn.NumLocals())
+		slots[i] = slot
+
+		locals := make([]types.Type, 0, fn.NumLocals()+1)
+		for j := 0; j < fn.NumLocals(); j++ {
+			locals = append(locals, fn.LocalType(j))
+		}
+		fn.SetLocals(append(locals, callee.LocalType(int(i))))
+
+		entry := fn.BlockAt(0)
+		for j := 0; j < entry.NumValues(); j++ {
+			if v := entry.ValueAt(j); !v.IsNil() && v.Op() == hlir.Prologue {
+				v.SetOperand(0, fn.ValueForConst(ir.IntConst(int64(fn.NumLocals()))))
+			}
+		}
+	}
+	return fn.ValueForConst(ir.IntConst(slot))
+}
+
+// localOperand returns the index of the operand of the op which is the
+// index of a local, or -1 if it has none.
+func localOperand(op ir.Op) int {
+	switch op {
+	case hlir.LoadLocal, hlir.LocalAddr:
+		return 0
+	case hlir.StoreLocal:
+		return 1
+	}
+	return -1
+}
+
+// predOrder returns the index of the predecessor of the block which
+// each predecessor of its copy is a copy of. Several edges from the same
+// predecessor are matched in order.
+func predOrder(b, nb *ir.Block, blocks map[ir.BlockID]ir.BlockID) []int {
+	order := make([]int, nb.NumPredecessors())
+	used := make([]bool, b.NumPredecessors())
+	for i := range order {
+		for j := 0; j < b.NumPredecessors(); j++ {
+			if !used[j] && blocks[b.Predecessor(j).ID()] == nb.Predecessor(i).ID() {
+				used[j] = true
+				order[i] = j
+				break
+			}
+		}
+	}
+	return order
+}
+
+// size returns the number of values of the function, other than its
+// Prologue and Epilogue.
+func size(fn *ir.Func) int {
+	n := 0
+	for i := 0; i < fn.NumBlocks(); i++ {
+		blk := fn.BlockAt(i)
+		for j := 0; j < blk.NumValues(); j++ {
+			if v := blk.ValueAt(j); !v.IsNil() && v.Op() != hlir.Prologue && v.Op() != hlir.Epilogue {
+				n++
+			}
+		}
+	}
+	return n
+}
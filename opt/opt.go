@@ -0,0 +1,118 @@
+// Package opt has the passes which optimize functions in the SSA form
+// of HLIR, between building SSA form and lowering it.
+package opt
+
+import (
+	"github.com/rj45/gosling/hlir"
+	"github.com/rj45/gosling/ir"
+)
+
+// AddPasses adds the optimization passes for the level to the pass
+// manager, after the pass which builds SSA form. Level 0 adds none.
+func AddPasses(pm *ir.PassManager, level int) {
+	if level <= 0 {
+		return
+	}
+	pm.AddProgramPass("inline", func(prog *ir.Program) error {
+		Inline(prog)
+		return nil
+	})
+	pm.AddFuncPass("sccp", ssaPass(SCCP))
+	pm.AddFuncPass("copyprop", ssaPass(CopyProp))
+	pm.AddFuncPass("gvn", ssaPass(GVN))
+	pm.AddFuncPass("dce", ssaPass(DCE))
+}
+
+// ssaPass returns a pass which runs the optimization on the functions
+// in SSA form, leaving the functions the backend assembles from their
+// stack operations as they are.
+func ssaPass(opt func(*ir.Func)) func(*ir.Func) error {
+	return func(fn *ir.Func) error {
+		if fn.SSA {
+			opt(fn)
+		}
+		return nil
+	}
+}
+
+// pure returns whether the value only computes its result from its
+// operands, so it can be removed if the result is unused, or replaced
+// with another value which computes the same result. A Div is only
+// pure if it can't divide by zero.
+func pure(v ir.Value) bool {
+	switch v.Op() {
+	case ir.Phi,
+		hlir.LoadInt, hlir.LocalAddr, hlir.Move,
+		hlir.Add, hlir.Sub, hlir.Mul, hlir.Neg,
+		hlir.Eq, hlir.Ne, hlir.Lt, hlir.Le, hlir.Gt, hlir.Ge:
+		return true
+	case hlir.Div:
+		c, ok := intConst(v.Operand(1))
+		return ok && c != 0
+	}
+	return false
+}
+
+// intConst returns the integer of the value, if it is an integer
+// constant.
+func intConst(v ir.Value) (int64, bool) {
+	if !v.IsConstant() {
+		return 0, false
+	}
+	return ir.Int64Value(v.Constant())
+}
+
+// fold returns the result of the op on constant operands, or false if
+// the op can't be folded, such as a division by zero, which must panic
+// when it runs. Comparisons are 1 if they are true, and 0 if not.
+func fold(op ir.Op, args []int64) (int64, bool) {
+	b := func(cond bool) (int64, bool) {
+		if cond {
+			return 1, true
+		}
+		return 0, true
+	}
+
+	switch op {
+	case hlir.LoadInt, hlir.Move:
+		return args[0], true
+	case hlir.Neg:
+		return -args[0], true
+	case hlir.Add:
+		return args[0] + args[1], true
+	case hlir.Sub:
+		return args[0] - args[1], true
+	case hlir.Mul:
+		return args[0] * args[1], true
+	case hlir.Div:
+		if args[1] == 0 {
+			return 0, false
+		}
+		return args[0] / args[1], true
+	case hlir.Eq:
+		return b(args[0] == args[1])
+	case hlir.Ne:
+		return b(args[0] != args[1])
+	case hlir.Lt:
+		return b(args[0] < args[1])
+	case hlir.Le:
+		return b(args[0] <= args[1])
+	case hlir.Gt:
+		return b(args[0] > args[1])
+	case hlir.Ge:
+		return b(args[0] >= args[1])
+	}
+	return 0, false
+}
+
+// remove removes the value from its block, and clears its operands, so
+// it no longer uses them.
+func remove(v ir.Value) {
+	blk := v.Block()
+	if v.Op() == ir.Phi {
+		blk.RemoveParam(v)
+	} else {
+		blk.RemoveValue(v)
+	}
+	v.SetOperands()
+}
@@ -0,0 +1,637 @@
+package opt_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rj45/gosling/ir"
+	"github.com/rj45/gosling/opt"
+	"github.com/rj45/gosling/token"
+)
+
+// inline inlines into the functions of the program of the function.
+func inline(fn *ir.Func) {
+	opt.Inline(fn.Program)
+}
+
+var tests = []struct {
+	name   string
+	pass   func(*ir.Func)
+	before string
+	after  string
+}{
+	{
+		name: "sccp folds constants",
+		pass: opt.SCCP,
+		before: `
+			func main() int {
+			entry:
+				v1 = LoadInt 1
+				v2 = LoadInt 2
+				v3 = Add v1, v2
+				v4 = LoadInt 2
+				v5 = Sub v3, v4
+				v6 = Mul v5, v2
+				v7 = Neg v6
+				v8 = Lt v7, v1
+				Return v5
+			}
+		`,
+		after: `
+			func main() int {
+			entry:
+				Return 1
+			}
+		`,
+	},
+	{
+		name: "sccp keeps division by zero",
+		pass: opt.SCCP,
+		before: `
+			func main() int {
+			entry:
+				v1 = LoadInt 0
+				v2 = LoadInt 7
+				v3 = Div v2, v1
+				Return v3
+			}
+		`,
+		after: `
+			func main() int {
+			entry:
+				v3 = Div 7, 0
+				Return v3
+			}
+		`,
+	},
+	{
+		name: "sccp follows the branches taken",
+		pass: opt.SCCP,
+		before: `
+			func main(r0 int) int {
+			entry:
+				v1 = Arg 0
+				v2 = LoadInt 3
+				v3 = LoadInt 2
+				v4 = Gt v2, v3
+				If v4, then, else
+			then:
+				Jump join
+			else:
+				v5 = Add v1, 1
+				Jump join
+			join: <- then, else
+				v6 = Phi v2, v5
+				v7 = Mul v6, v1
+				Return v7
+			}
+		`,
+		after: `
+			func main(r0 int) int {
+			entry:
+				v1 = Arg 0
+				Jump then
+			then:
+				Jump join
+			join:
+				v7 = Mul 3, v1
+				Return v7
+			}
+		`,
+	},
+	{
+		name: "sccp assumes the best of loops",
+		pass: opt.SCCP,
+		before: `
+			func main(r0 int) int {
+			entry:
+				v1 = Arg 0
+				v2 = LoadInt 5
+				Jump loop
+			loop: <- entry, body
+				v3 = Phi v2, v6
+				v4 = Phi v1, v7
+				v5 = Lt v4, 10
+				If v5, body, exit
+			body:
+				v6 = Mul v3, 1
+				v7 = Add v4, 1
+				Jump loop
+			exit:
+				Return v3
+			}
+		`,
+		after: `
+			func main(r0 int) int {
+			entry:
+				v1 = Arg 0
+				Jump loop
+			loop: <- entry, body
+				v4 = Phi v1, v7
+				v5 = Lt v4, 10
+				If v5, body, exit
+			body:
+				v7 = Add v4, 1
+				Jump loop
+			exit:
+				Return 5
+			}
+		`,
+	},
+	{
+		name: "copyprop",
+		pass: opt.CopyProp,
+		before: `
+			func main(r0 int) int {
+			entry:
+				v1 = Arg 0
+				v2 = Move v1
+				Jump loop
+			loop: <- entry, body
+				v3 = Phi v2, v4
+				If v3, body, exit
+			body: <- loop
+				v4 = Phi v3
+				Jump loop
+			exit:
+				v5 = Add v3, v2
+				Return v5
+			}
+		`,
+		after: `
+			func main(r0 int) int {
+			entry:
+				v1 = Arg 0
+				Jump loop
+			loop:
+				If v1, body, exit
+			body:
+				Jump loop
+			exit:
+				v5 = Add v1, v1
+				Return v5
+			}
+		`,
+	},
+	{
+		name: "gvn",
+		pass: opt.GVN,
+		before: `
+			func main(r0 int, r1 int) int {
+			entry:
+				v1 = Arg 0
+				v2 = Arg 1
+				v3 = Add v1, v2
+				v4 = Add v2, v1
+				v5 = Sub v2, v1
+				If v3, then, else
+			then:
+				v6 = Sub v1, v2
+				v7 = Add v1, v2
+				v8 = Call f, v6
+				v9 = Call f, v6
+				Jump join
+			else:
+				v10 = Sub v1, v2
+				Jump join
+			join: <- then, else
+				v11 = Phi v9, v10
+				v12 = Mul v4, v7
+				v13 = Div v11, v5
+				v14 = Div v11, v5
+				Return v14
+			}
+
+			func f(r0 int) int {
+			entry:
+				Return r0
+			}
+		`,
+		after: `
+			func main(r0 int, r1 int) int {
+			entry:
+				v1 = Arg 0
+				v2 = Arg 1
+				v3 = Add v1, v2
+				v5 = Sub v2, v1
+				If v3, then, else
+			then:
+				v6 = Sub v1, v2
+				v8 = Call f, v6
+				v9 = Call f, v6
+				Jump join
+			else:
+				v10 = Sub v1, v2
+				Jump join
+			join: <- then, else
+				v11 = Phi v9, v10
+				v12 = Mul v3, v3
+				v13 = Div v11, v5
+				v14 = Div v11, v5
+				Return v14
+			}
+
+			func f(r0 int) int {
+			entry:
+				Return r0
+			}
+		`,
+	},
+	{
+		name: "dce",
+		pass: opt.DCE,
+		before: `
+			func main(r0 int) int {
+			entry:
+				v1 = Arg 0
+				v2 = LocalAddr 0
+				v3 = Load v2
+				v4 = LoadLocal 1
+				v5 = Add v4, v1
+				v6 = Div v1, v1
+				v7 = Div v1, 2
+				v8 = Call f, v1
+				Jump loop
+			loop: <- entry, loop
+				v9 = Phi v1, v10
+				v10 = Add v9, 1
+				If v1, loop, exit
+			exit:
+				Return v1
+			}
+
+			func f(r0 int) int {
+			entry:
+				Return r0
+			}
+		`,
+		after: `
+			func main(r0 int) int {
+			entry:
+				v1 = Arg 0
+				v2 = LocalAddr 0
+				v3 = Load v2
+				v6 = Div v1, v1
+				v8 = Call f, v1
+				Jump loop
+			loop:
+				If v1, loop, exit
+			exit:
+				Return v1
+			}
+
+			func f(r0 int) int {
+			entry:
+				Return r0
+			}
+		`,
+	},
+	{
+		name: "inline",
+		pass: inline,
+		before: `
+			func main(r0 int) int {
+			entry:
+				v1 = Arg 0
+				v2 = Call abs, v1
+				v3 = Call keep, v2
+				v4 = Add v3, 1
+				Return v4
+			}
+
+			func abs(r0 int) int {
+			entry:
+				v1 = Arg 0
+				v2 = Lt v1, 0
+				If v2, neg, done
+			neg:
+				v3 = Neg v1
+				Return v3
+			done:
+				Return v1
+			}
+
+			//gosling:noinline
+			func keep(r0 int) int {
+			entry:
+				v1 = Arg 0
+				Return v1
+			}
+		`,
+		after: `
+			func main(r0 int) int {
+			entry:
+				v1 = Arg 0
+				Jump main.inline0.entry
+			main.inline0.cont: <- main.inline0.neg, main.inline0.done
+				v16 = Phi v14, v1
+				v3 = Call keep, v16
+				v4 = Add v3, 1
+				Return v4
+			main.inline0.entry:
+				v12 = Lt v1, 0
+				If v12, main.inline0.neg, main.inline0.done
+			main.inline0.neg:
+				v14 = Neg v1
+				Jump main.inline0.cont
+			main.inline0.done:
+				Jump main.inline0.cont
+			}
+
+			func abs(r0 int) int {
+			entry:
+				v1 = Arg 0
+				v2 = Lt v1, 0
+				If v2, neg, done
+			neg:
+				v3 = Neg v1
+				Return v3
+			done:
+				Return v1
+			}
+
+			//gosling:noinline
+			func keep(r0 int) int {
+			entry:
+				v1 = Arg 0
+				Return v1
+			}
+		`,
+	},
+	{
+		name: "inline inlines into callees first",
+		pass: inline,
+		before: `
+			func main(r0 int) int {
+			entry:
+				v1 = Arg 0
+				v2 = Call double, v1
+				Return v2
+			}
+
+			func double(r0 int) int {
+			entry:
+				v1 = Arg 0
+				v2 = Call inc, v1
+				v3 = Mul v2, 2
+				Return v3
+			}
+
+			func inc(r0 int) int {
+			entry:
+				v1 = Arg 0
+				v2 = Add v1, 1
+				Return v2
+			}
+		`,
+		after: `
+			func main(r0 int) int {
+			entry:
+				v1 = Arg 0
+				Jump main.inline0.entry
+			main.inline0.cont:
+				Return v9
+			main.inline0.entry:
+				Jump main.inline0.double.inline0.entry
+			main.inline0.double.inline0.cont:
+				v9 = Mul v11, 2
+				Jump main.inline0.cont
+			main.inline0.double.inline0.entry:
+				v11 = Add v1, 1
+				Jump main.inline0.double.inline0.cont
+			}
+
+			func double(r0 int) int {
+			entry:
+				v1 = Arg 0
+				Jump double.inline0.entry
+			double.inline0.cont:
+				v3 = Mul v10, 2
+				Return v3
+			double.inline0.entry:
+				v10 = Add v1, 1
+				Jump double.inline0.cont
+			}
+
+			func inc(r0 int) int {
+			entry:
+				v1 = Arg 0
+				v2 = Add v1, 1
+				Return v2
+			}
+		`,
+	},
+	{
+		name: "inline stops at recursion",
+		pass: inline,
+		before: `
+			func main(r0 int) int {
+			entry:
+				v1 = Arg 0
+				v2 = Call fact, v1
+				Return v2
+			}
+
+			func fact(r0 int) int {
+			entry:
+				v1 = Arg 0
+				v2 = Le v1, 1
+				If v2, rec, base
+			base:
+				Jump done
+			rec:
+				v3 = Sub v1, 1
+				v4 = Call fact, v3
+				v5 = Mul v1, v4
+				Jump done
+			done: <- rec, base
+				v6 = Phi v5, 1
+				Return v6
+			}
+		`,
+		after: `
+			func main(r0 int) int {
+			entry:
+				v1 = Arg 0
+				Jump main.inline0.entry
+			main.inline0.cont:
+				Return v15
+			main.inline0.entry:
+				v8 = Le v1, 1
+				If v8, main.inline0.rec, main.inline0.base
+			main.inline0.base:
+				Jump main.inline0.done
+			main.inline0.rec:
+				v11 = Sub v1, 1
+				v12 = Call fact, v11
+				v13 = Mul v1, v12
+				Jump main.inline0.done
+			main.inline0.done: <- main.inline0.base, main.inline0.rec
+				v15 = Phi 1, v13
+				Jump main.inline0.cont
+			}
+
+			func fact(r0 int) int {
+			entry:
+				v1 = Arg 0
+				v2 = Le v1, 1
+				If v2, rec, base
+			base:
+				Jump done
+			rec:
+				v3 = Sub v1, 1
+				v4 = Call fact, v3
+				v5 = Mul v1, v4
+				Jump done
+			done: <- rec, base
+				v6 = Phi v5, 1
+				Return v6
+			}
+		`,
+	},
+}
+
+func TestPasses(t *testing.T) {
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			prog, err := ir.Parse(trim(test.before), ir.HLIR)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := prog.Dump(); trim(actual) != trim(test.before) {
+				t.Fatalf("expected the IR before to round trip, but got:\n%s", actual)
+			}
+
+			fn := prog.Func(0)
+			test.pass(fn)
+			for _, err := range ir.Verify(fn) {
+				t.Error(err)
+			}
+			if actual := prog.Dump(); trim(actual) != trim(test.after) {
+				t.Errorf("expected:\n%s\nactual:\n%s", test.after, actual)
+			}
+		})
+	}
+}
+
+func TestAddPasses(t *testing.T) {
+	pm := &ir.PassManager{PassOptions: ir.PassOptions{Verify: true}}
+	opt.AddPasses(pm, 0)
+	prog, err := ir.Parse(trim(tests[0].before), ir.HLIR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Run(prog); err != nil || trim(prog.Dump()) != trim(tests[0].before) {
+		t.Errorf("expected level 0 not to optimize, but got:\n%s", prog.Dump())
+	}
+
+	opt.AddPasses(pm, 1)
+	src := `
+		func main(r0 int) int {
+		entry:
+			v1 = Arg 0
+			v2 = LoadInt 2
+			v3 = LoadInt 1
+			v4 = Gt v2, v3
+			If v4, then, else
+		then:
+			v5 = Mul v1, v2
+			v6 = Mul v2, v1
+			v7 = Move v6
+			v8 = Add v5, v7
+			Jump join
+		else:
+			v9 = Sub v1, v3
+			Jump join
+		join: <- then, else
+			v10 = Phi v8, v9
+			Return v10
+		}
+	`
+	expected := `
+		func main(r0 int) int {
+		entry:
+			v1 = Arg 0
+			Jump then
+		then:
+			v5 = Mul v1, 2
+			v8 = Add v5, v5
+			Jump join
+		join:
+			Return v8
+		}
+	`
+	prog, err = ir.Parse(trim(src), ir.HLIR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Run(prog); err != nil {
+		t.Fatal(err)
+	}
+	if actual := prog.Dump(); trim(actual) != trim(expected) {
+		t.Errorf("expected:\n%s\nactual:\n%s", expected, actual)
+	}
+}
+
+// TestInlineStmts checks the inlined blocks keep the statements which
+// start in them, which coverage counts the runs of.
+func TestInlineStmts(t *testing.T) {
+	prog, err := ir.Parse(trim(`
+		func main(r0 int) int {
+		entry:
+			v1 = Arg 0
+			v2 = Call abs, v1
+			Return v2
+		}
+
+		func abs(r0 int) int {
+		entry:
+			v1 = Arg 0
+			v2 = Lt v1, 0
+			If v2, neg, done
+		neg:
+			v3 = Neg v1
+			Return v3
+		done:
+			Return v1
+		}
+	`), ir.HLIR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs := prog.FuncNamed("abs")
+	for i := 0; i < abs.NumBlocks(); i++ {
+		abs.BlockAt(i).AddStmt(token.NewToken(token.Return, 10*i))
+	}
+
+	opt.Inline(prog)
+
+	main := prog.FuncNamed("main")
+	for i := 0; i < abs.NumBlocks(); i++ {
+		b := abs.BlockAt(i)
+		found := false
+		for j := 0; j < main.NumBlocks(); j++ {
+			nb := main.BlockAt(j)
+			if nb.Name != "main.inline0."+b.Name {
+				continue
+			}
+			found = true
+			if len(nb.Stmts()) != 1 || nb.Stmts()[0] != b.Stmts()[0] {
+				t.Errorf("expected %s to keep the statements %v, but got %v", nb.Name, b.Stmts(), nb.Stmts())
+			}
+		}
+		if !found {
+			t.Errorf("expected %s to be inlined into main", b.Name)
+		}
+	}
+}
+
+func trim(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,247 @@
+package opt
+
+import (
+	"github.com/rj45/gosling/hlir"
+	"github.com/rj45/gosling/ir"
+)
+
+// SCCP is sparse conditional constant propagation, from "Constant
+// Propagation with Conditional Branches" by Wegman and Zadeck.
+//
+// Values are assumed to be unknown until they are found to be a
+// constant, or to vary, and only the blocks and edges which can be
+// reached are followed, so a value which is only changed along an edge
+// which is never taken is still a constant. The values which are
+// constants are replaced with them, the If terminators with a constant
+// condition become a Jump, and the blocks which can't be reached are
+// removed.
+func SCCP(fn *ir.Func) {
+	if fn.Extern {
+		return
+	}
+
+	s := &sccp{
+		fn:        fn,
+		cells:     make([]cell, fn.NumValues()+1),
+		reachable: make(map[ir.BlockID]bool),
+		edges:     make(map[edge]bool),
+	}
+	s.run()
+	s.rewrite()
+}
+
+type state uint8
+
+const (
+	unknown state = iota
+	constant
+	varies
+)
+
+// cell is what is known about a value: whether it is unknown, a
+// constant, or varies.
+type cell struct {
+	state state
+	c     int64
+}
+
+type edge struct {
+	from, to ir.BlockID
+}
+
+type sccp struct {
+	fn    *ir.Func
+	cells []cell
+
+	reachable map[ir.BlockID]bool
+	edges     map[edge]bool
+
+	// the edges which were found to be taken, and the values whose
+	// cell changed, to visit
+	flowWork []edge
+	ssaWork  []ir.Value
+}
+
+func (s *sccp) run() {
+	entry := s.fn.BlockAt(0)
+	s.visitBlock(entry)
+
+	for len(s.flowWork) > 0 || len(s.ssaWork) > 0 {
+		for len(s.flowWork) > 0 {
+			e := s.flowWork[len(s.flowWork)-1]
+			s.flowWork = s.flowWork[:len(s.flowWork)-1]
+
+			blk := s.fn.Block(e.to)
+			if s.reachable[e.to] {
+				// only the phis see the new edge
+				for i := 0; i < blk.NumParams(); i++ {
+					s.visit(blk.Param(i))
+				}
+				continue
+			}
+			s.visitBlock(blk)
+		}
+
+		for len(s.ssaWork) > 0 {
+			v := s.ssaWork[len(s.ssaWork)-1]
+			s.ssaWork = s.ssaWork[:len(s.ssaWork)-1]
+			if s.reachable[v.Block().ID()] {
+				s.visit(v)
+			}
+		}
+	}
+}
+
+// visitBlock visits each value of a block the first time it is reached.
+func (s *sccp) visitBlock(blk *ir.Block) {
+	s.reachable[blk.ID()] = true
+	for i := 0; i < blk.NumParams(); i++ {
+		s.visit(blk.Param(i))
+	}
+	for i := 0; i < blk.NumValues(); i++ {
+		if v := blk.ValueAt(i); !v.IsNil() {
+			s.visit(v)
+		}
+	}
+	s.visit(blk.Terminator())
+}
+
+// cell returns what is known about the value, which is its constant if
+// it is an integer constant, and varies if it is any other constant.
+func (s *sccp) cell(v ir.Value) cell {
+	if v.IsConstant() {
+		if c, ok := intConst(v); ok {
+			return cell{state: constant, c: c}
+		}
+		return cell{state: varies}
+	}
+	return s.cells[v.ID()]
+}
+
+// set lowers the cell of the value, and visits its users if it changed.
+func (s *sccp) set(v ir.Value, c cell) {
+	if s.cells[v.ID()] == c {
+		return
+	}
+	s.cells[v.ID()] = c
+	s.ssaWork = append(s.ssaWork, v.Users()...)
+}
+
+// take marks the edge from the block to its successor at the index as
+// taken.
+func (s *sccp) take(blk *ir.Block, index int) {
+	e := edge{blk.ID(), blk.Successor(index).ID()}
+	if !s.edges[e] {
+		s.edges[e] = true
+		s.flowWork = append(s.flowWork, e)
+	}
+}
+
+func (s *sccp) visit(v ir.Value) {
+	if s.cells[v.ID()].state == varies {
+		return
+	}
+	blk := v.Block()
+
+	switch v.Op() {
+	case ir.Phi:
+		// the meet of the values along the edges which are taken
+		c := cell{}
+		for i := 0; i < v.NumOperands(); i++ {
+			if !s.edges[edge{blk.Predecessor(i).ID(), blk.ID()}] {
+				continue
+			}
+			oc := s.cell(v.Operand(i))
+			switch {
+			case oc.state == unknown:
+			case c.state == unknown:
+				c = oc
+			case oc != c:
+				c = cell{state: varies}
+			}
+		}
+		s.set(v, c)
+		return
+
+	case hlir.If:
+		switch c := s.cell(v.Operand(0)); c.state {
+		case constant:
+			if c.c != 0 {
+				s.take(blk, 0)
+			} else {
+				s.take(blk, 1)
+			}
+		case varies:
+			s.take(blk, 0)
+			s.take(blk, 1)
+		}
+		return
+	}
+
+	if blk.Terminator().ID() == v.ID() {
+		for i := 0; i < blk.NumSuccessors(); i++ {
+			s.take(blk, i)
+		}
+		return
+	}
+
+	args := make([]int64, v.NumOperands())
+	for i := range args {
+		c := s.cell(v.Operand(i))
+		switch c.state {
+		case unknown:
+			if pure(v) {
+				return
+			}
+		case varies:
+			s.set(v, cell{state: varies})
+			return
+		}
+		args[i] = c.c
+	}
+	if c, ok := fold(v.Op(), args); ok {
+		s.set(v, cell{state: constant, c: c})
+		return
+	}
+	s.set(v, cell{state: varies})
+}
+
+// rewrite replaces the values which are constants, and removes the
+// edges which are never taken, and the blocks which are never reached.
+func (s *sccp) rewrite() {
+	fn := s.fn
+	var consts []ir.Value
+	fn.EachValue(func(v ir.Value) {
+		if s.cells[v.ID()].state == constant && pure(v) && s.reachable[v.Block().ID()] {
+			consts = append(consts, v)
+		}
+	})
+	for _, v := range consts {
+		v.ReplaceAllUsesWith(fn.ValueForConst(ir.IntConst(s.cells[v.ID()].c)))
+		remove(v)
+	}
+
+	for b := 0; b < fn.NumBlocks(); b++ {
+		blk := fn.BlockAt(b)
+		term := blk.Terminator()
+		if term.Op() != hlir.If || !s.reachable[blk.ID()] {
+			continue
+		}
+		c, ok := intConst(term.Operand(0))
+		if !ok {
+			continue
+		}
+		taken := 0
+		if c == 0 {
+			taken = 1
+		}
+		term.SetOp(hlir.Jump).SetOperands()
+		blk.RemoveSuccessor(1 - taken)
+	}
+
+	for b := fn.NumBlocks() - 1; b >= 0; b-- {
+		if blk := fn.BlockAt(b); !s.reachable[blk.ID()] {
+			fn.RemoveBlock(blk)
+		}
+	}
+}
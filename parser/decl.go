@@ -5,11 +5,21 @@ import (
 	"github.com/rj45/gosling/token"
 )
 
-// declList = decl*
+// declList = packageClause? importDecl* decl*
 func (p *Parser) declList() ast.NodeID {
 	tok := p.tok
 
 	var decls []ast.NodeID
+	if p.tok.Kind() == token.Package {
+		decls = append(decls, p.packageClause())
+	}
+	for p.tok.Kind() == token.Import {
+		decls = append(decls, p.importDecl())
+		if p.tok.Kind() != token.EOF {
+			p.expect(token.Semicolon)
+		}
+	}
+
 	for p.tok.Kind() != token.EOF {
 		before := p.tok
 		decls = append(decls, p.decl())
@@ -28,18 +38,54 @@ func (p *Parser) declList() ast.NodeID {
 	return p.ast.AddNode(ast.DeclList, tok, decls...)
 }
 
-// decl = funcDecl
+// packageClause = "package" ident ";"
+func (p *Parser) packageClause() ast.NodeID {
+	tok := p.expect(token.Package)
+	name := p.name()
+	if p.tok.Kind() != token.EOF {
+		p.expect(token.Semicolon)
+	}
+	return p.ast.AddNode(ast.PackageClause, tok, name)
+}
+
+// importDecl = "import" (importSpec | "(" (importSpec ";")* ")")
+// importSpec = string
+func (p *Parser) importDecl() ast.NodeID {
+	tok := p.expect(token.Import)
+	if p.tok.Kind() != token.LParen {
+		return p.ast.AddNode(ast.ImportDecl, tok, p.node(ast.Literal, token.String))
+	}
+	p.next()
+
+	var specs []ast.NodeID
+	for p.tok.Kind() != token.RParen && p.tok.Kind() != token.EOF {
+		specs = append(specs, p.node(ast.Literal, token.String))
+		if p.tok.Kind() != token.RParen && p.tok.Kind() != token.EOF {
+			p.expect(token.Semicolon)
+		}
+	}
+	p.expect(token.RParen)
+
+	return p.ast.AddNode(ast.ImportDecl, tok, specs...)
+}
+
+// decl = funcDecl | constDecl
 func (p *Parser) decl() ast.NodeID {
 	switch p.tok.Kind() {
 	case token.Func:
 		return p.funcDecl()
+	case token.Const:
+		return p.constDecl()
+	case token.Import:
+		p.error("imports must appear before other declarations")
+		return p.importDecl()
 	default:
 		p.error("expected declaration")
 		return ast.InvalidNode
 	}
 }
 
-// funcDecl = "func" ident "(" fieldList? ")" ident? block
+// funcDecl = "func" ident "(" fieldList? ")" typ? block?
 func (p *Parser) funcDecl() ast.NodeID {
 	tok := p.expect(token.Func)
 	name := p.name()
@@ -49,15 +95,70 @@ func (p *Parser) funcDecl() ast.NodeID {
 	p.expect(token.RParen)
 
 	var ret ast.NodeID
-	if p.tok.Kind() == token.Ident {
-		ret = p.name()
+	if p.isTypeStart() {
+		ret = p.typ()
 	}
 
-	body := p.block()
+	// a function without a body is an extern function, but a body on
+	// the next line is still an error, since the newline ends the decl
+	var body ast.NodeID
+	if p.tok.Kind() == token.LBrace || p.tok.Kind() == token.Semicolon && p.tok.Next(p.src).Kind() == token.LBrace {
+		body = p.block()
+	}
 
 	return p.ast.AddNode(ast.FuncDecl, tok, name, params, ret, body)
 }
 
+// constDecl = "const" (constSpec | "(" (constSpec ";")* ")")
+func (p *Parser) constDecl() ast.NodeID {
+	tok := p.expect(token.Const)
+	if p.tok.Kind() != token.LParen {
+		return p.ast.AddNode(ast.ConstDecl, tok, p.constSpec(ast.InvalidNode))
+	}
+	p.next()
+
+	var specs []ast.NodeID
+	prev := ast.InvalidNode
+	for p.tok.Kind() != token.RParen && p.tok.Kind() != token.EOF {
+		spec := p.constSpec(prev)
+		specs = append(specs, spec)
+		prev = spec
+
+		if p.tok.Kind() != token.RParen && p.tok.Kind() != token.EOF {
+			p.expect(token.Semicolon)
+		}
+	}
+	p.expect(token.RParen)
+
+	return p.ast.AddNode(ast.ConstDecl, tok, specs...)
+}
+
+// constSpec = ident typ? ("=" expr)?
+func (p *Parser) constSpec(prev ast.NodeID) ast.NodeID {
+	tok := p.tok
+	name := p.name()
+
+	var typ, value ast.NodeID
+	if p.isTypeStart() {
+		typ = p.typ()
+	}
+
+	switch {
+	case p.tok.Kind() == token.Assign:
+		p.next()
+		value = p.expr()
+	case prev != ast.InvalidNode && typ == ast.InvalidNode:
+		// an omitted type and value repeat the previous ones in the group,
+		// and the copy is checked again with the next value of iota
+		typ = p.ast.Clone(p.ast.Child(prev, ast.ConstSpecType))
+		value = p.ast.Clone(p.ast.Child(prev, ast.ConstSpecValue))
+	default:
+		p.errorAt(tok, "missing init expr for const declaration")
+	}
+
+	return p.ast.AddNode(ast.ConstSpec, tok, name, typ, value)
+}
+
 // fieldList = (field (sep field)*)?
 func (p *Parser) fieldList(sep token.Kind, end token.Kind) ast.NodeID {
 	tok := p.tok
@@ -73,14 +174,62 @@ func (p *Parser) fieldList(sep token.Kind, end token.Kind) ast.NodeID {
 	return p.ast.AddNode(ast.FieldList, tok, nodes...)
 }
 
-// field = ident ident
+// field = ident typ
 func (p *Parser) field() ast.NodeID {
 	tok := p.tok
 	name := p.name()
-	if p.tok.Kind() != token.Ident {
+	if !p.isTypeStart() {
 		p.error("expected type")
 		return ast.InvalidNode
 	}
-	typ := p.name()
+	typ := p.typ()
 	return p.ast.AddNode(ast.Field, tok, name, typ)
 }
+
+// typ = ident | mapType | chanType | pointerType
+func (p *Parser) typ() ast.NodeID {
+	switch p.tok.Kind() {
+	case token.Map:
+		return p.mapType()
+	case token.Chan:
+		return p.chanType()
+	case token.Star:
+		return p.pointerType()
+	case token.Ident:
+		return p.name()
+	default:
+		p.error("expected type")
+		return ast.InvalidNode
+	}
+}
+
+// isTypeStart returns whether the token can be the start of a type.
+func (p *Parser) isTypeStart() bool {
+	switch p.tok.Kind() {
+	case token.Ident, token.Map, token.Chan, token.Star:
+		return true
+	}
+	return false
+}
+
+// mapType = "map" "[" typ "]" typ
+func (p *Parser) mapType() ast.NodeID {
+	tok := p.expect(token.Map)
+	p.expect(token.LBrack)
+	key := p.typ()
+	p.expect(token.RBrack)
+	elem := p.typ()
+	return p.ast.AddNode(ast.MapType, tok, key, elem)
+}
+
+// chanType = "chan" typ
+func (p *Parser) chanType() ast.NodeID {
+	tok := p.expect(token.Chan)
+	return p.ast.AddNode(ast.ChanType, tok, p.typ())
+}
+
+// pointerType = "*" typ
+func (p *Parser) pointerType() ast.NodeID {
+	tok := p.expect(token.Star)
+	return p.ast.AddNode(ast.PointerType, tok, p.typ())
+}
@@ -54,6 +54,38 @@ func TestParseFuncDecl(t *testing.T) {
 			Name("int"),
 			StmtList(),
 		)`},
+		{"func foo(m map[int]bool) map[int]int {}", `FuncDecl(
+			Name("foo"),
+			FieldList(
+				Field(
+					Name("m"),
+					MapType(Name("int"), Name("bool")),
+				),
+			),
+			MapType(Name("int"), Name("int")),
+			StmtList(),
+		)`},
+		{"func write(fd int, p *int, n int) int\nfunc main() {}", `FuncDecl(
+			Name("write"),
+			FieldList(
+				Field(Name("fd"), Name("int")),
+				Field(
+					Name("p"),
+					PointerType(Name("int")),
+				),
+				Field(Name("n"), Name("int")),
+			),
+			Name("int"),
+			nil,
+		)`},
+		{"func exit(code int)", `FuncDecl(
+			Name("exit"),
+			FieldList(
+				Field(Name("code"), Name("int")),
+			),
+			nil,
+			nil,
+		)`},
 	}
 
 	for _, tt := range tests {
@@ -69,3 +101,120 @@ func TestParseFuncDecl(t *testing.T) {
 		}
 	}
 }
+
+func TestParseConstDecl(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{"const a = 1", `ConstDecl(
+			ConstSpec(
+				Name("a"),
+				nil,
+				Literal("1"),
+			),
+		)`},
+		{"const a int = 1 + 2", `ConstDecl(
+			ConstSpec(
+				Name("a"),
+				Name("int"),
+				BinaryExpr("+", Literal("1"), Literal("2")),
+			),
+		)`},
+		{"const (\n\ta = iota\n\tb\n\tc = 5\n)", `ConstDecl(
+			ConstSpec(
+				Name("a"),
+				nil,
+				Name("iota"),
+			),
+			ConstSpec(
+				Name("b"),
+				nil,
+				Name("iota"),
+			),
+			ConstSpec(
+				Name("c"),
+				nil,
+				Literal("5"),
+			),
+		)`},
+		{"const (a bool = true; b)", `ConstDecl(
+			ConstSpec(
+				Name("a"),
+				Name("bool"),
+				Name("true"),
+			),
+			ConstSpec(
+				Name("b"),
+				Name("bool"),
+				Name("true"),
+			),
+		)`},
+		{"const ()", `ConstDecl()`},
+	}
+
+	for _, tt := range tests {
+		a, decllist, errs := parse(t, tt.src)
+		if len(errs) > 0 {
+			t.Errorf("Expected no error, but got %s", errs)
+		}
+
+		decl := a.Child(decllist, 0)
+
+		if trim(a.StringOf(decl)) != trim(tt.expected) {
+			t.Errorf("Expected: %s\nBut got: %s", tt.expected, a.StringOf(decl))
+		}
+	}
+}
+
+func TestParsePackageClause(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{"package main\nfunc main() {}", `DeclList(
+			PackageClause(Name("main")),
+			FuncDecl(
+				Name("main"),
+				FieldList(),
+				nil,
+				StmtList(),
+			),
+		)`},
+		{"package main\nimport \"mathx\"", `DeclList(
+			PackageClause(Name("main")),
+			ImportDecl(Literal("\"mathx\"")),
+		)`},
+		{"package main\nimport (\n\t\"a\"\n\t\"b/c\"\n)\nimport \"d\"", `DeclList(
+			PackageClause(Name("main")),
+			ImportDecl(Literal("\"a\""), Literal("\"b/c\"")),
+			ImportDecl(Literal("\"d\"")),
+		)`},
+		{"func main() { mathx.Add(1, 2) }", `DeclList(
+			FuncDecl(
+				Name("main"),
+				FieldList(),
+				nil,
+				StmtList(
+					ExprStmt(
+						CallExpr(
+							SelectorExpr(Name("mathx"), Name("Add")),
+							ExprList(Literal("1"), Literal("2")),
+						),
+					),
+				),
+			),
+		)`},
+	}
+
+	for _, tt := range tests {
+		a, decllist, errs := parse(t, tt.src)
+		if len(errs) > 0 {
+			t.Errorf("Expected no error, but got %s", errs)
+		}
+
+		if trim(a.StringOf(decllist)) != trim(tt.expected) {
+			t.Errorf("Expected: %s\nBut got: %s", tt.expected, a.StringOf(decllist))
+		}
+	}
+}
@@ -41,7 +41,9 @@ func TestStmtParseError(t *testing.T) {
 		expected string
 	}{
 		{"func main() int {for i = 9 {}}", `expected for condition to be expression statement`},
-		{"func main() int {9 = 45}", `expected name or deref on the left side of the assignment`},
+		{"func main() int {9 = 45}", `expected name, deref or index on the left side of the assignment`},
+		{"func main() int {go 1}", `expression in go must be function call`},
+		{"func main() int {defer 1}", `expression in defer must be function call`},
 	}
 
 	for _, tt := range tests {
@@ -71,6 +73,13 @@ func TestDeclParseError(t *testing.T) {
 		{"func foo()\n{}", `expected '{'`}, // bracket must be on same line (like in Go)
 		{"func foo() {", `expected '}'`},
 		{"func foo {}", `expected '('`},
+		{"const a", `missing init expr for const declaration`},
+		{"const (a int; b)", `missing init expr for const declaration`},
+		{"const (a = 1", `expected ')'`},
+		{"package", `expected name`},
+		{"import mathx", `expected string`},
+		{"import \"mathx", `illegal token`},
+		{"func main() {}\nimport \"mathx\"", `imports must appear before other declarations`},
 	}
 
 	for _, tt := range tests {
@@ -71,7 +71,7 @@ func (p *Parser) mul() ast.NodeID {
 	}
 }
 
-// unary = ("+" | "-" | "*" | "&") unary | primary
+// unary = ("+" | "-" | "*" | "&" | "<-") unary | primary
 func (p *Parser) unary() ast.NodeID {
 	switch p.tok.Kind() {
 	case token.Add:
@@ -83,13 +83,27 @@ func (p *Parser) unary() ast.NodeID {
 		return p.ast.AddNode(ast.DerefExpr, p.next(), p.unary())
 	case token.And:
 		return p.ast.AddNode(ast.AddrExpr, p.next(), p.unary())
+	case token.Arrow:
+		return p.ast.AddNode(ast.RecvExpr, p.next(), p.unary())
 	default:
 		return p.primary()
 	}
 }
 
-// primary = "(" expr ")" | block | ifExpr | number | name ( "(" argList ")" )?
+// primary = operand ("[" expr "]")*
 func (p *Parser) primary() ast.NodeID {
+	node := p.operand()
+	for p.tok.Kind() == token.LBrack {
+		tok := p.next()
+		index := p.expr()
+		p.expect(token.RBrack)
+		node = p.ast.AddNode(ast.IndexExpr, tok, node, index)
+	}
+	return node
+}
+
+// operand = "(" expr ")" | block | ifExpr | number | mapType compositeLit? | chanType | name ("." name)? ( "(" argList ")" )?
+func (p *Parser) operand() ast.NodeID {
 	switch p.tok.Kind() {
 	case token.LParen:
 		p.next()
@@ -102,8 +116,19 @@ func (p *Parser) primary() ast.NodeID {
 		return p.ifExpr()
 	case token.Int:
 		return p.node(ast.Literal, token.Int)
+	case token.Map:
+		typ := p.mapType()
+		if p.tok.Kind() == token.LBrace {
+			return p.compositeLit(typ)
+		}
+		return typ
+	case token.Chan:
+		return p.chanType()
 	case token.Ident:
 		node := p.name()
+		if p.tok.Kind() == token.Period {
+			node = p.ast.AddNode(ast.SelectorExpr, p.next(), node, p.name())
+		}
 		if p.tok.Kind() == token.LParen {
 			return p.ast.AddNode(ast.CallExpr, p.tok, node, p.argList())
 		}
@@ -114,6 +139,29 @@ func (p *Parser) primary() ast.NodeID {
 	}
 }
 
+// compositeLit = "{" (keyValue ("," keyValue)* ","?)? "}"
+func (p *Parser) compositeLit(typ ast.NodeID) ast.NodeID {
+	tok := p.expect(token.LBrace)
+	var elems []ast.NodeID
+	for p.tok.Kind() != token.RBrace && p.tok.Kind() != token.EOF {
+		elems = append(elems, p.keyValue())
+		if p.tok.Kind() != token.Comma {
+			break
+		}
+		p.next()
+	}
+	list := p.ast.AddNode(ast.ExprList, tok, elems...)
+	p.expect(token.RBrace)
+	return p.ast.AddNode(ast.CompositeLit, p.ast.Token(typ), typ, list)
+}
+
+// keyValue = expr ":" expr
+func (p *Parser) keyValue() ast.NodeID {
+	key := p.expr()
+	tok := p.expect(token.Colon)
+	return p.ast.AddNode(ast.KeyValueExpr, tok, key, p.expr())
+}
+
 // argList = "(" (expr ("," expr)*)? ")"
 func (p *Parser) argList() ast.NodeID {
 	p.expect(token.LParen)
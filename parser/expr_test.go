@@ -358,6 +358,96 @@ func TestParseCallExpr(t *testing.T) {
 	}
 }
 
+func TestParseMapExpr(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{"m[1]", `IndexExpr(Name("m"), Literal("1"))`},
+		{"m[k][j]", `IndexExpr(
+			IndexExpr(Name("m"), Name("k")),
+			Name("j"),
+		)`},
+		{"make(map[int]bool)", `CallExpr(
+			Name("make"),
+			ExprList(
+				MapType(Name("int"), Name("bool")),
+			),
+		)`},
+		{"map[int]int{}", `CompositeLit(
+			MapType(Name("int"), Name("int")),
+			ExprList(),
+		)`},
+		{"map[int]int{1: 2, 3: 4,}", `CompositeLit(
+			MapType(Name("int"), Name("int")),
+			ExprList(
+				KeyValueExpr(Literal("1"), Literal("2")),
+				KeyValueExpr(Literal("3"), Literal("4")),
+			),
+		)`},
+	}
+	for _, tt := range tests {
+		a, stmt, errs := parseStmt(t, tt.src)
+		if len(errs) > 0 {
+			t.Errorf("Expected no error, but got %s", errs)
+		}
+
+		if a.Kind(stmt) != ast.ExprStmt {
+			t.Errorf("Expected ExprStmt, but got %s", a.Kind(stmt))
+		}
+
+		expr := a.Child(stmt, ast.ExprStmtExpr)
+
+		if trim(a.StringOf(expr)) != trim(tt.expected) {
+			t.Errorf("Expected: %s\nBut got: %s", tt.expected, a.StringOf(expr))
+		}
+	}
+}
+
+func TestParseChanExpr(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{"<-ch", `RecvExpr(Name("ch"))`},
+		{"<-ch + 1", `BinaryExpr("+",
+			RecvExpr(Name("ch")),
+			Literal("1"),
+		)`},
+		{"make(chan int, 3)", `CallExpr(
+			Name("make"),
+			ExprList(
+				ChanType(Name("int")),
+				Literal("3"),
+			),
+		)`},
+		{"make(chan map[int]int)", `CallExpr(
+			Name("make"),
+			ExprList(
+				ChanType(
+					MapType(Name("int"), Name("int")),
+				),
+			),
+		)`},
+	}
+	for _, tt := range tests {
+		a, stmt, errs := parseStmt(t, tt.src)
+		if len(errs) > 0 {
+			t.Errorf("Expected no error, but got %s", errs)
+		}
+
+		if a.Kind(stmt) != ast.ExprStmt {
+			t.Errorf("Expected ExprStmt, but got %s", a.Kind(stmt))
+		}
+
+		expr := a.Child(stmt, ast.ExprStmtExpr)
+
+		if trim(a.StringOf(expr)) != trim(tt.expected) {
+			t.Errorf("Expected: %s\nBut got: %s", tt.expected, a.StringOf(expr))
+		}
+	}
+}
+
 func trim(s string) string {
 	lines := strings.Split(strings.TrimSpace(s), "\n")
 	for i, l := range lines {
@@ -7,7 +7,12 @@ import (
 )
 
 type Parser struct {
-	ast *ast.AST
+	ast  *ast.AST
+	file *token.File
+
+	// src is the source up to the end of the file, which
+	// the offsets of the file's tokens index into
+	src []byte
 
 	tok token.Token
 
@@ -15,9 +20,22 @@ type Parser struct {
 }
 
 func New(file *token.File) *Parser {
-	return &Parser{ast: ast.New(file)}
+	return NewWithAST(ast.New(file.FileSet()), file)
+}
+
+// NewWithAST creates a parser which adds the file to an existing AST,
+// so the files of a program can be parsed into one AST. The files must
+// be in the same file set.
+func NewWithAST(a *ast.AST, file *token.File) *Parser {
+	return &Parser{
+		ast:  a,
+		file: file,
+		src:  file.Buf(),
+		tok:  token.NewToken(token.Illegal, file.Base),
+	}
 }
 
+// Parse parses the file, whose DeclList is the root of the AST afterwards.
 func (p *Parser) Parse() (*ast.AST, []error) {
 	p.next()
 
@@ -28,7 +46,7 @@ func (p *Parser) Parse() (*ast.AST, []error) {
 
 func (p *Parser) next() token.Token {
 	tok := p.tok
-	p.tok = p.tok.Next(p.ast.Src)
+	p.tok = p.tok.Next(p.src)
 
 	if p.tok.Kind() == token.Illegal {
 		p.errorIllegalToken()
@@ -65,14 +83,14 @@ func (p *Parser) errorAt(tok token.Token, msg string, args ...interface{}) {
 			}
 		}
 	}
-	p.errs = append(p.errs, errors.Newf(p.ast.Src, tok, msg, args...))
+	p.errs = append(p.errs, errors.Newf(p.file, tok, msg, args...))
 }
 
 func (p *Parser) errorIllegalToken() {
 	tok := p.tok
 	pos := p.tok.Offset()
-	p.tok = p.tok.NextValidToken(p.ast.Src)
-	p.errorAt(tok, "illegal token %q", p.ast.Src[pos:p.tok.Offset()])
+	p.tok = p.tok.NextValidToken(p.src)
+	p.errorAt(tok, "illegal token %q", p.src[pos:p.tok.Offset()])
 }
 
 var tokenErrStrs = map[token.Kind]string{
@@ -81,5 +99,9 @@ var tokenErrStrs = map[token.Kind]string{
 	token.LBrace:    "'{'",
 	token.RBrace:    "'}'",
 	token.LParen:    "'('",
+	token.RParen:    "')'",
+	token.RBrack:    "']'",
+	token.Colon:     "':'",
 	token.Ident:     "name",
+	token.String:    "string",
 }
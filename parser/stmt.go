@@ -9,7 +9,7 @@ import (
 func (p *Parser) stmtList() ast.NodeID {
 	nodes := []ast.NodeID{}
 	tok := p.tok
-	for p.tok.Kind() != token.EOF && p.tok.Kind() != token.RBrace {
+	for p.tok.Kind() != token.EOF && p.tok.Kind() != token.RBrace && p.tok.Kind() != token.Case && p.tok.Kind() != token.Default {
 		nodes = append(nodes, p.stmt())
 		if len(p.errs) > 0 {
 			// todo: implement error recovery
@@ -19,7 +19,7 @@ func (p *Parser) stmtList() ast.NodeID {
 	return p.ast.AddNode(ast.StmtList, tok, nodes...)
 }
 
-// stmt = returnStmt | ifStmt | forStmt | blockStmt | simpleStmt
+// stmt = returnStmt | goStmt | deferStmt | constDecl | ifStmt | forStmt | selectStmt | blockStmt | simpleStmt
 func (p *Parser) stmt() ast.NodeID {
 	switch p.tok.Kind() {
 	case token.Return:
@@ -28,10 +28,30 @@ func (p *Parser) stmt() ast.NodeID {
 			p.expect(token.Semicolon)
 		}
 		return stmt
+	case token.Go:
+		stmt := p.goStmt()
+		if p.tok.Kind() != token.RBrace {
+			p.expect(token.Semicolon)
+		}
+		return stmt
+	case token.Defer:
+		stmt := p.deferStmt()
+		if p.tok.Kind() != token.RBrace {
+			p.expect(token.Semicolon)
+		}
+		return stmt
+	case token.Const:
+		stmt := p.constDecl()
+		if p.tok.Kind() != token.RBrace {
+			p.expect(token.Semicolon)
+		}
+		return stmt
 	case token.If:
 		return p.ifExpr()
 	case token.For:
 		return p.forStmt()
+	case token.Select:
+		return p.selectStmt()
 	case token.LBrace:
 		return p.block()
 	default:
@@ -79,6 +99,59 @@ func (p *Parser) forStmt() ast.NodeID {
 	return p.ast.AddNode(ast.ForStmt, tok, init, cond, post, body)
 }
 
+// goStmt = "go" callExpr
+func (p *Parser) goStmt() ast.NodeID {
+	tok := p.expect(token.Go)
+	call := p.expr()
+	if p.ast.Kind(call) != ast.CallExpr {
+		p.errorAt(p.ast.Token(call), "expression in go must be function call")
+	}
+	return p.ast.AddNode(ast.GoStmt, tok, call)
+}
+
+// deferStmt = "defer" callExpr
+func (p *Parser) deferStmt() ast.NodeID {
+	tok := p.expect(token.Defer)
+	call := p.expr()
+	if p.ast.Kind(call) != ast.CallExpr {
+		p.errorAt(p.ast.Token(call), "expression in defer must be function call")
+	}
+	return p.ast.AddNode(ast.DeferStmt, tok, call)
+}
+
+// selectStmt = "select" "{" commClause* "}"
+func (p *Parser) selectStmt() ast.NodeID {
+	tok := p.expect(token.Select)
+	p.expect(token.LBrace)
+
+	var clauses []ast.NodeID
+	for p.tok.Kind() == token.Case || p.tok.Kind() == token.Default {
+		clauses = append(clauses, p.commClause())
+		if len(p.errs) > 0 {
+			break
+		}
+	}
+
+	p.expect(token.RBrace)
+	return p.ast.AddNode(ast.SelectStmt, tok, clauses...)
+}
+
+// commClause = ("case" simpleStmt | "default") ":" stmtList
+func (p *Parser) commClause() ast.NodeID {
+	tok := p.tok
+
+	var comm ast.NodeID
+	if p.tok.Kind() == token.Default {
+		p.next()
+	} else {
+		p.expect(token.Case)
+		comm = p.simpleStmt()
+	}
+
+	p.expect(token.Colon)
+	return p.ast.AddNode(ast.CommClause, tok, comm, p.stmtList())
+}
+
 // returnStmt = "return" expr?
 func (p *Parser) returnStmt() ast.NodeID {
 	tok := p.expect(token.Return)
@@ -88,7 +161,7 @@ func (p *Parser) returnStmt() ast.NodeID {
 	return p.ast.AddNode(ast.ReturnStmt, tok, p.expr())
 }
 
-// simpleStmt = name "=" expr | expr
+// simpleStmt = exprList ("=" | ":=") expr | expr "<-" expr | expr
 func (p *Parser) simpleStmt() ast.NodeID {
 	tok := p.tok
 
@@ -98,18 +171,44 @@ func (p *Parser) simpleStmt() ast.NodeID {
 
 	lhs := p.expr()
 
-	switch p.tok.Kind() {
-	case token.Assign, token.Define:
-		node := lhs
-		for p.ast.Kind(node) == ast.DerefExpr {
-			node = p.ast.Child(node, ast.DerefExprExpr)
+	if p.tok.Kind() == token.Comma {
+		nodes := []ast.NodeID{lhs}
+		for p.tok.Kind() == token.Comma {
+			p.next()
+			nodes = append(nodes, p.expr())
+		}
+		lhs = p.ast.AddNode(ast.ExprList, tok, nodes...)
+
+		if p.tok.Kind() != token.Assign && p.tok.Kind() != token.Define {
+			p.error("expected assignment after expression list")
 		}
+	}
 
-		if p.ast.Kind(node) != ast.Name {
-			p.errorAt(p.ast.Token(lhs), "expected name or deref on the left side of the assignment")
+	switch p.tok.Kind() {
+	case token.Arrow:
+		return p.ast.AddNode(ast.SendStmt, p.next(), lhs, p.expr())
+	case token.Assign, token.Define:
+		if p.ast.Kind(lhs) == ast.ExprList {
+			for _, node := range p.ast.Children(lhs) {
+				p.checkAssignable(node)
+			}
+		} else {
+			p.checkAssignable(lhs)
 		}
 		return p.ast.AddNode(ast.AssignStmt, p.next(), lhs, p.expr())
 	}
 
 	return p.ast.AddNode(ast.ExprStmt, tok, lhs)
 }
+
+// checkAssignable errors if lhs cannot appear on the left side of an assignment
+func (p *Parser) checkAssignable(lhs ast.NodeID) {
+	node := lhs
+	for p.ast.Kind(node) == ast.DerefExpr {
+		node = p.ast.Child(node, ast.DerefExprExpr)
+	}
+
+	if p.ast.Kind(node) != ast.Name && p.ast.Kind(node) != ast.IndexExpr {
+		p.errorAt(p.ast.Token(lhs), "expected name, deref or index on the left side of the assignment")
+	}
+}
@@ -152,6 +152,74 @@ func TestParseAssignStmt(t *testing.T) {
         	),
         	Literal("42"),
         )`},
+		{"m[1] = 2", `AssignStmt("=",
+			IndexExpr(Name("m"), Literal("1")),
+			Literal("2"),
+		)`},
+		{"v, ok := m[k]", `AssignStmt(":=",
+			ExprList(Name("v"), Name("ok")),
+			IndexExpr(Name("m"), Name("k")),
+		)`},
+		{"defer foo(1)", `DeferStmt(
+			CallExpr(
+				Name("foo"),
+				ExprList(Literal("1")),
+			),
+		)`},
+	}
+
+	for _, tt := range tests {
+		a, stmt, errs := parseStmt(t, tt.src)
+		if len(errs) > 0 {
+			t.Errorf("Expected no error, but got %s", errs)
+		}
+
+		if trim(a.StringOf(stmt)) != trim(tt.expected) {
+			t.Errorf("Expected: %s\nBut got: %s", tt.expected, a.StringOf(stmt))
+		}
+	}
+}
+
+func TestParseChanStmt(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{"ch <- 1", `SendStmt(Name("ch"), Literal("1"))`},
+		{"go foo(1)", `GoStmt(
+			CallExpr(
+				Name("foo"),
+				ExprList(Literal("1")),
+			),
+		)`},
+		{"select {}", `SelectStmt()`},
+		{"select { case v := <-a: v; case b <- 2: case <-a:\n default: 3 }", `SelectStmt(
+			CommClause(
+				AssignStmt(":=",
+					Name("v"),
+					RecvExpr(Name("a")),
+				),
+				StmtList(
+					ExprStmt(Name("v")),
+				),
+			),
+			CommClause(
+				SendStmt(Name("b"), Literal("2")),
+				StmtList(),
+			),
+			CommClause(
+				ExprStmt(
+					RecvExpr(Name("a")),
+				),
+				StmtList(),
+			),
+			CommClause(
+				nil,
+				StmtList(
+					ExprStmt(Literal("3")),
+				),
+			),
+		)`},
 	}
 
 	for _, tt := range tests {
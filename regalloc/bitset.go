@@ -0,0 +1,57 @@
+package regalloc
+
+import (
+	"math/bits"
+
+	"github.com/rj45/gosling/ir"
+)
+
+// bitset is a set of values, by their IDs.
+type bitset []uint64
+
+func newBitset(size int) bitset {
+	return make(bitset, (size+63)/64)
+}
+
+func (s bitset) set(id ir.ValueID) {
+	s[id/64] |= 1 << (id % 64)
+}
+
+func (s bitset) has(id ir.ValueID) bool {
+	return s[id/64]&(1<<(id%64)) != 0
+}
+
+func (s bitset) clone() bitset {
+	return append(bitset(nil), s...)
+}
+
+func (s bitset) union(other bitset) {
+	for i := range s {
+		s[i] |= other[i]
+	}
+}
+
+func (s bitset) subtract(other bitset) {
+	for i := range s {
+		s[i] &^= other[i]
+	}
+}
+
+func (s bitset) equal(other bitset) bool {
+	for i := range s {
+		if s[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// each calls fn with each value in the set, in order.
+func (s bitset) each(fn func(ir.ValueID)) {
+	for i, word := range s {
+		for word != 0 {
+			fn(ir.ValueID(i*64 + bits.TrailingZeros64(word)))
+			word &= word - 1
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package regalloc
+
+import "github.com/rj45/gosling/ir"
+
+// Move copies the source register to the destination register.
+type Move struct {
+	Dst, Src ir.RegID
+}
+
+// Moves returns the moves which copy the operands of the Phi parameters
+// of the successor of the block into the registers of the parameters,
+// when the block jumps to its only successor. The parameters are copied
+// all at once, so the moves are ordered to read each register before it
+// is overwritten, and each cycle of moves is broken by copying one of
+// the registers to the scratch register first.
+func Moves(blk *ir.Block, scratch ir.RegID) []Move {
+	if blk.NumSuccessors() != 1 {
+		return nil
+	}
+
+	var pending []Move
+	phiOperands(blk, func(phi ir.Value, j int) {
+		dst, src := phi.Regs().Peek(), phi.Operand(j).Regs().Peek()
+		if dst != src {
+			pending = append(pending, Move{Dst: dst, Src: src})
+		}
+	})
+
+	var moves []Move
+	for len(pending) > 0 {
+		ready := -1
+		for i, m := range pending {
+			if !reads(pending, m.Dst) {
+				ready = i
+				break
+			}
+		}
+
+		if ready < 0 {
+			// every destination is still to be read, so the moves
+			// are in cycles
+			dst := pending[0].Dst
+			moves = append(moves, Move{Dst: scratch, Src: dst})
+			for i := range pending {
+				if pending[i].Src == dst {
+					pending[i].Src = scratch
+				}
+			}
+			continue
+		}
+
+		moves = append(moves, pending[ready])
+		pending = append(pending[:ready], pending[ready+1:]...)
+	}
+	return moves
+}
+
+// reads returns whether any of the moves reads the register.
+func reads(moves []Move, reg ir.RegID) bool {
+	for _, m := range moves {
+		if m.Src == reg {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package regalloc
+
+import (
+	"github.com/rj45/gosling/hlir"
+	"github.com/rj45/gosling/ir"
+	"github.com/rj45/gosling/llir"
+	"github.com/rj45/gosling/opt"
+)
+
+// AddPasses adds the passes which take the functions from their stack
+// operations to registers: they are put in SSA form, optimized at the
+// level of the pass manager, lowered for the target, and allocated the
+// registers of the config. The functions which defer calls are left to
+// be assembled from their stack operations, since they resume at their
+// deferreturn with the frame laid out by them.
+func AddPasses(pm *ir.PassManager, target *llir.Target, config *Config) {
+	pm.AddFuncPass("ssa", func(fn *ir.Func) error {
+		if !fn.HasOp(hlir.Defer, hlir.DeferReturn) {
+			hlir.BuildSSA(fn)
+		}
+		return nil
+	})
+	opt.AddPasses(pm, pm.OptLevel)
+	pm.AddFuncPass("lower", func(fn *ir.Func) error {
+		if fn.SSA {
+			llir.Lower(fn, target)
+		}
+		return nil
+	})
+	pm.AddFuncPass("regalloc", func(fn *ir.Func) error {
+		if !fn.SSA {
+			return nil
+		}
+		return Allocate(fn, config)
+	})
+}
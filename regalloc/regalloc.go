@@ -0,0 +1,631 @@
+// Package regalloc allocates registers to the values of a function in
+// LLIR, with a linear scan over the live intervals of the values.
+package regalloc
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/rj45/gosling/ir"
+	"github.com/rj45/gosling/llir"
+	"github.com/rj45/gosling/types"
+)
+
+// Config describes the registers of the target.
+type Config struct {
+	// Regs are the registers which values can be allocated to.
+	Regs ir.RegMask
+
+	// CallerSaved are the registers which calls may overwrite, so
+	// values which are live across a call are not allocated to them.
+	CallerSaved ir.RegMask
+
+	// Class, if it is set, returns the registers of Regs which values
+	// of the type can be allocated to, for targets which keep some
+	// kinds of values in registers of their own.
+	Class func(types.Type) ir.RegMask
+}
+
+// Allocate allocates a register from the config to every value of the
+// function which has a result, in place. The function must be in LLIR.
+//
+// When there are not enough registers, the interval of a value is
+// split where its register is taken: the value keeps the register for
+// its uses before the split which can't be reached from after it, and
+// it is stored to a new local with a Spill right after it is defined.
+// Its other uses are split into intervals of their own, one for each
+// run of uses in a block, which load the value from the local with a
+// Reload before the first use of the run. Values without a type, like
+// the flags of a Cmp, are not allocated.
+//
+// Critical edges into blocks with Phi parameters are split, so the
+// parameters can be copied with the Moves at the end of each
+// predecessor. An error is returned if there are more values in use at
+// once than there are registers.
+func Allocate(fn *ir.Func, config *Config) error {
+	if fn.Extern {
+		return nil
+	}
+
+	fn.SplitCriticalEdges(llir.Jump, hasPhis)
+
+	a := &allocator{fn: fn, config: config}
+	a.number()
+	a.liveness()
+	a.buildIntervals()
+	if err := a.scan(); err != nil {
+		return err
+	}
+	a.rewrite()
+	return nil
+}
+
+func hasPhis(blk *ir.Block) bool {
+	for i := 0; i < blk.NumParams(); i++ {
+		if blk.Param(i).Op() == ir.Phi {
+			return true
+		}
+	}
+	return false
+}
+
+// allocated returns whether the value is allocated a register.
+func allocated(v ir.Value) bool {
+	return !v.IsNil() && !v.IsConstant() && v.Type() != types.Void && v.Type() != types.None
+}
+
+// isCall returns whether the op calls a function, which may overwrite
+// the caller saved registers.
+func isCall(op ir.Op) bool {
+	switch op {
+	case llir.Call, llir.CallExtern, llir.Go, llir.Defer, llir.DeferReturn,
+		llir.MakeMap, llir.MapIndex, llir.MapIndexOk, llir.MapAssign, llir.MapDelete,
+		llir.MapLen, llir.MakeChan, llir.ChanSend, llir.ChanRecv, llir.ChanRecv2,
+		llir.ChanClose, llir.Select, llir.Panic, llir.Recover:
+		return true
+	}
+	return false
+}
+
+// Positions number the points in the function where values are used
+// and defined. Each value, and the parameters and the terminator of
+// each block, take four positions: the operands are read at the first,
+// and the result is defined at the third. Values inserted for spills
+// and reloads take the positions in between.
+
+// use is an operand of a value, or of a Phi, which comes from the end
+// of the predecessor.
+type use struct {
+	user  ir.Value
+	index int
+	pos   int
+
+	// the predecessor the operand of a Phi comes from
+	pred ir.BlockID
+}
+
+// interval is the range of positions a value is live over, without
+// holes, so it is allocated one register for all of it. The interval
+// of a reload has no value, and covers a run of uses in a block.
+type interval struct {
+	v          ir.Value
+	start, end int
+	def        int
+	uses       []use
+	reg        ir.RegID
+
+	// fixed intervals are short enough that they are never spilled
+	fixed bool
+
+	// the local a spilled value is stored to, or a reload loads from
+	slot int
+	typ  types.Type
+}
+
+// valueType returns the type of the value of the interval.
+func (iv *interval) valueType() types.Type {
+	if iv.v.IsNil() {
+		return iv.typ
+	}
+	return iv.v.Type()
+}
+
+type allocator struct {
+	fn     *ir.Func
+	config *Config
+
+	// the position of each value, and of the start and end of each block
+	pos        map[ir.ValueID]int
+	start, end []int
+
+	// the positions of the calls
+	calls []int
+
+	liveIn, liveOut []bitset
+
+	intervals map[ir.ValueID]*interval
+	unhandled []*interval
+	active    []*interval
+
+	spilled []*interval
+	reloads []*interval
+}
+
+// number gives each value its position, in the order of the blocks.
+func (a *allocator) number() {
+	fn := a.fn
+	a.pos = make(map[ir.ValueID]int)
+	a.start = make([]int, fn.NumBlocks())
+	a.end = make([]int, fn.NumBlocks())
+
+	p := 0
+	for b := 0; b < fn.NumBlocks(); b++ {
+		blk := fn.BlockAt(b)
+		a.start[b] = p
+		for i := 0; i < blk.NumParams(); i++ {
+			a.pos[blk.Param(i).ID()] = p
+		}
+		p += 4
+		for i := 0; i < blk.NumValues(); i++ {
+			if v := blk.ValueAt(i); !v.IsNil() {
+				a.pos[v.ID()] = p
+				if isCall(v.Op()) {
+					a.calls = append(a.calls, p)
+				}
+				p += 4
+			}
+		}
+		a.pos[blk.Terminator().ID()] = p
+		a.end[b] = p + 3
+		p += 4
+	}
+}
+
+// index returns the index of the block in the function.
+func (a *allocator) index(blk *ir.Block) int {
+	return int(blk.ID()) - 1
+}
+
+// phiOperands calls fn with the index of each operand of the Phi
+// parameters of the successors of the block, which come from the block.
+func phiOperands(blk *ir.Block, fn func(phi ir.Value, index int)) {
+	for s := 0; s < blk.NumSuccessors(); s++ {
+		succ := blk.Successor(s)
+		for j := 0; j < succ.NumPredecessors(); j++ {
+			if succ.Predecessor(j).ID() != blk.ID() {
+				continue
+			}
+			for i := 0; i < succ.NumParams(); i++ {
+				if phi := succ.Param(i); phi.Op() == ir.Phi {
+					fn(phi, j)
+				}
+			}
+		}
+	}
+}
+
+// liveness finds the values which are live at the start and end of
+// each block.
+func (a *allocator) liveness() {
+	fn := a.fn
+	n := fn.NumBlocks()
+	size := fn.NumValues() + 1
+
+	gen := make([]bitset, n)
+	kill := make([]bitset, n)
+	phis := make([]bitset, n)
+	a.liveIn = make([]bitset, n)
+	a.liveOut = make([]bitset, n)
+
+	for b := 0; b < n; b++ {
+		blk := fn.BlockAt(b)
+		gen[b], kill[b], phis[b] = newBitset(size), newBitset(size), newBitset(size)
+		a.liveIn[b], a.liveOut[b] = newBitset(size), newBitset(size)
+
+		read := func(v ir.Value) {
+			for i := 0; i < v.NumOperands(); i++ {
+				if op := v.Operand(i); allocated(op) && !kill[b].has(op.ID()) {
+					gen[b].set(op.ID())
+				}
+			}
+		}
+		for i := 0; i < blk.NumParams(); i++ {
+			kill[b].set(blk.Param(i).ID())
+		}
+		for i := 0; i < blk.NumValues(); i++ {
+			if v := blk.ValueAt(i); !v.IsNil() {
+				read(v)
+				if allocated(v) {
+					kill[b].set(v.ID())
+				}
+			}
+		}
+		read(blk.Terminator())
+		phiOperands(blk, func(phi ir.Value, j int) {
+			if op := phi.Operand(j); allocated(op) {
+				phis[b].set(op.ID())
+			}
+		})
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for b := n - 1; b >= 0; b-- {
+			blk := fn.BlockAt(b)
+			out := phis[b].clone()
+			for s := 0; s < blk.NumSuccessors(); s++ {
+				out.union(a.liveIn[a.index(blk.Successor(s))])
+			}
+			in := out.clone()
+			in.subtract(kill[b])
+			in.union(gen[b])
+
+			if !in.equal(a.liveIn[b]) || !out.equal(a.liveOut[b]) {
+				a.liveIn[b], a.liveOut[b] = in, out
+				changed = true
+			}
+		}
+	}
+}
+
+// buildIntervals finds the interval of each allocated value, which
+// covers its definition, its uses, and the blocks it is live through.
+func (a *allocator) buildIntervals() {
+	fn := a.fn
+	a.intervals = make(map[ir.ValueID]*interval)
+
+	get := func(v ir.Value) *interval {
+		iv, found := a.intervals[v.ID()]
+		if !found {
+			iv = &interval{v: v, start: -1, end: -1}
+			a.intervals[v.ID()] = iv
+			a.unhandled = append(a.unhandled, iv)
+		}
+		return iv
+	}
+	extend := func(v ir.Value, p int) {
+		iv := get(v)
+		if iv.start < 0 || p < iv.start {
+			iv.start = p
+		}
+		if p > iv.end {
+			iv.end = p
+		}
+	}
+	define := func(v ir.Value, p int) {
+		if allocated(v) {
+			extend(v, p)
+			get(v).def = p
+		}
+	}
+	read := func(v ir.Value, p int) {
+		for i := 0; i < v.NumOperands(); i++ {
+			if op := v.Operand(i); allocated(op) {
+				extend(op, p)
+				get(op).uses = append(get(op).uses, use{user: v, index: i, pos: p})
+			}
+		}
+	}
+
+	for b := 0; b < fn.NumBlocks(); b++ {
+		blk := fn.BlockAt(b)
+		a.liveIn[b].each(func(id ir.ValueID) {
+			extend(fn.Value(id), a.start[b])
+		})
+		a.liveOut[b].each(func(id ir.ValueID) {
+			extend(fn.Value(id), a.end[b])
+		})
+
+		for i := 0; i < blk.NumParams(); i++ {
+			define(blk.Param(i), a.start[b]+2)
+		}
+		for i := 0; i < blk.NumValues(); i++ {
+			if v := blk.ValueAt(i); !v.IsNil() {
+				read(v, a.pos[v.ID()])
+				define(v, a.pos[v.ID()]+2)
+			}
+		}
+		term := blk.Terminator()
+		read(term, a.pos[term.ID()])
+
+		// the parameters are copied after the terminator reads its operands
+		phiOperands(blk, func(phi ir.Value, j int) {
+			if op := phi.Operand(j); allocated(op) {
+				p := a.pos[term.ID()] + 1
+				extend(op, p)
+				get(op).uses = append(get(op).uses, use{user: phi, index: j, pos: p, pred: blk.ID()})
+			}
+		})
+	}
+
+	sort.SliceStable(a.unhandled, func(i, j int) bool {
+		return a.unhandled[i].start < a.unhandled[j].start
+	})
+}
+
+// scan allocates the intervals in the order they start.
+func (a *allocator) scan() error {
+	for len(a.unhandled) > 0 {
+		cur := a.unhandled[0]
+		a.unhandled = a.unhandled[1:]
+
+		// free the registers of the intervals which have ended
+		active := a.active[:0]
+		for _, iv := range a.active {
+			if iv.end >= cur.start {
+				active = append(active, iv)
+			}
+		}
+		a.active = active
+
+		if err := a.allocate(cur); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add adds an interval to the unhandled intervals, in order.
+func (a *allocator) add(iv *interval) {
+	i := sort.Search(len(a.unhandled), func(i int) bool {
+		return a.unhandled[i].start > iv.start
+	})
+	a.unhandled = append(a.unhandled, nil)
+	copy(a.unhandled[i+1:], a.unhandled[i:])
+	a.unhandled[i] = iv
+}
+
+// crossesCall returns whether the value of the interval must survive
+// a call.
+func (a *allocator) crossesCall(iv *interval) bool {
+	for _, p := range a.calls {
+		if iv.start < p && p < iv.end {
+			return true
+		}
+	}
+	return false
+}
+
+// allocate allocates a free register to the interval, spilling the
+// interval which lives the longest until one is free.
+func (a *allocator) allocate(cur *interval) error {
+	for {
+		allowed := a.config.Regs
+		if a.config.Class != nil {
+			allowed = allowed.Intersect(a.config.Class(cur.valueType()))
+		}
+		if a.crossesCall(cur) {
+			allowed &^= a.config.CallerSaved
+		}
+
+		free := allowed
+		for _, iv := range a.active {
+			free.ClearReg(iv.reg)
+		}
+		if !free.IsEmpty() {
+			// prefer the registers which don't need to be saved
+			if regs := free.Intersect(a.config.CallerSaved); !regs.IsEmpty() && !a.crossesCall(cur) {
+				free = regs
+			}
+			cur.reg = free.Peek()
+			a.active = append(a.active, cur)
+			return nil
+		}
+
+		var victim *interval
+		for _, iv := range a.active {
+			if !iv.fixed && allowed.HasReg(iv.reg) && (victim == nil || iv.end > victim.end) {
+				victim = iv
+			}
+		}
+		if !cur.fixed && (victim == nil || cur.end >= victim.end) {
+			a.spill(cur, cur.start)
+			return nil
+		}
+		if victim == nil {
+			return fmt.Errorf("%s: out of registers", a.fn.Name)
+		}
+		a.spill(victim, cur.start)
+	}
+}
+
+// spill spills the value of the interval at position p, where its
+// register is taken. The value keeps the register for the uses before
+// p which can't be reached from p, as nothing else has the register
+// before p, and its other uses are reloaded from the local it is
+// spilled to.
+func (a *allocator) spill(iv *interval, p int) {
+	for i, act := range a.active {
+		if act == iv {
+			a.active = append(a.active[:i], a.active[i+1:]...)
+			break
+		}
+	}
+
+	if iv.v.IsNil() {
+		a.splitReload(iv, p)
+		return
+	}
+
+	reach := a.reachable(p)
+	var kept, rest []use
+	for _, u := range iv.uses {
+		if u.pos < p && !reach[a.block(u)] {
+			kept = append(kept, u)
+		} else {
+			rest = append(rest, u)
+		}
+	}
+	iv.uses = kept
+	if len(rest) > 0 {
+		iv.slot = a.newSlot(iv.v.Type())
+		a.spilled = append(a.spilled, iv)
+		for len(rest) > 0 {
+			// the runs of uses in a block are split at p
+			n := 1
+			for n < len(rest) && a.block(rest[n]) == a.block(rest[0]) && (rest[n].pos < p) == (rest[0].pos < p) {
+				n++
+			}
+			a.reload(iv, rest[:n:n], p)
+			rest = rest[n:]
+		}
+	}
+
+	if iv.def >= p {
+		// the definition is still to be allocated
+		iv.start, iv.end, iv.fixed = iv.def, iv.def+1, true
+		a.add(iv)
+	}
+}
+
+// splitReload splits the interval of a reload at p. The uses before p
+// keep the register, as the reload and its uses are in one block, and
+// the rest are reloaded again. A reload spilled before its first use
+// is split into a reload for each use, which are never spilled.
+func (a *allocator) splitReload(iv *interval, p int) {
+	n := 0
+	for n < len(iv.uses) && iv.uses[n].pos < p {
+		n++
+	}
+	kept, rest := iv.uses[:n:n], iv.uses[n:]
+
+	if len(kept) == 0 {
+		iv.uses = nil
+		for _, u := range rest {
+			a.reload(iv, []use{u}, p)
+		}
+		return
+	}
+
+	iv.uses, iv.end = kept, kept[n-1].pos
+	if len(rest) > 0 {
+		a.reload(iv, rest, p)
+	}
+}
+
+// reload makes the interval of a reload of the value of the interval
+// for a run of uses in a block. If the uses are before p, the reload
+// has the register the interval had, which was not used for anything
+// else.
+func (a *allocator) reload(iv *interval, uses []use, p int) {
+	// reloads start before the value they are for, along with
+	// the reloads of its other operands
+	r := &interval{
+		start: uses[0].pos&^3 - 1,
+		end:   uses[len(uses)-1].pos,
+		uses:  uses,
+		fixed: len(uses) == 1,
+		slot:  iv.slot,
+		typ:   iv.valueType(),
+	}
+	a.reloads = append(a.reloads, r)
+	if r.end < p {
+		r.reg = iv.reg
+	} else {
+		a.add(r)
+	}
+}
+
+// reachable returns whether each block can be reached from the blocks
+// at or after p, where a register which is taken at p may have been
+// overwritten.
+func (a *allocator) reachable(p int) []bool {
+	fn := a.fn
+	reach := make([]bool, fn.NumBlocks())
+	var work []*ir.Block
+	for b := fn.NumBlocks() - 1; b >= 0 && a.end[b] >= p; b-- {
+		work = append(work, fn.BlockAt(b))
+	}
+	for len(work) > 0 {
+		blk := work[len(work)-1]
+		work = work[:len(work)-1]
+		for s := 0; s < blk.NumSuccessors(); s++ {
+			if succ := blk.Successor(s); !reach[a.index(succ)] {
+				reach[a.index(succ)] = true
+				work = append(work, succ)
+			}
+		}
+	}
+	return reach
+}
+
+// block returns the index of the block of the use, which for an
+// operand of a Phi is the predecessor it comes from.
+func (a *allocator) block(u use) int {
+	if u.user.Op() == ir.Phi {
+		return a.index(a.fn.Block(u.pred))
+	}
+	return a.index(u.user.Block())
+}
+
+// newSlot adds a local for a spilled value to the frame.
+func (a *allocator) newSlot(typ types.Type) int {
+	n := a.fn.NumLocals()
+	locals := make([]types.Type, 0, n+1)
+	for i := 0; i < n; i++ {
+		locals = append(locals, a.fn.LocalType(i))
+	}
+	a.fn.SetLocals(append(locals, typ))
+	return n
+}
+
+// rewrite sets the registers of the values, and inserts the spills
+// and reloads.
+func (a *allocator) rewrite() {
+	fn := a.fn
+	for _, iv := range a.intervals {
+		iv.v.SetRegs(ir.NewRegMask(iv.reg))
+	}
+
+	for _, iv := range a.spilled {
+		blk := iv.v.Block()
+		index := 0
+		if iv.v.Op() == ir.Phi || iv.v.Op() == ir.Arg {
+			// parameters are spilled at the start of the block, but
+			// after the prologue makes the frame
+			if blk.NumValues() > 0 && blk.ValueAt(0).Op() == llir.Prologue {
+				index = 1
+			}
+		} else {
+			index = blk.IndexOf(iv.v) + 1
+		}
+		blk.InsertValue(index, llir.Spill, iv.v.Token(), types.Void, iv.v, a.slot(iv))
+	}
+
+	for _, r := range a.reloads {
+		if len(r.uses) == 0 {
+			// split into reloads for each use
+			continue
+		}
+
+		// the reload is right before the first of its uses
+		u := r.uses[0]
+		blk := u.user.Block()
+		var index int
+		switch {
+		case u.user.Op() == ir.Phi:
+			blk = fn.Block(u.pred)
+			index = blk.NumValues()
+		case u.user.ID() == blk.Terminator().ID():
+			index = blk.NumValues()
+		default:
+			index = blk.IndexOf(u.user)
+		}
+		reload := blk.InsertValue(index, llir.Reload, u.user.Token(), r.typ, a.slot(r))
+		reload.SetRegs(ir.NewRegMask(r.reg))
+		for _, u := range r.uses {
+			u.user.SetOperand(u.index, reload)
+		}
+	}
+
+	if len(a.spilled) > 0 {
+		entry := fn.BlockAt(0)
+		if prologue := entry.ValueAt(0); !prologue.IsNil() && prologue.Op() == llir.Prologue {
+			prologue.SetOperand(0, fn.ValueForConst(ir.IntConst(int64(fn.NumLocals()))))
+		}
+	}
+}
+
+func (a *allocator) slot(iv *interval) ir.Value {
+	return a.fn.ValueForConst(ir.IntConst(int64(iv.slot)))
+}
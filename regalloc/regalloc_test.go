@@ -0,0 +1,603 @@
+package regalloc_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rj45/gosling/codegen"
+	"github.com/rj45/gosling/hlir"
+	"github.com/rj45/gosling/ir"
+	"github.com/rj45/gosling/llir"
+	"github.com/rj45/gosling/parser"
+	"github.com/rj45/gosling/regalloc"
+	"github.com/rj45/gosling/semantics"
+	"github.com/rj45/gosling/token"
+	"github.com/rj45/gosling/types"
+)
+
+var target = &llir.Target{
+	WordSize:  8,
+	MinImm:    0,
+	MaxImm:    255,
+	MinOffset: -256,
+	MaxOffset: 255,
+}
+
+// config is a machine with four registers, of which calls overwrite
+// the first two.
+var config = &regalloc.Config{
+	Regs:        ir.NewRegMask(0, 1, 2, 3),
+	CallerSaved: ir.NewRegMask(0, 1),
+}
+
+// scratch is the register the moves of cycles go through.
+const scratch = ir.RegID(7)
+
+var tests = []struct {
+	name string
+	src  string
+	ir   string
+}{
+	{
+		name: "registers",
+		src: `
+			func main() int {
+				a := 1
+				b := a + 2
+				return a * b
+			}
+		`,
+		ir: `
+			func main() int {
+			main.entry0: // 2:4
+				Prologue 2 // 2:4
+				r0 = LoadImm 1 // 4:12
+				r0 = Add r0, 2 // 4:12
+				r1 = LoadImm 1 // 5:14
+				r0 = Mul r1, r0 // 5:14
+				Jump main.epilogue0 // 5:5
+			main.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return r0 // 2:4
+			}
+		`,
+	},
+	{
+		name: "live across a call",
+		src: `
+			func one() int {
+				return 1
+			}
+			func main() int {
+				a := one()
+				return one() + a
+			}
+		`,
+		ir: `
+			func one() int {
+			one.entry0: // 2:4
+				Prologue 0 // 2:4
+				Jump one.epilogue0 // 3:5
+			one.epilogue0: // 2:4
+				Epilogue // 2:4
+				r0 = LoadImm 1 // 2:4
+				Return r0 // 2:4
+			}
+
+			func main() int {
+			main.entry0: // 5:4
+				Prologue 1 // 5:4
+				r2 = Call one // 6:15
+				r0 = Call one // 7:18
+				r0 = Add r0, r2 // 7:18
+				Jump main.epilogue0 // 7:5
+			main.epilogue0: // 5:4
+				Epilogue // 5:4
+				Return r0 // 5:4
+			}
+		`,
+	},
+	{
+		name: "spills",
+		src: `
+			func sum(a int, b int, c int) int {
+				d := a * b
+				e := b * c
+				return a + b + c + d + e + a + b + c + d + e
+			}
+		`,
+		ir: `
+			func sum(r0 int, r1 int, r2 int) int {
+			sum.entry0: // 2:4
+				r0 = Arg 0 // 2:4
+				r1 = Arg 1 // 2:4
+				r2 = Arg 2 // 2:4
+				Prologue 8 // 2:4
+				Spill r2, 7 // 2:4
+				r3 = Mul r0, r1 // 3:12
+				Spill r3, 6 // 3:12
+				r3 = Mul r1, r2 // 4:12
+				Spill r3, 5 // 4:12
+				r3 = Add r0, r1 // 5:14
+				r3 = Add r3, r2 // 5:18
+				r2 = Reload 6 // 5:22
+				r2 = Add r3, r2 // 5:22
+				r3 = Reload 5 // 5:26
+				r2 = Add r2, r3 // 5:26
+				r0 = Add r2, r0 // 5:30
+				r0 = Add r0, r1 // 5:34
+				r1 = Reload 7 // 5:38
+				r0 = Add r0, r1 // 5:38
+				r1 = Reload 6 // 5:42
+				r0 = Add r0, r1 // 5:42
+				r0 = Add r0, r3 // 5:46
+				Jump sum.epilogue0 // 5:5
+			sum.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return r0 // 2:4
+			}
+		`,
+	},
+	{
+		name: "split in a loop",
+		src: `
+			func f(a int, b int, c int) int {
+				s := a
+				for i := 0; i < 3; i = i + 1 {
+					s = s + a
+					d := s * b
+					e := s * c
+					s = d + e + a
+				}
+				return s + a + b + c
+			}
+		`,
+		ir: `
+			func f(r0 int, r1 int, r2 int) int {
+			f.entry0: // 2:4
+				r0 = Arg 0 // 2:4
+				r1 = Arg 1 // 2:4
+				r2 = Arg 2 // 2:4
+				Prologue 11 // 2:4
+				Spill r0, 9 // 2:4
+				Spill r1, 8 // 2:4
+				Spill r2, 7 // 2:4
+				r3 = LoadImm 0 // 2:4
+				Jump loop0 // 2:4
+			loop0: <- f.entry0, loopbody0 // 4:5
+				r3 = Phi r3, r1 // 4:5
+				r2 = Phi r0, r0 // 4:5
+				Spill r2, 10 // 4:5
+				v107 none = Cmp r3, 3 // 4:19
+				BranchLt v107, loopbody0, endloop0 // 4:5
+			loopbody0: // 4:5
+				r0 = Reload 9 // 5:12
+				r2 = Reload 10 // 5:12
+				r1 = Add r2, r0 // 5:12
+				r0 = Reload 8 // 6:13
+				r0 = Mul r1, r0 // 6:13
+				r2 = Reload 7 // 7:13
+				r1 = Mul r1, r2 // 7:13
+				r0 = Add r0, r1 // 8:12
+				r1 = Reload 9 // 8:16
+				r0 = Add r0, r1 // 8:16
+				r1 = Add r3, 1 // 4:30
+				Jump loop0 // 4:5
+			endloop0: // 4:5
+				r0 = Reload 9 // 10:14
+				r1 = Reload 10 // 10:14
+				r0 = Add r1, r0 // 10:14
+				r1 = Reload 8 // 10:18
+				r0 = Add r0, r1 // 10:18
+				r1 = Reload 7 // 10:22
+				r0 = Add r0, r1 // 10:22
+				Jump f.epilogue0 // 10:5
+			f.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return r0 // 2:4
+			}
+		`,
+	},
+	{
+		name: "critical edges",
+		src: `
+			func abs(a int) int {
+				if a < 0 {
+					a = -a
+				}
+				return a
+			}
+		`,
+		ir: `
+			func abs(r0 int) int {
+			abs.entry0: // 2:4
+				r0 = Arg 0 // 2:4
+				Prologue 1 // 2:4
+				v25 none = Cmp r0, 0 // 3:10
+				BranchLt v25, then0, abs.entry0.endif0 // 3:5
+			then0: // 3:5
+				r1 = Neg r0 // 4:10
+				Jump endif0 // 3:5
+			endif0: <- abs.entry0.endif0, then0 // 3:5
+				r1 = Phi r0, r1 // 3:5
+				Jump abs.epilogue0 // 6:5
+			abs.epilogue0: // 2:4
+				Epilogue // 2:4
+				Return r1 // 2:4
+			abs.entry0.endif0: // 3:5
+				Jump endif0 // 3:5
+			}
+		`,
+	},
+}
+
+func TestAllocate(t *testing.T) {
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			program := build(t, test.src)
+			actual := program.Dump()
+
+			if trim(actual) != trim(test.ir) {
+				t.Errorf("expected:\n%s\nactual:\n%s", test.ir, actual)
+			}
+		})
+	}
+}
+
+// TestAllocateClass allocates maps to a register of their own, like
+// the VM does for heap references, and checks no other values share it.
+func TestAllocateClass(t *testing.T) {
+	config := &regalloc.Config{
+		Regs: ir.NewRegMask(0, 1, 2, 3),
+		Class: func(typ types.Type) ir.RegMask {
+			if typ.Kind() == types.MapType {
+				return ir.NewRegMask(3)
+			}
+			return ir.NewRegMask(0, 1, 2)
+		},
+	}
+	program := buildWith(t, `
+		func main() int {
+			m := make(map[int]int)
+			n := 1
+			m[n] = 2
+			k := make(map[int]int)
+			k[2] = m[1]
+			return m[1] + n + k[2]
+		}
+	`, config)
+
+	fn := program.FuncNamed("main")
+	for i := 0; i < fn.NumValues(); i++ {
+		v := fn.ValueAt(i)
+		if v.Regs().IsEmpty() {
+			continue
+		}
+		if isMap := v.Type().Kind() == types.MapType; isMap != v.Regs().HasReg(3) {
+			t.Errorf("expected only maps in r3, but %s is in %s\n%s", v, v.Regs(), fn.Dump())
+		}
+	}
+}
+
+var runTests = []struct {
+	name   string
+	src    string
+	output int64
+}{
+	{
+		name: "recursion",
+		src: `
+			func fib(n int) int {
+				if n < 2 {
+					return n
+				}
+				return fib(n-1) + fib(n-2)
+			}
+			func main() int {
+				return fib(15)
+			}
+		`,
+		output: 610,
+	},
+	{
+		name: "pressure",
+		src: `
+			func calc(a int, b int, c int) int {
+				x := a * b
+				y := b * c
+				z := a * c
+				w := a + c
+				return x + y + z + w + x*y - z*w + a + b + c
+			}
+			func main() int {
+				return calc(1, 2, 3)
+			}
+		`,
+		output: 2 + 6 + 3 + 4 + 12 - 12 + 6,
+	},
+	{
+		name: "swap in a loop",
+		src: `
+			func main() int {
+				a := 1
+				b := 2
+				for i := 0; i < 5; i = i + 1 {
+					t := a
+					a = b
+					b = t
+				}
+				return a*10 + b
+			}
+		`,
+		output: 21,
+	},
+	{
+		name: "live across calls in a loop",
+		src: `
+			func add(a int, b int) int {
+				return a + b
+			}
+			func main() int {
+				a := 0
+				b := 1
+				c := 2
+				for i := 0; i < 10; i = i + 1 {
+					a = add(a, b)
+					b = add(b, c)
+					c = add(c, i)
+				}
+				return a + b + c
+			}
+		`,
+		output: 498,
+	},
+	{
+		name: "split in a loop",
+		src: `
+			func f(a int, b int, c int) int {
+				s := a
+				for i := 0; i < 3; i = i + 1 {
+					s = s + a
+					d := s * b
+					e := s * c
+					s = d + e + a
+				}
+				return s + a + b + c
+			}
+			func main() int {
+				return f(1, 2, 3)
+			}
+		`,
+		output: 317,
+	},
+	{
+		name: "address taken",
+		src: `
+			func set(p *int, v int) int {
+				*p = v
+				return 0
+			}
+			func main() int {
+				x := 1
+				y := 2
+				set(&x, y+5)
+				if x > y {
+					y = x * 3
+				}
+				return x + y
+			}
+		`,
+		output: 28,
+	},
+}
+
+// TestRun runs the allocated functions on a machine which overwrites
+// the caller saved registers across calls, so values which are live
+// across a call are only correct if they are allocated properly.
+func TestRun(t *testing.T) {
+	for _, test := range runTests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			program := build(t, test.src)
+
+			m := &machine{regs: map[ir.RegID]int64{}, mem: map[int64]int64{}, sp: 1 << 20}
+			actual := m.call(program.FuncNamed("main"), nil)
+			if actual != test.output {
+				t.Errorf("expected: %d; but got: %d\n%s", test.output, actual, program.Dump())
+			}
+		})
+	}
+}
+
+// poison is the value of registers which have been overwritten.
+const poison = -0x5eed
+
+type machine struct {
+	regs map[ir.RegID]int64
+	mem  map[int64]int64
+	sp   int64
+}
+
+func (m *machine) call(fn *ir.Func, args []int64) int64 {
+	// the callee saved registers are restored on return
+	saved := map[ir.RegID]int64{}
+	for _, reg := range (config.Regs &^ config.CallerSaved).Regs() {
+		saved[reg] = m.regs[reg]
+	}
+
+	fp := m.sp
+	m.sp -= int64(fn.NumLocals()) * 8
+	defer func() {
+		m.sp = fp
+		for reg, val := range saved {
+			m.regs[reg] = val
+		}
+		for _, reg := range config.CallerSaved.Regs() {
+			m.regs[reg] = poison
+		}
+	}()
+
+	get := func(v ir.Value) int64 {
+		if v.IsConstant() {
+			c, _ := ir.Int64Value(v.Constant())
+			return c
+		}
+		return m.regs[v.Regs().Peek()]
+	}
+	set := func(v ir.Value, val int64) {
+		m.regs[v.Regs().Peek()] = val
+	}
+	slot := func(v ir.Value) int64 {
+		return fp - (get(v)+1)*8
+	}
+
+	// the flags of each Cmp
+	flags := map[ir.ValueID][2]int64{}
+
+	blk := fn.BlockAt(0)
+	for i := 0; i < blk.NumParams(); i++ {
+		arg := blk.Param(i)
+		set(arg, args[get(arg.Operand(0))])
+	}
+
+	for {
+		for i := 0; i < blk.NumValues(); i++ {
+			v := blk.ValueAt(i)
+			if v.IsNil() {
+				continue
+			}
+
+			var ops []int64
+			for j := 0; j < v.NumOperands(); j++ {
+				if v.Op() != llir.Call || j > 0 {
+					ops = append(ops, get(v.Operand(j)))
+				}
+			}
+
+			switch v.Op() {
+			case llir.Prologue, llir.Epilogue:
+			case llir.LoadImm, llir.Copy:
+				set(v, ops[0])
+			case llir.FramePtr:
+				set(v, fp)
+			case llir.Load:
+				set(v, m.mem[ops[0]+ops[1]])
+			case llir.Store:
+				m.mem[ops[1]+ops[2]] = ops[0]
+			case llir.Spill:
+				m.mem[slot(v.Operand(1))] = ops[0]
+			case llir.Reload:
+				set(v, m.mem[slot(v.Operand(0))])
+			case llir.Add:
+				set(v, ops[0]+ops[1])
+			case llir.Sub:
+				set(v, ops[0]-ops[1])
+			case llir.Mul:
+				set(v, ops[0]*ops[1])
+			case llir.Div:
+				set(v, ops[0]/ops[1])
+			case llir.Neg:
+				set(v, -ops[0])
+			case llir.Cmp:
+				flags[v.ID()] = [2]int64{ops[0], ops[1]}
+			case llir.SetEq, llir.SetNe, llir.SetLt, llir.SetLe, llir.SetGt, llir.SetGe:
+				if compare(v.Op(), flags[v.OperandID(0)]) {
+					set(v, 1)
+				} else {
+					set(v, 0)
+				}
+			case llir.Call:
+				callee, _ := ir.FuncValue(v.Operand(0).Constant())
+				set(v, m.call(callee, ops))
+			default:
+				panic("unsupported op " + v.Op().String())
+			}
+		}
+
+		term := blk.Terminator()
+		switch term.Op() {
+		case llir.Jump:
+			for _, move := range regalloc.Moves(blk, scratch) {
+				m.regs[move.Dst] = m.regs[move.Src]
+			}
+			blk = blk.Successor(0)
+		case llir.Return:
+			return get(term.Operand(0))
+		default:
+			if compare(term.Op(), flags[term.OperandID(0)]) {
+				blk = blk.Successor(0)
+			} else {
+				blk = blk.Successor(1)
+			}
+		}
+	}
+}
+
+func compare(op ir.Op, flags [2]int64) bool {
+	a, b := flags[0], flags[1]
+	switch op {
+	case llir.SetEq, llir.BranchEq:
+		return a == b
+	case llir.SetNe, llir.BranchNe:
+		return a != b
+	case llir.SetLt, llir.BranchLt:
+		return a < b
+	case llir.SetLe, llir.BranchLe:
+		return a <= b
+	case llir.SetGt, llir.BranchGt:
+		return a > b
+	case llir.SetGe, llir.BranchGe:
+		return a >= b
+	}
+	panic("unsupported op " + op.String())
+}
+
+func build(t *testing.T, src string) *ir.Program {
+	return buildWith(t, src, config)
+}
+
+func buildWith(t *testing.T, src string, config *regalloc.Config) *ir.Program {
+	file := token.NewFile("test.gos", []byte(src))
+
+	parser := parser.New(file)
+	ast, errs := parser.Parse()
+	for _, err := range errs {
+		t.Error(err)
+	}
+
+	tc := semantics.NewTypeChecker(ast)
+
+	symtab, errs := tc.Check(ast.Root())
+	for _, err := range errs {
+		t.Error(err)
+	}
+
+	asm := hlir.NewBuilder(file.FileSet())
+
+	gen := codegen.New(ast, symtab, tc.Universe(), asm)
+	gen.Generate()
+
+	for i := 0; i < asm.Program.NumFuncs(); i++ {
+		fn := asm.Program.Func(i)
+		hlir.BuildSSA(fn)
+		llir.Lower(fn, target)
+		if err := regalloc.Allocate(fn, config); err != nil {
+			t.Fatal(err)
+		}
+		for _, err := range ir.Verify(fn) {
+			t.Error(err)
+		}
+	}
+	return asm.Program
+}
+
+func trim(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,122 @@
+package semantics
+
+import (
+	"github.com/rj45/gosling/ast"
+	"github.com/rj45/gosling/types"
+)
+
+// checkBuiltinCall checks calls to the built-in functions make, len, delete and close.
+func (tc *TypeChecker) checkBuiltinCall(node ast.NodeID, name string) {
+	args := tc.ast.Children(tc.ast.Child(node, ast.CallExprArgs))
+	for _, arg := range args {
+		if tc.ast.Type(arg) == types.None {
+			return
+		}
+	}
+
+	switch name {
+	case "make":
+		if len(args) < 1 || len(args) > 2 {
+			tc.errorf(node, "wrong number of arguments to make: expected 1 or 2, got %d", len(args))
+			return
+		}
+
+		typ := tc.ast.Type(args[0])
+		if !tc.isTypeExpr(args[0]) || (!tc.uni.IsMap(typ) && !tc.uni.IsChan(typ)) {
+			tc.errorf(node, "cannot make %s; type must be a map or channel", tc.uni.StringOf(typ))
+			return
+		}
+
+		if len(args) == 2 {
+			size := tc.ast.Type(args[1])
+			if tc.uni.Unify(size, types.Int) == types.None {
+				kind := "map size"
+				if tc.uni.IsChan(typ) {
+					kind = "channel buffer size"
+				}
+				tc.errorf(node, "%s must be int but was %s", kind, tc.uni.StringOf(size))
+				return
+			}
+			tc.ast.SetType(args[1], types.Int)
+		}
+
+		tc.ast.SetType(node, typ)
+
+	case "len":
+		if len(args) != 1 {
+			tc.errorf(node, "wrong number of arguments to len: expected 1, got %d", len(args))
+			return
+		}
+
+		typ := tc.ast.Type(args[0])
+		if tc.isTypeExpr(args[0]) || !tc.uni.IsMap(typ) {
+			tc.errorf(node, "invalid argument to len: %s", tc.uni.StringOf(typ))
+			return
+		}
+
+		tc.ast.SetType(node, types.Int)
+
+	case "delete":
+		if len(args) != 2 {
+			tc.errorf(node, "wrong number of arguments to delete: expected 2, got %d", len(args))
+			return
+		}
+
+		typ := tc.ast.Type(args[0])
+		if tc.isTypeExpr(args[0]) || !tc.uni.IsMap(typ) {
+			tc.errorf(node, "first argument to delete must be a map but was %s", tc.uni.StringOf(typ))
+			return
+		}
+		m := tc.uni.Map(typ)
+
+		key := tc.ast.Type(args[1])
+		if !tc.uni.IsAssignable(m.Key(), key) {
+			tc.errorf(node, "cannot use %s as %s map key", tc.uni.StringOf(key), tc.uni.StringOf(m.Key()))
+			return
+		}
+		tc.ast.SetType(args[1], m.Key())
+
+		tc.ast.SetType(node, types.Void)
+
+	case "close":
+		if len(args) != 1 {
+			tc.errorf(node, "wrong number of arguments to close: expected 1, got %d", len(args))
+			return
+		}
+
+		typ := tc.ast.Type(args[0])
+		if tc.isTypeExpr(args[0]) || !tc.uni.IsChan(typ) {
+			tc.errorf(node, "invalid argument to close: %s", tc.uni.StringOf(typ))
+			return
+		}
+
+		tc.ast.SetType(node, types.Void)
+
+	case "panic":
+		// there are no interfaces yet, so panic values are ints
+		if len(args) != 1 {
+			tc.errorf(node, "wrong number of arguments to panic: expected 1, got %d", len(args))
+			return
+		}
+
+		typ := tc.ast.Type(args[0])
+		if tc.isTypeExpr(args[0]) || !tc.uni.IsAssignable(types.Int, typ) {
+			tc.errorf(node, "cannot use %s as int in argument to panic", tc.uni.StringOf(typ))
+			return
+		}
+		tc.ast.SetType(args[0], types.Int)
+
+		tc.ast.SetType(node, types.Void)
+
+	case "recover":
+		if len(args) != 0 {
+			tc.errorf(node, "wrong number of arguments to recover: expected 0, got %d", len(args))
+			return
+		}
+
+		tc.ast.SetType(node, types.Int)
+
+	default:
+		panic("unknown builtin " + name)
+	}
+}
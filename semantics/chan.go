@@ -0,0 +1,114 @@
+package semantics
+
+import (
+	"github.com/rj45/gosling/ast"
+	"github.com/rj45/gosling/types"
+)
+
+func (tc *TypeChecker) checkChanType(node ast.NodeID) {
+	elem := tc.ast.Type(tc.ast.Child(node, ast.ChanTypeElem))
+	if elem == types.None {
+		return
+	}
+
+	tc.ast.SetType(node, tc.uni.ChanFor(elem))
+}
+
+func (tc *TypeChecker) checkRecvExpr(node ast.NodeID) {
+	ch := tc.ast.Child(node, ast.RecvExprChan)
+	typ := tc.ast.Type(ch)
+	if typ == types.None {
+		return
+	}
+
+	if tc.isTypeExpr(ch) || !tc.uni.IsChan(typ) {
+		tc.errorf(node, "cannot receive from non-chan type %s", tc.uni.StringOf(typ))
+		return
+	}
+
+	tc.ast.SetType(node, tc.uni.Chan(typ).Elem())
+}
+
+func (tc *TypeChecker) checkSendStmt(node ast.NodeID) {
+	ch := tc.ast.Child(node, ast.SendStmtChan)
+	value := tc.ast.Child(node, ast.SendStmtValue)
+
+	chType := tc.ast.Type(ch)
+	valueType := tc.ast.Type(value)
+	if chType == types.None || valueType == types.None {
+		return
+	}
+
+	if tc.isTypeExpr(ch) || !tc.uni.IsChan(chType) {
+		tc.errorf(node, "cannot send to non-chan type %s", tc.uni.StringOf(chType))
+		return
+	}
+	elem := tc.uni.Chan(chType).Elem()
+
+	if tc.isTypeExpr(value) || !tc.uni.IsAssignable(elem, valueType) {
+		tc.errorf(node, "cannot send %s on %s", tc.uni.StringOf(valueType), tc.uni.StringOf(chType))
+		return
+	}
+	tc.ast.SetType(value, elem)
+
+	tc.ast.SetType(node, types.Void)
+}
+
+func (tc *TypeChecker) checkGoStmt(node ast.NodeID) {
+	call := tc.ast.Child(node, ast.GoStmtCall)
+	if tc.ast.Kind(call) != ast.CallExpr {
+		// the parser has already reported this
+		return
+	}
+
+	sym := tc.callee(call)
+	if sym != nil && sym.Kind == ast.BuiltinSymbol {
+		tc.errorf(node, "cannot start goroutine with built-in function %s", sym.Name)
+		return
+	}
+	if sym != nil && sym.Extern {
+		tc.errorf(node, "cannot start goroutine with extern function %s", sym.Name)
+		return
+	}
+
+	tc.ast.SetType(node, types.Void)
+}
+
+func (tc *TypeChecker) checkSelectStmt(node ast.NodeID) {
+	defaults := 0
+	for _, clause := range tc.ast.Children(node) {
+		if tc.ast.Child(clause, ast.CommClauseComm) == ast.InvalidNode {
+			defaults++
+		}
+	}
+
+	if defaults > 1 {
+		tc.errorf(node, "multiple defaults in select")
+		return
+	}
+
+	tc.ast.SetType(node, types.Void)
+}
+
+func (tc *TypeChecker) checkCommClause(node ast.NodeID) {
+	comm := tc.ast.Child(node, ast.CommClauseComm)
+
+	valid := false
+	switch tc.ast.Kind(comm) {
+	case ast.IllegalNode, ast.SendStmt:
+		valid = true
+	case ast.ExprStmt:
+		valid = tc.ast.Kind(tc.ast.Child(comm, ast.ExprStmtExpr)) == ast.RecvExpr
+	case ast.AssignStmt:
+		lhs := tc.ast.Child(comm, ast.AssignStmtLHS)
+		rhs := tc.ast.Child(comm, ast.AssignStmtRHS)
+		valid = tc.ast.Kind(lhs) == ast.Name && tc.ast.Kind(rhs) == ast.RecvExpr
+	}
+
+	if !valid {
+		tc.errorf(node, "select case must be receive, send or assign recv")
+		return
+	}
+
+	tc.ast.SetType(node, types.Void)
+}
@@ -0,0 +1,184 @@
+package semantics
+
+import (
+	"math/big"
+
+	"github.com/rj45/gosling/ast"
+	"github.com/rj45/gosling/token"
+	"github.com/rj45/gosling/types"
+)
+
+// checkConstDecl checks each spec of a const declaration with its value of iota.
+func (tc *TypeChecker) checkConstDecl(node ast.NodeID) {
+	for i, spec := range tc.ast.Children(node) {
+		tc.iota = i
+		tc.check(spec)
+	}
+	tc.iota = -1
+}
+
+// defineConstSpec evaluates the value of a constant and defines it in the symtab.
+func (tc *TypeChecker) defineConstSpec(node ast.NodeID) {
+	name := tc.ast.Child(node, ast.ConstSpecName)
+	typNode := tc.ast.Child(node, ast.ConstSpecType)
+	value := tc.ast.Child(node, ast.ConstSpecValue)
+
+	tc.check(typNode)
+	tc.check(value)
+
+	if tc.symtab.Lookup(tc.ast.NodeString(name)) != nil {
+		tc.errorf(node, "cannot redefine %s", tc.ast.NodeString(name))
+		return
+	}
+
+	// the constant is defined even on error to avoid spurious undefined name errors
+	sym := tc.symtab.NewSymbol(tc.ast.NodeString(name), ast.ConstSymbol, types.None)
+
+	typ := tc.ast.Type(value)
+	if typ == types.None {
+		return
+	}
+
+	c := tc.ast.Const(value)
+	if c == nil {
+		tc.errorf(node, "value of constant %s is not constant", tc.ast.NodeString(name))
+		return
+	}
+
+	if typNode != ast.InvalidNode {
+		constType := tc.ast.Type(typNode)
+		if constType == types.None {
+			return
+		}
+
+		if !tc.isTypeExpr(typNode) {
+			tc.errorf(node, "%s is not a type", tc.ast.NodeString(typNode))
+			return
+		}
+
+		if under := tc.uni.Underlying(constType); under != types.Int && under != types.Bool {
+			tc.errorf(node, "invalid constant type %s", tc.uni.StringOf(constType))
+			return
+		}
+
+		if !tc.uni.IsAssignable(constType, typ) {
+			tc.errorf(node, "cannot use %s as %s value in constant declaration", tc.uni.StringOf(typ), tc.uni.StringOf(constType))
+			return
+		}
+
+		if v, ok := types.BigIntValue(c); ok && !v.IsInt64() {
+			tc.errorf(node, "constant %s overflows int", v)
+			return
+		}
+
+		tc.ast.SetType(value, constType)
+		typ = constType
+	}
+
+	sym.Type = typ
+	sym.Const = c
+}
+
+// foldBinaryExpr evaluates a binary expression with constant operands.
+func (tc *TypeChecker) foldBinaryExpr(node ast.NodeID) {
+	x := tc.ast.Const(tc.ast.Child(node, ast.BinaryExprLHS))
+	y := tc.ast.Const(tc.ast.Child(node, ast.BinaryExprRHS))
+	if x == nil || y == nil || tc.ast.Type(node) == types.None {
+		return
+	}
+
+	op := tc.ast.Token(node).Kind()
+
+	if xb, ok := types.BoolValue(x); ok {
+		yb, ok := types.BoolValue(y)
+		if !ok {
+			return
+		}
+		switch op {
+		case token.Eq:
+			tc.ast.SetConst(node, types.BoolConst(xb == yb))
+		case token.Ne:
+			tc.ast.SetConst(node, types.BoolConst(xb != yb))
+		}
+		return
+	}
+
+	xi, ok := types.BigIntValue(x)
+	if !ok {
+		return
+	}
+	yi, ok := types.BigIntValue(y)
+	if !ok {
+		return
+	}
+
+	switch op {
+	case token.Add:
+		tc.setIntConst(node, new(big.Int).Add(xi, yi))
+	case token.Sub:
+		tc.setIntConst(node, new(big.Int).Sub(xi, yi))
+	case token.Star:
+		tc.setIntConst(node, new(big.Int).Mul(xi, yi))
+	case token.Div:
+		if yi.Sign() == 0 {
+			// already reported
+			return
+		}
+		// integer division truncates towards zero
+		tc.setIntConst(node, new(big.Int).Quo(xi, yi))
+	case token.Eq:
+		tc.ast.SetConst(node, types.BoolConst(xi.Cmp(yi) == 0))
+	case token.Ne:
+		tc.ast.SetConst(node, types.BoolConst(xi.Cmp(yi) != 0))
+	case token.Lt:
+		tc.ast.SetConst(node, types.BoolConst(xi.Cmp(yi) < 0))
+	case token.Le:
+		tc.ast.SetConst(node, types.BoolConst(xi.Cmp(yi) <= 0))
+	case token.Gt:
+		tc.ast.SetConst(node, types.BoolConst(xi.Cmp(yi) > 0))
+	case token.Ge:
+		tc.ast.SetConst(node, types.BoolConst(xi.Cmp(yi) >= 0))
+	}
+}
+
+// foldUnaryExpr evaluates the negation of a constant.
+func (tc *TypeChecker) foldUnaryExpr(node ast.NodeID) {
+	x := tc.ast.Const(tc.ast.Child(node, ast.UnaryExprExpr))
+	if x == nil {
+		return
+	}
+
+	if xi, ok := types.BigIntValue(x); ok {
+		tc.setIntConst(node, new(big.Int).Neg(xi))
+	}
+}
+
+// setIntConst sets the value of an integer constant expression, which
+// must fit in an int if the expression is typed.
+func (tc *TypeChecker) setIntConst(node ast.NodeID, v *big.Int) {
+	if tc.ast.Type(node) != types.UntypedInt && !v.IsInt64() {
+		tc.errorf(node, "constant %s overflows int", v)
+		return
+	}
+	tc.ast.SetConst(node, types.BigIntConst(v))
+}
+
+// checkConstOverflow reports untyped constants which are used
+// as values but are too large to be represented by an int.
+func (tc *TypeChecker) checkConstOverflow(node ast.NodeID) {
+	if node == ast.InvalidNode || tc.ast.Kind(node) == ast.ConstDecl {
+		// constant declarations are checked where they are used
+		return
+	}
+
+	if c := tc.ast.Const(node); c != nil {
+		if v, ok := types.BigIntValue(c); ok && !v.IsInt64() {
+			tc.errorf(node, "constant %s overflows int", v)
+		}
+		return
+	}
+
+	for _, child := range tc.ast.Children(node) {
+		tc.checkConstOverflow(child)
+	}
+}
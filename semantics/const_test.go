@@ -0,0 +1,173 @@
+package semantics_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rj45/gosling/ast"
+	"github.com/rj45/gosling/types"
+)
+
+func TestTypeCheckingConsts(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		expected string
+		value    string
+		err      string
+	}{
+		{
+			name:     "iota counts const specs",
+			src:      "const (a = iota; b; c); c",
+			expected: "int constant",
+			value:    "2",
+		},
+		{
+			name:     "typed const",
+			src:      "const a int = 5; a",
+			expected: "int",
+			value:    "5",
+		},
+		{
+			name:     "omitted type and value are repeated",
+			src:      "const (a int = iota * 10; b; c); c",
+			expected: "int",
+			value:    "20",
+		},
+		{
+			name:     "arithmetic is folded",
+			src:      "const a = 7 / 2 - -3; a * 2",
+			expected: "int constant",
+			value:    "12",
+		},
+		{
+			name:     "division truncates towards zero",
+			src:      "-7 / 2",
+			expected: "int constant",
+			value:    "-3",
+		},
+		{
+			name:     "comparison is folded",
+			src:      "const a = 1 < 2; a == true",
+			expected: "bool",
+			value:    "true",
+		},
+		{
+			name:     "untyped constants have arbitrary precision",
+			src:      "const big = 10000000000 * 10000000000; big / 10000000000",
+			expected: "int constant",
+			value:    "10000000000",
+		},
+		{
+			name:     "untyped constants can overflow int before they are used",
+			src:      "const a = 9223372036854775807 * 4; const b = a / 8; b",
+			expected: "int constant",
+			value:    "4611686018427387903",
+		},
+		{
+			name:     "expression with variable is not folded",
+			src:      "x := 1; x + 1",
+			expected: "int",
+			value:    "",
+		},
+		{
+			name: "untyped constant overflows int when used",
+			src:  "const big = 10000000000 * 10000000000; a := big",
+			err:  "constant 100000000000000000000 overflows int",
+		},
+		{
+			name: "int literal overflows int",
+			src:  "99999999999999999999",
+			err:  "constant 99999999999999999999 overflows int",
+		},
+		{
+			name: "typed constant overflows int",
+			src:  "const a int = 10000000000 * 10000000000",
+			err:  "constant 100000000000000000000 overflows int",
+		},
+		{
+			name: "typed constant arithmetic overflows int",
+			src:  "const a int = 9223372036854775807; a + 1",
+			err:  "constant 9223372036854775808 overflows int",
+		},
+		{
+			name: "division by zero",
+			src:  "x := 1; x / 0",
+			err:  "invalid operation: division by zero",
+		},
+		{
+			name: "value must be constant",
+			src:  "x := 1; const a = x",
+			err:  "value of constant a is not constant",
+		},
+		{
+			name: "iota outside constant declaration",
+			src:  "iota",
+			err:  "cannot use iota outside constant declaration",
+		},
+		{
+			name: "cannot assign to constant",
+			src:  "const a = 1; a = 2",
+			err:  "cannot assign to constant a",
+		},
+		{
+			name: "cannot take address of constant",
+			src:  "const a = 1; &a",
+			err:  "cannot take address of constant a",
+		},
+		{
+			name: "invalid constant type",
+			src:  "const m map[int]int = 1",
+			err:  "invalid constant type map[int]int",
+		},
+		{
+			name: "value must be assignable to type",
+			src:  "const a bool = 1",
+			err:  "cannot use int constant as bool value in constant declaration",
+		},
+		{
+			name: "constant redefinition",
+			src:  "a := 1; const a = 2",
+			err:  "cannot redefine a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, uni, node, errs := parseStmt(t, tt.src)
+
+			if errs != nil {
+				for _, err := range errs {
+					if tt.err == "" {
+						t.Errorf("Expected no error, but got %s", errs)
+					} else if !strings.Contains(err.Error(), tt.err) {
+						t.Errorf("Expected error to contain %q, but got %q", tt.err, err)
+					}
+				}
+				return
+			}
+
+			if errs == nil && tt.err != "" {
+				t.Errorf("Expected error %q, but got none", tt.err)
+				return
+			}
+
+			expr := a.Child(node, ast.ExprStmtExpr)
+
+			actual := a.Type(expr)
+			if actual == types.None && tt.expected != "" {
+				t.Errorf("Expected type %q, but got none", tt.expected)
+			} else if uni.StringOf(actual) != tt.expected {
+				t.Errorf("Expected: %s\nBut got: %s", tt.expected, uni.StringOf(actual))
+			}
+
+			value := ""
+			if c := a.Const(expr); c != nil {
+				value = c.String()
+			}
+			if value != tt.value {
+				t.Errorf("Expected value %q, but got %q", tt.value, value)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package semantics
+
+import (
+	"math/big"
+
+	"github.com/rj45/gosling/ast"
+	"github.com/rj45/gosling/types"
+)
+
+// Exports are the exported declarations of a package, which are all
+// a package importing it needs to be checked and compiled separately.
+// Types are described independently of the universe of the package.
+type Exports struct {
+	Funcs  []ExportedFunc  `json:",omitempty"`
+	Consts []ExportedConst `json:",omitempty"`
+}
+
+// ExportedFunc is the signature of an exported function.
+type ExportedFunc struct {
+	Name   string
+	Type   *types.Desc
+	Extern bool `json:",omitempty"`
+}
+
+// ExportedConst is an exported constant, with its value in the
+// same form as a literal in the source.
+type ExportedConst struct {
+	Name  string
+	Type  *types.Desc
+	Value string
+}
+
+// Export returns the exports of a checked package.
+func (tc *TypeChecker) Export(pkg ast.NodeID) *Exports {
+	exports := &Exports{}
+	for _, sym := range tc.symtab.ScopeSymbols(tc.symtab.NodeScope(pkg)) {
+		if !ast.IsExported(sym.Name) {
+			continue
+		}
+
+		switch sym.Kind {
+		case ast.FuncSymbol:
+			exports.Funcs = append(exports.Funcs, ExportedFunc{
+				Name:   sym.Name,
+				Type:   tc.uni.Describe(sym.Type),
+				Extern: sym.Extern,
+			})
+		case ast.ConstSymbol:
+			exports.Consts = append(exports.Consts, ExportedConst{
+				Name:  sym.Name,
+				Type:  tc.uni.Describe(sym.Type),
+				Value: sym.Const.String(),
+			})
+		}
+	}
+	return exports
+}
+
+// Import defines a package which was checked separately, from its
+// exports, so that the packages being checked can import it.
+func (tc *TypeChecker) Import(path, name string, exports *Exports) {
+	scope := tc.symtab.NewScope()
+	defer tc.symtab.LeaveScope()
+
+	tc.packages[path] = pkgScope{name: name, scope: scope}
+
+	for _, fn := range exports.Funcs {
+		sym := tc.symtab.NewSymbol(fn.Name, ast.FuncSymbol, tc.uni.TypeOf(fn.Type))
+		sym.Extern = fn.Extern
+	}
+
+	for _, c := range exports.Consts {
+		sym := tc.symtab.NewSymbol(c.Name, ast.ConstSymbol, tc.uni.TypeOf(c.Type))
+		if sym.Type == types.Bool {
+			sym.Const = types.BoolConst(c.Value == "true")
+			continue
+		}
+		val, _ := new(big.Int).SetString(c.Value, 10)
+		sym.Const = types.BigIntConst(val)
+	}
+}
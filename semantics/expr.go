@@ -1,6 +1,8 @@
 package semantics
 
 import (
+	"math/big"
+
 	"github.com/rj45/gosling/ast"
 	"github.com/rj45/gosling/token"
 	"github.com/rj45/gosling/types"
@@ -31,6 +33,15 @@ func (tc *TypeChecker) checkExprChild(parent, child ast.NodeID) {
 			tc.ast.SetType(then, uniType)
 			tc.ast.SetType(els, uniType)
 		}
+
+	case ast.Name:
+		sym := tc.symtab.Lookup(tc.ast.NodeString(child))
+		if sym == nil || sym.Kind != ast.BuiltinSymbol {
+			return
+		}
+		if tc.ast.Kind(parent) != ast.CallExpr || tc.ast.Child(parent, ast.CallExprName) != child {
+			tc.errorf(child, "%s (built-in function) must be called", sym.Name)
+		}
 	}
 }
 
@@ -47,23 +58,42 @@ func (tc *TypeChecker) checkBinaryExpr(node ast.NodeID) {
 
 	switch tc.ast.Token(node).Kind() {
 	case token.Eq, token.Ne:
-		// todo: check if types are comparable and compatible
+		// todo: check if types are compatible
+		for _, typ := range []types.Type{lhs, rhs} {
+			if !tc.uni.IsComparable(typ) {
+				// there is no nil yet, which maps could be compared with
+				tc.errorf(node, "invalid operation: %s cannot be compared", tc.uni.StringOf(typ))
+				return
+			}
+		}
 		tc.ast.SetType(node, types.Bool)
+		tc.foldBinaryExpr(node)
 		return
 	case token.Lt, token.Gt, token.Le, token.Ge:
 		// todo: check if types are ordered and compatible
 		tc.ast.SetType(node, types.Bool)
+		tc.foldBinaryExpr(node)
 		return
 	}
 
 	uniType := tc.uni.Unify(lhs, rhs)
 	tc.ast.SetType(node, uniType)
-	tc.ast.SetType(node, uniType)
+
+	if tc.ast.Token(node).Kind() == token.Div {
+		y, ok := types.Int64Value(tc.ast.Const(tc.ast.Child(node, ast.BinaryExprRHS)))
+		if ok && y == 0 {
+			tc.errorf(node, "invalid operation: division by zero")
+			return
+		}
+	}
+
+	tc.foldBinaryExpr(node)
 }
 
 func (tc *TypeChecker) checkUnaryExpr(node ast.NodeID) {
 	child := tc.ast.Child(node, ast.UnaryExprExpr)
 	tc.ast.SetType(node, tc.ast.Type(child))
+	tc.foldUnaryExpr(node)
 }
 
 func (tc *TypeChecker) checkDerefExpr(node ast.NodeID) {
@@ -92,6 +122,11 @@ func (tc *TypeChecker) checkAddrExpr(node ast.NodeID) {
 		return
 	}
 
+	if tc.ast.Const(child) != nil {
+		tc.errorf(node, "cannot take address of constant %s", tc.ast.NodeString(child))
+		return
+	}
+
 	if typ.Indirections() >= 3 {
 		tc.errorf(node, "cannot take address of triple pointer type %s", tc.uni.StringOf(typ))
 		return
@@ -103,7 +138,12 @@ func (tc *TypeChecker) checkAddrExpr(node ast.NodeID) {
 func (tc *TypeChecker) checkCallExpr(node ast.NodeID) {
 	name := tc.ast.Child(node, ast.CallExprName)
 
-	sym := tc.symtab.Lookup(tc.ast.NodeString(name))
+	if tc.ast.Kind(name) == ast.SelectorExpr && tc.ast.Type(name) == types.None {
+		// the selector has already been reported
+		return
+	}
+
+	sym := tc.callee(node)
 	if sym == nil {
 		// remove the "undefined name" error
 		tc.errs = tc.errs[:len(tc.errs)-1]
@@ -111,10 +151,15 @@ func (tc *TypeChecker) checkCallExpr(node ast.NodeID) {
 		return
 	}
 
+	if sym.Kind == ast.BuiltinSymbol {
+		tc.checkBuiltinCall(node, sym.Name)
+		return
+	}
+
 	typ := sym.Type
 
 	if typ.Kind() != types.FuncType {
-		tc.errorf(node, "cannot call non-function %s of type %s", tc.ast.NodeString(name), tc.uni.StringOf(typ))
+		tc.errorf(node, "cannot call non-function %s of type %s", tc.nameOf(name), tc.uni.StringOf(typ))
 		return
 	}
 	fnTyp := tc.uni.Func(typ)
@@ -122,7 +167,7 @@ func (tc *TypeChecker) checkCallExpr(node ast.NodeID) {
 	argsNode := tc.ast.Child(node, ast.CallExprArgs)
 	args := tc.ast.Children(argsNode)
 	if len(args) != len(fnTyp.ParamTypes()) {
-		tc.errorf(node, "wrong number of arguments to %s: expected %d, got %d", tc.ast.NodeString(name), len(fnTyp.ParamTypes()), len(args))
+		tc.errorf(node, "wrong number of arguments to %s: expected %d, got %d", tc.nameOf(name), len(fnTyp.ParamTypes()), len(args))
 		return
 	}
 	for i, arg := range args {
@@ -130,6 +175,10 @@ func (tc *TypeChecker) checkCallExpr(node ast.NodeID) {
 		if typ == types.None {
 			continue
 		}
+		if tc.isTypeExpr(arg) {
+			tc.errorf(node, "%s is not an expression", tc.uni.StringOf(typ))
+			continue
+		}
 		uniType := tc.uni.Unify(typ, fnTyp.ParamTypes()[i])
 		if uniType == types.None {
 			tc.errorf(node, "wrong type for argument: expected %s, got %s", tc.uni.StringOf(fnTyp.ParamTypes()[i]), tc.uni.StringOf(typ))
@@ -141,10 +190,133 @@ func (tc *TypeChecker) checkCallExpr(node ast.NodeID) {
 	tc.ast.SetType(node, fnTyp.ReturnType())
 }
 
+func (tc *TypeChecker) checkIndexExpr(node ast.NodeID) {
+	expr := tc.ast.Child(node, ast.IndexExprExpr)
+	index := tc.ast.Child(node, ast.IndexExprIndex)
+
+	typ := tc.ast.Type(expr)
+	if typ == types.None {
+		return
+	}
+
+	if !tc.uni.IsMap(typ) {
+		tc.errorf(node, "cannot index non-map type %s", tc.uni.StringOf(typ))
+		return
+	}
+	m := tc.uni.Map(typ)
+
+	indexType := tc.ast.Type(index)
+	if indexType == types.None {
+		return
+	}
+
+	if !tc.uni.IsAssignable(m.Key(), indexType) {
+		tc.errorf(node, "cannot use %s as %s map key", tc.uni.StringOf(indexType), tc.uni.StringOf(m.Key()))
+		return
+	}
+	tc.ast.SetType(index, m.Key())
+
+	tc.ast.SetType(node, m.Elem())
+}
+
+func (tc *TypeChecker) checkCompositeLit(node ast.NodeID) {
+	typ := tc.ast.Type(tc.ast.Child(node, ast.CompositeLitType))
+	if typ == types.None {
+		return
+	}
+
+	if !tc.uni.IsMap(typ) {
+		tc.errorf(node, "invalid composite literal type %s", tc.uni.StringOf(typ))
+		return
+	}
+	m := tc.uni.Map(typ)
+
+	// the constant keys already in the literal
+	keys := make(map[string]bool)
+
+	elems := tc.ast.Child(node, ast.CompositeLitElems)
+	for _, elem := range tc.ast.Children(elems) {
+		key := tc.ast.Child(elem, ast.KeyValueExprKey)
+		value := tc.ast.Child(elem, ast.KeyValueExprValue)
+
+		if c := tc.ast.Const(key); c != nil {
+			if keys[c.String()] {
+				tc.errorf(key, "duplicate key %s in map literal", c.String())
+			}
+			keys[c.String()] = true
+		}
+
+		keyType := tc.ast.Type(key)
+		if keyType != types.None {
+			if !tc.uni.IsAssignable(m.Key(), keyType) {
+				tc.errorf(elem, "cannot use %s as %s map key", tc.uni.StringOf(keyType), tc.uni.StringOf(m.Key()))
+			} else {
+				tc.ast.SetType(key, m.Key())
+			}
+		}
+
+		valueType := tc.ast.Type(value)
+		if valueType != types.None {
+			if !tc.uni.IsAssignable(m.Elem(), valueType) {
+				tc.errorf(elem, "cannot use %s as %s map value", tc.uni.StringOf(valueType), tc.uni.StringOf(m.Elem()))
+			} else {
+				tc.ast.SetType(value, m.Elem())
+			}
+		}
+	}
+
+	tc.ast.SetType(node, typ)
+}
+
+func (tc *TypeChecker) checkPointerType(node ast.NodeID) {
+	elem := tc.ast.Type(tc.ast.Child(node, ast.PointerTypeElem))
+	if elem == types.None {
+		return
+	}
+
+	if elem.Indirections() == 3 {
+		tc.errorf(node, "too many pointer indirections in *%s", tc.uni.StringOf(elem))
+		return
+	}
+
+	tc.ast.SetType(node, elem.Pointer())
+}
+
+func (tc *TypeChecker) checkMapType(node ast.NodeID) {
+	key := tc.ast.Type(tc.ast.Child(node, ast.MapTypeKey))
+	elem := tc.ast.Type(tc.ast.Child(node, ast.MapTypeElem))
+	if key == types.None || elem == types.None {
+		return
+	}
+
+	if !tc.uni.IsComparable(key) {
+		tc.errorf(node, "invalid map key type %s", tc.uni.StringOf(key))
+		return
+	}
+
+	tc.ast.SetType(node, tc.uni.MapFor(key, elem))
+}
+
+// isTypeExpr returns whether the node names a type rather than a value.
+func (tc *TypeChecker) isTypeExpr(node ast.NodeID) bool {
+	switch tc.ast.Kind(node) {
+	case ast.MapType, ast.ChanType, ast.PointerType:
+		return true
+	case ast.Name:
+		sym := tc.symtab.Lookup(tc.ast.NodeString(node))
+		return sym != nil && sym.Kind == ast.TypeSymbol
+	}
+	return false
+}
+
 func (tc *TypeChecker) checkLiteral(node ast.NodeID) {
 	switch tc.ast.Token(node).Kind() {
 	case token.Int:
 		tc.ast.SetType(node, types.UntypedInt)
+
+		// the tokenizer only accepts decimal digits, so this cannot fail
+		v, _ := new(big.Int).SetString(tc.ast.NodeString(node), 10)
+		tc.ast.SetConst(node, types.BigIntConst(v))
 	default:
 		panic("unknown literal type " + tc.ast.Token(node).Kind().String())
 	}
@@ -156,7 +328,26 @@ func (tc *TypeChecker) checkName(node ast.NodeID) {
 		tc.errorf(node, "undefined name %s", tc.ast.NodeString(node))
 		return
 	}
+
+	if sym.Kind == ast.PackageSymbol {
+		tc.errorf(node, "use of package %s without selector", sym.Name)
+		return
+	}
+
+	if sym.Scope == ast.BuiltinScope && sym.Name == "iota" {
+		if tc.iota < 0 {
+			tc.errorf(node, "cannot use iota outside constant declaration")
+			return
+		}
+		tc.ast.SetType(node, sym.Type)
+		tc.ast.SetConst(node, types.IntConst(int64(tc.iota)))
+		return
+	}
+
 	tc.ast.SetType(node, sym.Type)
+	if sym.Const != nil {
+		tc.ast.SetConst(node, sym.Const)
+	}
 }
 
 func (tc *TypeChecker) checkBlock(node ast.NodeID) {
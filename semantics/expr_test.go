@@ -153,6 +153,144 @@ func TestTypeCheckingExprs(t *testing.T) {
 			expected: "",
 			err:      "cannot call non-function a of type int",
 		},
+		{
+			name:     "make map",
+			src:      "make(map[int]bool)",
+			expected: "map[int]bool",
+			err:      "",
+		},
+		{
+			name:     "make map with size",
+			src:      "make(map[int]int, 10)",
+			expected: "map[int]int",
+			err:      "",
+		},
+		{
+			name:     "make needs a map type",
+			src:      "make(int)",
+			expected: "",
+			err:      "cannot make int; type must be a map",
+		},
+		{
+			name:     "map literal",
+			src:      "map[int]bool{1: true, 2: false}",
+			expected: "map[int]bool",
+			err:      "",
+		},
+		{
+			name:     "map literal with wrong value type",
+			src:      "map[int]bool{1: 2}",
+			expected: "",
+			err:      "cannot use int constant as bool map value",
+		},
+		{
+			name:     "map literal with duplicate keys",
+			src:      "map[int]int{1: 2, 1: 3}",
+			expected: "",
+			err:      "duplicate key 1 in map literal",
+		},
+		{
+			name:     "map literal with duplicate constant expression keys",
+			src:      "map[bool]int{true: 2, 1 < 2: 3}",
+			expected: "",
+			err:      "duplicate key true in map literal",
+		},
+		{
+			name:     "maps cannot be compared",
+			src:      "m := map[int]int{}; m == m",
+			expected: "",
+			err:      "invalid operation: map[int]int cannot be compared",
+		},
+		{
+			name:     "map index is elem type",
+			src:      "m := map[int]bool{}; m[1]",
+			expected: "bool",
+			err:      "",
+		},
+		{
+			name:     "map index with wrong key type",
+			src:      "m := map[int]bool{}; m[true]",
+			expected: "",
+			err:      "cannot use bool as int map key",
+		},
+		{
+			name:     "cannot index non-map",
+			src:      "a := 1; a[1]",
+			expected: "",
+			err:      "cannot index non-map type int",
+		},
+		{
+			name:     "len of map is int",
+			src:      "m := map[int]int{}; len(m)",
+			expected: "int",
+			err:      "",
+		},
+		{
+			name:     "len of non-map",
+			src:      "len(1)",
+			expected: "",
+			err:      "invalid argument to len: int constant",
+		},
+		{
+			name:     "delete from map is void",
+			src:      "m := map[int]int{}; delete(m, 1)",
+			expected: "void",
+			err:      "",
+		},
+		{
+			name:     "builtin must be called",
+			src:      "a := len",
+			expected: "",
+			err:      "len (built-in function) must be called",
+		},
+		{
+			name:     "map of maps",
+			src:      "m := map[int]map[int]bool{}; m[1]",
+			expected: "map[int]bool",
+			err:      "",
+		},
+		{
+			name:     "make chan",
+			src:      "make(chan int)",
+			expected: "chan int",
+			err:      "",
+		},
+		{
+			name:     "make buffered chan",
+			src:      "make(chan map[int]bool, 3)",
+			expected: "chan map[int]bool",
+			err:      "",
+		},
+		{
+			name:     "chan buffer size must be int",
+			src:      "make(chan int, true)",
+			expected: "",
+			err:      "channel buffer size must be int but was bool",
+		},
+		{
+			name:     "receive is elem type",
+			src:      "ch := make(chan bool); <-ch",
+			expected: "bool",
+			err:      "",
+		},
+		{
+			name:     "cannot receive from non-chan",
+			src:      "a := 1; <-a",
+			expected: "",
+			err:      "cannot receive from non-chan type int",
+		},
+		{
+			name:     "close chan is void",
+			src:      "ch := make(chan int); close(ch)",
+			expected: "void",
+			err:      "",
+		},
+		{
+			name:     "cannot close non-chan",
+			src:      "m := map[int]int{}; close(m)",
+			expected: "",
+			err:      "invalid argument to close: map[int]int",
+		},
 	}
 
 	for _, tt := range tests {
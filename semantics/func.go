@@ -33,7 +33,16 @@ func (tc *TypeChecker) defineFunc(node ast.NodeID) {
 		typ = tc.uni.FuncFor(params, tc.ast.Type(ret))
 	}
 
-	tc.symtab.NewSymbol(tc.ast.NodeString(name), ast.FuncSymbol, typ)
+	sym := tc.symtab.NewSymbol(tc.ast.NodeString(name), ast.FuncSymbol, typ)
+
+	// a function without a body is an extern function, which is
+	// defined in C or by the host of the vm
+	if tc.ast.Child(node, ast.FuncDeclBody) == ast.InvalidNode {
+		if sym.Name == "main" {
+			tc.errorf(node, "missing function body for main")
+		}
+		sym.Extern = true
+	}
 }
 
 func (tc *TypeChecker) defineFuncParams(node ast.NodeID) {
@@ -62,7 +71,7 @@ func (tc *TypeChecker) checkFuncDecl(node ast.NodeID) {
 	}
 
 	retType := tc.uni.Func(sym.Type).ReturnType()
-	if retType != types.Void && retType != types.None {
+	if retType != types.Void && retType != types.None && !sym.Extern {
 		body := tc.ast.Child(node, ast.FuncDeclBody)
 		if !tc.returns(body) {
 			tc.errorf(node, "missing return statement in function %s", tc.ast.NodeString(name))
@@ -74,6 +83,14 @@ func (tc *TypeChecker) returns(node ast.NodeID) bool {
 	switch tc.ast.Kind(node) {
 	case ast.ReturnStmt:
 		return true
+	case ast.ExprStmt:
+		// a call to panic never returns normally
+		expr := tc.ast.Child(node, ast.ExprStmtExpr)
+		if tc.ast.Kind(expr) != ast.CallExpr {
+			return false
+		}
+		sym := tc.callee(expr)
+		return sym != nil && sym.Kind == ast.BuiltinSymbol && sym.Name == "panic"
 	case ast.StmtList:
 		num := tc.ast.NumChildren(node)
 		if num == 0 {
@@ -87,6 +104,13 @@ func (tc *TypeChecker) returns(node ast.NodeID) bool {
 	case ast.ForStmt:
 		body := tc.ast.Child(node, ast.ForStmtBody)
 		return tc.returns(body)
+	case ast.SelectStmt:
+		for _, clause := range tc.ast.Children(node) {
+			if !tc.returns(tc.ast.Child(clause, ast.CommClauseBody)) {
+				return false
+			}
+		}
+		return true
 	}
 	return false
 }
@@ -134,6 +134,54 @@ func TestTypeCheckingFuncs(t *testing.T) {
 			expected: "func() int",
 			err:      "",
 		},
+		{
+			name:     "function can end in panic",
+			src:      "func main() int { panic(1) }",
+			expected: "func() int",
+			err:      "",
+		},
+		{
+			name:     "function can use constant declared after it",
+			src:      "func main() int { return a } const a = 1",
+			expected: "func() int",
+			err:      "",
+		},
+		{
+			name:     "extern function",
+			src:      "func write(fd int, p *int, n int) int",
+			expected: "func(int, *int, int) int",
+			err:      "",
+		},
+		{
+			name:     "extern function can be called",
+			src:      "func main() int { x := 1; return write(1, &x, 1) } func write(fd int, p *int, n int) int",
+			expected: "func() int",
+			err:      "",
+		},
+		{
+			name:     "extern function called with wrong pointer type",
+			src:      "func main() int { x := 1; return write(1, &x, 1) } func write(fd int, p **int, n int) int",
+			expected: "",
+			err:      "wrong type for argument: expected **int, got *int",
+		},
+		{
+			name:     "main must have a body",
+			src:      "func main() int",
+			expected: "",
+			err:      "missing function body for main",
+		},
+		{
+			name:     "cannot start goroutine with extern function",
+			src:      "func main() { go exit(1) } func exit(code int)",
+			expected: "",
+			err:      "cannot start goroutine with extern function exit",
+		},
+		{
+			name:     "cannot defer extern function",
+			src:      "func main() { defer exit(1) } func exit(code int)",
+			expected: "",
+			err:      "defer of extern function exit is not supported",
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,141 @@
+package semantics
+
+import (
+	"github.com/rj45/gosling/ast"
+	"github.com/rj45/gosling/types"
+)
+
+// definePackage registers a package so the packages after it can
+// import it, and defines the declarations of all of its files.
+func (tc *TypeChecker) definePackage(node ast.NodeID) {
+	tc.pkg = node
+
+	if path := tc.ast.Child(node, ast.PackagePath); path != ast.InvalidNode {
+		// the loader checked the path when it was imported
+		importPath, _ := tc.ast.ImportPath(path)
+		tc.packages[importPath] = pkgScope{
+			name:  tc.ast.NodeString(node),
+			scope: tc.symtab.Scope(),
+		}
+		tc.ast.SetType(path, types.Void)
+	}
+
+	tc.defineDecls(tc.ast.Children(node)[ast.PackageFiles:]...)
+}
+
+// defineDecls gathers the imports, functions and constants of the files
+// of a package in the symtab before checking them. This way functions
+// can be in any order, and can use constants declared after them.
+func (tc *TypeChecker) defineDecls(files ...ast.NodeID) {
+	for _, file := range files {
+		tc.file = file
+		for _, decl := range tc.ast.Children(file) {
+			switch tc.ast.Kind(decl) {
+			case ast.PackageClause:
+				tc.ast.SetType(decl, types.Void)
+			case ast.ImportDecl:
+				tc.defineImports(decl)
+			case ast.FuncDecl:
+				tc.defineFunc(decl)
+			}
+		}
+	}
+
+	for _, file := range files {
+		tc.file = file
+		for _, decl := range tc.ast.Children(file) {
+			if tc.ast.Kind(decl) == ast.ConstDecl {
+				tc.check(decl)
+			}
+		}
+	}
+}
+
+// defineImports defines the names of the packages imported by the
+// current file. They are defined in the package scope, but can only
+// be used by the files which import them.
+func (tc *TypeChecker) defineImports(node ast.NodeID) {
+	tc.ast.SetType(node, types.Void)
+
+	for _, spec := range tc.ast.Children(node) {
+		tc.ast.SetType(spec, types.Void)
+
+		path, ok := tc.ast.ImportPath(spec)
+		if !ok {
+			tc.errorf(spec, "malformed import path %s", tc.ast.NodeString(spec))
+			continue
+		}
+		pkg, ok := tc.packages[path]
+		if !ok {
+			tc.errorf(spec, "could not import %s", path)
+			continue
+		}
+		name := pkg.name
+		scope := pkg.scope
+
+		sym := tc.symtab.Lookup(name)
+		if sym == nil {
+			sym = tc.symtab.NewSymbol(name, ast.PackageSymbol, types.None)
+			sym.Pkg = scope
+			sym.Path = path
+		} else if sym.Kind != ast.PackageSymbol || sym.Pkg != scope {
+			tc.errorf(spec, "%s redeclared by import %s", name, path)
+			continue
+		}
+
+		if tc.imports[tc.file] == nil {
+			tc.imports[tc.file] = make(map[string]bool)
+		}
+		tc.imports[tc.file][name] = true
+	}
+}
+
+func (tc *TypeChecker) checkSelectorExpr(node ast.NodeID) {
+	x := tc.ast.Child(node, ast.SelectorExprX)
+	sel := tc.ast.NodeString(tc.ast.Child(node, ast.SelectorExprSel))
+
+	// there are no structs yet, so only packages have selectors
+	pkg := tc.ast.NodeString(x)
+	if sym := tc.symtab.Lookup(pkg); sym == nil || sym.Kind != ast.PackageSymbol || !tc.imports[tc.file][pkg] {
+		tc.errorf(x, "undefined name %s", pkg)
+		return
+	}
+
+	if !ast.IsExported(sel) {
+		tc.errorf(node, "name %s not exported by package %s", sel, pkg)
+		return
+	}
+
+	sym := tc.symtab.LookupQualified(pkg, sel)
+	if sym == nil {
+		tc.errorf(node, "undefined name %s.%s", pkg, sel)
+		return
+	}
+
+	tc.ast.SetType(node, sym.Type)
+	if sym.Const != nil {
+		tc.ast.SetConst(node, sym.Const)
+	}
+}
+
+// callee returns the symbol of the function called by a call
+// expression, or nil if it is undefined.
+func (tc *TypeChecker) callee(call ast.NodeID) *ast.Symbol {
+	name := tc.ast.Child(call, ast.CallExprName)
+	if tc.ast.Kind(name) == ast.SelectorExpr {
+		x := tc.ast.NodeString(tc.ast.Child(name, ast.SelectorExprX))
+		sel := tc.ast.NodeString(tc.ast.Child(name, ast.SelectorExprSel))
+		return tc.symtab.LookupQualified(x, sel)
+	}
+	return tc.symtab.Lookup(tc.ast.NodeString(name))
+}
+
+// nameOf returns the text of a name, which may be qualified by a package.
+func (tc *TypeChecker) nameOf(node ast.NodeID) string {
+	if tc.ast.Kind(node) == ast.SelectorExpr {
+		x := tc.ast.NodeString(tc.ast.Child(node, ast.SelectorExprX))
+		sel := tc.ast.NodeString(tc.ast.Child(node, ast.SelectorExprSel))
+		return x + "." + sel
+	}
+	return tc.ast.NodeString(node)
+}
@@ -13,6 +13,11 @@ func (tc *TypeChecker) defineAssignStmt(node ast.NodeID) {
 
 	lhs := tc.ast.Child(node, ast.AssignStmtLHS)
 
+	if tc.ast.Kind(lhs) == ast.ExprList {
+		tc.defineCommaOk(node)
+		return
+	}
+
 	if tc.ast.Kind(lhs) != ast.Name {
 		tc.errorf(node, "cannot define non-name %s", tc.ast.NodeString(lhs))
 		return
@@ -34,10 +39,54 @@ func (tc *TypeChecker) defineAssignStmt(node ast.NodeID) {
 	tc.symtab.NewSymbol(tc.ast.NodeString(lhs), ast.VarSymbol, rhsType)
 }
 
+// isCommaOk returns whether the expression can produce a second, ok, value.
+func (tc *TypeChecker) isCommaOk(node ast.NodeID) bool {
+	kind := tc.ast.Kind(node)
+	return kind == ast.IndexExpr || kind == ast.RecvExpr
+}
+
+// defineCommaOk defines the variables in a `v, ok := m[k]` or `v, ok := <-ch` statement.
+func (tc *TypeChecker) defineCommaOk(node ast.NodeID) {
+	names := tc.ast.Children(tc.ast.Child(node, ast.AssignStmtLHS))
+	rhs := tc.ast.Child(node, ast.AssignStmtRHS)
+
+	for _, name := range names {
+		if tc.ast.Kind(name) != ast.Name {
+			tc.errorf(node, "cannot define non-name %s", tc.ast.NodeString(name))
+			return
+		}
+		if tc.symtab.Lookup(tc.ast.NodeString(name)) != nil {
+			tc.errorf(node, "cannot redefine %s", tc.ast.NodeString(name))
+			return
+		}
+	}
+
+	// the names are defined even on error to avoid spurious undefined name errors
+	typs := []types.Type{types.None, types.None}
+	if len(names) != 2 || !tc.isCommaOk(rhs) {
+		tc.errorf(node, "assignment mismatch: %d variables but 1 value", len(names))
+		typs = make([]types.Type, len(names))
+	} else {
+		tc.check(rhs)
+		if elemType := tc.ast.Type(rhs); elemType != types.None {
+			typs = []types.Type{elemType, types.Bool}
+		}
+	}
+
+	for i, name := range names {
+		tc.symtab.NewSymbol(tc.ast.NodeString(name), ast.VarSymbol, typs[i])
+	}
+}
+
 func (tc *TypeChecker) checkAssignStmt(node ast.NodeID) {
 	lhs := tc.ast.Child(node, ast.AssignStmtLHS)
 	rhs := tc.ast.Child(node, ast.AssignStmtRHS)
 
+	if tc.ast.Kind(lhs) == ast.ExprList {
+		tc.checkCommaOk(node)
+		return
+	}
+
 	lhsType := tc.ast.Type(lhs)
 	rhsType := tc.ast.Type(rhs)
 
@@ -45,6 +94,11 @@ func (tc *TypeChecker) checkAssignStmt(node ast.NodeID) {
 		return
 	}
 
+	if tc.ast.Const(lhs) != nil && tc.ast.Token(node).Kind() != token.Define {
+		tc.errorf(node, "cannot assign to constant %s", tc.ast.NodeString(lhs))
+		return
+	}
+
 	if !tc.uni.IsAssignable(lhsType, rhsType) && tc.ast.Token(node).Kind() != token.Define {
 		tc.errorf(node, "cannot assign %s to %s", tc.uni.StringOf(rhsType), tc.uni.StringOf(lhsType))
 		return
@@ -53,6 +107,32 @@ func (tc *TypeChecker) checkAssignStmt(node ast.NodeID) {
 	tc.ast.SetType(node, tc.uni.Unify(lhsType, rhsType))
 }
 
+func (tc *TypeChecker) checkCommaOk(node ast.NodeID) {
+	lhs := tc.ast.Children(tc.ast.Child(node, ast.AssignStmtLHS))
+	rhs := tc.ast.Child(node, ast.AssignStmtRHS)
+
+	if len(lhs) != 2 || !tc.isCommaOk(rhs) {
+		if tc.ast.Token(node).Kind() != token.Define {
+			tc.errorf(node, "assignment mismatch: %d variables but 1 value", len(lhs))
+		}
+		return
+	}
+
+	rhsTypes := []types.Type{tc.ast.Type(rhs), types.Bool}
+	for i, name := range lhs {
+		lhsType := tc.ast.Type(name)
+		if lhsType == types.None || rhsTypes[i] == types.None {
+			return
+		}
+		if !tc.uni.IsAssignable(lhsType, rhsTypes[i]) {
+			tc.errorf(node, "cannot assign %s to %s", tc.uni.StringOf(rhsTypes[i]), tc.uni.StringOf(lhsType))
+			return
+		}
+	}
+
+	tc.ast.SetType(node, types.Void)
+}
+
 func (tc *TypeChecker) checkForStmt(node ast.NodeID) {
 	cond := tc.ast.Child(node, ast.ForStmtCond)
 	condType := tc.ast.Type(cond)
@@ -64,3 +144,23 @@ func (tc *TypeChecker) checkForStmt(node ast.NodeID) {
 		return
 	}
 }
+
+func (tc *TypeChecker) checkDeferStmt(node ast.NodeID) {
+	call := tc.ast.Child(node, ast.DeferStmtCall)
+	if tc.ast.Kind(call) != ast.CallExpr {
+		// the parser has already reported this
+		return
+	}
+
+	sym := tc.callee(call)
+	if sym != nil && sym.Kind == ast.BuiltinSymbol {
+		tc.errorf(node, "defer of built-in function %s is not supported", sym.Name)
+		return
+	}
+	if sym != nil && sym.Extern {
+		tc.errorf(node, "defer of extern function %s is not supported", sym.Name)
+		return
+	}
+
+	tc.ast.SetType(node, types.Void)
+}
@@ -56,6 +56,126 @@ func TestTypeCheckingStmts(t *testing.T) {
 			expected: "",
 			err:      "undefined name a",
 		},
+		{
+			name:     "assign to map element",
+			src:      "m := map[int]int{}; m[1] = 2",
+			expected: "int",
+			err:      "",
+		},
+		{
+			name:     "comma ok defines elem and bool",
+			src:      "m := map[int]int{}; v, ok := m[1]; ok",
+			expected: "bool",
+			err:      "",
+		},
+		{
+			name:     "comma ok value has elem type",
+			src:      "m := map[int]bool{}; v, ok := m[1]; v",
+			expected: "bool",
+			err:      "",
+		},
+		{
+			name:     "comma ok needs index expression",
+			src:      "v, ok := 1",
+			expected: "",
+			err:      "assignment mismatch: 2 variables but 1 value",
+		},
+		{
+			name:     "comma ok assignment",
+			src:      "m := map[int]int{}; v := 1; ok := false; v, ok = m[1]",
+			expected: "void",
+			err:      "",
+		},
+		{
+			name:     "comma ok receive",
+			src:      "ch := make(chan bool); v, ok := <-ch; v",
+			expected: "bool",
+			err:      "",
+		},
+		{
+			name:     "send",
+			src:      "ch := make(chan int); ch <- 1",
+			expected: "void",
+			err:      "",
+		},
+		{
+			name:     "send wrong type",
+			src:      "ch := make(chan int); ch <- true",
+			expected: "",
+			err:      "cannot send bool on chan int",
+		},
+		{
+			name:     "send to non-chan",
+			src:      "a := 1; a <- 1",
+			expected: "",
+			err:      "cannot send to non-chan type int",
+		},
+		{
+			name:     "go statement",
+			src:      "go foo()",
+			expected: "void",
+			err:      "",
+		},
+		{
+			name:     "go builtin",
+			src:      "ch := make(chan int); go close(ch)",
+			expected: "",
+			err:      "cannot start goroutine with built-in function close",
+		},
+		{
+			name:     "select",
+			src:      "a := make(chan int); select { case v := <-a: v; case a <- 1: case <-a: default: }",
+			expected: "void",
+			err:      "",
+		},
+		{
+			name:     "select case scope",
+			src:      "a := make(chan int); select { case v := <-a: v }; v",
+			expected: "",
+			err:      "undefined name v",
+		},
+		{
+			name:     "select case must communicate",
+			src:      "select { case 1: }",
+			expected: "",
+			err:      "select case must be receive, send or assign recv",
+		},
+		{
+			name:     "defer",
+			src:      "defer foo()",
+			expected: "void",
+			err:      "",
+		},
+		{
+			name:     "defer builtin",
+			src:      "ch := make(chan int); defer close(ch)",
+			expected: "",
+			err:      "defer of built-in function close is not supported",
+		},
+		{
+			name:     "panic",
+			src:      "panic(1)",
+			expected: "void",
+			err:      "",
+		},
+		{
+			name:     "panic with non-int",
+			src:      "panic(true)",
+			expected: "",
+			err:      "cannot use bool as int in argument to panic",
+		},
+		{
+			name:     "recover",
+			src:      "recover() + 1",
+			expected: "int",
+			err:      "",
+		},
+		{
+			name:     "select multiple defaults",
+			src:      "select { default: default: }",
+			expected: "",
+			err:      "multiple defaults in select",
+		},
 	}
 
 	for _, tt := range tests {
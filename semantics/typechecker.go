@@ -12,6 +12,25 @@ type TypeChecker struct {
 	symtab *ast.SymTab
 	ast    *ast.AST
 	errs   []error
+
+	// iota is the index of the const spec being checked, or -1
+	iota int
+
+	// pkg is the Package being checked, if the program has packages,
+	// and file is the DeclList of the file being checked
+	pkg  ast.NodeID
+	file ast.NodeID
+
+	// packages are the checked or imported packages by import path,
+	// and imports are the names of the packages imported by each file
+	packages map[string]pkgScope
+	imports  map[ast.NodeID]map[string]bool
+}
+
+// pkgScope is the scope holding the declarations of a package.
+type pkgScope struct {
+	name  string
+	scope ast.ScopeID
 }
 
 // NewTypeChecker creates a new TypeChecker.
@@ -20,6 +39,10 @@ func NewTypeChecker(a *ast.AST) *TypeChecker {
 		uni:    types.NewUniverse(),
 		ast:    a,
 		symtab: ast.NewSymTab(),
+		iota:   -1,
+
+		packages: make(map[string]pkgScope),
+		imports:  make(map[ast.NodeID]map[string]bool),
 	}
 }
 
@@ -30,13 +53,14 @@ func (tc *TypeChecker) Universe() *types.Universe {
 // Check checks the AST for type errors, labeling the AST with types.
 func (tc *TypeChecker) Check(node ast.NodeID) (*ast.SymTab, []error) {
 	tc.check(node)
+	tc.checkConstOverflow(node)
 	errs := tc.errs
 	tc.errs = nil
 	return tc.symtab, errs
 }
 
 func (tc *TypeChecker) errorf(node ast.NodeID, msg string, args ...interface{}) {
-	tc.errs = append(tc.errs, errors.Newf(tc.ast.Src, tc.ast.Token(node), msg, args...))
+	tc.errs = append(tc.errs, errors.Newf(tc.ast.File(tc.ast.Token(node)), tc.ast.Token(node), msg, args...))
 }
 
 func (tc *TypeChecker) check(node ast.NodeID) {
@@ -46,16 +70,22 @@ func (tc *TypeChecker) check(node ast.NodeID) {
 
 	// pre-checks before checking children
 	switch tc.ast.Kind(node) {
-	case ast.DeclList:
+	case ast.Package:
 		tc.symtab.EnterScope(node)
 		defer tc.symtab.LeaveScope()
-		for _, child := range tc.ast.Children(node) {
-			if tc.ast.Kind(child) == ast.FuncDecl {
-				// define functions first
-				// this way they can be in any order
-				tc.defineFunc(child)
-			}
+		tc.definePackage(node)
+	case ast.DeclList:
+		if tc.pkg == ast.InvalidNode {
+			// a program with a single file has no packages
+			tc.symtab.EnterScope(node)
+			defer tc.symtab.LeaveScope()
+			tc.defineDecls(node)
 		}
+		tc.file = node
+	case ast.SelectorExpr:
+		// the selected name is looked up in the package scope
+		tc.checkSelectorExpr(node)
+		return
 
 	case ast.AssignStmt:
 		// ensure defined variables are created in the symtab
@@ -64,9 +94,13 @@ func (tc *TypeChecker) check(node ast.NodeID) {
 		tc.symtab.EnterScope(node)
 		defer tc.symtab.LeaveScope()
 		tc.defineFuncParams(node)
-	case ast.StmtList:
+	case ast.StmtList, ast.CommClause:
 		tc.symtab.EnterScope(node)
 		defer tc.symtab.LeaveScope()
+	case ast.ConstDecl:
+		tc.checkConstDecl(node)
+	case ast.ConstSpec:
+		tc.defineConstSpec(node)
 	}
 
 	// check children
@@ -82,8 +116,10 @@ func (tc *TypeChecker) check(node ast.NodeID) {
 	}
 
 	switch tc.ast.Kind(node) {
-	case ast.DeclList:
+	case ast.Program, ast.Package, ast.DeclList:
 		// nothing to do
+	case ast.ConstDecl, ast.ConstSpec:
+		tc.ast.SetType(node, types.Void)
 	case ast.FuncDecl:
 		tc.checkFuncDecl(node)
 	case ast.ExprList:
@@ -98,6 +134,18 @@ func (tc *TypeChecker) check(node ast.NodeID) {
 		tc.checkAddrExpr(node)
 	case ast.CallExpr:
 		tc.checkCallExpr(node)
+	case ast.IndexExpr:
+		tc.checkIndexExpr(node)
+	case ast.CompositeLit:
+		tc.checkCompositeLit(node)
+	case ast.MapType:
+		tc.checkMapType(node)
+	case ast.ChanType:
+		tc.checkChanType(node)
+	case ast.PointerType:
+		tc.checkPointerType(node)
+	case ast.RecvExpr:
+		tc.checkRecvExpr(node)
 	case ast.Literal:
 		tc.checkLiteral(node)
 	case ast.Name:
@@ -110,13 +158,23 @@ func (tc *TypeChecker) check(node ast.NodeID) {
 		tc.checkForStmt(node)
 	case ast.ReturnStmt:
 		tc.checkReturnStmt(node)
+	case ast.SendStmt:
+		tc.checkSendStmt(node)
+	case ast.GoStmt:
+		tc.checkGoStmt(node)
+	case ast.DeferStmt:
+		tc.checkDeferStmt(node)
+	case ast.SelectStmt:
+		tc.checkSelectStmt(node)
+	case ast.CommClause:
+		tc.checkCommClause(node)
 	case ast.ExprStmt:
 		tc.ast.SetType(node, tc.ast.Type(tc.ast.Child(node, ast.ExprStmtExpr)))
 	case ast.StmtList:
 		tc.checkBlock(node)
 	case ast.Field:
 		tc.ast.SetType(node, tc.ast.Type(tc.ast.Child(node, ast.FieldTyp)))
-	case ast.EmptyStmt, ast.FieldList:
+	case ast.EmptyStmt, ast.FieldList, ast.KeyValueExpr:
 		// nothing to do
 	default:
 		panic("todo: implement node kind " + tc.ast.Kind(node).String())
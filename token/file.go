@@ -1,19 +1,103 @@
 package token
 
+import (
+	"sort"
+	"strings"
+)
+
+// FileSet is a set of source files. The sources of the files are
+// stored one after another in a single buffer, each starting at its
+// base offset, so the offset of a token identifies both the file it
+// is in and its position within the file.
+type FileSet struct {
+	// Src is the buffer holding the source of every file, which
+	// token offsets index into.
+	Src []byte
+
+	files []*File
+}
+
+// NewFileSet creates a new empty file set.
+func NewFileSet() *FileSet {
+	return &FileSet{}
+}
+
+// AddFile adds a file to the set.
+func (s *FileSet) AddFile(filename string, src []byte) *File {
+	if len(s.files) > 0 {
+		// leave a gap so a token at the end of one file, such as
+		// EOF, is not mistaken for the start of the next file
+		s.Src = append(s.Src, '\n')
+	}
+
+	f := &File{Filename: filename, Src: src, Base: len(s.Src), set: s}
+	s.Src = append(s.Src, src...)
+	s.files = append(s.files, f)
+	return f
+}
+
+// Files returns the files in the set in the order they were added.
+func (s *FileSet) Files() []*File {
+	return s.files
+}
+
+// File returns the file the token is in.
+func (s *FileSet) File(tok Token) *File {
+	offset := tok.Offset()
+	i := sort.Search(len(s.files), func(i int) bool {
+		return s.files[i].Base > offset
+	})
+	if i == 0 {
+		return nil
+	}
+	return s.files[i-1]
+}
+
+// PositionOf returns the file, line and column of the given token
+func (s *FileSet) PositionOf(tok Token) (file *File, line int, col int) {
+	file = s.File(tok)
+	line, col = file.PositionOf(tok)
+	return file, line, col
+}
+
 // File is a source file.
 type File struct {
 	Filename string
-	Src      []byte
+
+	// Src is the source of the file alone, so a token's offset
+	// must have Base subtracted to index into it.
+	Src []byte
+
+	// Base is the offset of the start of the file in its set.
+	Base int
+
+	set *FileSet
 }
 
-// NewFile creates a new file
+// NewFile creates a new file in a file set of its own
 func NewFile(filename string, src []byte) *File {
-	return &File{Filename: filename, Src: src}
+	return NewFileSet().AddFile(filename, src)
+}
+
+// FileSet returns the set the file is in.
+func (f *File) FileSet() *FileSet {
+	return f.set
+}
+
+// End returns the offset of the end of the file in its set.
+func (f *File) End() int {
+	return f.Base + len(f.Src)
+}
+
+// Buf returns the buffer of the file set up to the end of the file,
+// which can be scanned for tokens without running into the next file.
+func (f *File) Buf() []byte {
+	return f.set.Src[:f.End()]
 }
 
 // TokenBytes returns a cheap byte slice of the token text
 func (f *File) TokenBytes(t Token) []byte {
-	return f.Src[t.Offset():t.EndOfToken(f.Src)]
+	return f.Buf()[t.Offset():t.EndOfToken(f.Buf())]
 }
 
 // TokenString allocates a new string copy of the token text
@@ -23,7 +107,7 @@ func (f *File) TokenString(t Token) string {
 
 // PositionOf returns the line and column of the given token
 func (f *File) PositionOf(tok Token) (line int, col int) {
-	offset := tok.Offset()
+	offset := tok.Offset() - f.Base
 	var lineoffset int
 	for i, ch := range f.Src {
 		if i >= offset {
@@ -31,7 +115,7 @@ func (f *File) PositionOf(tok Token) (line int, col int) {
 		}
 		if ch == '\n' {
 			line++
-			lineoffset = i
+			lineoffset = i + 1
 		}
 	}
 	col = offset - lineoffset
@@ -42,3 +126,31 @@ func (f *File) PositionOf(tok Token) (line int, col int) {
 func (f *File) String() string {
 	return f.Filename
 }
+
+// Directives returns the names of the //gosling:name directives in the
+// line comments directly above the line of the token, such as the
+// noinline of //gosling:noinline above a function.
+func (f *File) Directives(tok Token) []string {
+	offset := tok.Offset() - f.Base
+	start := offset
+	for start > 0 && f.Src[start-1] != '\n' {
+		start--
+	}
+
+	var names []string
+	for start > 0 {
+		end := start - 1
+		start = end
+		for start > 0 && f.Src[start-1] != '\n' {
+			start--
+		}
+		line := strings.TrimSpace(string(f.Src[start:end]))
+		if !strings.HasPrefix(line, "//") {
+			break
+		}
+		if name, found := strings.CutPrefix(line, "//gosling:"); found {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+	return names
+}
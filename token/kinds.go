@@ -8,9 +8,12 @@ const (
 
 	Semicolon
 	Comma
+	Colon
+	Period
 
 	Ident
 	Int
+	String
 
 	Assign // =
 	Define // :=
@@ -28,10 +31,14 @@ const (
 	Gt
 	Ge
 
+	Arrow // <-
+
 	LParen
 	RParen
 	LBrace
 	RBrace
+	LBrack
+	RBrack
 
 	// keywords
 	Return
@@ -39,6 +46,16 @@ const (
 	Else
 	For
 	Func
+	Const
+	Package
+	Import
+	Map
+	Chan
+	Go
+	Defer
+	Select
+	Case
+	Default
 
 	NumTokens
 )
@@ -48,8 +65,11 @@ var kindStrs = [...]string{
 	EOF:       "EOF",
 	Semicolon: "Semicolon",
 	Comma:     "Comma",
+	Colon:     "Colon",
+	Period:    "Period",
 	Ident:     "Ident",
 	Int:       "Int",
+	String:    "String",
 	Assign:    "Assign",
 	Add:       "Add",
 	Sub:       "Sub",
@@ -61,15 +81,28 @@ var kindStrs = [...]string{
 	Le:        "Le",
 	Gt:        "Gt",
 	Ge:        "Ge",
+	Arrow:     "Arrow",
 	LParen:    "LParen",
 	RParen:    "RParen",
 	LBrace:    "LBrace",
 	RBrace:    "RBrace",
+	LBrack:    "LBrack",
+	RBrack:    "RBrack",
 	Return:    "Return",
 	If:        "If",
 	Else:      "Else",
 	For:       "For",
 	Func:      "Func",
+	Const:     "Const",
+	Package:   "Package",
+	Import:    "Import",
+	Map:       "Map",
+	Chan:      "Chan",
+	Go:        "Go",
+	Defer:     "Defer",
+	Select:    "Select",
+	Case:      "Case",
+	Default:   "Default",
 }
 
 func (k Kind) String() string {
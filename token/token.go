@@ -39,16 +39,26 @@ func (t Token) EndOfToken(src []byte) int {
 			eot++
 		}
 
-	case Return, If, Else, For, Func:
+	case String:
+		// the closing quote is included if there is one
+		eot++
+		for eot < len(src) && src[eot] != '"' && src[eot] != '\n' {
+			eot++
+		}
+		if eot < len(src) && src[eot] == '"' {
+			eot++
+		}
+
+	case Return, If, Else, For, Func, Const, Package, Import, Map, Chan, Go, Defer, Select, Case, Default:
 		// for keywords, assume kind length is the token length
 		eot += len(t.Kind().String())
 
 	case Illegal, EOF:
 		// zero length
 
-	case Add, Sub, And, Star, Div, LParen, RParen, LBrace, RBrace, Lt, Gt, Semicolon, Assign, Comma:
+	case Add, Sub, And, Star, Div, LParen, RParen, LBrace, RBrace, LBrack, RBrack, Lt, Gt, Semicolon, Assign, Comma, Colon, Period:
 		eot++ // For single character tokens (like '+', '-', etc.)
-	case Eq, Ne, Le, Ge, Define:
+	case Eq, Ne, Le, Ge, Define, Arrow:
 		eot += 2 // For double character tokens (like '==', '!=', etc.)
 	default:
 		panic("todo: handle other tokens")
@@ -60,18 +70,30 @@ func (t Token) EndOfToken(src []byte) int {
 // nlsemi is a list of tokens that have the a following newline converted to a semicolon
 var nlsemi = [NumTokens]bool{
 	Int:    true,
+	String: true,
 	Ident:  true,
 	RParen: true,
 	RBrace: true,
+	RBrack: true,
 	Return: true,
 }
 
 var keywords = map[string]Kind{
-	"return": Return,
-	"if":     If,
-	"else":   Else,
-	"for":    For,
-	"func":   Func,
+	"return":  Return,
+	"if":      If,
+	"else":    Else,
+	"for":     For,
+	"func":    Func,
+	"const":   Const,
+	"package": Package,
+	"import":  Import,
+	"map":     Map,
+	"chan":    Chan,
+	"go":      Go,
+	"defer":   Defer,
+	"select":  Select,
+	"case":    Case,
+	"default": Default,
 }
 
 // Next returns the next Token in src relative to the current Token.
@@ -140,16 +162,34 @@ skip:
 		return NewToken(LBrace, pos)
 	case ch == '}':
 		return NewToken(RBrace, pos)
+	case ch == '[':
+		return NewToken(LBrack, pos)
+	case ch == ']':
+		return NewToken(RBrack, pos)
 
 	case ch == '=':
 		if pos+1 < len(src) && src[pos+1] == '=' {
 			return NewToken(Eq, pos)
 		}
 		return NewToken(Assign, pos)
+	case ch == '.':
+		return NewToken(Period, pos)
+	case ch == '"':
+		// escapes are not supported, so a string ends at the next quote
+		end := pos + 1
+		for end < len(src) && src[end] != '"' && src[end] != '\n' {
+			end++
+		}
+		if end >= len(src) || src[end] != '"' {
+			// unterminated string
+			return NewToken(Illegal, pos)
+		}
+		return NewToken(String, pos)
 	case ch == ':':
 		if pos+1 < len(src) && src[pos+1] == '=' {
 			return NewToken(Define, pos)
 		}
+		return NewToken(Colon, pos)
 	case ch == '!':
 		if pos+1 < len(src) && src[pos+1] == '=' {
 			return NewToken(Ne, pos)
@@ -158,6 +198,9 @@ skip:
 		if pos+1 < len(src) && src[pos+1] == '=' {
 			return NewToken(Le, pos)
 		}
+		if pos+1 < len(src) && src[pos+1] == '-' {
+			return NewToken(Arrow, pos)
+		}
 		return NewToken(Lt, pos)
 	case ch == '>':
 		if pos+1 < len(src) && src[pos+1] == '=' {
@@ -170,7 +213,7 @@ skip:
 		for pos < len(src) && src[pos] >= '0' && src[pos] <= '9' {
 			pos++
 		}
-		if src[pos] >= 'a' && src[pos] <= 'z' || src[pos] >= 'A' && src[pos] <= 'Z' {
+		if pos < len(src) && (src[pos] >= 'a' && src[pos] <= 'z' || src[pos] >= 'A' && src[pos] <= 'Z') {
 			// identifier starting with a number
 			return NewToken(Illegal, start)
 		}
@@ -0,0 +1,16 @@
+package types
+
+// Chan is a channel type.
+type Chan struct {
+	uni  *Universe
+	elem Type
+}
+
+func (c *Chan) String() string {
+	return "chan " + c.uni.StringOf(c.elem)
+}
+
+// Elem returns the type of the elements sent on the channel.
+func (c *Chan) Elem() Type {
+	return c.elem
+}
@@ -1,7 +1,7 @@
 package types
 
 import (
-	"strconv"
+	"math/big"
 )
 
 type Const interface {
@@ -9,11 +9,16 @@ type Const interface {
 	String() string
 }
 
-type int64Const int64
+// intConst is an integer constant of arbitrary precision, so untyped
+// constant expressions can have values that do not fit in an int
+type intConst struct {
+	val *big.Int
+}
+
 type boolConst bool
 
-func (c int64Const) String() string {
-	return strconv.FormatInt(int64(c), 10)
+func (c intConst) String() string {
+	return c.val.String()
 }
 
 func (b boolConst) String() string {
@@ -23,27 +28,42 @@ func (b boolConst) String() string {
 	return "false"
 }
 
-func (c int64Const) IsConst() bool { return true }
-func (b boolConst) IsConst() bool  { return true }
+func (c intConst) IsConst() bool  { return true }
+func (b boolConst) IsConst() bool { return true }
 
-func (c int64Const) Underlying() Type { return UntypedInt }
-func (b boolConst) Underlying() Type  { return Bool }
+func (c intConst) Underlying() Type  { return UntypedInt }
+func (b boolConst) Underlying() Type { return Bool }
 
 func IntConst(v int64) Const {
-	return int64Const(v)
+	return intConst{big.NewInt(v)}
+}
+
+func BigIntConst(v *big.Int) Const {
+	return intConst{new(big.Int).Set(v)}
 }
 
 func BoolConst(v bool) Const {
 	return boolConst(v)
 }
 
+// Int64Value returns the value of an integer constant, if it is
+// one and its value fits in an int64.
 func Int64Value(c Const) (int64, bool) {
-	if t, ok := c.(int64Const); ok {
-		return int64(t), true
+	if t, ok := c.(intConst); ok && t.val.IsInt64() {
+		return t.val.Int64(), true
 	}
 	return 0, false
 }
 
+// BigIntValue returns the value of an integer constant, if it is one.
+// The value must not be modified.
+func BigIntValue(c Const) (*big.Int, bool) {
+	if t, ok := c.(intConst); ok {
+		return t.val, true
+	}
+	return nil, false
+}
+
 func BoolValue(c Const) (bool, bool) {
 	if t, ok := c.(boolConst); ok {
 		return bool(t), true
@@ -0,0 +1,69 @@
+package types
+
+// Desc describes a type independently of the universe it is in, so it
+// can be written out with the exports of a package and recreated in
+// the universe of a package importing it.
+type Desc struct {
+	Kind         TypeKind
+	Indirections int `json:",omitempty"`
+
+	// Basic is the type itself if it is a basic type
+	Basic Type `json:",omitempty"`
+
+	Params []*Desc `json:",omitempty"`
+	Ret    *Desc   `json:",omitempty"`
+	Key    *Desc   `json:",omitempty"`
+	Elem   *Desc   `json:",omitempty"`
+}
+
+// Describe returns the description of a type.
+func (u *Universe) Describe(t Type) *Desc {
+	d := &Desc{Kind: t.Kind(), Indirections: t.Indirections()}
+
+	switch t.Kind() {
+	case BasicType:
+		d.Basic = newType(BasicType, t.Index(), 0)
+	case FuncType:
+		f := u.Func(t)
+		for _, p := range f.params {
+			d.Params = append(d.Params, u.Describe(p))
+		}
+		d.Ret = u.Describe(f.ret)
+	case MapType:
+		m := u.Map(t)
+		d.Key = u.Describe(m.key)
+		d.Elem = u.Describe(m.elem)
+	case ChanType:
+		d.Elem = u.Describe(u.Chan(t).elem)
+	default:
+		panic("unknown type kind")
+	}
+
+	return d
+}
+
+// TypeOf returns the type in the universe matching the description.
+func (u *Universe) TypeOf(d *Desc) Type {
+	var t Type
+	switch d.Kind {
+	case BasicType:
+		t = d.Basic
+	case FuncType:
+		params := make([]Type, len(d.Params))
+		for i, p := range d.Params {
+			params[i] = u.TypeOf(p)
+		}
+		t = u.FuncFor(params, u.TypeOf(d.Ret))
+	case MapType:
+		t = u.MapFor(u.TypeOf(d.Key), u.TypeOf(d.Elem))
+	case ChanType:
+		t = u.ChanFor(u.TypeOf(d.Elem))
+	default:
+		panic("unknown type kind")
+	}
+
+	for i := 0; i < d.Indirections; i++ {
+		t = t.Pointer()
+	}
+	return t
+}
@@ -0,0 +1,21 @@
+package types
+
+type Map struct {
+	uni  *Universe
+	key  Type
+	elem Type
+}
+
+func (m *Map) String() string {
+	return "map[" + m.uni.StringOf(m.key) + "]" + m.uni.StringOf(m.elem)
+}
+
+// Key returns the key type of the map.
+func (m *Map) Key() Type {
+	return m.key
+}
+
+// Elem returns the element type of the map.
+func (m *Map) Elem() Type {
+	return m.elem
+}
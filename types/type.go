@@ -5,6 +5,8 @@ type TypeKind uint8
 const (
 	BasicType TypeKind = iota
 	FuncType
+	MapType
+	ChanType
 )
 
 // Type identifies a type within the universe of types.
@@ -16,7 +18,7 @@ const (
 type Type uint32
 
 func newType(kind TypeKind, index int, indirections int) Type {
-	if kind < BasicType || kind > FuncType {
+	if kind < BasicType || kind > ChanType {
 		panic("kind out of range")
 	}
 	if index < 0 || index > 0x3ffff {
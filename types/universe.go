@@ -5,6 +5,8 @@ package types
 // It also allows to compare types by their ID.
 type Universe struct {
 	funcs []Func
+	maps  []Map
+	chans []Chan
 }
 
 func NewUniverse() *Universe {
@@ -31,6 +33,30 @@ outer:
 	return newType(FuncType, len(u.funcs)-1, 0)
 }
 
+// MapFor returns the map type with the given key and element types.
+func (u *Universe) MapFor(key, elem Type) Type {
+	for i, m := range u.maps {
+		if m.key == key && m.elem == elem {
+			return newType(MapType, i, 0)
+		}
+	}
+	m := Map{uni: u, key: key, elem: elem}
+	u.maps = append(u.maps, m)
+	return newType(MapType, len(u.maps)-1, 0)
+}
+
+// ChanFor returns the channel type with the given element type.
+func (u *Universe) ChanFor(elem Type) Type {
+	for i, c := range u.chans {
+		if c.elem == elem {
+			return newType(ChanType, i, 0)
+		}
+	}
+	c := Chan{uni: u, elem: elem}
+	u.chans = append(u.chans, c)
+	return newType(ChanType, len(u.chans)-1, 0)
+}
+
 func (u *Universe) Basic(t Type) *Basic {
 	if t.Kind() != BasicType {
 		panic("not a basic type")
@@ -45,6 +71,20 @@ func (u *Universe) Func(t Type) *Func {
 	return &u.funcs[t.Index()]
 }
 
+func (u *Universe) Map(t Type) *Map {
+	if t.Kind() != MapType {
+		panic("not a map type")
+	}
+	return &u.maps[t.Index()]
+}
+
+func (u *Universe) Chan(t Type) *Chan {
+	if t.Kind() != ChanType {
+		panic("not a chan type")
+	}
+	return &u.chans[t.Index()]
+}
+
 func (u *Universe) StringOf(t Type) string {
 	prefix := ""
 	for i := 0; i < t.Indirections(); i++ {
@@ -56,6 +96,10 @@ func (u *Universe) StringOf(t Type) string {
 		return prefix + u.Basic(t).String()
 	case FuncType:
 		return prefix + u.Func(t).String()
+	case MapType:
+		return prefix + u.Map(t).String()
+	case ChanType:
+		return prefix + u.Chan(t).String()
 	default:
 		panic("unknown type kind")
 	}
@@ -97,10 +141,23 @@ func (u *Universe) IsAssignable(dst, src Type) bool {
 
 // IsComparable returns whether t is comparable.
 func (u *Universe) IsComparable(t Type) bool {
+	if t.Indirections() > 0 || t.Kind() == ChanType {
+		return true
+	}
 	return t.Kind() == BasicType && t != Void
 }
 
+// IsMap returns whether t is a map (and not a pointer to one).
+func (u *Universe) IsMap(t Type) bool {
+	return t.Kind() == MapType && t.Indirections() == 0
+}
+
 // IsOrdered returns whether t is ordered.
 func (u *Universe) IsOrdered(t Type) bool {
 	return t.Kind() == BasicType && t != Void
 }
+
+// IsChan returns whether t is a channel (and not a pointer to one).
+func (u *Universe) IsChan(t Type) bool {
+	return t.Kind() == ChanType && t.Indirections() == 0
+}
@@ -4,41 +4,179 @@ import (
 	"log"
 
 	"github.com/rj45/gosling/ir"
+	"github.com/rj45/gosling/token"
+	"github.com/rj45/gosling/types"
 )
 
 type Asm struct {
-	Program []Instr
+	Program *Program
+
+	// Registers assembles functions to register instructions, which
+	// operate on the slots of the frame, rather than to the stack
+	// instructions the IR is made of. Optimizing the functions
+	// assembles them to register instructions too.
+	Registers bool
+
+	// Coverage instruments the blocks which have statements to count
+	// how many times they run, for coverage reports.
+	Coverage bool
 
 	labels map[string]int
-	refs   map[string][]int
 	fn     string
+	uni    *types.Universe
+
+	// whether each value pushed by the current function
+	// is a heap reference, for building stack maps
+	pushed []bool
+
+	// target is the pc of the last label, which must not be combined
+	// into a superinstruction with the instruction before it
+	target int
 }
 
 func NewAsm() *Asm {
-	return &Asm{}
+	return &Asm{
+		Program: &Program{
+			StackMaps: make(map[int]StackMap),
+			Defers:    make(map[int]DeferInfo),
+			Relocs:    make(map[string][]int),
+		},
+	}
 }
 
 func (a *Asm) instr(op Opcode) {
-	a.Program = append(a.Program, Instr(op))
+	a.emit(op, 0)
 }
 
 func (a *Asm) instr1(op Opcode, arg int) {
-	a.Program = append(a.Program, Instr(op)|Instr(arg)<<8)
+	a.emit(op, arg)
+}
+
+// superinstructions maps pairs of instructions to the superinstruction
+// which replaces them. The argument of the superinstruction is the argument
+// of whichever instruction of the pair has one.
+var superinstructions = map[[2]Opcode]Opcode{
+	{LoadLocal, Push}: LoadLocalPush,
+	{Pop, Add}:        PopAdd,
+	{Pop, Sub}:        PopSub,
+	{Pop, Mul}:        PopMul,
+	{Eq, JumpIfFalse}: JumpIfNotEq,
+	{Ne, JumpIfFalse}: JumpIfNotNe,
+	{Lt, JumpIfFalse}: JumpIfNotLt,
+	{Le, JumpIfFalse}: JumpIfNotLe,
+	{Gt, JumpIfFalse}: JumpIfNotGt,
+	{Ge, JumpIfFalse}: JumpIfNotGe,
+}
+
+// emit appends an instruction, or combines it with the previous one
+// into a superinstruction, and returns its pc. None of the instructions
+// which are combined are safepoints, so the stack maps stay correct.
+func (a *Asm) emit(op Opcode, arg int) int {
+	code := a.Program.Code
+	if pc := len(code) - 1; pc >= 0 && a.target != len(code) {
+		prev := code[pc]
+		super, found := superinstructions[[2]Opcode{prev.Opcode(), op}]
+
+		// superinstructions always pop into r1
+		if found && (prev.Opcode() != Pop || prev.Arg() == int(ir.R1)) {
+			switch {
+			case opcodeFormat[super] == fmtNone:
+				arg = 0
+			case opcodeFormat[op] == fmtNone:
+				arg = prev.Arg()
+			}
+			code[pc] = Instr(super) | Instr(arg)<<8
+			return pc
+		}
+	}
+	a.Program.Code = append(code, Instr(op)|Instr(arg)<<8)
+	return len(code)
+}
+
+// safepoint records the stack map for the next instruction.
+func (a *Asm) safepoint() {
+	sm := StackMap{Depth: len(a.pushed)}
+	for i, ref := range a.pushed {
+		if ref {
+			sm.Refs = append(sm.Refs, i)
+		}
+	}
+	a.Program.StackMaps[len(a.Program.Code)] = sm
 }
 
-func (a *Asm) Prologue(fn string, locals int) {
-	a.fn = "_" + fn
+// Pos records the source line of the following instructions.
+func (a *Asm) Pos(file *token.File, line int, col int) {
+	pc := len(a.Program.Code)
+	lines := a.Program.Lines
+	if n := len(lines); n > 0 {
+		last := &lines[n-1]
+		if last.File == file.Filename && last.Line == line {
+			return
+		}
+		if last.PC == pc {
+			// no instructions were assembled for the last line
+			last.File, last.Line = file.Filename, line
+			return
+		}
+	}
+	a.Program.Lines = append(lines, Line{PC: pc, File: file.Filename, Line: line})
+}
+
+// Cover counts the runs of the block, if the assembler is in coverage
+// mode and any statements start in the block.
+func (a *Asm) Cover(blk *ir.Block) {
+	if !a.Coverage || len(blk.Stmts()) == 0 {
+		return
+	}
+
+	// the statements of inlined blocks are those of the function
+	// they were inlined from
+	cb := CoverBlock{Func: blk.Func.Name, Entry: blk.ID() == blk.Func.BlockAt(0).ID()}
+	if from, entry := blk.InlinedFrom(); from != "" {
+		cb.Func, cb.Entry = from, entry
+	}
+	for _, tok := range blk.Stmts() {
+		file, line, _ := blk.Func.PositionOf(tok)
+		cb.File = file.Filename
+		if len(cb.Lines) == 0 || cb.Lines[len(cb.Lines)-1] != line {
+			cb.Lines = append(cb.Lines, line)
+		}
+	}
+
+	a.instr1(Cover, len(a.Program.Cover))
+	a.Program.Cover = append(a.Program.Cover, cb)
+}
+
+func (a *Asm) Prologue(fn *ir.Func) {
+	a.fn = "_" + fn.Name
+	a.uni = fn.Types()
+	a.pushed = a.pushed[:0]
 	a.Label(a.fn)
-	a.instr1(Prologue, locals)
+
+	info := Func{
+		Name:   fn.Name,
+		Entry:  len(a.Program.Code),
+		Args:   len(fn.Types().Func(fn.Sig).ParamTypes()),
+		Locals: fn.NumLocals(),
+	}
+	for i := 0; i < fn.NumLocals(); i++ {
+		if isRef(fn.LocalType(i)) {
+			info.Refs = append(info.Refs, i)
+		}
+	}
+	a.Program.Funcs = append(a.Program.Funcs, info)
+
+	a.instr1(Prologue, fn.NumLocals())
 }
 
 func (a *Asm) Epilogue() {
 }
 
-func (a *Asm) Push(src ir.RegMask) {
+func (a *Asm) Push(src ir.RegMask, typ types.Type) {
 	if !src.HasReg(ir.R0) {
 		panic("src must be R0")
 	}
+	a.pushed = append(a.pushed, isRef(typ))
 	a.instr(Push)
 }
 
@@ -46,6 +184,7 @@ func (a *Asm) Pop(dest ir.RegMask) {
 	if len(dest.Regs()) != 1 {
 		panic("dest must have one register")
 	}
+	a.pushed = a.pushed[:len(a.pushed)-1]
 	a.instr1(Pop, int(dest.Pop()))
 }
 
@@ -87,7 +226,19 @@ func (a *Asm) LoadInt(dest ir.RegMask, imm int64) {
 	if !dest.HasReg(ir.R0) {
 		panic("dest must be R0")
 	}
-	a.instr1(LoadInt, int(imm))
+	if fits(imm, 56) {
+		a.instr1(LoadInt, int(imm))
+		return
+	}
+	a.instr1(LoadInt, int(imm>>32))
+	a.instr1(LoadIntLow, int(imm&0xffffffff))
+}
+
+// fits returns whether the constant fits in a signed immediate of the
+// number of bits. Wider constants are loaded in two parts, the high 32
+// bits and then the low 32 bits.
+func fits(c int64, bits int) bool {
+	return c == c<<(64-bits)>>(64-bits)
 }
 
 func (a *Asm) LocalAddr(dest ir.RegMask, local int) {
@@ -146,6 +297,8 @@ func (a *Asm) Div(dest ir.RegMask, src1 ir.RegMask, src2 ir.RegMask) {
 	if !src2.HasReg(ir.R0) {
 		panic("src2 must be R0")
 	}
+	// dividing by zero panics
+	a.safepoint()
 	a.instr(Div)
 }
 
@@ -238,9 +391,81 @@ func (a *Asm) Ge(dest ir.RegMask, src1 ir.RegMask, src2 ir.RegMask) {
 }
 
 func (a *Asm) Call(fn string) {
+	a.safepoint()
 	a.jump(Call, "_"+fn)
 }
 
+// CallExtern calls a host function, which takes the arguments which were
+// pushed on the stack.
+func (a *Asm) CallExtern(fn *ir.Func) {
+	nargs := len(fn.Types().Func(fn.Sig).ParamTypes())
+	a.pushed = a.pushed[:len(a.pushed)-nargs]
+
+	index := len(a.Program.Externs)
+	for i, ext := range a.Program.Externs {
+		if ext.Name == fn.Name {
+			index = i
+		}
+	}
+	if index == len(a.Program.Externs) {
+		a.Program.Externs = append(a.Program.Externs, Extern{Name: fn.Name, Args: nargs})
+	}
+
+	a.instr1(CallExtern, index)
+}
+
+// CallRuntime calls a runtime function. The low byte of the argument is
+// the index of the function, and the rest is flags describing the type
+// the function operates on.
+func (a *Asm) CallRuntime(fn string, typ types.Type) {
+	index := runtimeIndex(fn)
+	if runtimeFuncs[index].safepoint {
+		a.safepoint()
+	}
+
+	flags := 0
+	switch fn {
+	case "makemap":
+		flags = mapFlags(a.uni.Map(typ))
+	case "makechan":
+		flags = chanFlags(a.uni.Chan(typ))
+	}
+	a.instr1(CallRuntime, index|flags<<8)
+}
+
+// Go starts a goroutine, which suspends the current thread.
+func (a *Asm) Go(fn string) {
+	a.safepoint()
+	a.jump(Go, "_"+fn)
+}
+
+// Defer records a call to fn with the arguments in the registers,
+// to be made when the current function returns or panics.
+func (a *Asm) Defer(fn *ir.Func) {
+	params := fn.Types().Func(fn.Sig).ParamTypes()
+	info := DeferInfo{Args: len(params)}
+	for i, param := range params {
+		if isRef(param) {
+			info.Refs = append(info.Refs, i)
+		}
+	}
+	a.Program.Defers[len(a.Program.Code)] = info
+	a.jump(Defer, "_"+fn.Name)
+}
+
+// DeferReturn runs the deferred calls of the current function. Each
+// call returns to the jump after the deferreturn, which runs it again
+// until there are no calls left, and then it skips over the jump.
+func (a *Asm) DeferReturn() {
+	pc := len(a.Program.Code)
+	a.Program.Funcs[len(a.Program.Funcs)-1].DeferReturn = pc
+
+	a.safepoint()
+	a.instr1(DeferReturn, pc+2)
+	a.safepoint()
+	a.instr1(Jump, pc)
+}
+
 func (a *Asm) If(test ir.RegMask, then string, els string) {
 	if !test.HasReg(ir.R0) {
 		panic("test must be R0")
@@ -255,25 +480,27 @@ func (a *Asm) Jump(label string) {
 
 func (a *Asm) jump(op Opcode, label string) {
 	if loc, found := a.labels[label]; found {
+		if op == Jump {
+			// threads can be preempted at backward jumps
+			a.safepoint()
+		}
 		a.instr1(op, loc)
 		return
 	}
-	if a.refs == nil {
-		a.refs = make(map[string][]int)
-	}
-	a.refs[label] = append(a.refs[label], len(a.Program))
-	a.instr1(op, 0)
+	pc := a.emit(op, 0)
+	a.Program.Relocs[label] = append(a.Program.Relocs[label], pc)
 }
 
 func (a *Asm) Label(label string) {
-	loc := len(a.Program)
+	loc := len(a.Program.Code)
+	a.target = loc
 
 	// fixup any references to this label
-	if refs, found := a.refs[label]; found {
+	if refs, found := a.Program.Relocs[label]; found {
 		for _, ref := range refs {
-			a.Program[ref] |= Instr(loc) << 8
+			a.Program.Code[ref] |= Instr(loc) << 8
 		}
-		delete(a.refs, label)
+		delete(a.Program.Relocs, label)
 	}
 
 	if a.labels == nil {
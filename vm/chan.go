@@ -0,0 +1,197 @@
+package vm
+
+import "github.com/rj45/gosling/types"
+
+// chanElemRefs is set in a channel's flags when
+// its elements are heap references.
+const chanElemRefs = 1 << iota
+
+func chanFlags(typ *types.Chan) int {
+	if isRef(typ.Elem()) {
+		return chanElemRefs
+	}
+	return 0
+}
+
+// channel is a channel with a buffer of up to size values, and
+// queues of the threads waiting to send and receive on it.
+type channel struct {
+	buf    []int
+	size   int
+	closed bool
+	flags  int
+
+	sendq []*waiter
+	recvq []*waiter
+}
+
+// wait is a thread blocked until one of its cases is ready.
+// Plain sends and receives have one case, selects can have many.
+type wait struct {
+	t      *thread
+	reason string
+	cases  []*waiter
+}
+
+// waiter is a case of a wait, which is queued on a channel.
+type waiter struct {
+	wait *wait
+	ch   int
+	send bool
+
+	// val is the value to send, or the local to store
+	// the received value in, or -1 if there is none
+	val int
+
+	// ok is the local to store whether a value was received, or -1
+	ok int
+
+	// index is the case index returned by a select, or -1
+	index int
+}
+
+func newChannel(size int, flags int) *channel {
+	return &channel{buf: make([]int, 0, size), size: size, flags: flags}
+}
+
+func (ch *channel) words() int {
+	return 4 + ch.size
+}
+
+func (ch *channel) scan(mark func(ref int)) {
+	if ch.flags&chanElemRefs == 0 {
+		return
+	}
+	for _, val := range ch.buf {
+		mark(val)
+	}
+	for _, w := range ch.sendq {
+		mark(w.val)
+	}
+}
+
+func (c *CPU) channel(ref int) *channel {
+	if ref == 0 {
+		return nil
+	}
+	return c.heap.objects[ref-1].(*channel)
+}
+
+// trySend sends val on ch if it can without blocking. The channel
+// must not be closed.
+func (c *CPU) trySend(ch *channel, val int) bool {
+	if len(ch.recvq) > 0 {
+		w := ch.recvq[0]
+		ch.recvq = ch.recvq[1:]
+		c.wake(w, val, true)
+		return true
+	}
+
+	if len(ch.buf) < ch.size {
+		ch.buf = append(ch.buf, val)
+		return true
+	}
+
+	return false
+}
+
+// tryRecv receives from ch if it can without blocking. The
+// ok result is false if the channel is closed and empty.
+func (c *CPU) tryRecv(ch *channel) (val int, ok bool, done bool) {
+	if len(ch.buf) > 0 {
+		val = ch.buf[0]
+		copy(ch.buf, ch.buf[1:])
+		ch.buf = ch.buf[:len(ch.buf)-1]
+
+		// make room for the first blocked sender
+		if len(ch.sendq) > 0 {
+			w := ch.sendq[0]
+			ch.sendq = ch.sendq[1:]
+			ch.buf = append(ch.buf, w.val)
+			c.wake(w, 0, true)
+		}
+		return val, true, true
+	}
+
+	if len(ch.sendq) > 0 {
+		w := ch.sendq[0]
+		ch.sendq = ch.sendq[1:]
+		c.wake(w, 0, true)
+		return w.val, true, true
+	}
+
+	if ch.closed {
+		return 0, false, true
+	}
+
+	return 0, false, false
+}
+
+// park blocks the current thread with a waiter queued on
+// the channel of each case.
+func (c *CPU) park(reason string, cases ...*waiter) {
+	w := &wait{reason: reason, cases: cases}
+	for _, wr := range cases {
+		wr.wait = w
+		ch := c.channel(wr.ch)
+		if wr.send {
+			ch.sendq = append(ch.sendq, wr)
+		} else {
+			ch.recvq = append(ch.recvq, wr)
+		}
+	}
+	c.block(w)
+}
+
+// wake completes a waiter which has been removed from its
+// channel's queue, and makes its thread runnable.
+func (c *CPU) wake(w *waiter, val int, ok bool) {
+	t := w.wait.t
+
+	for _, other := range w.wait.cases {
+		if other != w {
+			c.dequeue(other)
+		}
+	}
+
+	if !w.send {
+		if w.val >= 0 {
+			t.ctx.locals[w.val] = val
+		}
+		if w.ok >= 0 {
+			t.ctx.locals[w.ok] = boolInt(ok)
+		}
+	}
+
+	switch {
+	case w.index >= 0:
+		t.ctx.regs[0] = w.index
+	case !w.send:
+		t.ctx.regs[0] = val
+		t.retRef = c.channel(w.ch).flags&chanElemRefs != 0
+	}
+
+	c.ready(t)
+}
+
+// dequeue removes a waiter from its channel's queue.
+func (c *CPU) dequeue(w *waiter) {
+	ch := c.channel(w.ch)
+	q := &ch.recvq
+	if w.send {
+		q = &ch.sendq
+	}
+	for i, other := range *q {
+		if other == w {
+			*q = append((*q)[:i], (*q)[i+1:]...)
+			return
+		}
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
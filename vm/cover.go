@@ -0,0 +1,181 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/rj45/gosling/token"
+)
+
+// coverFile is the coverage of the lines of a source file.
+type coverFile struct {
+	name string
+
+	// lines are the number of times each line with statements ran,
+	// which is the most times any block with a statement on the
+	// line ran
+	lines map[int]int
+
+	// funcs are the functions in the file, and the number of
+	// times they were called, which is how many times their entry
+	// blocks ran, inlined or not
+	funcs     []string
+	funcLines map[string]int
+	calls     map[string]int
+}
+
+// coverFiles returns the coverage of each file with instrumented blocks,
+// sorted by name.
+func (c *CPU) coverFiles() []*coverFile {
+	files := make(map[string]*coverFile)
+	for i, blk := range c.program.Cover {
+		f := files[blk.File]
+		if f == nil {
+			f = &coverFile{
+				name:      blk.File,
+				lines:     make(map[int]int),
+				funcLines: make(map[string]int),
+				calls:     make(map[string]int),
+			}
+			files[blk.File] = f
+		}
+
+		count := 0
+		if i < len(c.Coverage) {
+			count = c.Coverage[i]
+		}
+		for _, line := range blk.Lines {
+			f.lines[line] = max(f.lines[line], count)
+		}
+
+		if _, found := f.funcLines[blk.Func]; !found {
+			f.funcs = append(f.funcs, blk.Func)
+			f.funcLines[blk.Func] = blk.Lines[0]
+		}
+		if blk.Entry {
+			f.funcLines[blk.Func] = blk.Lines[0]
+			f.calls[blk.Func] += count
+		}
+	}
+
+	out := make([]*coverFile, 0, len(files))
+	for _, f := range files {
+		out = append(out, f)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].name < out[j].name
+	})
+	return out
+}
+
+// sortedLines returns the lines with statements in order.
+func (f *coverFile) sortedLines() []int {
+	lines := make([]int, 0, len(f.lines))
+	for line := range f.lines {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// hit returns the number of lines with statements which ran.
+func (f *coverFile) hit() int {
+	n := 0
+	for _, count := range f.lines {
+		if count > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// WriteLCOV writes the coverage of the program as an LCOV tracefile,
+// with the number of times each line and function ran.
+func (c *CPU) WriteLCOV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "TN:")
+	for _, f := range c.coverFiles() {
+		fmt.Fprintf(bw, "SF:%s\n", f.name)
+
+		hit := 0
+		for _, fn := range f.funcs {
+			fmt.Fprintf(bw, "FN:%d,%s\n", f.funcLines[fn], fn)
+		}
+		for _, fn := range f.funcs {
+			fmt.Fprintf(bw, "FNDA:%d,%s\n", f.calls[fn], fn)
+			if f.calls[fn] > 0 {
+				hit++
+			}
+		}
+		fmt.Fprintf(bw, "FNF:%d\n", len(f.funcs))
+		fmt.Fprintf(bw, "FNH:%d\n", hit)
+
+		for _, line := range f.sortedLines() {
+			fmt.Fprintf(bw, "DA:%d,%d\n", line, f.lines[line])
+		}
+		fmt.Fprintf(bw, "LF:%d\n", len(f.lines))
+		fmt.Fprintf(bw, "LH:%d\n", f.hit())
+		fmt.Fprintln(bw, "end_of_record")
+	}
+	return bw.Flush()
+}
+
+// WriteCoverHTML writes the coverage of the program as an HTML page
+// showing the source of each file, with the lines which ran and the
+// lines which did not highlighted. The sources are found in the file
+// set the program was compiled from.
+func (c *CPU) WriteCoverHTML(w io.Writer, fset *token.FileSet) error {
+	sources := make(map[string][]byte)
+	for _, file := range fset.Files() {
+		sources[file.Filename] = file.Src
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprint(bw, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gosling coverage</title>
+<style>
+body { font-family: sans-serif; }
+pre { font-family: monospace; line-height: 1.3; }
+.line { display: block; }
+.num { display: inline-block; width: 4em; color: #888; text-align: right; margin-right: 1em; }
+.count { display: inline-block; width: 5em; color: #888; text-align: right; margin-right: 1em; }
+.hit { background: #dfd; }
+.miss { background: #fdd; }
+</style>
+</head>
+<body>
+`)
+
+	for _, f := range c.coverFiles() {
+		percent := 100.0
+		if len(f.lines) > 0 {
+			percent = 100 * float64(f.hit()) / float64(len(f.lines))
+		}
+		fmt.Fprintf(bw, "<h2>%s: %.1f%% of lines covered</h2>\n<pre>\n", html.EscapeString(f.name), percent)
+
+		lines := strings.Split(string(sources[f.name]), "\n")
+		for i, text := range lines {
+			line := i + 1
+			class, count := "line", ""
+			if n, found := f.lines[line]; found {
+				class, count = "line miss", "0"
+				if n > 0 {
+					class, count = "line hit", fmt.Sprint(n)
+				}
+			}
+			fmt.Fprintf(bw, "<span class=\"%s\"><span class=\"num\">%d</span><span class=\"count\">%s</span>%s</span>",
+				class, line, count, html.EscapeString(text))
+		}
+		fmt.Fprint(bw, "</pre>\n")
+	}
+
+	fmt.Fprint(bw, "</body>\n</html>\n")
+	return bw.Flush()
+}
@@ -0,0 +1,42 @@
+package vm
+
+// decoded is an instruction with its operands unpacked, so that they
+// are not decoded again every time the instruction executes. Jump
+// targets are pcs in the decoded code, which are the same as in the
+// program, as each instruction decodes to one.
+type decoded struct {
+	op Opcode
+
+	// arg is the argument or the immediate
+	arg int
+
+	// a, b and c are the slots of register instructions, where c is
+	// the immediate of those with one
+	a, b, c int
+}
+
+// decode predecodes the instructions of a program.
+func decode(code []Instr) []decoded {
+	out := make([]decoded, len(code))
+	for i, instr := range code {
+		d := decoded{op: instr.Opcode()}
+		if int(d.op) < len(opcodeFormat) {
+			switch opcodeFormat[d.op] {
+			case fmtArg:
+				d.arg = instr.Arg()
+			case fmtA, fmtAB, fmtABC, fmtFrame:
+				d.a, d.b, d.c = instr.A(), instr.B(), instr.C()
+			case fmtABImm:
+				d.a, d.b, d.c = instr.A(), instr.B(), instr.CImm()
+			case fmtAImm:
+				d.a, d.arg = instr.A(), instr.Imm()
+			case fmtImm:
+				d.arg = instr.Int()
+			case fmtArgC:
+				d.arg, d.c = instr.Arg(), instr.C()
+			}
+		}
+		out[i] = d
+	}
+	return out
+}
@@ -0,0 +1,185 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// deferred is a call recorded by a defer statement.
+type deferred struct {
+	entry int
+	args  []int
+	refs  []int
+
+	// frame is the depth of the frame which deferred the call
+	frame int
+}
+
+// panicking is the state of a thread which is panicking.
+type panicking struct {
+	value     int
+	err       string
+	recovered bool
+
+	// frame is the depth of the frame which deferred the running
+	// call, and depth is the depth of the running call itself, which
+	// is the only frame recover can stop the panic from.
+	frame int
+	depth int
+}
+
+// PanicError is returned by Run when a thread panics and
+// does not recover.
+type PanicError struct {
+	Value  int
+	Thread int
+
+	// Err is the message of the runtime error the thread panicked
+	// with, such as a division by zero, or empty if it called panic.
+	Err string
+
+	// Stack is the names of the functions on the thread's
+	// call stack, innermost first.
+	Stack []string
+}
+
+func (e *PanicError) Error() string {
+	var sb strings.Builder
+	if e.Err != "" {
+		fmt.Fprintf(&sb, "panic: runtime error: %s\n\ngoroutine %d [running]:", e.Err, e.Thread)
+	} else {
+		fmt.Fprintf(&sb, "panic: %d\n\ngoroutine %d [running]:", e.Value, e.Thread)
+	}
+	for _, fn := range e.Stack {
+		fmt.Fprintf(&sb, "\n\t%s", fn)
+	}
+	return sb.String()
+}
+
+// deferCall records a deferred call to the function at entry,
+// with the arguments in the registers.
+func (c *CPU) deferCall(entry int) {
+	info := c.program.Defers[c.pc-1]
+	c.defers = append(c.defers, deferred{
+		entry: entry,
+		args:  append([]int(nil), c.regs[:info.Args]...),
+		refs:  info.Refs,
+		frame: len(c.callStack),
+	})
+}
+
+// callDeferred calls the last deferred call of the current frame, which
+// returns to ret. It returns false if the frame has no deferred calls.
+func (c *CPU) callDeferred(ret int) bool {
+	n := len(c.defers)
+	if n == 0 || c.defers[n-1].frame != len(c.callStack) {
+		return false
+	}
+	c.call(ret)
+	return true
+}
+
+// call calls the last deferred call, which returns to ret.
+func (c *CPU) call(ret int) {
+	d := c.defers[len(c.defers)-1]
+	c.defers = c.defers[:len(c.defers)-1]
+
+	c.checkCall(d.entry)
+	copy(c.regs[:], d.args)
+	c.callStack = append(c.callStack, ret)
+	c.localStack = append(c.localStack, c.locals)
+	c.pc = d.entry
+}
+
+// gopanic(v)
+func (c *CPU) gopanic(int) {
+	c.panic = &panicking{value: c.regs[0]}
+	c.unwind(c.pc)
+}
+
+// runtimeErrorValue is the value recover returns for a runtime error.
+const runtimeErrorValue = -1
+
+// runtimeError panics with a runtime error, like gopanic, so the
+// thread unwinds and the error can be recovered. The instruction which
+// failed must be a safepoint, as its frame is left on the stack while
+// the deferred calls run, and the caller must return without changing
+// the context, which is set up for the first deferred call.
+func (c *CPU) runtimeError(msg string) {
+	c.panic = &panicking{value: runtimeErrorValue, err: msg}
+	c.unwind(c.pc)
+}
+
+// gorecover() v
+func (c *CPU) gorecover(int) {
+	p := c.panic
+	if p == nil || p.recovered || p.depth != len(c.callStack) {
+		c.regs[0] = 0
+		return
+	}
+	p.recovered = true
+	c.regs[0] = p.value
+}
+
+// unwind makes the next deferred call while panicking. The frames are
+// left on the stack while the deferred calls run, and each returns to
+// ret, the instruction after the one which panicked, where the return
+// continues unwinding until the panic is recovered or there are no
+// deferred calls left.
+func (c *CPU) unwind(ret int) {
+	p := c.panic
+	if p.recovered {
+		c.resume(p.frame)
+		return
+	}
+
+	if len(c.defers) == 0 {
+		panic(c.panicError())
+	}
+
+	p.frame = c.defers[len(c.defers)-1].frame
+	c.call(ret)
+	p.depth = len(c.callStack)
+}
+
+// resume stops panicking and returns from the frame at depth as if
+// it had returned normally with a zero result, by resuming it at its
+// deferreturn to run the rest of its deferred calls.
+func (c *CPU) resume(frame int) {
+	c.panic = nil
+
+	base := 0
+	for i := 0; i < frame; i++ {
+		base += c.program.StackMaps[c.callStack[i]-1].Depth
+	}
+
+	pc := c.pc - 1
+	if frame < len(c.callStack) {
+		pc = c.callStack[frame] - 1
+		c.locals = c.localStack[frame]
+		c.callStack = c.callStack[:frame]
+		c.localStack = c.localStack[:frame]
+	}
+
+	fn := c.program.FuncFor(pc)
+	c.pc = fn.DeferReturn
+
+	// the result, if there is one, is in a register, like a pushed value
+	clear(c.locals[fn.Locals : fn.Locals+fn.Regs])
+
+	// the result, if there is one, is pushed before the deferreturn
+	c.stack = c.stack[:base]
+	for i := 0; i < c.program.StackMaps[c.pc].Depth; i++ {
+		c.stack = append(c.stack, 0)
+	}
+	c.regs[0] = 0
+}
+
+func (c *CPU) panicError() *PanicError {
+	err := &PanicError{Value: c.panic.value, Thread: c.cur.id, Err: c.panic.err}
+	err.Stack = append(err.Stack, c.program.FuncFor(c.pc-1).Name)
+	for i := len(c.callStack) - 1; i >= 0; i-- {
+		err.Stack = append(err.Stack, c.program.FuncFor(c.callStack[i]-1).Name)
+	}
+	return err
+}
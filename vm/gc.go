@@ -0,0 +1,238 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/rj45/gosling/types"
+)
+
+// minHeapWords is the smallest heap size which triggers a collection,
+// so that small programs never need to collect at all.
+const minHeapWords = 4096
+
+// object is something allocated on the heap.
+type object interface {
+	// words returns the size of the object in words.
+	words() int
+
+	// scan calls mark with each heap reference held by the object.
+	scan(mark func(ref int))
+}
+
+// heap is a mark and sweep garbage collected heap. Objects are
+// referenced by their index + 1, so that 0 is the nil reference.
+type heap struct {
+	objects []object
+	free    []int
+
+	// marked is the mark bit of each object, and grey is the
+	// list of marked objects which have not been scanned yet
+	marked []bool
+	grey   []int
+
+	// size is the size of the heap in words
+	size   int
+	nextGC int
+
+	stats HeapStats
+}
+
+// HeapStats are statistics about the heap and garbage collector,
+// similar to those in runtime.MemStats.
+type HeapStats struct {
+	// Objects is the number of objects in the heap, including
+	// unreachable objects which have not been collected yet.
+	Objects int
+
+	// Words is the size of the heap in words.
+	Words int
+
+	// TotalAlloc is the cumulative number of words allocated.
+	TotalAlloc int
+
+	// Mallocs is the cumulative number of objects allocated.
+	Mallocs int
+
+	// Frees is the cumulative number of objects freed.
+	Frees int
+
+	// NumGC is the number of completed collections.
+	NumGC int
+
+	// NextGC is the heap size in words which triggers the next collection.
+	NextGC int
+}
+
+// isRef returns whether values of the type are heap references.
+// Pointers are not heap references, since they can only point
+// at locals.
+func isRef(typ types.Type) bool {
+	switch typ.Kind() {
+	case types.MapType, types.ChanType:
+		return typ.Indirections() == 0
+	}
+	return false
+}
+
+func (c *CPU) resetHeap() {
+	c.heap = heap{nextGC: minHeapWords}
+}
+
+// HeapStats returns statistics about the heap.
+func (c *CPU) HeapStats() HeapStats {
+	stats := c.heap.stats
+	stats.Objects = len(c.heap.objects) - len(c.heap.free)
+	stats.Words = c.heap.size
+	stats.NextGC = c.heap.nextGC
+	return stats
+}
+
+// alloc adds an object to the heap, collecting garbage first if
+// the heap has grown too large, and returns a reference to it.
+// It must only be called from runtime functions at safepoints.
+func (c *CPU) alloc(obj object) int {
+	size := obj.words()
+	if c.GCStress || (c.GCPercent >= 0 && c.heap.size+size > c.heap.nextGC) {
+		c.GC()
+	}
+	if c.MaxHeap > 0 && c.heap.size+size > c.MaxHeap {
+		// collect in case the garbage would make room
+		c.GC()
+		c.checkHeap(size)
+	}
+
+	c.heap.size += size
+	c.heap.stats.TotalAlloc += size
+	c.heap.stats.Mallocs++
+
+	if n := len(c.heap.free); n > 0 {
+		index := c.heap.free[n-1]
+		c.heap.free = c.heap.free[:n-1]
+		c.heap.objects[index] = obj
+		return index + 1
+	}
+
+	c.heap.objects = append(c.heap.objects, obj)
+	return len(c.heap.objects)
+}
+
+// resize accounts for an object growing or shrinking in place.
+func (c *CPU) resize(delta int) {
+	c.checkHeap(delta)
+	c.heap.size += delta
+	if delta > 0 {
+		c.heap.stats.TotalAlloc += delta
+	}
+}
+
+// GC runs a full garbage collection. While the program is running
+// it can only happen at a safepoint, since the roots are found with
+// the stack maps. Once the program has exited, nothing is reachable.
+func (c *CPU) GC() {
+	h := &c.heap
+	h.marked = make([]bool, len(h.objects))
+
+	if c.running {
+		c.markRoots()
+	}
+
+	for len(h.grey) > 0 {
+		ref := h.grey[len(h.grey)-1]
+		h.grey = h.grey[:len(h.grey)-1]
+		h.objects[ref-1].scan(c.mark)
+	}
+
+	h.size = 0
+	for i, obj := range h.objects {
+		if obj == nil {
+			continue
+		}
+		if !h.marked[i] {
+			h.objects[i] = nil
+			h.free = append(h.free, i)
+			h.stats.Frees++
+			continue
+		}
+		h.size += obj.words()
+	}
+	h.marked = nil
+
+	h.nextGC = h.size + h.size*c.GCPercent/100
+	if h.nextGC < minHeapWords {
+		h.nextGC = minHeapWords
+	}
+	h.stats.NumGC++
+}
+
+// markRoots marks every object referenced by a thread. Each frame's
+// locals are described by its function, and its part of the value
+// stack by the stack map at the safepoint it is suspended at. gosling
+// has no global variables yet, so the threads hold all the roots.
+func (c *CPU) markRoots() {
+	for _, t := range c.threads {
+		if t == c.cur {
+			c.markFrames(&c.context, c.pc-1)
+			c.markDefers(&c.context)
+			continue
+		}
+
+		c.markFrames(&t.ctx, t.safepoint)
+		c.markDefers(&t.ctx)
+		if t.retRef {
+			c.mark(t.ctx.regs[0])
+		}
+
+		// the channels a thread is blocked on may only be in its registers
+		if t.wait != nil {
+			for _, w := range t.wait.cases {
+				c.mark(w.ch)
+			}
+		}
+	}
+}
+
+// markFrames marks the frames of a thread suspended at the safepoint.
+func (c *CPU) markFrames(ctx *context, safepoint int) {
+	base := 0
+	for i := 0; i <= len(ctx.callStack); i++ {
+		pc, locals := safepoint, ctx.locals
+		if i < len(ctx.callStack) {
+			pc, locals = ctx.callStack[i]-1, ctx.localStack[i]
+		}
+
+		fn := c.program.FuncFor(pc)
+		for _, ref := range fn.Refs {
+			c.mark(locals[ref])
+		}
+
+		sm, found := c.program.StackMaps[pc]
+		if !found {
+			panic(fmt.Sprintf("gc: no stack map at pc %d in %s", pc, fn.Name))
+		}
+		for _, ref := range sm.Refs {
+			c.mark(ctx.stack[base+ref])
+		}
+		base += sm.Depth
+	}
+
+	if base != len(ctx.stack) {
+		panic(fmt.Sprintf("gc: stack maps cover %d values but the stack has %d", base, len(ctx.stack)))
+	}
+}
+
+// markDefers marks the arguments of a thread's deferred calls.
+func (c *CPU) markDefers(ctx *context) {
+	for _, d := range ctx.defers {
+		for _, ref := range d.refs {
+			c.mark(d.args[ref])
+		}
+	}
+}
+
+func (c *CPU) mark(ref int) {
+	if ref == 0 || c.heap.marked[ref-1] {
+		return
+	}
+	c.heap.marked[ref-1] = true
+	c.heap.grey = append(c.heap.grey, ref)
+}
@@ -0,0 +1,199 @@
+package vm
+
+import "github.com/rj45/gosling/types"
+
+type slotState uint8
+
+const (
+	emptySlot slotState = iota
+	fullSlot
+	deletedSlot
+)
+
+// hashMap is an open addressing hash table with linear probing
+// which maps words to words. Deleted entries leave a tombstone
+// behind so that probe sequences are not broken, and the
+// tombstones are cleared out when the table is rehashed.
+type hashMap struct {
+	keys  []int
+	vals  []int
+	state []slotState
+
+	// count is the number of live entries
+	count int
+
+	// used is the number of live entries plus tombstones
+	used int
+
+	// flags are whether the keys and values are heap references
+	flags int
+}
+
+const (
+	mapKeyRefs = 1 << iota
+	mapElemRefs
+)
+
+// mapFlags returns the flags describing which parts of the map are heap references.
+func mapFlags(m *types.Map) int {
+	flags := 0
+	if isRef(m.Key()) {
+		flags |= mapKeyRefs
+	}
+	if isRef(m.Elem()) {
+		flags |= mapElemRefs
+	}
+	return flags
+}
+
+const minMapSize = 8
+
+func newHashMap(hint int, flags int) *hashMap {
+	size := minMapSize
+	for size*3/4 < hint {
+		size *= 2
+	}
+	return &hashMap{
+		keys:  make([]int, size),
+		vals:  make([]int, size),
+		state: make([]slotState, size),
+		flags: flags,
+	}
+}
+
+// words returns the size of the map in words, including a header.
+func (m *hashMap) words() int {
+	return 4 + len(m.keys) + len(m.vals) + (len(m.state)+7)/8
+}
+
+func (m *hashMap) scan(mark func(ref int)) {
+	if m.flags == 0 {
+		return
+	}
+	for i, state := range m.state {
+		if state != fullSlot {
+			continue
+		}
+		if m.flags&mapKeyRefs != 0 {
+			mark(m.keys[i])
+		}
+		if m.flags&mapElemRefs != 0 {
+			mark(m.vals[i])
+		}
+	}
+}
+
+// hashWord is the splitmix64 finalizer, which mixes all bits of
+// the key so that sequential keys do not form clusters.
+func hashWord(k int) uint64 {
+	x := uint64(k)
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// find returns the slot containing the key, or else the slot
+// the key should be inserted into if it is not found.
+func (m *hashMap) find(key int) (int, bool) {
+	mask := len(m.keys) - 1
+	insert := -1
+	for i := int(hashWord(key)) & mask; ; i = (i + 1) & mask {
+		switch m.state[i] {
+		case emptySlot:
+			if insert < 0 {
+				insert = i
+			}
+			return insert, false
+		case deletedSlot:
+			if insert < 0 {
+				insert = i
+			}
+		case fullSlot:
+			if m.keys[i] == key {
+				return i, true
+			}
+		}
+	}
+}
+
+func (m *hashMap) len() int {
+	if m == nil {
+		return 0
+	}
+	return m.count
+}
+
+func (m *hashMap) get(key int) (int, bool) {
+	if m == nil {
+		return 0, false
+	}
+	i, found := m.find(key)
+	if !found {
+		return 0, false
+	}
+	return m.vals[i], true
+}
+
+func (m *hashMap) set(key, val int) {
+	i, found := m.find(key)
+	if found {
+		m.vals[i] = val
+		return
+	}
+
+	if m.state[i] == emptySlot {
+		if (m.used+1)*4 > len(m.keys)*3 {
+			m.rehash()
+			i, _ = m.find(key)
+		}
+		m.used++
+	}
+
+	m.keys[i] = key
+	m.vals[i] = val
+	m.state[i] = fullSlot
+	m.count++
+}
+
+func (m *hashMap) delete(key int) {
+	if m == nil {
+		return
+	}
+	i, found := m.find(key)
+	if !found {
+		return
+	}
+	m.state[i] = deletedSlot
+	m.count--
+}
+
+// rehash reinserts all live entries, growing the table
+// if more than half of it is live entries.
+func (m *hashMap) rehash() {
+	size := len(m.keys)
+	if m.count*2 >= size {
+		size *= 2
+	}
+
+	old := *m
+	*m = hashMap{
+		keys:  make([]int, size),
+		vals:  make([]int, size),
+		state: make([]slotState, size),
+		flags: old.flags,
+	}
+
+	for i, state := range old.state {
+		if state == fullSlot {
+			j, _ := m.find(old.keys[i])
+			m.keys[j] = old.keys[i]
+			m.vals[j] = old.vals[i]
+			m.state[j] = fullSlot
+			m.count++
+			m.used++
+		}
+	}
+}
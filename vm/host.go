@@ -0,0 +1,42 @@
+package vm
+
+import "fmt"
+
+// HostFunc is a Go function which a program can call as an extern
+// function. Every argument and the result are words, and the result
+// of a host function bound to an extern function without one is
+// ignored.
+type HostFunc func(args ...int) int
+
+// RegisterHost registers a host function, which is bound to the extern
+// functions of the same name when the program runs.
+func (c *CPU) RegisterHost(name string, fn HostFunc) {
+	if c.hosts == nil {
+		c.hosts = make(map[string]HostFunc)
+	}
+	c.hosts[name] = fn
+}
+
+// bindExterns binds the program's extern functions to host functions.
+func (c *CPU) bindExterns() error {
+	c.externs = make([]HostFunc, len(c.program.Externs))
+	for i, ext := range c.program.Externs {
+		fn, found := c.hosts[ext.Name]
+		if !found {
+			return fmt.Errorf("no host function for extern function %s", ext.Name)
+		}
+		c.externs[i] = fn
+	}
+	return nil
+}
+
+// callHost calls the host function bound to an extern function,
+// with the arguments on the stack.
+func (c *CPU) callHost(index int) {
+	nargs := c.program.Externs[index].Args
+	args := make([]int, nargs)
+	copy(args, c.stack[len(c.stack)-nargs:])
+	c.stack = c.stack[:len(c.stack)-nargs]
+
+	c.regs[0] = c.externs[index](args...)
+}
@@ -0,0 +1,163 @@
+package vm
+
+import (
+	stdcontext "context"
+	"fmt"
+)
+
+// checkInterval is how often the instruction limit and whether
+// RunContext has been canceled are checked, in instructions.
+const checkInterval = 1 << 12
+
+// Limits bound the resources a program can use, so that a runaway
+// program returns an error rather than hanging or crashing the host.
+// A limit of zero or less is no limit.
+type Limits struct {
+	// MaxInstructions is the number of instructions the program
+	// can execute, summed across all of its threads.
+	MaxInstructions int
+
+	// MaxCallDepth is the number of frames each thread's call
+	// stack can hold.
+	MaxCallDepth int
+
+	// MaxStack is the number of words each thread's value
+	// stack can hold.
+	MaxStack int
+
+	// MaxHeap is the size of the heap in words, after collecting
+	// garbage.
+	MaxHeap int
+}
+
+// InstructionLimitError is returned when the program executes more
+// instructions than MaxInstructions.
+type InstructionLimitError struct {
+	Limit int
+}
+
+func (e *InstructionLimitError) Error() string {
+	return fmt.Sprintf("instruction limit of %d exceeded", e.Limit)
+}
+
+// CallDepthError is returned when a thread's calls nest deeper
+// than MaxCallDepth, such as with infinite recursion.
+type CallDepthError struct {
+	Limit  int
+	Thread int
+	Func   string
+}
+
+func (e *CallDepthError) Error() string {
+	return fmt.Sprintf("goroutine %d exceeded call depth limit of %d calling %s", e.Thread, e.Limit, e.Func)
+}
+
+// StackLimitError is returned when a thread's value stack grows
+// larger than MaxStack.
+type StackLimitError struct {
+	Limit  int
+	Thread int
+}
+
+func (e *StackLimitError) Error() string {
+	return fmt.Sprintf("goroutine %d exceeded stack limit of %d words", e.Thread, e.Limit)
+}
+
+// HeapLimitError is returned when the live heap would grow
+// larger than MaxHeap.
+type HeapLimitError struct {
+	Limit int
+
+	// Size is the size in words the heap would have grown to.
+	Size int
+}
+
+func (e *HeapLimitError) Error() string {
+	return fmt.Sprintf("heap of %d words exceeds limit of %d words", e.Size, e.Limit)
+}
+
+// CanceledError is returned by RunContext when its context is
+// canceled or its deadline passes before the program returns.
+type CanceledError struct {
+	Err error
+}
+
+func (e *CanceledError) Error() string {
+	return "execution canceled: " + e.Err.Error()
+}
+
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// RunContext runs the program like Run, but stops with a *CanceledError
+// once the context is done. Cancellation is checked periodically, so the
+// program may run a few more instructions before it stops, and it is not
+// noticed while a host function is running.
+func (c *CPU) RunContext(ctx stdcontext.Context) (result int, err error) {
+	c.ctx = ctx
+	defer func() { c.ctx = nil }()
+	return c.run(0, nil)
+}
+
+// Steps returns the number of instructions the last run executed,
+// summed across all of its threads.
+func (c *CPU) Steps() int {
+	return c.steps
+}
+
+// nextCheck checks the instruction limit, and returns the number of
+// instructions which can execute before it is checked again, or the
+// profiler takes its next sample.
+func (c *CPU) nextCheck() int {
+	n := checkInterval
+	if c.Profiler != nil {
+		n = min(n, c.Profiler.left)
+	}
+	if c.MaxInstructions > 0 {
+		left := c.MaxInstructions - c.steps
+		if left <= 0 {
+			panic(&InstructionLimitError{Limit: c.MaxInstructions})
+		}
+		n = min(n, left)
+	}
+	return n
+}
+
+// checkCancel checks whether the context of RunContext is done.
+func (c *CPU) checkCancel() {
+	if c.ctx == nil {
+		return
+	}
+	select {
+	case <-c.ctx.Done():
+		panic(&CanceledError{Err: c.ctx.Err()})
+	default:
+	}
+}
+
+// checkCall checks the call depth limit before calling the
+// function at entry.
+func (c *CPU) checkCall(entry int) {
+	if c.MaxCallDepth > 0 && len(c.callStack) >= c.MaxCallDepth {
+		err := &CallDepthError{Limit: c.MaxCallDepth, Thread: c.cur.id}
+		if fn := c.program.FuncFor(entry); fn != nil {
+			err.Func = fn.Name
+		}
+		panic(err)
+	}
+}
+
+// checkStack checks the value stack limit before a value is pushed.
+func (c *CPU) checkStack() {
+	if c.MaxStack > 0 && len(c.stack) >= c.MaxStack {
+		panic(&StackLimitError{Limit: c.MaxStack, Thread: c.cur.id})
+	}
+}
+
+// checkHeap checks that the heap can grow by size words.
+func (c *CPU) checkHeap(size int) {
+	if c.MaxHeap > 0 && c.heap.size+size > c.MaxHeap {
+		panic(&HeapLimitError{Limit: c.MaxHeap, Size: c.heap.size + size})
+	}
+}
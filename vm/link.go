@@ -0,0 +1,114 @@
+package vm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Link links the programs of separately assembled packages into one
+// program, resolving the calls between them. Execution starts at the
+// beginning of the first program, so it must be the main package.
+func Link(progs ...*Program) (*Program, error) {
+	out := &Program{
+		StackMaps: make(map[int]StackMap),
+		Defers:    make(map[int]DeferInfo),
+		Relocs:    make(map[string][]int),
+	}
+	entries := make(map[string]int)
+	externs := make(map[string]int)
+
+	for _, prog := range progs {
+		base := len(out.Code)
+
+		// references to other programs are resolved once all are added
+		external := make(map[int]bool)
+		for label, refs := range prog.Relocs {
+			for _, ref := range refs {
+				external[ref] = true
+				out.Relocs[label] = append(out.Relocs[label], base+ref)
+			}
+		}
+
+		// the same extern function can be called by several programs
+		externIndex := make([]int, len(prog.Externs))
+		for i, ext := range prog.Externs {
+			index, found := externs[ext.Name]
+			if !found {
+				index = len(out.Externs)
+				externs[ext.Name] = index
+				out.Externs = append(out.Externs, ext)
+			}
+			externIndex[i] = index
+		}
+
+		coverBase := len(out.Cover)
+		out.Cover = append(out.Cover, prog.Cover...)
+
+		for pc, instr := range prog.Code {
+			switch {
+			case argIsPC(instr.Opcode()) && !external[pc]:
+				instr = instr.withArg(base + instr.Arg())
+			case instr.Opcode() == CallExtern:
+				instr = Instr(CallExtern) | Instr(externIndex[instr.Arg()])<<8
+			case instr.Opcode() == Cover:
+				instr = instr.withArg(coverBase + instr.Arg())
+			}
+			out.Code = append(out.Code, instr)
+		}
+
+		for _, fn := range prog.Funcs {
+			if _, found := entries["_"+fn.Name]; found {
+				return nil, fmt.Errorf("duplicate symbol %s", fn.Name)
+			}
+			fn.Entry += base
+			if fn.DeferReturn != 0 {
+				fn.DeferReturn += base
+			}
+			entries["_"+fn.Name] = fn.Entry
+			out.Funcs = append(out.Funcs, fn)
+		}
+
+		for _, line := range prog.Lines {
+			line.PC += base
+			out.Lines = append(out.Lines, line)
+		}
+
+		for pc, sm := range prog.StackMaps {
+			out.StackMaps[base+pc] = sm
+		}
+		for pc, info := range prog.Defers {
+			out.Defers[base+pc] = info
+		}
+	}
+
+	labels := make([]string, 0, len(out.Relocs))
+	for label := range out.Relocs {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		entry, found := entries[label]
+		if !found {
+			return nil, fmt.Errorf("undefined: %s", strings.TrimPrefix(label, "_"))
+		}
+		for _, ref := range out.Relocs[label] {
+			out.Code[ref] |= Instr(entry) << 8
+		}
+		delete(out.Relocs, label)
+	}
+
+	return out, nil
+}
+
+// argIsPC returns whether the argument of an instruction is the pc of
+// another instruction, which moves when programs are linked.
+func argIsPC(op Opcode) bool {
+	switch op {
+	case Call, Go, Defer, DeferReturn, JumpIfFalse, Jump, RJumpIfFalse,
+		JumpIfNotEq, JumpIfNotNe, JumpIfNotLt, JumpIfNotLe, JumpIfNotGt, JumpIfNotGe:
+		return true
+	}
+	return false
+}
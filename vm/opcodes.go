@@ -1,5 +1,7 @@
 package vm
 
+import "fmt"
+
 type Opcode uint8
 
 func (i Instr) Opcode() Opcode {
@@ -10,6 +12,42 @@ func (i Instr) Arg() int {
 	return int((i >> 8) & 0xffffffff)
 }
 
+// A, B and C are the slot operands of register instructions,
+// where A is usually the destination.
+func (i Instr) A() int {
+	return int((i >> 8) & 0xffff)
+}
+
+func (i Instr) B() int {
+	return int((i >> 24) & 0xffff)
+}
+
+func (i Instr) C() int {
+	return int((i >> 40) & 0xffff)
+}
+
+// Imm is the signed immediate of register instructions with one slot.
+func (i Instr) Imm() int {
+	return int(int64(i) >> 24)
+}
+
+// CImm is the signed immediate of register instructions with two
+// slots, which is in place of the third slot.
+func (i Instr) CImm() int {
+	return int(int64(i) >> 40)
+}
+
+// Int is the signed immediate of instructions without slots, which is
+// the rest of the instruction after the opcode.
+func (i Instr) Int() int {
+	return int(int64(i) >> 8)
+}
+
+// withArg returns the instruction with its argument replaced.
+func (i Instr) withArg(arg int) Instr {
+	return i&^(0xffffffff<<8) | Instr(arg)<<8
+}
+
 const (
 	Undef Opcode = iota
 	Prologue
@@ -20,6 +58,7 @@ const (
 	LoadLocal
 	StoreLocal
 	LoadInt
+	LoadIntLow // shifts r0 up 32 bits, and ors in the low bits
 	LocalAddr
 	Add
 	Sub
@@ -33,10 +72,71 @@ const (
 	Gt
 	Ge
 	Call
+	CallRuntime
+	CallExtern
+	Go
+	Defer
+	DeferReturn
 	JumpIfFalse
 	Jump
 	Return
 	Exit
+
+	// Register instructions operate on the slots of the frame, which
+	// hold the locals followed by the function's virtual registers.
+	// They are passed arguments and return results in the machine
+	// registers like other functions, so the two kinds of functions
+	// can call each other.
+	RMove
+	RMoveInt
+	RMoveIntLow // shifts a slot up 32 bits, and ors in the low bits
+	RAdd
+	RSub
+	RMul
+	RDiv
+	RNeg
+	REq
+	RNe
+	RLt
+	RLe
+	RGt
+	RGe
+	RLoad
+	RStore
+	RPut
+	RTake
+	RPush
+	Drop
+	RJumpIfFalse
+	RPrologue // makes a frame of a slots, and takes c arguments into the slots from b
+
+	// Register instructions with an immediate have it in place of
+	// their last slot, so the constant needs no slot of its own.
+	RAddImm
+	RSubImm
+	REqImm
+	RNeImm
+	RLtImm
+	RLeImm
+	RGtImm
+	RGeImm
+
+	// Superinstructions do the work of a common sequence of stack
+	// instructions, which the assembler combines into one so that
+	// it is dispatched once.
+	LoadLocalPush // loadlocal n; push
+	PopAdd        // pop 1; add
+	PopSub        // pop 1; sub
+	PopMul        // pop 1; mul
+	JumpIfNotEq   // eq; jumpiffalse target
+	JumpIfNotNe   // ne; jumpiffalse target
+	JumpIfNotLt   // lt; jumpiffalse target
+	JumpIfNotLe   // le; jumpiffalse target
+	JumpIfNotGt   // gt; jumpiffalse target
+	JumpIfNotGe   // ge; jumpiffalse target
+
+	// Cover counts a run of the block it starts, for coverage.
+	Cover
 )
 
 var opcodeNames = [...]string{
@@ -49,6 +149,7 @@ var opcodeNames = [...]string{
 	LoadLocal:   "loadlocal",
 	StoreLocal:  "storelocal",
 	LoadInt:     "loadint",
+	LoadIntLow:  "loadintlow",
 	LocalAddr:   "localaddr",
 	Add:         "add",
 	Sub:         "sub",
@@ -62,10 +163,59 @@ var opcodeNames = [...]string{
 	Gt:          "gt",
 	Ge:          "ge",
 	Call:        "call",
+	CallRuntime: "callruntime",
+	CallExtern:  "callextern",
+	Go:          "go",
+	Defer:       "defer",
+	DeferReturn: "deferreturn",
 	JumpIfFalse: "jumpiffalse",
 	Jump:        "jump",
 	Return:      "return",
 	Exit:        "exit",
+
+	RMove:        "rmove",
+	RMoveInt:     "rmoveint",
+	RMoveIntLow:  "rmoveintlow",
+	RAdd:         "radd",
+	RSub:         "rsub",
+	RMul:         "rmul",
+	RDiv:         "rdiv",
+	RNeg:         "rneg",
+	REq:          "req",
+	RNe:          "rne",
+	RLt:          "rlt",
+	RLe:          "rle",
+	RGt:          "rgt",
+	RGe:          "rge",
+	RLoad:        "rload",
+	RStore:       "rstore",
+	RPut:         "rput",
+	RTake:        "rtake",
+	RPush:        "rpush",
+	Drop:         "drop",
+	RJumpIfFalse: "rjumpiffalse",
+	RPrologue:    "rprologue",
+	RAddImm:      "raddimm",
+	RSubImm:      "rsubimm",
+	REqImm:       "reqimm",
+	RNeImm:       "rneimm",
+	RLtImm:       "rltimm",
+	RLeImm:       "rleimm",
+	RGtImm:       "rgtimm",
+	RGeImm:       "rgeimm",
+
+	LoadLocalPush: "loadlocalpush",
+	PopAdd:        "popadd",
+	PopSub:        "popsub",
+	PopMul:        "popmul",
+	JumpIfNotEq:   "jumpifnoteq",
+	JumpIfNotNe:   "jumpifnotne",
+	JumpIfNotLt:   "jumpifnotlt",
+	JumpIfNotLe:   "jumpifnotle",
+	JumpIfNotGt:   "jumpifnotgt",
+	JumpIfNotGe:   "jumpifnotge",
+
+	Cover: "cover",
 }
 
 func (o Opcode) String() string {
@@ -75,31 +225,124 @@ func (o Opcode) String() string {
 	return "unknown"
 }
 
-var opcodeHasArg = [...]bool{
-	Undef:       false,
-	Prologue:    true,
-	Load:        false,
-	Store:       false,
-	Push:        false,
-	Pop:         true,
-	LoadLocal:   true,
-	StoreLocal:  true,
-	LoadInt:     true,
-	LocalAddr:   true,
-	Add:         false,
-	Sub:         false,
-	Mul:         false,
-	Div:         false,
-	Neg:         false,
-	Eq:          false,
-	Ne:          false,
-	Lt:          false,
-	Le:          false,
-	Gt:          false,
-	Ge:          false,
-	Call:        true,
-	JumpIfFalse: true,
-	Jump:        true,
-	Return:      false,
-	Exit:        false,
+// format is how the operands of an instruction are encoded.
+type format uint8
+
+const (
+	fmtNone  format = iota
+	fmtArg          // arg
+	fmtA            // a
+	fmtAB           // a, b
+	fmtABC          // a, b, c
+	fmtAImm         // a, imm
+	fmtABImm        // a, b, imm
+	fmtImm          // imm
+	fmtArgC         // c, arg
+	fmtFrame        // size, b, n
+)
+
+var opcodeFormat = [...]format{
+	Undef:        fmtNone,
+	Prologue:     fmtArg,
+	Load:         fmtNone,
+	Store:        fmtNone,
+	Push:         fmtNone,
+	Pop:          fmtArg,
+	LoadLocal:    fmtArg,
+	StoreLocal:   fmtArg,
+	LoadInt:      fmtImm,
+	LoadIntLow:   fmtImm,
+	LocalAddr:    fmtArg,
+	Add:          fmtNone,
+	Sub:          fmtNone,
+	Mul:          fmtNone,
+	Div:          fmtNone,
+	Neg:          fmtNone,
+	Eq:           fmtNone,
+	Ne:           fmtNone,
+	Lt:           fmtNone,
+	Le:           fmtNone,
+	Gt:           fmtNone,
+	Ge:           fmtNone,
+	Call:         fmtArg,
+	CallRuntime:  fmtArg,
+	CallExtern:   fmtArg,
+	Go:           fmtArg,
+	Defer:        fmtArg,
+	DeferReturn:  fmtArg,
+	JumpIfFalse:  fmtArg,
+	Jump:         fmtArg,
+	Return:       fmtNone,
+	Exit:         fmtNone,
+	RMove:        fmtAB,
+	RMoveInt:     fmtAImm,
+	RMoveIntLow:  fmtAImm,
+	RAdd:         fmtABC,
+	RSub:         fmtABC,
+	RMul:         fmtABC,
+	RDiv:         fmtABC,
+	RNeg:         fmtAB,
+	REq:          fmtABC,
+	RNe:          fmtABC,
+	RLt:          fmtABC,
+	RLe:          fmtABC,
+	RGt:          fmtABC,
+	RGe:          fmtABC,
+	RLoad:        fmtAB,
+	RStore:       fmtAB,
+	RPut:         fmtAB,
+	RTake:        fmtAB,
+	RPush:        fmtA,
+	Drop:         fmtArg,
+	RJumpIfFalse: fmtArgC,
+	RPrologue:    fmtFrame,
+	RAddImm:      fmtABImm,
+	RSubImm:      fmtABImm,
+	REqImm:       fmtABImm,
+	RNeImm:       fmtABImm,
+	RLtImm:       fmtABImm,
+	RLeImm:       fmtABImm,
+	RGtImm:       fmtABImm,
+	RGeImm:       fmtABImm,
+
+	LoadLocalPush: fmtArg,
+	PopAdd:        fmtNone,
+	PopSub:        fmtNone,
+	PopMul:        fmtNone,
+	JumpIfNotEq:   fmtArg,
+	JumpIfNotNe:   fmtArg,
+	JumpIfNotLt:   fmtArg,
+	JumpIfNotLe:   fmtArg,
+	JumpIfNotGt:   fmtArg,
+	JumpIfNotGe:   fmtArg,
+
+	Cover: fmtArg,
+}
+
+func (i Instr) String() string {
+	op := i.Opcode()
+	if int(op) >= len(opcodeFormat) {
+		return op.String()
+	}
+	switch opcodeFormat[op] {
+	case fmtArg:
+		return fmt.Sprintf("%s %d", op, i.Arg())
+	case fmtA:
+		return fmt.Sprintf("%s s%d", op, i.A())
+	case fmtAB:
+		return fmt.Sprintf("%s s%d, s%d", op, i.A(), i.B())
+	case fmtABC:
+		return fmt.Sprintf("%s s%d, s%d, s%d", op, i.A(), i.B(), i.C())
+	case fmtAImm:
+		return fmt.Sprintf("%s s%d, %d", op, i.A(), i.Imm())
+	case fmtABImm:
+		return fmt.Sprintf("%s s%d, s%d, %d", op, i.A(), i.B(), i.CImm())
+	case fmtImm:
+		return fmt.Sprintf("%s %d", op, i.Int())
+	case fmtArgC:
+		return fmt.Sprintf("%s s%d, %d", op, i.C(), i.Arg())
+	case fmtFrame:
+		return fmt.Sprintf("%s %d, s%d, %d", op, i.A(), i.B(), i.C())
+	}
+	return op.String()
 }
@@ -0,0 +1,258 @@
+package vm
+
+import (
+	"compress/gzip"
+	"io"
+	"sort"
+)
+
+// The fields of the messages of profile.proto, the format of the
+// profiles pprof reads.
+const (
+	profileSampleType  = 1
+	profileSample      = 2
+	profileMapping     = 3
+	profileLocation    = 4
+	profileFunction    = 5
+	profileStringTable = 6
+	profilePeriodType  = 11
+	profilePeriod      = 12
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	mappingID             = 1
+	mappingMemoryLimit    = 3
+	mappingFilename       = 5
+	mappingHasFunctions   = 7
+	mappingHasFilenames   = 8
+	mappingHasLineNumbers = 9
+
+	locationID        = 1
+	locationMappingID = 2
+	locationAddress   = 3
+	locationLine      = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID        = 1
+	functionName      = 2
+	functionFilename  = 4
+	functionStartLine = 5
+)
+
+// WriteProfile writes the samples as a gzipped profile.proto, which
+// go tool pprof can read. Each sample counts both the sample and the
+// instructions it stands for, and the address of each location is
+// the pc.
+func (p *Profiler) WriteProfile(w io.Writer) error {
+	var strs stringTable
+	var b protobuf
+
+	valueType := func(field int, typ, unit string) {
+		b.message(field, func(b *protobuf) {
+			b.int(valueTypeType, strs.index(typ))
+			b.int(valueTypeUnit, strs.index(unit))
+		})
+	}
+	valueType(profileSampleType, "samples", "count")
+	valueType(profileSampleType, "instructions", "count")
+
+	// the samples are written in order so the profile is the
+	// same each time
+	samples := make([]*sample, 0, len(p.samples))
+	for _, s := range p.samples {
+		samples = append(samples, s)
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return lessStack(samples[i].stack, samples[j].stack)
+	})
+
+	// locations are numbered from one by pc, and functions by name
+	locations := make(map[int]int)
+	var pcs []int
+	for _, s := range samples {
+		ids := make([]uint64, len(s.stack))
+		for i, pc := range s.stack {
+			id, found := locations[pc]
+			if !found {
+				id = len(locations) + 1
+				locations[pc] = id
+				pcs = append(pcs, pc)
+			}
+			ids[i] = uint64(id)
+		}
+
+		b.message(profileSample, func(b *protobuf) {
+			b.packed(sampleLocationID, ids)
+			b.packed(sampleValue, []uint64{uint64(s.count), uint64(s.count * p.rate)})
+		})
+	}
+
+	codeLen := 0
+	if p.program != nil {
+		codeLen = len(p.program.Code)
+	}
+	b.message(profileMapping, func(b *protobuf) {
+		b.int(mappingID, 1)
+		b.int(mappingMemoryLimit, codeLen)
+		b.int(mappingFilename, strs.index("gosling"))
+		b.bool(mappingHasFunctions, true)
+		b.bool(mappingHasFilenames, true)
+		b.bool(mappingHasLineNumbers, true)
+	})
+
+	// functions are numbered from one by name, and are in the file
+	// of their locations
+	functions := make(map[string]int)
+	var funcs []*Func
+	var files []string
+	for _, pc := range pcs {
+		name := p.funcName(pc)
+		id, found := functions[name]
+		if !found {
+			id = len(functions) + 1
+			functions[name] = id
+			funcs = append(funcs, p.program.FuncFor(pc))
+			files = append(files, "")
+		}
+
+		file, line := p.program.LineFor(pc)
+		if files[id-1] == "" {
+			files[id-1] = file
+		}
+		b.message(profileLocation, func(b *protobuf) {
+			b.int(locationID, locations[pc])
+			b.int(locationMappingID, 1)
+			b.int(locationAddress, pc)
+			b.message(locationLine, func(b *protobuf) {
+				b.int(lineFunctionID, id)
+				b.int(lineLine, line)
+			})
+		})
+	}
+
+	for i, fn := range funcs {
+		name, line := "?", 0
+		if fn != nil {
+			name = fn.Name
+			_, line = p.program.LineFor(fn.Entry)
+		}
+		b.message(profileFunction, func(b *protobuf) {
+			b.int(functionID, i+1)
+			b.int(functionName, strs.index(name))
+			b.int(functionFilename, strs.index(files[i]))
+			b.int(functionStartLine, line)
+		})
+	}
+
+	valueType(profilePeriodType, "instructions", "count")
+	b.int(profilePeriod, p.rate)
+
+	for _, s := range strs.strs {
+		b.string(profileStringTable, s)
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(b.data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// lessStack orders stacks of pcs.
+func lessStack(a, b []int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// stringTable is the string table of a profile, which the other
+// messages refer to strings by the index of. The first string must
+// be empty.
+type stringTable struct {
+	strs []string
+	ids  map[string]int
+}
+
+// index returns the index of the string, adding it if it is new.
+func (t *stringTable) index(s string) int {
+	if t.ids == nil {
+		t.strs = []string{""}
+		t.ids = map[string]int{"": 0}
+	}
+	id, found := t.ids[s]
+	if !found {
+		id = len(t.strs)
+		t.strs = append(t.strs, s)
+		t.ids[s] = id
+	}
+	return id
+}
+
+// protobuf encodes a protocol buffer message.
+type protobuf struct {
+	data []byte
+}
+
+func (b *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+// key encodes the key of a field, which is its number and wire type.
+func (b *protobuf) key(field int, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+// int encodes an integer field, which is left out if it is zero.
+func (b *protobuf) int(field int, x int) {
+	if x == 0 {
+		return
+	}
+	b.key(field, 0)
+	b.varint(uint64(x))
+}
+
+func (b *protobuf) bool(field int, x bool) {
+	if x {
+		b.int(field, 1)
+	}
+}
+
+func (b *protobuf) bytes(field int, data []byte) {
+	b.key(field, 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *protobuf) string(field int, s string) {
+	b.bytes(field, []byte(s))
+}
+
+// packed encodes a repeated integer field in packed form.
+func (b *protobuf) packed(field int, xs []uint64) {
+	var p protobuf
+	for _, x := range xs {
+		p.varint(x)
+	}
+	b.bytes(field, p.data)
+}
+
+// message encodes an embedded message, which fill encodes the
+// fields of.
+func (b *protobuf) message(field int, fill func(b *protobuf)) {
+	var m protobuf
+	fill(&m)
+	b.bytes(field, m.data)
+}
@@ -0,0 +1,150 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Profiler samples the pc of a CPU every so many instructions, along
+// with the pcs of the calls on the running thread's call stack, to find
+// out where a program spends its time. Samples are taken between the
+// batches of instructions the CPU executes, so profiling costs nothing
+// between samples.
+type Profiler struct {
+	rate int
+
+	// left is the number of instructions until the next sample
+	left int
+
+	program *Program
+
+	// samples are the samples taken for each distinct stack
+	samples map[string]*sample
+	total   int
+}
+
+// sample is a stack of pcs, innermost first, and the number of times
+// it was sampled.
+type sample struct {
+	stack []int
+	count int
+}
+
+// NewProfiler returns a profiler which samples every rate instructions.
+// Set it as the CPU's Profiler before running the program.
+func NewProfiler(rate int) *Profiler {
+	if rate < 1 {
+		rate = 1
+	}
+	return &Profiler{rate: rate, left: rate, samples: make(map[string]*sample)}
+}
+
+// Rate returns the number of instructions between samples.
+func (p *Profiler) Rate() int {
+	return p.rate
+}
+
+// Samples returns the number of samples taken.
+func (p *Profiler) Samples() int {
+	return p.total
+}
+
+// profile counts the n instructions which have executed, and samples
+// the current thread if it is time to.
+func (c *CPU) profile(n int) {
+	p := c.Profiler
+	if p == nil {
+		return
+	}
+	p.left -= n
+	if p.left > 0 {
+		return
+	}
+	p.left = p.rate
+	p.program = c.program
+
+	// each frame is sampled at its call, which is just before
+	// where it returns to
+	stack := make([]int, 0, len(c.callStack)+1)
+	stack = append(stack, c.pc)
+	for i := len(c.callStack) - 1; i >= 0; i-- {
+		stack = append(stack, c.callStack[i]-1)
+	}
+
+	var key strings.Builder
+	for _, pc := range stack {
+		key.WriteString(strconv.Itoa(pc))
+		key.WriteByte(' ')
+	}
+
+	s := p.samples[key.String()]
+	if s == nil {
+		s = &sample{stack: stack}
+		p.samples[key.String()] = s
+	}
+	s.count++
+	p.total++
+}
+
+// funcName returns the name of the function containing the pc.
+func (p *Profiler) funcName(pc int) string {
+	if fn := p.program.FuncFor(pc); fn != nil {
+		return fn.Name
+	}
+	return "?"
+}
+
+// WriteReport writes a report of the number of instructions executed
+// in each function, estimated from the samples. Flat counts are the
+// instructions executed in the function itself, and cumulative counts
+// include the functions it calls.
+func (p *Profiler) WriteReport(w io.Writer) error {
+	flat := make(map[string]int)
+	cum := make(map[string]int)
+	for _, s := range p.samples {
+		flat[p.funcName(s.stack[0])] += s.count
+
+		// recursive functions are only counted once per sample
+		seen := make(map[string]bool)
+		for _, pc := range s.stack {
+			name := p.funcName(pc)
+			if !seen[name] {
+				seen[name] = true
+				cum[name] += s.count
+			}
+		}
+	}
+
+	names := make([]string, 0, len(cum))
+	for name := range cum {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if flat[names[i]] != flat[names[j]] {
+			return flat[names[i]] > flat[names[j]]
+		}
+		if cum[names[i]] != cum[names[j]] {
+			return cum[names[i]] > cum[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	percent := func(n int) float64 {
+		return 100 * float64(n) / float64(max(p.total, 1))
+	}
+
+	if _, err := fmt.Fprintf(w, "%12s %7s %12s %7s  %s\n", "flat", "flat%", "cum", "cum%", "function"); err != nil {
+		return err
+	}
+	for _, name := range names {
+		_, err := fmt.Fprintf(w, "%12d %6.2f%% %12d %6.2f%%  %s\n",
+			flat[name]*p.rate, percent(flat[name]), cum[name]*p.rate, percent(cum[name]), name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package vm
+
+import "sort"
+
+// Program is an assembled program along with the metadata
+// the CPU needs to run it, such as the stack maps used by
+// the garbage collector.
+type Program struct {
+	// Code is the list of instructions.
+	Code []Instr
+
+	// Funcs is the list of functions, in order of their entry point.
+	Funcs []Func
+
+	// StackMaps are the stack maps at each safepoint, indexed by
+	// the pc of the instruction. Safepoints are calls, runtime calls
+	// which can allocate or block, go statements and backward jumps,
+	// which are the only places the garbage collector can run or a
+	// thread can be suspended.
+	StackMaps map[int]StackMap
+
+	// Defers describe the arguments of each defer instruction,
+	// indexed by its pc.
+	Defers map[int]DeferInfo
+
+	// Externs are the extern functions the program calls, which are
+	// bound to host functions when it runs, indexed by the argument
+	// of the callextern instructions.
+	Externs []Extern
+
+	// Lines are the source lines of the instructions, in order of pc.
+	// Each line is the line of the instructions from its pc up to the
+	// pc of the next line.
+	Lines []Line
+
+	// Cover are the blocks which are instrumented for coverage,
+	// indexed by the argument of the cover instructions.
+	Cover []CoverBlock
+
+	// Relocs are the pcs of the instructions referring to each label
+	// which is not defined yet. Once a package is assembled, these are
+	// the functions of other packages, which Link resolves.
+	Relocs map[string][]int
+}
+
+// Func describes a function in the Program.
+type Func struct {
+	Name  string
+	Entry int
+
+	// Args is the number of arguments, which are passed in registers.
+	Args int
+
+	// Locals is the number of local slots in the stack frame.
+	Locals int
+
+	// Regs is the number of virtual register slots in the stack frame
+	// after the locals, for functions assembled to register instructions.
+	Regs int
+
+	// Refs are the indices of the locals and virtual registers which
+	// hold heap references.
+	Refs []int
+
+	// DeferReturn is the pc of the function's deferreturn instruction,
+	// where a function which recovers from a panic resumes, or 0 if
+	// the function has no deferred calls.
+	DeferReturn int
+}
+
+// Extern describes an extern function.
+type Extern struct {
+	Name string
+
+	// Args is the number of arguments, which are on the stack.
+	Args int
+}
+
+// Line is the source line of the instructions starting at PC.
+type Line struct {
+	PC   int
+	File string
+	Line int
+}
+
+// CoverBlock describes a block which is instrumented for coverage.
+type CoverBlock struct {
+	Func string
+	File string
+
+	// Entry is whether the block is the entry block of the function,
+	// or a copy of it inlined into another, which count its calls.
+	Entry bool
+
+	// Lines are the source lines of the statements which start in
+	// the block.
+	Lines []int
+}
+
+// DeferInfo describes the arguments of a deferred call.
+type DeferInfo struct {
+	// Args is the number of arguments.
+	Args int
+
+	// Refs are the indices of the arguments which are heap references.
+	Refs []int
+}
+
+// StackMap describes the part of the value stack which belongs
+// to a function's frame at a safepoint.
+type StackMap struct {
+	// Depth is the number of values the frame has pushed.
+	Depth int
+
+	// Refs are the indices of the pushed values which are heap
+	// references, relative to the bottom of the frame.
+	Refs []int
+}
+
+// FuncNamed returns the function with the given name, or nil if there is none.
+func (p *Program) FuncNamed(name string) *Func {
+	for i := range p.Funcs {
+		if p.Funcs[i].Name == name {
+			return &p.Funcs[i]
+		}
+	}
+	return nil
+}
+
+// FuncFor returns the function containing the pc, or nil if there is none.
+func (p *Program) FuncFor(pc int) *Func {
+	i := sort.Search(len(p.Funcs), func(i int) bool {
+		return p.Funcs[i].Entry > pc
+	})
+	if i == 0 {
+		return nil
+	}
+	return &p.Funcs[i-1]
+}
+
+// LineFor returns the source file and line of the instruction at pc,
+// or an empty file and zero if they are not known.
+func (p *Program) LineFor(pc int) (file string, line int) {
+	i := sort.Search(len(p.Lines), func(i int) bool {
+		return p.Lines[i].PC > pc
+	})
+	if i == 0 {
+		return "", 0
+	}
+
+	// lines do not carry over from one function into the next
+	l := p.Lines[i-1]
+	if fn := p.FuncFor(pc); fn != nil && l.PC < fn.Entry {
+		return "", 0
+	}
+	return l.File, l.Line
+}
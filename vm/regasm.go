@@ -0,0 +1,577 @@
+package vm
+
+import (
+	"math"
+
+	"github.com/rj45/gosling/ir"
+	"github.com/rj45/gosling/llir"
+	"github.com/rj45/gosling/regalloc"
+	"github.com/rj45/gosling/types"
+)
+
+// In register mode, functions are put in SSA form, lowered and
+// allocated registers like for a real machine, and each register is
+// then a slot of the frame after the locals. The registers are as
+// cheap as locals, so there are plenty, and calls don't overwrite
+// them. Heap references are allocated registers of their own, so the
+// garbage collector knows which slots hold them.
+
+// intRegs are the registers of values which are not heap references,
+// and refRegs are the registers of heap references.
+const (
+	intRegs = ir.RegMask(1<<23 - 1)
+	refRegs = ir.RegMask(0xff << 23)
+)
+
+// scratchReg is the register the moves of Phi parameters go through
+// to break cycles, which is a slot for each kind of register.
+const scratchReg = ir.RegID(31)
+
+// target has every local one word above the last, like the addresses
+// of the VM, and any constant can be an operand, as it is given a slot
+// of its own.
+var target = &llir.Target{
+	WordSize:    1,
+	LocalsAbove: true,
+	MinImm:      math.MinInt64,
+	MaxImm:      math.MaxInt64,
+	MinOffset:   math.MinInt64,
+	MaxOffset:   math.MaxInt64,
+}
+
+var config = &regalloc.Config{
+	Regs: intRegs | refRegs,
+	Class: func(typ types.Type) ir.RegMask {
+		if isRef(typ) {
+			return refRegs
+		}
+		return intRegs
+	},
+}
+
+var registerOps = map[ir.Op]Opcode{
+	llir.Add:   RAdd,
+	llir.Sub:   RSub,
+	llir.Mul:   RMul,
+	llir.Div:   RDiv,
+	llir.SetEq: REq,
+	llir.SetNe: RNe,
+	llir.SetLt: RLt,
+	llir.SetLe: RLe,
+	llir.SetGt: RGt,
+	llir.SetGe: RGe,
+
+	llir.BranchEq: REq,
+	llir.BranchNe: RNe,
+	llir.BranchLt: RLt,
+	llir.BranchLe: RLe,
+	llir.BranchGt: RGt,
+	llir.BranchGe: RGe,
+}
+
+// immOps are the instructions which take an immediate in place of
+// the slot of their last operand.
+var immOps = map[Opcode]Opcode{
+	RAdd: RAddImm,
+	RSub: RSubImm,
+	REq:  REqImm,
+	RNe:  RNeImm,
+	RLt:  RLtImm,
+	RLe:  RLeImm,
+	RGt:  RGtImm,
+	RGe:  RGeImm,
+}
+
+// inverse are the comparisons which are true when each is false.
+var inverse = map[Opcode]Opcode{
+	REq: RNe,
+	RNe: REq,
+	RLt: RGe,
+	RGe: RLt,
+	RLe: RGt,
+	RGt: RLe,
+}
+
+var runtimeOps = map[ir.Op]string{
+	llir.MakeMap:    "makemap",
+	llir.MapIndex:   "mapaccess1",
+	llir.MapIndexOk: "mapaccess2",
+	llir.MapAssign:  "mapassign",
+	llir.MapDelete:  "mapdelete",
+	llir.MapLen:     "maplen",
+	llir.MakeChan:   "makechan",
+	llir.ChanSend:   "chansend",
+	llir.ChanRecv:   "chanrecv1",
+	llir.ChanRecv2:  "chanrecv2",
+	llir.ChanClose:  "closechan",
+	llir.Select:     "selectgo",
+	llir.Panic:      "gopanic",
+	llir.Recover:    "gorecover",
+}
+
+// AddPasses adds the passes which take the functions to registers, if
+// the assembler is in register mode or the functions are optimized,
+// since the optimizations work on the SSA form.
+func (a *Asm) AddPasses(pm *ir.PassManager) {
+	if !a.Registers && pm.OptLevel == 0 {
+		return
+	}
+	regalloc.AddPasses(pm, target, config)
+}
+
+// regFunc is a function being assembled to register instructions.
+type regFunc struct {
+	*Asm
+	fn *ir.Func
+
+	// refs is whether each slot holds heap references
+	refs []bool
+
+	// the slots of the registers, of the constants, and of the
+	// scratch register for each kind of register
+	regs    map[ir.RegID]int
+	consts  map[int64]int
+	scratch map[bool]int
+
+	// constants are the constants with slots, in order
+	constants []int64
+
+	// temp is a slot for the results of the comparisons of branches,
+	// and the addresses of loads and stores at an offset
+	temp int
+
+	// cmp is the last Cmp, whose operands are compared by the Set or
+	// Branch after it, and cmpSlots are the slots they are in, which
+	// are the copies if they had to be copied. If cmpImm is set, the
+	// second operand is an immediate, which is in cmpSlots[1].
+	cmp      ir.Value
+	cmpSlots [2]int
+	cmpImm   bool
+	copies   []int
+}
+
+// AssembleFunc assembles the function to register instructions, if
+// the passes put it in SSA form.
+func (a *Asm) AssembleFunc(fn *ir.Func) bool {
+	if !fn.SSA {
+		return false
+	}
+
+	r := &regFunc{
+		Asm:     a,
+		fn:      fn,
+		regs:    make(map[ir.RegID]int),
+		consts:  make(map[int64]int),
+		scratch: make(map[bool]int),
+	}
+	for i := 0; i < fn.NumLocals(); i++ {
+		r.refs = append(r.refs, isRef(fn.LocalType(i)))
+	}
+	r.temp = r.newSlot(false)
+	fn.EachValue(func(v ir.Value) {
+		for i := 0; i < v.NumOperands(); i++ {
+			if c, ok := r.constOperand(v, i); ok {
+				if _, found := r.consts[c]; !found {
+					r.consts[c] = r.newSlot(false)
+					r.constants = append(r.constants, c)
+				}
+			}
+		}
+	})
+
+	a.fn = "_" + fn.Name
+	a.uni = fn.Types()
+	a.pushed = a.pushed[:0]
+	a.Label(a.fn)
+
+	a.Program.Funcs = append(a.Program.Funcs, Func{
+		Name:   fn.Name,
+		Entry:  len(a.Program.Code),
+		Args:   len(fn.Types().Func(fn.Sig).ParamTypes()),
+		Locals: fn.NumLocals(),
+	})
+	info := len(a.Program.Funcs) - 1
+	prologue := len(a.Program.Code)
+
+	for i := 0; i < fn.NumBlocks(); i++ {
+		blk := fn.BlockAt(i)
+		a.Label(blk.Name)
+		if i == 0 {
+			r.prologue(blk)
+		}
+		a.Cover(blk)
+
+		for j := 0; j < blk.NumValues(); j++ {
+			if v := blk.ValueAt(j); !v.IsNil() && v.Op() != llir.Prologue {
+				r.pos(v)
+				r.value(v)
+			}
+		}
+		r.pos(blk.Terminator())
+		r.terminator(blk, i)
+	}
+
+	if len(r.refs) > 1<<16 {
+		panic("too many registers in " + fn.Name)
+	}
+	a.Program.Code[prologue] |= Instr(len(r.refs)) << 8
+	a.Program.Funcs[info].Regs = len(r.refs) - fn.NumLocals()
+	for i, ref := range r.refs {
+		if ref {
+			a.Program.Funcs[info].Refs = append(a.Program.Funcs[info].Refs, i)
+		}
+	}
+	return true
+}
+
+// newSlot adds a slot to the frame after the others.
+func (r *regFunc) newSlot(ref bool) int {
+	r.refs = append(r.refs, ref)
+	return len(r.refs) - 1
+}
+
+// reg returns the slot of the register.
+func (r *regFunc) reg(reg ir.RegID) int {
+	slot, found := r.regs[reg]
+	if !found {
+		slot = r.newSlot(refRegs.HasReg(reg))
+		r.regs[reg] = slot
+	}
+	return slot
+}
+
+// slot returns the slot holding the value, which is the slot of
+// its register, or of the constant.
+func (r *regFunc) slot(v ir.Value) int {
+	if v.IsConstant() {
+		c, _ := ir.Int64Value(v.Constant())
+		return r.consts[c]
+	}
+	return r.reg(v.Regs().Peek())
+}
+
+// move returns the slot of a register of a move of Phi parameters,
+// where the scratch register is the scratch slot for the kind of
+// register it is moved to or from.
+func (r *regFunc) move(reg, other ir.RegID) int {
+	if reg != scratchReg {
+		return r.reg(reg)
+	}
+	ref := refRegs.HasReg(other)
+	slot, found := r.scratch[ref]
+	if !found {
+		slot = r.newSlot(ref)
+		r.scratch[ref] = slot
+	}
+	return slot
+}
+
+// constOperand returns the constant of the operand at index i of the
+// value, if it is a constant which is read from a slot of its own.
+func (r *regFunc) constOperand(v ir.Value, i int) (int64, bool) {
+	op := v.Operand(i)
+	if !op.IsConstant() {
+		return 0, false
+	}
+	c, ok := ir.Int64Value(op.Constant())
+	if !ok {
+		return 0, false
+	}
+
+	// the second operand of a binary op, and the offset of a load or
+	// store, are immediates if they fit
+	switch v.Op() {
+	case llir.Add, llir.Sub, llir.Cmp:
+		return c, !isFramePtr(v.Operand(0)) && (i == 0 || !fits(c, 24))
+	case llir.Load, llir.Store:
+		return c, i == v.NumOperands()-1 && !fits(c, 24) && !isFramePtr(v.Operand(i-1))
+	}
+	return 0, false
+}
+
+// isImm returns whether the value is a constant which fits the
+// immediate of a register instruction.
+func isImm(v ir.Value) bool {
+	if !v.IsConstant() {
+		return false
+	}
+	c, ok := ir.Int64Value(v.Constant())
+	return ok && fits(c, 24)
+}
+
+func isFramePtr(v ir.Value) bool {
+	return !v.IsConstant() && v.Op() == llir.FramePtr
+}
+
+func constInt(v ir.Value) int {
+	i, _ := ir.Int64Value(v.Constant())
+	return int(i)
+}
+
+func (r *regFunc) pos(v ir.Value) {
+	if v.Token() != 0 {
+		r.Pos(r.fn.PositionOf(v.Token()))
+	}
+}
+
+// prologue makes the frame, takes the arguments from the registers,
+// and loads the constants into their slots. The arguments from the
+// first register which are taken into consecutive slots are taken by
+// the prologue instruction itself.
+func (r *regFunc) prologue(entry *ir.Block) {
+	at := len(r.Program.Code)
+	r.rinstr(RPrologue, 0, 0, 0)
+
+	first, n := 0, 0
+	for i := 0; i < entry.NumParams(); i++ {
+		arg := entry.Param(i)
+		slot, reg := r.slot(arg), constInt(arg.Operand(0))
+		if reg == n && (n == 0 || slot == first+n) {
+			if n == 0 {
+				first = slot
+			}
+			n++
+			continue
+		}
+		r.rinstr(RTake, slot, reg, 0)
+	}
+	r.Program.Code[at] |= Instr(first)<<24 | Instr(n)<<40
+
+	for _, c := range r.constants {
+		r.moveInt(r.consts[c], c)
+	}
+}
+
+// rinstr appends a register instruction.
+func (r *regFunc) rinstr(op Opcode, a, b, c int) {
+	r.Program.Code = append(r.Program.Code, Instr(op)|Instr(a)<<8|Instr(b)<<24|Instr(c)<<40)
+}
+
+// moveInt moves the constant into the slot.
+func (r *regFunc) moveInt(slot int, c int64) {
+	if fits(c, 40) {
+		r.Program.Code = append(r.Program.Code, Instr(RMoveInt)|Instr(slot)<<8|Instr(c)<<24)
+		return
+	}
+	r.Program.Code = append(r.Program.Code,
+		Instr(RMoveInt)|Instr(slot)<<8|Instr(c>>32)<<24,
+		Instr(RMoveIntLow)|Instr(slot)<<8|Instr(c&0xffffffff)<<24)
+}
+
+// binary appends the register instruction of a binary op, with the
+// immediate form of the instruction if the second operand fits it.
+func (r *regFunc) binary(op Opcode, dest int, x, y ir.Value) {
+	if imm, found := immOps[op]; found && isImm(y) {
+		r.rinstr(imm, dest, r.slot(x), constInt(y))
+		return
+	}
+	r.rinstr(op, dest, r.slot(x), r.slot(y))
+}
+
+// addr returns the slot holding the address at an offset from the
+// base, computing it in the temp slot if the offset is not zero.
+func (r *regFunc) addr(base, offset ir.Value) int {
+	if constInt(offset) == 0 {
+		return r.slot(base)
+	}
+	r.binary(RAdd, r.temp, base, offset)
+	return r.temp
+}
+
+func (r *regFunc) value(v ir.Value) {
+	switch op := v.Op(); op {
+	case llir.Epilogue:
+		// the frame is taken down by the return
+	case llir.LoadImm:
+		c, _ := ir.Int64Value(v.Operand(0).Constant())
+		r.moveInt(r.slot(v), c)
+	case llir.Copy:
+		r.rinstr(RMove, r.slot(v), r.slot(v.Operand(0)), 0)
+	case llir.FramePtr:
+		// the address of the first local
+		r.moveInt(r.slot(v), 0)
+	case llir.Load:
+		if isFramePtr(v.Operand(0)) {
+			r.rinstr(RMove, r.slot(v), constInt(v.Operand(1)), 0)
+			return
+		}
+		r.rinstr(RLoad, r.slot(v), r.addr(v.Operand(0), v.Operand(1)), 0)
+	case llir.Store:
+		if isFramePtr(v.Operand(1)) {
+			r.rinstr(RMove, constInt(v.Operand(2)), r.slot(v.Operand(0)), 0)
+			return
+		}
+		r.rinstr(RStore, r.addr(v.Operand(1), v.Operand(2)), r.slot(v.Operand(0)), 0)
+	case llir.Spill:
+		r.rinstr(RMove, constInt(v.Operand(1)), r.slot(v.Operand(0)), 0)
+	case llir.Reload:
+		r.rinstr(RMove, r.slot(v), constInt(v.Operand(0)), 0)
+	case llir.Add:
+		if isFramePtr(v.Operand(0)) {
+			// the address of a local
+			r.moveInt(r.slot(v), int64(constInt(v.Operand(1))))
+			return
+		}
+		r.binary(RAdd, r.slot(v), v.Operand(0), v.Operand(1))
+	case llir.Sub, llir.Mul:
+		r.binary(registerOps[op], r.slot(v), v.Operand(0), v.Operand(1))
+	case llir.Div:
+		// dividing by zero panics
+		r.safepoint()
+		r.rinstr(RDiv, r.slot(v), r.slot(v.Operand(0)), r.slot(v.Operand(1)))
+	case llir.Neg:
+		r.rinstr(RNeg, r.slot(v), r.slot(v.Operand(0)), 0)
+	case llir.Cmp:
+		r.compare(v)
+	case llir.SetEq, llir.SetNe, llir.SetLt, llir.SetLe, llir.SetGt, llir.SetGe:
+		r.compared(registerOps[op], r.slot(v))
+	case llir.Call:
+		callee, _ := ir.FuncValue(v.Operand(0).Constant())
+		r.args(v.Operands()[1:])
+		r.Call(callee.Name)
+		r.result(v)
+	case llir.CallExtern:
+		callee, _ := ir.FuncValue(v.Operand(0).Constant())
+		r.push(v.Operands()[1:])
+		r.CallExtern(callee)
+		r.result(v)
+	case llir.Go:
+		callee, _ := ir.FuncValue(v.Operand(0).Constant())
+		r.args(v.Operands()[1:])
+		r.Go(callee.Name)
+	case llir.Select:
+		// the cases of a select are passed on the stack
+		cases := v.Operands()[2:]
+		r.args(v.Operands()[:2])
+		r.push(cases)
+		r.CallRuntime("selectgo", v.Type())
+		if len(cases) > 0 {
+			r.pushed = r.pushed[:len(r.pushed)-len(cases)]
+			r.instr1(Drop, len(cases))
+		}
+		r.result(v)
+	default:
+		name, found := runtimeOps[op]
+		if !found {
+			panic("unknown op: " + op.String())
+		}
+		r.args(v.Operands())
+		r.CallRuntime(name, v.Type())
+		r.result(v)
+	}
+}
+
+// compare keeps the operands of the Cmp for the Set or Branch after
+// it. If anything comes between them, which can overwrite the
+// registers the operands are in, they are copied to slots of their own.
+func (r *regFunc) compare(cmp ir.Value) {
+	r.cmp = cmp
+	r.cmpImm = isImm(cmp.Operand(1))
+	if r.cmpImm {
+		r.cmpSlots = [2]int{r.slot(cmp.Operand(0)), constInt(cmp.Operand(1))}
+	} else {
+		r.cmpSlots = [2]int{r.slot(cmp.Operand(0)), r.slot(cmp.Operand(1))}
+	}
+
+	blk := cmp.Block()
+	next := blk.Terminator()
+	for i := blk.IndexOf(cmp) + 1; i < blk.NumValues(); i++ {
+		if v := blk.ValueAt(i); !v.IsNil() {
+			next = v
+			break
+		}
+	}
+	if next.NumOperands() > 0 && next.Operand(0).ID() == cmp.ID() {
+		return
+	}
+
+	if r.copies == nil {
+		r.copies = []int{r.newSlot(false), r.newSlot(false)}
+	}
+	for i, slot := range r.cmpSlots {
+		if i == 1 && r.cmpImm {
+			break
+		}
+		r.rinstr(RMove, r.copies[i], slot, 0)
+		r.cmpSlots[i] = r.copies[i]
+	}
+}
+
+// compared appends the comparison of the operands of the last Cmp
+// into the slot.
+func (r *regFunc) compared(op Opcode, dest int) {
+	if r.cmpImm {
+		op = immOps[op]
+	}
+	r.rinstr(op, dest, r.cmpSlots[0], r.cmpSlots[1])
+}
+
+// args puts the arguments in the registers.
+func (r *regFunc) args(args []ir.Value) {
+	for i, arg := range args {
+		r.rinstr(RPut, i, r.slot(arg), 0)
+	}
+}
+
+// push pushes the values on the stack.
+func (r *regFunc) push(values []ir.Value) {
+	for _, v := range values {
+		r.pushed = append(r.pushed, isRef(v.Type()))
+		r.rinstr(RPush, r.slot(v), 0, 0)
+	}
+}
+
+// result takes the result of a call from the registers.
+func (r *regFunc) result(v ir.Value) {
+	if !v.Regs().IsEmpty() {
+		r.rinstr(RTake, r.slot(v), 0, 0)
+	}
+}
+
+// terminator assembles the terminator of the block at index b. The
+// jumps to the next block fall through instead.
+func (r *regFunc) terminator(blk *ir.Block, b int) {
+	next := ""
+	if b+1 < r.fn.NumBlocks() {
+		next = r.fn.BlockAt(b + 1).Name
+	}
+
+	term := blk.Terminator()
+	switch op := term.Op(); op {
+	case llir.Jump:
+		for _, m := range regalloc.Moves(blk, scratchReg) {
+			r.rinstr(RMove, r.move(m.Dst, m.Src), r.move(m.Src, m.Dst), 0)
+		}
+		if succ := blk.Successor(0).Name; succ != next {
+			r.Jump(succ)
+		}
+	case llir.Return:
+		if term.NumOperands() > 0 {
+			r.rinstr(RPut, 0, r.slot(term.Operand(0)), 0)
+		}
+		r.Return()
+	default:
+		then, els := blk.Successor(0).Name, blk.Successor(1).Name
+		cmp := registerOps[op]
+
+		if op == llir.BranchNe && r.cmp.Operand(1).IsConstant() && constInt(r.cmp.Operand(1)) == 0 {
+			// the condition is tested directly
+			r.jumpIfFalse(r.cmpSlots[0], els)
+		} else if els == next {
+			r.compared(inverse[cmp], r.temp)
+			r.jumpIfFalse(r.temp, then)
+			return
+		} else {
+			r.compared(cmp, r.temp)
+			r.jumpIfFalse(r.temp, els)
+		}
+		if then != next {
+			r.Jump(then)
+		}
+	}
+}
+
+func (r *regFunc) jumpIfFalse(slot int, label string) {
+	r.jump(RJumpIfFalse, label)
+	r.Program.Code[len(r.Program.Code)-1] |= Instr(slot) << 40
+}
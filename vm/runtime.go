@@ -0,0 +1,246 @@
+package vm
+
+import "log"
+
+// runtimeFuncs are the runtime helpers that compiled code can call.
+// Arguments are passed in registers starting at regs[0], and the
+// result is returned in regs[0]. The flags describe the type the
+// function operates on. Functions which can allocate, block or panic
+// are safepoints, and have a stack map recorded at each call.
+var runtimeFuncs = [...]struct {
+	name      string
+	fn        func(c *CPU, flags int)
+	safepoint bool
+}{
+	{"makemap", (*CPU).makemap, true},
+	{"mapaccess1", (*CPU).mapaccess1, false},
+	{"mapaccess2", (*CPU).mapaccess2, false},
+	{"mapassign", (*CPU).mapassign, true},
+	{"mapdelete", (*CPU).mapdelete, false},
+	{"maplen", (*CPU).maplen, false},
+	{"makechan", (*CPU).makechan, true},
+	{"chansend", (*CPU).chansend, true},
+	{"chanrecv1", (*CPU).chanrecv1, true},
+	{"chanrecv2", (*CPU).chanrecv2, true},
+	{"closechan", (*CPU).closechan, true},
+	{"selectgo", (*CPU).selectgo, true},
+	{"gopanic", (*CPU).gopanic, true},
+	{"gorecover", (*CPU).gorecover, false},
+}
+
+func runtimeIndex(name string) int {
+	for i, f := range runtimeFuncs {
+		if f.name == name {
+			return i
+		}
+	}
+	log.Panicf("unknown runtime function %s", name)
+	return -1
+}
+
+func (c *CPU) hashMap(ref int) *hashMap {
+	if ref == 0 {
+		return nil
+	}
+	return c.heap.objects[ref-1].(*hashMap)
+}
+
+// makemap(hint) map
+func (c *CPU) makemap(flags int) {
+	c.regs[0] = c.alloc(newHashMap(c.regs[0], flags))
+}
+
+// mapaccess1(m, k) v
+func (c *CPU) mapaccess1(int) {
+	m := c.hashMap(c.regs[0])
+	c.regs[0], _ = m.get(c.regs[1])
+}
+
+// mapaccess2(m, k, &ok) v
+func (c *CPU) mapaccess2(int) {
+	m := c.hashMap(c.regs[0])
+	val, ok := m.get(c.regs[1])
+	c.locals[c.regs[2]] = 0
+	if ok {
+		c.locals[c.regs[2]] = 1
+	}
+	c.regs[0] = val
+}
+
+// mapassign(m, k, v) m
+func (c *CPU) mapassign(int) {
+	m := c.hashMap(c.regs[0])
+	if m == nil {
+		c.runtimeError("assignment to entry in nil map")
+		return
+	}
+	size := m.words()
+	m.set(c.regs[1], c.regs[2])
+	c.resize(m.words() - size)
+}
+
+// mapdelete(m, k)
+func (c *CPU) mapdelete(int) {
+	c.hashMap(c.regs[0]).delete(c.regs[1])
+}
+
+// maplen(m) int
+func (c *CPU) maplen(int) {
+	c.regs[0] = c.hashMap(c.regs[0]).len()
+}
+
+// makechan(size) chan
+func (c *CPU) makechan(flags int) {
+	size := c.regs[0]
+	if size < 0 {
+		c.runtimeError("makechan: size out of range")
+		return
+	}
+	c.regs[0] = c.alloc(newChannel(size, flags))
+}
+
+// chansend(ch, v)
+func (c *CPU) chansend(int) {
+	ch := c.channel(c.regs[0])
+	if ch == nil {
+		c.block(&wait{reason: "chan send (nil chan)"})
+		return
+	}
+	if ch.closed {
+		c.runtimeError("send on closed channel")
+		return
+	}
+	if !c.trySend(ch, c.regs[1]) {
+		c.park("chan send", &waiter{ch: c.regs[0], send: true, val: c.regs[1], ok: -1, index: -1})
+	}
+}
+
+// chanrecv1(ch) v
+func (c *CPU) chanrecv1(int) {
+	ch := c.channel(c.regs[0])
+	if ch == nil {
+		c.block(&wait{reason: "chan receive (nil chan)"})
+		return
+	}
+	val, _, done := c.tryRecv(ch)
+	if !done {
+		c.park("chan receive", &waiter{ch: c.regs[0], val: -1, ok: -1, index: -1})
+		return
+	}
+	c.regs[0] = val
+}
+
+// chanrecv2(ch, &ok) v
+func (c *CPU) chanrecv2(int) {
+	ch := c.channel(c.regs[0])
+	if ch == nil {
+		c.block(&wait{reason: "chan receive (nil chan)"})
+		return
+	}
+	val, ok, done := c.tryRecv(ch)
+	if !done {
+		c.park("chan receive", &waiter{ch: c.regs[0], val: -1, ok: c.regs[1], index: -1})
+		return
+	}
+	c.locals[c.regs[1]] = boolInt(ok)
+	c.regs[0] = val
+}
+
+// closechan(ch)
+func (c *CPU) closechan(int) {
+	ch := c.channel(c.regs[0])
+	if ch == nil {
+		c.runtimeError("close of nil channel")
+		return
+	}
+	if ch.closed {
+		c.runtimeError("close of closed channel")
+		return
+	}
+	ch.closed = true
+
+	// the blocked senders panic once they run again
+	for _, w := range ch.sendq {
+		w.wait.t.err = "send on closed channel"
+		c.wake(w, 0, false)
+	}
+	ch.sendq = nil
+	for _, w := range ch.recvq {
+		c.wake(w, 0, false)
+	}
+	ch.recvq = nil
+}
+
+// selectgo(n, hasDefault) int
+//
+// The cases are (chan, value, kind) triples on the stack, where kind
+// is 0 for send, 1 for receive, and 2 for receive into the local whose
+// address is the value. Ready cases are chosen at random, and the index
+// of the chosen case is returned, or n if the default was chosen.
+func (c *CPU) selectgo(int) {
+	n, hasDefault := c.regs[0], c.regs[1] != 0
+	cases := c.stack[len(c.stack)-3*n:]
+
+	order := make([]int, n)
+	for i := range order {
+		j := int(c.random() % uint64(i+1))
+		order[i] = order[j]
+		order[j] = i
+	}
+
+	for _, i := range order {
+		ch := c.channel(cases[3*i])
+		if ch == nil {
+			continue
+		}
+
+		val, kind := cases[3*i+1], cases[3*i+2]
+		if kind == 0 {
+			if ch.closed {
+				c.runtimeError("send on closed channel")
+				return
+			}
+			if c.trySend(ch, val) {
+				c.regs[0] = i
+				return
+			}
+			continue
+		}
+
+		recv, _, done := c.tryRecv(ch)
+		if done {
+			if kind == 2 {
+				c.locals[val] = recv
+			}
+			c.regs[0] = i
+			return
+		}
+	}
+
+	if hasDefault {
+		c.regs[0] = n
+		return
+	}
+
+	var waiters []*waiter
+	for i := 0; i < n; i++ {
+		if cases[3*i] == 0 {
+			continue
+		}
+		w := &waiter{ch: cases[3*i], val: -1, ok: -1, index: i}
+		switch cases[3*i+2] {
+		case 0:
+			w.send = true
+			w.val = cases[3*i+1]
+		case 2:
+			w.val = cases[3*i+1]
+		}
+		waiters = append(waiters, w)
+	}
+
+	if len(waiters) == 0 {
+		c.block(&wait{reason: "select (no cases)"})
+		return
+	}
+	c.park("select", waiters...)
+}
@@ -0,0 +1,203 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// context is the state of a thread of execution.
+type context struct {
+	regs       [8]int
+	stack      []int
+	locals     []int
+	localStack [][]int
+	callStack  []int
+	pc         int
+
+	defers []deferred
+	panic  *panicking
+}
+
+// thread is a lightweight thread started by a go statement,
+// or the main thread.
+type thread struct {
+	id  int
+	ctx context
+
+	// safepoint is the pc of the instruction the thread was suspended
+	// at, which has the stack map describing the thread's frame.
+	safepoint int
+
+	// retRef is whether a heap reference was delivered into regs[0]
+	// while the thread was suspended, so it needs to be marked.
+	retRef bool
+
+	// wait is what the thread is blocked on, or nil if it is runnable.
+	wait *wait
+
+	// err is the runtime error the thread panics with when it runs
+	// again, such as a send on a channel closed while it was blocked.
+	err string
+}
+
+// sched is the state of the scheduler. Threads are switched
+// cooperatively at channel operations, go statements and when
+// they exit, and they are preempted at backward jumps once they
+// have used up their time slice.
+type sched struct {
+	cur     *thread
+	threads []*thread
+	runq    []*thread
+	nextID  int
+	budget  int
+	rand    uint64
+}
+
+// DeadlockError is returned by Run when every thread is blocked,
+// so the program can never make progress.
+type DeadlockError struct {
+	Threads []BlockedThread
+}
+
+// BlockedThread describes a thread which is blocked forever.
+type BlockedThread struct {
+	ID     int
+	Func   string
+	PC     int
+	Reason string
+}
+
+func (e *DeadlockError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("all goroutines are asleep - deadlock!")
+	for _, t := range e.Threads {
+		fmt.Fprintf(&sb, "\n\ngoroutine %d [%s]:\n\t%s at pc %d", t.ID, t.Reason, t.Func, t.PC)
+	}
+	return sb.String()
+}
+
+func (c *CPU) resetSched() {
+	main := &thread{id: 1}
+	c.sched = sched{
+		cur:     main,
+		threads: []*thread{main},
+		nextID:  1,
+		rand:    0x9e3779b97f4a7c15,
+	}
+	c.budget = c.timeSlice()
+}
+
+func (c *CPU) timeSlice() int {
+	if c.TimeSlice <= 0 {
+		return int(^uint(0) >> 1)
+	}
+	return c.TimeSlice
+}
+
+// random returns a pseudo-random number from a xorshift generator,
+// which is seeded the same way on every run so runs are repeatable.
+func (c *CPU) random() uint64 {
+	c.rand ^= c.rand << 13
+	c.rand ^= c.rand >> 7
+	c.rand ^= c.rand << 17
+	return c.rand
+}
+
+// suspend saves the context of the current thread, which is
+// stopped at the safepoint at pc.
+func (c *CPU) suspend(pc int) {
+	c.cur.ctx = c.context
+	c.cur.safepoint = pc
+}
+
+func (c *CPU) switchTo(t *thread) {
+	c.cur = t
+	c.context = t.ctx
+	t.retRef = false
+	c.budget = c.timeSlice()
+
+	if t.err != "" {
+		err := t.err
+		t.err = ""
+		c.runtimeError(err)
+	}
+}
+
+// schedule switches to the next runnable thread. The current
+// thread must have been suspended or have exited.
+func (c *CPU) schedule() {
+	if len(c.runq) == 0 {
+		panic(c.deadlock())
+	}
+	t := c.runq[0]
+	c.runq = c.runq[1:]
+	c.switchTo(t)
+}
+
+// yield preempts the current thread at the jump at pc, if
+// there is another thread to run.
+func (c *CPU) yield(pc int) {
+	if len(c.runq) == 0 {
+		c.budget = c.timeSlice()
+		return
+	}
+	c.suspend(pc)
+	c.runq = append(c.runq, c.cur)
+	c.schedule()
+}
+
+// spawn starts a thread running the function at entry, with the
+// arguments in the current registers. The new thread runs first,
+// and the current thread resumes after the go instruction.
+func (c *CPU) spawn(entry int) {
+	c.nextID++
+	t := &thread{id: c.nextID}
+	t.ctx = context{regs: c.regs, stack: make([]int, 0, 16), pc: entry}
+	c.threads = append(c.threads, t)
+
+	c.suspend(c.pc - 1)
+	c.runq = append([]*thread{c.cur}, c.runq...)
+	c.switchTo(t)
+}
+
+// exit ends the current thread.
+func (c *CPU) exit() {
+	for i, t := range c.threads {
+		if t == c.cur {
+			c.threads = append(c.threads[:i], c.threads[i+1:]...)
+			break
+		}
+	}
+	c.schedule()
+}
+
+// block suspends the current thread until one of the cases of the
+// wait is ready. It must only be called from runtime functions at
+// safepoints.
+func (c *CPU) block(w *wait) {
+	w.t = c.cur
+	c.cur.wait = w
+	c.suspend(c.pc - 1)
+	c.schedule()
+}
+
+// ready makes a blocked thread runnable again.
+func (c *CPU) ready(t *thread) {
+	t.wait = nil
+	c.runq = append(c.runq, t)
+}
+
+func (c *CPU) deadlock() *DeadlockError {
+	err := &DeadlockError{}
+	for _, t := range c.threads {
+		blocked := BlockedThread{ID: t.id, PC: t.safepoint}
+		if fn := c.program.FuncFor(t.safepoint); fn != nil {
+			blocked.Func = fn.Name
+		}
+		if t.wait != nil {
+			blocked.Reason = t.wait.reason
+		}
+		err.Threads = append(err.Threads, blocked)
+	}
+	return err
+}
@@ -1,72 +1,199 @@
 package vm
 
-import "fmt"
+import (
+	stdcontext "context"
+	"fmt"
+)
 
 type Instr uint64
 
 // CPU is a quick and dirty stack-based virtual machine
 // which can be used to test code generation without
 // having to run an external assembler.
+//
+// The CPU schedules goroutines as lightweight threads. The
+// context of the thread which is running is kept in the CPU,
+// and is swapped out when the scheduler switches threads.
 type CPU struct {
-	regs       [8]int
-	stack      []int
-	locals     []int
-	localStack [][]int
-	callStack  []int
+	context
 
-	program []Instr
-	pc      int
+	heap    heap
+	running bool
+
+	program *Program
+
+	// code is the program's code, predecoded
+	code []decoded
+
+	// frames are the frames of returned calls, which are reused by
+	// the next calls instead of allocating frames of their own
+	frames [][]int
+
+	sched
+
+	// TimeSlice is the number of instructions a thread can run
+	// before it is preempted at the next backward jump. Zero or
+	// less disables preemption.
+	TimeSlice int
+
+	// GCPercent is the percentage the heap can grow by since the
+	// last collection before another collection is triggered, like
+	// GOGC. A negative percentage disables garbage collection.
+	GCPercent int
+
+	// GCStress collects garbage at every allocation, which shakes
+	// out bugs in the stack maps.
+	GCStress bool
 
 	Trace bool
+
+	// Profiler, if set, samples the program as it runs.
+	Profiler *Profiler
+
+	// Coverage is the number of times each block of the program's
+	// Cover has run, if it was assembled in coverage mode. The
+	// counts add up over every run.
+	Coverage []int
+
+	// Limits bound the resources the program can use.
+	Limits
+
+	// ctx is the context of RunContext, and steps is the number
+	// of instructions executed
+	ctx   stdcontext.Context
+	steps int
+
+	// hosts are the registered host functions by name, and externs
+	// are the host functions bound to the program's extern functions
+	hosts   map[string]HostFunc
+	externs []HostFunc
+}
+
+func NewCPU(prog *Program) *CPU {
+	return &CPU{
+		program:   prog,
+		code:      decode(prog.Code),
+		Coverage:  make([]int, len(prog.Cover)),
+		GCPercent: 100,
+		TimeSlice: 1000,
+	}
+}
+
+// Run runs the program until main returns, and returns its result.
+// If every thread becomes blocked, a *DeadlockError is returned, and
+// if a thread panics without recovering, a *PanicError is returned.
+func (c *CPU) Run() (result int, err error) {
+	// main is always first
+	return c.run(0, nil)
 }
 
-func NewCPU(prog []Instr) *CPU {
-	return &CPU{program: prog}
+// Call calls the named function with the args, and returns its result
+// once it returns. Like when main returns, the goroutines it started
+// are stopped. Errors are returned the same way as by Run.
+func (c *CPU) Call(name string, args ...int) (result int, err error) {
+	fn := c.program.FuncNamed(name)
+	if fn == nil {
+		return 0, fmt.Errorf("undefined function %s", name)
+	}
+	if len(args) != fn.Args {
+		return 0, fmt.Errorf("wrong number of arguments to %s: expected %d, got %d", name, fn.Args, len(args))
+	}
+	return c.run(fn.Entry, args)
 }
 
-func (c *CPU) Run() int {
-	c.stack = make([]int, 0, 100)
-	c.pc = 0
+// run runs the function at entry on the main thread, with the args in
+// the registers, until it returns.
+func (c *CPU) run(entry int, args []int) (result int, err error) {
+	if err := c.bindExterns(); err != nil {
+		return 0, err
+	}
+
+	c.context = context{stack: make([]int, 0, 100), pc: entry}
+	c.steps = 0
+	copy(c.regs[:], args)
+	c.resetHeap()
+	c.resetSched()
+
+	c.running = true
+	defer func() {
+		c.running = false
+		switch r := recover().(type) {
+		case nil:
+		case *DeadlockError:
+			err = r
+		case *PanicError:
+			err = r
+		case *InstructionLimitError, *CallDepthError, *StackLimitError, *HeapLimitError, *CanceledError:
+			err = r.(error)
+		default:
+			panic(r)
+		}
+	}()
 
 	if c.Trace {
-		fmt.Println("prog len:", len(c.program))
+		fmt.Println("prog len:", len(c.code))
+		c.trace()
+		return c.regs[0], nil
 	}
 
 	for {
-		instr := c.program[c.pc]
-		c.pc++
+		n := c.nextCheck()
+		if c.exec(n) {
+			return c.regs[0], nil
+		}
+		c.checkCancel()
+		c.profile(n)
+	}
+}
 
-		if c.Trace {
-			if opcodeHasArg[instr.Opcode()] {
-				fmt.Printf("%04d: %s %d\n", c.pc-1, instr.Opcode(), instr.Arg())
-			} else {
-				fmt.Printf("%04d: %s\n", c.pc-1, instr.Opcode())
-			}
+// trace runs the program like run, one instruction at a time, printing
+// each instruction before it executes.
+func (c *CPU) trace() {
+	for {
+		c.nextCheck()
+		fmt.Printf("%04d: %s\n", c.pc, c.program.Code[c.pc])
+		if c.exec(1) {
+			return
 		}
+		c.checkCancel()
+		c.profile(1)
+	}
+}
+
+// exec executes up to n instructions, and returns whether the function
+// the program was started with has returned, with its result in r0.
+func (c *CPU) exec(n int) bool {
+	code := c.code
+	for i := 0; i < n; i++ {
+		in := &code[c.pc]
+		c.pc++
+		c.budget--
 
-		switch instr.Opcode() {
+		switch in.op {
 		case Prologue:
-			locals := instr.Arg()
-			c.locals = make([]int, locals+1)
+			c.locals = c.frame(in.arg + 1)
 		case Push:
+			c.checkStack()
 			c.stack = append(c.stack, c.regs[0])
 		case Pop:
-			c.regs[instr.Arg()] = c.stack[len(c.stack)-1]
+			c.regs[in.arg] = c.stack[len(c.stack)-1]
 			c.stack = c.stack[:len(c.stack)-1]
 		case LoadLocal:
-			c.regs[0] = c.locals[instr.Arg()]
+			c.regs[0] = c.locals[in.arg]
 		case StoreLocal:
 			// this is kind of a hack... the vm can only take one argument
 			// this assumes that the arg reg will be stored in the same numbered local
-			c.locals[instr.Arg()] = c.regs[instr.Arg()]
+			c.locals[in.arg] = c.regs[in.arg]
 		case Load:
 			c.regs[0] = c.locals[c.regs[0]]
 		case Store:
 			c.locals[c.regs[1]] = c.regs[0]
 		case LocalAddr:
-			c.regs[0] = instr.Arg()
+			c.regs[0] = in.arg
 		case LoadInt:
-			c.regs[0] = instr.Arg()
+			c.regs[0] = in.arg
+		case LoadIntLow:
+			c.regs[0] = c.regs[0]<<32 | in.arg
 		case Add:
 			c.regs[0] = c.regs[1] + c.regs[0]
 		case Sub:
@@ -74,6 +201,10 @@ func (c *CPU) Run() int {
 		case Mul:
 			c.regs[0] = c.regs[1] * c.regs[0]
 		case Div:
+			if c.regs[0] == 0 {
+				c.runtimeError("integer divide by zero")
+				continue
+			}
 			c.regs[0] = c.regs[1] / c.regs[0]
 		case Neg:
 			c.regs[0] = -c.regs[0]
@@ -114,25 +245,196 @@ func (c *CPU) Run() int {
 				c.regs[0] = 0
 			}
 		case Call:
+			c.checkCall(in.arg)
 			c.callStack = append(c.callStack, c.pc)
 			c.localStack = append(c.localStack, c.locals)
-			c.pc = instr.Arg()
+			c.pc = in.arg
+		case CallRuntime:
+			arg := in.arg
+			runtimeFuncs[arg&0xff].fn(c, arg>>8)
+		case CallExtern:
+			c.callHost(in.arg)
 		case JumpIfFalse:
 			if c.regs[0] == 0 {
-				c.pc = instr.Arg()
+				c.pc = in.arg
 			}
 		case Jump:
-			c.pc = instr.Arg()
+			pc := c.pc - 1
+			c.pc = in.arg
+			if c.pc <= pc && c.budget <= 0 {
+				c.yield(pc)
+			}
+		case Go:
+			c.spawn(in.arg)
+		case Defer:
+			c.deferCall(in.arg)
+		case DeferReturn:
+			if !c.callDeferred(c.pc) {
+				c.pc = in.arg
+			}
 		case Return:
+			if len(c.callStack) == 0 {
+				if c.cur.id == 1 {
+					// the function called on the main thread has returned
+					c.steps += i + 1
+					return true
+				}
+
+				// the bottom frame of a goroutine has returned
+				c.exit()
+				continue
+			}
+
 			c.pc = c.callStack[len(c.callStack)-1]
 			c.callStack = c.callStack[:len(c.callStack)-1]
 
+			c.frames = append(c.frames, c.locals)
 			c.locals = c.localStack[len(c.localStack)-1]
 			c.localStack = c.localStack[:len(c.localStack)-1]
+
+			if p := c.panic; p != nil && len(c.callStack) == p.depth-1 {
+				// a deferred call made while panicking has returned
+				c.unwind(c.pc)
+			}
 		case Exit:
-			return c.regs[0]
+			c.steps += i + 1
+			return true
+		case RMove:
+			c.locals[in.a] = c.locals[in.b]
+		case RMoveInt:
+			c.locals[in.a] = in.arg
+		case RMoveIntLow:
+			c.locals[in.a] = c.locals[in.a]<<32 | in.arg
+		case RAdd:
+			c.locals[in.a] = c.locals[in.b] + c.locals[in.c]
+		case RSub:
+			c.locals[in.a] = c.locals[in.b] - c.locals[in.c]
+		case RMul:
+			c.locals[in.a] = c.locals[in.b] * c.locals[in.c]
+		case RDiv:
+			if c.locals[in.c] == 0 {
+				c.runtimeError("integer divide by zero")
+				continue
+			}
+			c.locals[in.a] = c.locals[in.b] / c.locals[in.c]
+		case RNeg:
+			c.locals[in.a] = -c.locals[in.b]
+		case REq:
+			c.locals[in.a] = bit(c.locals[in.b] == c.locals[in.c])
+		case RNe:
+			c.locals[in.a] = bit(c.locals[in.b] != c.locals[in.c])
+		case RLt:
+			c.locals[in.a] = bit(c.locals[in.b] < c.locals[in.c])
+		case RLe:
+			c.locals[in.a] = bit(c.locals[in.b] <= c.locals[in.c])
+		case RGt:
+			c.locals[in.a] = bit(c.locals[in.b] > c.locals[in.c])
+		case RGe:
+			c.locals[in.a] = bit(c.locals[in.b] >= c.locals[in.c])
+		case RLoad:
+			c.locals[in.a] = c.locals[c.locals[in.b]]
+		case RStore:
+			c.locals[c.locals[in.a]] = c.locals[in.b]
+		case RPut:
+			c.regs[in.a] = c.locals[in.b]
+		case RTake:
+			c.locals[in.a] = c.regs[in.b]
+		case RPush:
+			c.checkStack()
+			c.stack = append(c.stack, c.locals[in.a])
+		case Drop:
+			c.stack = c.stack[:len(c.stack)-in.arg]
+		case RJumpIfFalse:
+			if c.locals[in.c] == 0 {
+				c.pc = in.arg
+			}
+		case RPrologue:
+			c.locals = c.frame(in.a + 1)
+			copy(c.locals[in.b:in.b+in.c], c.regs[:in.c])
+		case RAddImm:
+			c.locals[in.a] = c.locals[in.b] + in.c
+		case RSubImm:
+			c.locals[in.a] = c.locals[in.b] - in.c
+		case REqImm:
+			c.locals[in.a] = bit(c.locals[in.b] == in.c)
+		case RNeImm:
+			c.locals[in.a] = bit(c.locals[in.b] != in.c)
+		case RLtImm:
+			c.locals[in.a] = bit(c.locals[in.b] < in.c)
+		case RLeImm:
+			c.locals[in.a] = bit(c.locals[in.b] <= in.c)
+		case RGtImm:
+			c.locals[in.a] = bit(c.locals[in.b] > in.c)
+		case RGeImm:
+			c.locals[in.a] = bit(c.locals[in.b] >= in.c)
+		case LoadLocalPush:
+			c.checkStack()
+			c.regs[0] = c.locals[in.arg]
+			c.stack = append(c.stack, c.regs[0])
+		case PopAdd:
+			c.regs[1] = c.stack[len(c.stack)-1]
+			c.stack = c.stack[:len(c.stack)-1]
+			c.regs[0] = c.regs[1] + c.regs[0]
+		case PopSub:
+			c.regs[1] = c.stack[len(c.stack)-1]
+			c.stack = c.stack[:len(c.stack)-1]
+			c.regs[0] = c.regs[1] - c.regs[0]
+		case PopMul:
+			c.regs[1] = c.stack[len(c.stack)-1]
+			c.stack = c.stack[:len(c.stack)-1]
+			c.regs[0] = c.regs[1] * c.regs[0]
+		case JumpIfNotEq:
+			c.regs[0] = c.jumpIfNot(c.regs[1] == c.regs[0], in.arg)
+		case JumpIfNotNe:
+			c.regs[0] = c.jumpIfNot(c.regs[1] != c.regs[0], in.arg)
+		case JumpIfNotLt:
+			c.regs[0] = c.jumpIfNot(c.regs[1] < c.regs[0], in.arg)
+		case JumpIfNotLe:
+			c.regs[0] = c.jumpIfNot(c.regs[1] <= c.regs[0], in.arg)
+		case JumpIfNotGt:
+			c.regs[0] = c.jumpIfNot(c.regs[1] > c.regs[0], in.arg)
+		case JumpIfNotGe:
+			c.regs[0] = c.jumpIfNot(c.regs[1] >= c.regs[0], in.arg)
+		case Cover:
+			c.Coverage[in.arg]++
 		default:
 			panic("unknown opcode")
 		}
 	}
+	c.steps += n
+	return false
+}
+
+// minFrame is the capacity frames are allocated with, so that most
+// frames fit the frames of other functions when they are reused.
+const minFrame = 16
+
+// frame returns a zeroed frame of n slots, reusing the frame of the
+// last returned call if it is big enough.
+func (c *CPU) frame(n int) []int {
+	if last := len(c.frames) - 1; last >= 0 && cap(c.frames[last]) >= n {
+		f := c.frames[last][:n]
+		c.frames = c.frames[:last]
+		clear(f)
+		return f
+	}
+	return make([]int, n, max(n, minFrame))
+}
+
+// jumpIfNot jumps to target unless the condition of a compare and
+// jump superinstruction holds, and returns the condition's value.
+func (c *CPU) jumpIfNot(cond bool, target int) int {
+	if !cond {
+		c.pc = target
+		return 0
+	}
+	return 1
+}
+
+// bit converts a condition to the 0 or 1 it is represented by.
+func bit(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
 }